@@ -2,11 +2,14 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
+	"syscall"
 	"time"
 
 	_ "modernc.org/sqlite"
@@ -36,6 +39,15 @@ func main() {
 	staleCheckInterval := getenvDurationDefault("PIXIA_OUTBOX_STALE_CHECK_INTERVAL", 30*time.Second)
 	jwtSecret := []byte(getenvDefault("PIXIA_JWT_SECRET", "pixia-secret"))
 	jwtTTL := getenvDurationDefault("PIXIA_JWT_TTL", 24*time.Hour)
+	accessTTL := getenvDurationDefault("PIXIA_ACCESS_TOKEN_TTL", 15*time.Minute)
+	backupDir := getenvDefault("PIXIA_BACKUP_DIR", filepath.Join(filepath.Dir(dbPath), "backups"))
+	backupInterval := getenvDurationDefault("PIXIA_BACKUP_INTERVAL", 24*time.Hour)
+	backupRetention := getenvIntDefault("PIXIA_BACKUP_RETENTION", 7)
+	flowFlushInterval := getenvDurationDefault("PIXIA_FLOW_FLUSH_INTERVAL", 2*time.Second)
+	flowMaxPending := getenvIntDefault("PIXIA_FLOW_MAX_PENDING", 500)
+	flowMaxBuffered := getenvIntDefault("PIXIA_FLOW_MAX_BUFFERED", 0)
+	trustedProxies := getenvDefault("PIXIA_TRUSTED_PROXIES", "127.0.0.1,::1")
+	migrationsDir := getenvDefault("PIXIA_MIGRATIONS_DIR", filepath.Join(".", "migrations"))
 
 	conn, err := db.Open(dbPath)
 	if err != nil {
@@ -43,16 +55,30 @@ func main() {
 	}
 	defer conn.Close()
 
-	if err := migrate.Apply(conn, filepath.Join(".", "migrations")); err != nil {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrateCommand(conn, migrationsDir, os.Args[2:]); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		return
+	}
+
+	if err := migrate.Apply(conn, migrationsDir); err != nil {
 		log.Fatalf("migrate: %v", err)
 	}
 
 	store := store.New(conn)
-	flowService := flow.New(store)
+	flowService := flow.New(store, flow.Options{
+		FlushInterval: flowFlushInterval,
+		MaxPending:    flowMaxPending,
+		MaxBuffered:   flowMaxBuffered,
+	})
 	hub := gost.NewHub()
-	hub.SetJWTSecret(jwtSecret)
 
-	server := httpapi.NewServer(store, flowService, hub, jwtSecret, jwtTTL)
+	server := httpapi.NewServer(store, flowService, hub, jwtSecret, accessTTL, jwtTTL)
+	if err := server.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatalf("trusted proxies: %v", err)
+	}
+	hub.SetAdminAuth(server.AuthorizeAdminSocket)
 	router := http.NewServeMux()
 	server.Register(router)
 	lookup := nodeLookup{store: store, api: server}
@@ -61,8 +87,18 @@ func main() {
 		router.Handle("/ws", hub.ServeWS(lookup))
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// The flow buffer outlives ctx so uploads accepted during shutdown are flushed.
+	flowCtx, flowCancel := context.WithCancel(context.Background())
+	flowDone := make(chan struct{})
+	go func() {
+		if err := flowService.Run(flowCtx); err != nil {
+			log.Printf("flow final flush failed: %v", err)
+		}
+		close(flowDone)
+	}()
 
 	worker := outbox.NewWorker(store, hub, outbox.WorkerOptions{
 		Interval:           interval,
@@ -79,13 +115,33 @@ func main() {
 	c := cron.New()
 	_, _ = c.AddFunc("0 0 * * *", func() { scheduler.DailyReset(ctx) })
 	_, _ = c.AddFunc("0 * * * *", func() { scheduler.HourlyStatistics(ctx) })
+	_, _ = c.AddFunc("@every 1m", func() { scheduler.RetireNodeSecrets(ctx) })
+	if backupInterval > 0 {
+		_, _ = c.AddFunc("@every "+backupInterval.String(), func() {
+			if err := scheduler.Backup(ctx, backupDir, backupRetention); err != nil {
+				log.Printf("backup: %v", err)
+			}
+		})
+	}
 	c.Start()
 
-	handler := httpapi.WithCORS(router)
+	srv := &http.Server{Addr: addr, Handler: httpapi.WithCORS(router)}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
 	log.Printf("pixia-panel listening on %s", addr)
-	if err := http.ListenAndServe(addr, handler); err != nil {
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalf("server error: %v", err)
 	}
+
+	<-c.Stop().Done()
+	flowCancel()
+	<-flowDone
+	log.Printf("pixia-panel stopped")
 }
 
 func getenvDefault(key, def string) string {
@@ -135,6 +191,6 @@ func (n nodeLookup) UpdateNodeStatus(ctx context.Context, nodeID int64, status i
 	return n.store.UpdateNodeStatus(ctx, nodeID, status, version, http, tls, socks)
 }
 
-func (n nodeLookup) ResyncNode(ctx context.Context, nodeID int64) {
-	n.api.ResyncNode(ctx, nodeID)
+func (n nodeLookup) ResyncNode(ctx context.Context, nodeID int64, configHash string) {
+	n.api.ResyncNode(ctx, nodeID, configHash)
 }
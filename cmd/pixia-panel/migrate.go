@@ -0,0 +1,54 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"pixia-panel/internal/migrate"
+)
+
+const migrateUsage = "usage: pixia-panel migrate status|up|down"
+
+func runMigrateCommand(conn *sql.DB, dir string, args []string) error {
+	if len(args) != 1 {
+		return errors.New(migrateUsage)
+	}
+
+	switch args[0] {
+	case "status":
+		list, err := migrate.List(conn, dir)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+		for _, st := range list {
+			status, appliedAt := "pending", "-"
+			if st.Applied {
+				status = "applied"
+				appliedAt = time.UnixMilli(st.AppliedAt).Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%03d\t%s\t%s\t%s\n", st.Version, st.Name, status, appliedAt)
+		}
+		return w.Flush()
+	case "up":
+		return migrate.Apply(conn, dir)
+	case "down":
+		m, err := migrate.Rollback(conn, dir)
+		if err != nil {
+			return err
+		}
+		if m == nil {
+			fmt.Println("no applied migrations")
+			return nil
+		}
+		fmt.Printf("rolled back %03d_%s\n", m.Version, m.Name)
+		return nil
+	default:
+		return errors.New(migrateUsage)
+	}
+}
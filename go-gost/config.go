@@ -13,6 +13,9 @@ type Config struct {
 	Http   int    `json:"http"`
 	Tls    int    `json:"tls"`
 	Socks  int    `json:"socks"`
+
+	// EnrollToken 为安装脚本写入的一次性安装令牌，首次启动时用于换取 Secret
+	EnrollToken string `json:"enroll_token,omitempty"`
 }
 
 // LoadConfig 加载配置文件
@@ -38,6 +41,9 @@ func LoadConfig(configPath string) (*Config, error) {
 	if config.Addr == "" {
 		return nil, fmt.Errorf("面板地址不能为空")
 	}
+	if config.Secret == "" && config.EnrollToken == "" {
+		return nil, fmt.Errorf("节点密钥不能为空")
+	}
 
 	return &config, nil
 }
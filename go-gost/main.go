@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -10,6 +11,7 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/go-gost/core/logger"
 	xlogger "github.com/go-gost/x/logger"
@@ -116,6 +118,10 @@ func main() {
 
 	fmt.Printf("✅ 配置加载成功 - addr: %s\n", config.Addr)
 
+	if config.Secret == "" {
+		config.Secret = enroll(config)
+	}
+
 	log := xlogger.NewLogger()
 	logger.SetDefault(log)
 
@@ -129,5 +135,22 @@ func main() {
 	}
 }
 
+// enroll 用安装令牌换取节点密钥。网络错误时持续重试，令牌被拒绝则退出。
+func enroll(config *Config) string {
+	for {
+		secret, err := socket.Enroll(config.Addr, config.EnrollToken)
+		if err == nil {
+			fmt.Println("✅ 节点注册成功，密钥已写入 config.json")
+			return secret
+		}
+		fmt.Printf("❌ 节点注册失败: %v\n", err)
+		if errors.Is(err, socket.ErrEnrollRejected) {
+			fmt.Println("请在面板重新生成安装命令")
+			os.Exit(1)
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
 // GOOS=linux GOARCH=amd64 go build -ldflags="-s -w" -o gost
 // upx --best --lzma gost
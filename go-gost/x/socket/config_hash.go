@@ -0,0 +1,197 @@
+package socket
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-gost/x/config"
+)
+
+// 以下结构与计算方式必须与面板 internal/gost/state.go 保持一致：
+// 面板按同样的方式计算期望配置的哈希，两者相同时重连不再重新下发配置。
+
+type hashServiceSpec struct {
+	Name      string             `json:"name"`
+	Addr      string             `json:"addr,omitempty"`
+	Limiter   string             `json:"limiter,omitempty"`
+	Handler   *hashHandlerSpec   `json:"handler,omitempty"`
+	Listener  *hashTypeSpec      `json:"listener,omitempty"`
+	Forwarder *hashForwarderSpec `json:"forwarder,omitempty"`
+	Metadata  map[string]any     `json:"metadata,omitempty"`
+}
+
+type hashHandlerSpec struct {
+	Type    string `json:"type"`
+	Chain   string `json:"chain,omitempty"`
+	Limiter string `json:"limiter,omitempty"`
+}
+
+type hashForwarderSpec struct {
+	Nodes []struct {
+		Name string `json:"name,omitempty"`
+		Addr string `json:"addr,omitempty"`
+	} `json:"nodes"`
+	Selector *struct {
+		Strategy    string `json:"strategy"`
+		MaxFails    int    `json:"maxFails"`
+		FailTimeout any    `json:"failTimeout,omitempty"`
+	} `json:"selector,omitempty"`
+}
+
+type hashChainSpec struct {
+	Name string `json:"name"`
+	Hops []struct {
+		Name  string `json:"name"`
+		Nodes []struct {
+			Name      string        `json:"name"`
+			Addr      string        `json:"addr,omitempty"`
+			Interface string        `json:"interface,omitempty"`
+			Connector *hashTypeSpec `json:"connector,omitempty"`
+			Dialer    *hashTypeSpec `json:"dialer,omitempty"`
+		} `json:"nodes,omitempty"`
+	} `json:"hops"`
+}
+
+type hashTypeSpec struct {
+	Type     string         `json:"type"`
+	Metadata map[string]any `json:"metadata,omitempty"`
+}
+
+type hashLimiterSpec struct {
+	Name   string   `json:"name"`
+	Limits []string `json:"limits,omitempty"`
+}
+
+// managedConfigHash 计算面板管理的服务、链和限速器的哈希，连接面板时随 config_hash 参数上报
+func managedConfigHash() string {
+	return configHash(config.Global())
+}
+
+func configHash(cfg *config.Config) string {
+	var lines []string
+
+	for _, svc := range cfg.Services {
+		if svc == nil || !isManagedName(svc.Name, "tcp", "udp", "tls") {
+			continue
+		}
+		data, err := json.Marshal(svc)
+		if err != nil {
+			continue
+		}
+		var spec hashServiceSpec
+		if err := json.Unmarshal(data, &spec); err != nil {
+			continue
+		}
+		// 先统一小写再取出 paused 标记，"Paused" 等写法同样视为暂停标记而不计入摘要
+		spec.Metadata = lowerKeys(spec.Metadata)
+		paused, _ := spec.Metadata["paused"].(bool)
+		if _, ok := spec.Metadata["paused"]; ok {
+			delete(spec.Metadata, "paused")
+			if len(spec.Metadata) == 0 {
+				spec.Metadata = nil
+			}
+		}
+		if spec.Listener != nil {
+			spec.Listener.Metadata = lowerKeys(spec.Listener.Metadata)
+		}
+		if spec.Forwarder != nil && spec.Forwarder.Selector != nil {
+			spec.Forwarder.Selector.FailTimeout = durationNanos(spec.Forwarder.Selector.FailTimeout)
+		}
+		line := "service:" + spec.Name + " " + specDigest(spec)
+		if paused {
+			line += " paused"
+		}
+		lines = append(lines, line)
+	}
+
+	for _, chain := range cfg.Chains {
+		if chain == nil || !isManagedName(chain.Name, "chains") {
+			continue
+		}
+		data, err := json.Marshal(chain)
+		if err != nil {
+			continue
+		}
+		var spec hashChainSpec
+		if err := json.Unmarshal(data, &spec); err != nil {
+			continue
+		}
+		for _, hop := range spec.Hops {
+			for _, node := range hop.Nodes {
+				for _, t := range []*hashTypeSpec{node.Connector, node.Dialer} {
+					if t != nil {
+						t.Metadata = lowerKeys(t.Metadata)
+					}
+				}
+			}
+		}
+		lines = append(lines, "chain:"+spec.Name+" "+specDigest(spec))
+	}
+
+	for _, limiter := range cfg.Limiters {
+		if limiter == nil {
+			continue
+		}
+		if _, err := strconv.ParseInt(limiter.Name, 10, 64); err != nil {
+			continue
+		}
+		spec := hashLimiterSpec{Name: limiter.Name, Limits: limiter.Limits}
+		lines = append(lines, "limiter:"+spec.Name+" "+specDigest(spec))
+	}
+
+	sort.Strings(lines)
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// isManagedName 判断名称是否为面板创建的 "转发ID_用户ID_用户隧道ID_类型" 格式
+func isManagedName(name string, types ...string) bool {
+	parts := strings.Split(name, "_")
+	if len(parts) < 4 {
+		return false
+	}
+	if id, err := strconv.ParseInt(parts[0], 10, 64); err != nil || id <= 0 {
+		return false
+	}
+	for _, t := range types {
+		if parts[3] == t {
+			return true
+		}
+	}
+	return false
+}
+
+// lowerKeys 将 metadata 的键统一为小写（从文件加载的配置中键名已被转为小写）
+func lowerKeys(m map[string]any) map[string]any {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]any, len(m))
+	for k, v := range m {
+		out[strings.ToLower(k)] = v
+	}
+	return out
+}
+
+func durationNanos(v any) any {
+	switch t := v.(type) {
+	case string:
+		if d, err := time.ParseDuration(strings.TrimSpace(t)); err == nil {
+			return int64(d)
+		}
+	case float64:
+		return int64(t)
+	}
+	return v
+}
+
+func specDigest(spec any) string {
+	b, _ := json.Marshal(spec)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:16])
+}
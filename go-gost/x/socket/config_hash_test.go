@@ -0,0 +1,45 @@
+package socket
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/go-gost/x/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// configHashGolden 为 testdata/config_hash.json 的哈希，面板 internal/gost/state_test.go
+// 对同一份配置校验同一个值，任何一侧破坏一致性都会导致其中一个测试失败
+const configHashGolden = "d5ad552d83f7a7a25c698e31d3786e50e84145ade22e2ff9864ac79048e6ccdf"
+
+func loadHashConfig(t *testing.T) *config.Config {
+	data, err := os.ReadFile("testdata/config_hash.json")
+	require.NoError(t, err)
+	var cfg config.Config
+	require.NoError(t, json.Unmarshal(data, &cfg))
+	return &cfg
+}
+
+func TestConfigHashGolden(t *testing.T) {
+	assert.Equal(t, configHashGolden, configHash(loadHashConfig(t)))
+}
+
+func TestConfigHashIgnoresUnmanaged(t *testing.T) {
+	cfg := loadHashConfig(t)
+	cfg.Services = append(cfg.Services, &config.ServiceConfig{Name: "web_api", Addr: ":18080"})
+	cfg.Chains = append(cfg.Chains, &config.ChainConfig{Name: "custom"})
+	cfg.Limiters = append(cfg.Limiters, &config.LimiterConfig{Name: "custom"})
+	assert.Equal(t, configHashGolden, configHash(cfg))
+}
+
+func TestConfigHashPausedFlag(t *testing.T) {
+	cfg := loadHashConfig(t)
+	for _, svc := range cfg.Services {
+		if svc.Name == "1_2_3_tcp" {
+			delete(svc.Metadata, "Paused")
+		}
+	}
+	assert.NotEqual(t, configHashGolden, configHash(cfg))
+}
@@ -0,0 +1,67 @@
+package socket
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrEnrollRejected 表示面板拒绝了安装令牌（无效、已使用或已过期），重试无意义
+var ErrEnrollRejected = errors.New("安装令牌无效或已过期")
+
+// Enroll 使用一次性安装令牌向面板换取节点密钥，并写入 config.json（同时移除令牌）
+func Enroll(addr, token string) (string, error) {
+	info := parsePanelAddr(addr)
+	if info.host == "" {
+		return "", fmt.Errorf("面板地址不能为空")
+	}
+	scheme := "http"
+	if wsSchemeFromPanel(info.scheme) == "wss" {
+		scheme = "https"
+	}
+	u := url.URL{
+		Scheme: scheme,
+		Host:   info.host,
+		Path:   joinPath(info.basePath, "/flow/enroll"),
+	}
+
+	body, err := json.Marshal(map[string]string{"token": token})
+	if err != nil {
+		return "", err
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(u.String(), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("请求面板失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+		Data struct {
+			Secret string `json:"secret"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&result); err != nil {
+		return "", fmt.Errorf("解析面板响应失败: %v", err)
+	}
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusBadRequest {
+		return "", fmt.Errorf("%w: %s", ErrEnrollRejected, result.Msg)
+	}
+	secret := strings.TrimSpace(result.Data.Secret)
+	if resp.StatusCode != http.StatusOK || result.Code != 0 || secret == "" {
+		return "", fmt.Errorf("面板返回异常: %d %s", resp.StatusCode, result.Msg)
+	}
+
+	if err := updateLocalSecretJSON(secret); err != nil {
+		return "", fmt.Errorf("写入config.json失败: %v", err)
+	}
+	return secret, nil
+}
@@ -197,6 +197,7 @@ type WebSocketReporter struct {
 	connecting     bool              // 新增：正在连接状态
 	connMutex      sync.Mutex        // 新增：连接状态锁
 	aesCrypto      *crypto.AESCrypto // 新增：AES加密器
+	prevCrypto     *crypto.AESCrypto // 密钥轮换前的加密器，用于解密轮换途中的消息
 }
 
 // NewWebSocketReporter 创建一个新的WebSocket报告器
@@ -336,6 +337,7 @@ func (w *WebSocketReporter) connect() error {
 	query.Set("http", strconv.Itoa(cfg.Http))
 	query.Set("tls", strconv.Itoa(cfg.Tls))
 	query.Set("socks", strconv.Itoa(cfg.Socks))
+	query.Set("config_hash", managedConfigHash())
 	u := url.URL{
 		Scheme:   scheme,
 		Host:     info.host,
@@ -540,9 +542,15 @@ func (w *WebSocketReporter) handleReceivedMessage(messageType int, message []byt
 
 		// 尝试解析为加密消息格式
 		if err := json.Unmarshal(message, &encryptedWrapper); err == nil && encryptedWrapper.Encrypted {
-			if w.aesCrypto != nil {
-				// 解密数据
-				decryptedData, err := w.aesCrypto.Decrypt(encryptedWrapper.Data)
+			w.connMutex.Lock()
+			aesCrypto, prevCrypto := w.aesCrypto, w.prevCrypto
+			w.connMutex.Unlock()
+			if aesCrypto != nil {
+				// 解密数据，失败时尝试轮换前的密钥
+				decryptedData, err := aesCrypto.Decrypt(encryptedWrapper.Data)
+				if err != nil && prevCrypto != nil {
+					decryptedData, err = prevCrypto.Decrypt(encryptedWrapper.Data)
+				}
 				if err != nil {
 					fmt.Printf("❌ 解密失败: %v\n", err)
 					w.sendErrorResponse("DecryptError", fmt.Sprintf("解密失败: %v", err))
@@ -619,7 +627,12 @@ func (w *WebSocketReporter) handleReceivedMessage(messageType int, message []byt
 
 // routeCommand 路由命令到对应的处理函数
 func (w *WebSocketReporter) routeCommand(cmd CommandMessage) {
-	jsonBytes, errs := json.Marshal(cmd)
+	logged := cmd
+	if cmd.Type == "RotateSecret" {
+		// 新密钥不能出现在日志中
+		logged.Data = "[REDACTED]"
+	}
+	jsonBytes, errs := json.Marshal(logged)
 	if errs != nil {
 		fmt.Println("Error marshaling JSON:", errs)
 		return
@@ -684,6 +697,11 @@ func (w *WebSocketReporter) routeCommand(cmd CommandMessage) {
 		err = w.handleSetProtocol(cmd.Data)
 		response.Type = "SetProtocolResponse"
 
+	// 节点密钥轮换
+	case "RotateSecret":
+		err = w.handleRotateSecret(cmd.Data)
+		response.Type = "RotateSecretResponse"
+
 	default:
 		err = fmt.Errorf("未知命令类型: %s", cmd.Type)
 		response.Type = "UnknownCommandResponse"
@@ -1054,6 +1072,75 @@ func updateLocalConfigJSON(httpVal int, tlsVal int, socksVal int) error {
 	return os.WriteFile(path, data, 0644)
 }
 
+// handleRotateSecret 处理面板下发的新密钥：写入 config.json 后切换加密器和上报地址。
+// 旧加密器保留用于解密轮换途中仍以旧密钥加密的消息。
+func (w *WebSocketReporter) handleRotateSecret(data interface{}) error {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("序列化密钥数据失败: %v", err)
+	}
+	var req struct {
+		Secret string `json:"secret"`
+	}
+	if err := json.Unmarshal(jsonData, &req); err != nil {
+		return fmt.Errorf("解析密钥数据失败: %v", err)
+	}
+	secret := strings.TrimSpace(req.Secret)
+	if secret == "" {
+		return fmt.Errorf("密钥不能为空")
+	}
+
+	aesCrypto, err := crypto.NewAESCrypto(secret)
+	if err != nil {
+		return fmt.Errorf("创建 AES 加密器失败: %v", err)
+	}
+	if err := updateLocalSecretJSON(secret); err != nil {
+		return fmt.Errorf("写入config.json失败: %v", err)
+	}
+
+	w.connMutex.Lock()
+	w.prevCrypto = w.aesCrypto
+	w.aesCrypto = aesCrypto
+	w.secret = secret
+	addr := w.addr
+	w.connMutex.Unlock()
+
+	service.SetHTTPReportURL(addr, secret)
+	fmt.Printf("🔑 节点密钥已更新\n")
+	return nil
+}
+
+// updateLocalSecretJSON 将新密钥写入工作目录下的 config.json 并移除已用过的安装令牌，
+// 其余字段原样保留
+func updateLocalSecretJSON(secret string) error {
+	path := "config.json"
+
+	cfg := map[string]interface{}{}
+	b, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(b, &cfg); err != nil {
+			return err
+		}
+	case !os.IsNotExist(err):
+		// 读取失败时不能用只含密钥的配置覆盖原文件
+		return err
+	}
+	cfg["secret"] = secret
+	delete(cfg, "enroll_token")
+
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	// 先写临时文件再替换，避免写到一半断电导致配置损坏
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
 // handleCall 处理服务端的call回调消息
 func (w *WebSocketReporter) handleCall(data interface{}) error {
 	// 解析call数据
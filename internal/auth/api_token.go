@@ -0,0 +1,20 @@
+package auth
+
+import "strings"
+
+// APITokenPrefix marks personal API tokens so they can be told apart from JWTs.
+const APITokenPrefix = "pxp_"
+
+// GenerateAPIToken returns a new random token and the short prefix shown in
+// listings to identify it.
+func GenerateAPIToken() (token, prefix string, err error) {
+	token, err = randomToken(APITokenPrefix)
+	if err != nil {
+		return "", "", err
+	}
+	return token, token[:len(APITokenPrefix)+8], nil
+}
+
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, APITokenPrefix)
+}
@@ -0,0 +1,9 @@
+package auth
+
+// EnrollTokenPrefix marks one-time node enrollment tokens.
+const EnrollTokenPrefix = "pxe_"
+
+// GenerateEnrollToken returns a new random node enrollment token.
+func GenerateEnrollToken() (string, error) {
+	return randomToken(EnrollTokenPrefix)
+}
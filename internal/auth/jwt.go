@@ -10,14 +10,29 @@ import (
 type Claims struct {
 	UserID int64 `json:"user_id"`
 	RoleID int64 `json:"role_id"`
+	// Purpose is empty for session tokens and names the step for
+	// intermediate login tokens, which Parse never accepts.
+	Purpose string `json:"purpose,omitempty"`
+	// SessionID binds a session token to its row in user_session so it can be revoked.
+	SessionID int64 `json:"sid,omitempty"`
+	// MustChangePassword marks sessions that may only change the password.
+	MustChangePassword bool `json:"mcp,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func Sign(secret []byte, userID, roleID int64, ttl time.Duration) (string, error) {
+// Purposes of intermediate login tokens.
+const (
+	PurposeTwoFactor      = "2fa"
+	PurposeTwoFactorSetup = "2fa_setup"
+)
+
+func Sign(secret []byte, userID, roleID, sessionID int64, mustChangePassword bool, ttl time.Duration) (string, error) {
 	now := time.Now()
 	claims := Claims{
-		UserID: userID,
-		RoleID: roleID,
+		UserID:             userID,
+		RoleID:             roleID,
+		SessionID:          sessionID,
+		MustChangePassword: mustChangePassword,
 		RegisteredClaims: jwt.RegisteredClaims{
 			IssuedAt: jwt.NewNumericDate(now),
 			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
@@ -29,6 +44,46 @@ func Sign(secret []byte, userID, roleID int64, ttl time.Duration) (string, error
 }
 
 func Parse(secret []byte, tokenStr string) (*Claims, error) {
+	claims, err := parseClaims(secret, tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != "" {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+// SignChallenge issues a short-lived token that only proves the first login
+// factor for purpose; it cannot be used as a session token.
+func SignChallenge(secret []byte, userID, roleID int64, purpose string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := Claims{
+		UserID:  userID,
+		RoleID:  roleID,
+		Purpose: purpose,
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(secret)
+}
+
+// ParseChallenge validates a token issued by SignChallenge for purpose.
+func ParseChallenge(secret []byte, tokenStr, purpose string) (*Claims, error) {
+	claims, err := parseClaims(secret, tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	if purpose == "" || claims.Purpose != purpose {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+func parseClaims(secret []byte, tokenStr string) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		if token.Method != jwt.SigningMethodHS256 {
 			return nil, errors.New("unexpected signing method")
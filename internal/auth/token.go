@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// RefreshTokenPrefix marks session refresh tokens.
+const RefreshTokenPrefix = "pxr_"
+
+// GenerateRefreshToken returns a new random refresh token.
+func GenerateRefreshToken() (string, error) {
+	return randomToken(RefreshTokenPrefix)
+}
+
+// HashToken returns the value stored for a random token such as an API or
+// refresh token; the tokens carry 256 bits of entropy, so a plain SHA-256 is
+// enough.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomToken(prefix string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,144 @@
+package captcha
+
+import (
+	"bytes"
+	"crypto/rand"
+	"image"
+	"image/color"
+	"image/png"
+	"math/big"
+	mrand "math/rand/v2"
+)
+
+// Image dimensions and glyph scale of rendered challenges.
+const (
+	ImageWidth  = 150
+	ImageHeight = 50
+	glyphScale  = 4
+)
+
+// CodeLength is the number of digits in an image challenge.
+const CodeLength = 5
+
+// digitGlyphs are 5x7 bitmaps of the digits 0-9, one row per byte with the
+// leftmost pixel in bit 4.
+var digitGlyphs = [10][7]byte{
+	{0x0e, 0x11, 0x13, 0x15, 0x19, 0x11, 0x0e},
+	{0x04, 0x0c, 0x04, 0x04, 0x04, 0x04, 0x0e},
+	{0x0e, 0x11, 0x01, 0x02, 0x04, 0x08, 0x1f},
+	{0x1f, 0x02, 0x04, 0x02, 0x01, 0x11, 0x0e},
+	{0x02, 0x06, 0x0a, 0x12, 0x1f, 0x02, 0x02},
+	{0x1f, 0x10, 0x1e, 0x01, 0x01, 0x11, 0x0e},
+	{0x06, 0x08, 0x10, 0x1e, 0x11, 0x11, 0x0e},
+	{0x1f, 0x01, 0x02, 0x04, 0x08, 0x08, 0x08},
+	{0x0e, 0x11, 0x11, 0x0e, 0x11, 0x11, 0x0e},
+	{0x0e, 0x11, 0x11, 0x0f, 0x01, 0x02, 0x0c},
+}
+
+// RandomCode returns a random code of CodeLength digits.
+func RandomCode() (string, error) {
+	b := make([]byte, CodeLength)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+		b[i] = byte('0' + n.Int64())
+	}
+	return string(b), nil
+}
+
+// RenderPNG draws code, which must consist of digits, as a distorted PNG
+// image with noise lines and dots.
+func RenderPNG(code string) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, ImageWidth, ImageHeight))
+	bg := color.RGBA{R: 235 + uint8(mrand.IntN(20)), G: 235 + uint8(mrand.IntN(20)), B: 235 + uint8(mrand.IntN(20)), A: 255}
+	for y := 0; y < ImageHeight; y++ {
+		for x := 0; x < ImageWidth; x++ {
+			img.SetRGBA(x, y, bg)
+		}
+	}
+
+	for i := 0; i < 120; i++ {
+		img.SetRGBA(mrand.IntN(ImageWidth), mrand.IntN(ImageHeight), randomColor(0, 160))
+	}
+
+	glyphW := 5 * glyphScale
+	step := (ImageWidth - 10) / max(len(code), 1)
+	for i, c := range code {
+		if c < '0' || c > '9' {
+			continue
+		}
+		x0 := 5 + i*step + mrand.IntN(max(step-glyphW, 1))
+		y0 := 4 + mrand.IntN(ImageHeight-7*glyphScale-8)
+		// Shear each glyph a little so the digits are not pixel-identical
+		// between images.
+		shear := mrand.IntN(5) - 2
+		drawGlyph(img, digitGlyphs[c-'0'], x0, y0, shear, randomColor(0, 90))
+	}
+
+	for i := 0; i < 4; i++ {
+		drawLine(img, mrand.IntN(ImageWidth), mrand.IntN(ImageHeight), mrand.IntN(ImageWidth), mrand.IntN(ImageHeight), randomColor(110, 200))
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func drawGlyph(img *image.RGBA, glyph [7]byte, x0, y0, shear int, ink color.RGBA) {
+	for row, bits := range glyph {
+		offset := shear * (3 - row)
+		for col := 0; col < 5; col++ {
+			if bits&(0x10>>col) == 0 {
+				continue
+			}
+			for dy := 0; dy < glyphScale; dy++ {
+				for dx := 0; dx < glyphScale; dx++ {
+					img.SetRGBA(x0+offset+col*glyphScale+dx, y0+row*glyphScale+dy, ink)
+				}
+			}
+		}
+	}
+}
+
+func drawLine(img *image.RGBA, x0, y0, x1, y1 int, ink color.RGBA) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	e := dx + dy
+	for {
+		img.SetRGBA(x0, y0, ink)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+// randomColor returns a random opaque color with channels in [lo, hi).
+func randomColor(lo, hi int) color.RGBA {
+	return color.RGBA{R: uint8(lo + mrand.IntN(hi-lo)), G: uint8(lo + mrand.IntN(hi-lo)), B: uint8(lo + mrand.IntN(hi-lo)), A: 255}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,132 @@
+package captcha
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrStoreFull is returned when too many challenges are outstanding.
+	ErrStoreFull = errors.New("captcha store full")
+	// ErrClientLimit is returned when one client has too many challenges
+	// outstanding.
+	ErrClientLimit = errors.New("too many captchas for client")
+)
+
+// Store keeps outstanding challenges in memory until they are checked or
+// expire. Every challenge can be checked once, right or wrong, so answers
+// cannot be guessed against the same image. Challenges are counted per client
+// so a single client cannot fill the store and lock everyone else out.
+type Store struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	max       int
+	maxClient int
+	entries   map[string]entry
+	clients   map[string]int
+}
+
+type entry struct {
+	client  string
+	answer  string
+	passed  bool
+	expires time.Time
+}
+
+// NewStore returns a store whose challenges live for ttl, holding at most max
+// of them at a time and at most maxClient for any one client.
+func NewStore(ttl time.Duration, max, maxClient int) *Store {
+	return &Store{ttl: ttl, max: max, maxClient: maxClient,
+		entries: make(map[string]entry), clients: make(map[string]int)}
+}
+
+// TTL returns how long challenges stay valid.
+func (s *Store) TTL() time.Duration {
+	return s.ttl
+}
+
+// Put stores a challenge with answer for client and returns its ID.
+func (s *Store) Put(client, answer string) (string, error) {
+	return s.put(entry{client: client, answer: answer})
+}
+
+// Pass stores an already solved challenge for client and returns its ID,
+// which Check accepts with any answer. It lets a client solve the challenge
+// first and present the returned token with the login later.
+func (s *Store) Pass(client string) (string, error) {
+	return s.put(entry{client: client, passed: true})
+}
+
+func (s *Store) put(e entry) (string, error) {
+	id, err := randomID()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	e.expires = now.Add(s.ttl)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.entries) >= s.max || s.clients[e.client] >= s.maxClient {
+		s.evictExpired(now)
+		if s.clients[e.client] >= s.maxClient {
+			return "", ErrClientLimit
+		}
+		if len(s.entries) >= s.max {
+			return "", ErrStoreFull
+		}
+	}
+	s.entries[id] = e
+	s.clients[e.client]++
+	return id, nil
+}
+
+// Check consumes the challenge id and reports whether answer solves it.
+// Answers are compared case-insensitively.
+func (s *Store) Check(id, answer string) bool {
+	if id == "" {
+		return false
+	}
+	s.mu.Lock()
+	e, ok := s.entries[id]
+	if ok {
+		s.remove(id, e)
+	}
+	s.mu.Unlock()
+	if !ok || time.Now().After(e.expires) {
+		return false
+	}
+	if e.passed {
+		return true
+	}
+	return answer != "" && strings.EqualFold(strings.TrimSpace(answer), e.answer)
+}
+
+func (s *Store) evictExpired(now time.Time) {
+	for id, e := range s.entries {
+		if now.After(e.expires) {
+			s.remove(id, e)
+		}
+	}
+}
+
+func (s *Store) remove(id string, e entry) {
+	delete(s.entries, id)
+	if s.clients[e.client] <= 1 {
+		delete(s.clients, e.client)
+	} else {
+		s.clients[e.client]--
+	}
+}
+
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,120 @@
+package captcha
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStoreCheckOnce(t *testing.T) {
+	s := NewStore(time.Minute, 10, 10)
+	id, err := s.Put("client", "AbC1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Check(id, " abc1 ") {
+		t.Fatal("right answer rejected")
+	}
+	if s.Check(id, "abc1") {
+		t.Fatal("challenge checked twice")
+	}
+
+	id, err = s.Put("client", "AbC1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Check(id, "wrong") {
+		t.Fatal("wrong answer accepted")
+	}
+	if s.Check(id, "abc1") {
+		t.Fatal("challenge still valid after a wrong answer")
+	}
+	if s.Check("", "") || s.Check("unknown", "abc1") {
+		t.Fatal("unknown challenge accepted")
+	}
+}
+
+func TestStoreEmptyAnswer(t *testing.T) {
+	s := NewStore(time.Minute, 10, 10)
+	id, err := s.Put("client", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Check(id, "") {
+		t.Fatal("empty answer accepted")
+	}
+}
+
+func TestStorePass(t *testing.T) {
+	s := NewStore(time.Minute, 10, 10)
+	id, err := s.Pass("client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Check(id, "") {
+		t.Fatal("pass token rejected")
+	}
+	if s.Check(id, "") {
+		t.Fatal("pass token used twice")
+	}
+}
+
+func TestStoreExpiry(t *testing.T) {
+	s := NewStore(10*time.Millisecond, 10, 10)
+	id, err := s.Put("client", "abc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pass, err := s.Pass("client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if s.Check(id, "abc1") {
+		t.Fatal("expired challenge accepted")
+	}
+	if s.Check(pass, "") {
+		t.Fatal("expired pass token accepted")
+	}
+}
+
+func TestStoreLimits(t *testing.T) {
+	s := NewStore(time.Minute, 3, 2)
+	for i := 0; i < 2; i++ {
+		if _, err := s.Put("a", "x"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Put("a", "x"); !errors.Is(err, ErrClientLimit) {
+		t.Fatalf("third put for a = %v, want ErrClientLimit", err)
+	}
+	id, err := s.Put("b", "x")
+	if err != nil {
+		t.Fatalf("put for b = %v", err)
+	}
+	if _, err := s.Put("c", "x"); !errors.Is(err, ErrStoreFull) {
+		t.Fatalf("put for c = %v, want ErrStoreFull", err)
+	}
+
+	// Checking a challenge frees its slot, in the store and for its client.
+	s.Check(id, "wrong")
+	if _, err := s.Put("c", "x"); err != nil {
+		t.Fatalf("put for c after check = %v", err)
+	}
+}
+
+func TestStoreEvictsExpired(t *testing.T) {
+	s := NewStore(10*time.Millisecond, 2, 2)
+	for i := 0; i < 2; i++ {
+		if _, err := s.Put("a", "x"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, err := s.Put("a", "x"); err != nil {
+		t.Fatalf("put after expiry = %v", err)
+	}
+	if _, err := s.Put("b", "x"); err != nil {
+		t.Fatalf("put for b after expiry = %v", err)
+	}
+}
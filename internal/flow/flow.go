@@ -3,29 +3,292 @@ package flow
 import (
 	"context"
 	"errors"
+	"log"
+	"math"
+	"sync"
+	"time"
 
 	"pixia-panel/internal/store"
 )
 
+// Tunnel billing directions stored in tunnel.flow. The panel shows in_flow as
+// upload (上传) and out_flow as download (下载).
+const (
+	BillingSingle int64 = 1 // only d (in_flow, upload) is billed
+	BillingDouble int64 = 2 // both d and u are billed
+)
+
+// Options configures the ingestion buffer.
+type Options struct {
+	FlushInterval time.Duration
+	MaxPending    int
+	// MaxBuffered caps the entries kept in the buffer while the store keeps
+	// failing; updates that no longer fit are dropped.
+	MaxBuffered int
+}
+
+// FlushHook is called after each flush with the deltas that were applied.
+type FlushHook func(ctx context.Context, applied []store.FlowDelta)
+
 type Service struct {
-	store *store.Store
+	store   *store.Store
+	opts    Options
+	flushCh chan struct{}
+
+	mu      sync.Mutex
+	pending map[bufferKey]*Update
+	onFlush FlushHook
+
+	flushMu sync.Mutex
 }
 
-func New(store *store.Store) *Service {
-	return &Service{store: store}
+type bufferKey struct {
+	forwardID    int64
+	userID       int64
+	userTunnelID int64
+	nodeID       int64
+	hour         int64
 }
 
+func New(store *store.Store, opts Options) *Service {
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = 2 * time.Second
+	}
+	if opts.MaxPending <= 0 {
+		opts.MaxPending = 500
+	}
+	if opts.MaxBuffered < opts.MaxPending {
+		opts.MaxBuffered = 20 * opts.MaxPending
+	}
+	return &Service{
+		store:   store,
+		opts:    opts,
+		flushCh: make(chan struct{}, 1),
+		pending: make(map[bufferKey]*Update),
+	}
+}
+
+// Update is one traffic report. NodeID is the reporting node; zero means the
+// tunnel's entry node.
 type Update struct {
 	ForwardID    int64
 	UserID       int64
 	UserTunnelID int64
+	NodeID       int64
 	Down         int64
 	Up           int64
+	Time         int64
 }
 
-func (s *Service) Apply(ctx context.Context, update Update) error {
+// SetFlushHook registers the function run once per flush, e.g. quota checks.
+func (s *Service) SetFlushHook(hook FlushHook) {
+	s.mu.Lock()
+	s.onFlush = hook
+	s.mu.Unlock()
+}
+
+// Add buffers a flow update until the next flush. Updates for the same
+// forward, user tunnel, node and hour are merged.
+func (s *Service) Add(update Update) error {
 	if update.ForwardID == 0 || update.UserID == 0 {
 		return errors.New("invalid flow update: missing IDs")
 	}
-	return s.store.ApplyFlow(ctx, update.ForwardID, update.UserID, update.UserTunnelID, update.Down, update.Up)
+	if update.Time == 0 {
+		update.Time = time.Now().UnixMilli()
+	}
+	key := bufferKey{
+		forwardID:    update.ForwardID,
+		userID:       update.UserID,
+		userTunnelID: update.UserTunnelID,
+		nodeID:       update.NodeID,
+		hour:         store.HourStart(time.UnixMilli(update.Time)).UnixMilli(),
+	}
+
+	s.mu.Lock()
+	if cur, ok := s.pending[key]; ok {
+		cur.Down += update.Down
+		cur.Up += update.Up
+	} else {
+		u := update
+		s.pending[key] = &u
+	}
+	full := len(s.pending) >= s.opts.MaxPending
+	s.mu.Unlock()
+
+	if full {
+		select {
+		case s.flushCh <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Run flushes the buffer every FlushInterval or once MaxPending entries are
+// buffered. When ctx is done it flushes whatever is left and returns the
+// error of that last flush, if any.
+func (s *Service) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.opts.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return s.Flush(context.Background())
+		case <-ticker.C:
+		case <-s.flushCh:
+		}
+		if err := s.Flush(ctx); err != nil {
+			log.Printf("flow flush failed: %v", err)
+		}
+	}
+}
+
+// Flush applies all buffered updates in one transaction and then runs the
+// flush hook. On a store error the updates are put back into the buffer.
+func (s *Service) Flush(ctx context.Context) error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	batch := s.pending
+	s.pending = make(map[bufferKey]*Update)
+	hook := s.onFlush
+	s.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	deltas, err := s.resolve(ctx, batch)
+	if err != nil {
+		s.requeue(batch)
+		return err
+	}
+	applied, err := s.store.ApplyFlows(ctx, deltas)
+	if err != nil {
+		s.requeue(batch)
+		return err
+	}
+	if hook != nil && len(applied) > 0 {
+		hook(ctx, applied)
+	}
+	return nil
+}
+
+// Reset runs fn, which replaces the data the buffered updates were meant for
+// such as a database restore, while no flush can run. Updates buffered before
+// fn are dropped if it succeeds and kept otherwise.
+func (s *Service) Reset(fn func() error) error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	batch := s.pending
+	s.pending = make(map[bufferKey]*Update)
+	s.mu.Unlock()
+	if err := fn(); err != nil {
+		s.requeue(batch)
+		return err
+	}
+	return nil
+}
+
+// resolve bills buffered updates against their tunnels. Updates whose
+// forward or tunnel no longer exists are dropped.
+func (s *Service) resolve(ctx context.Context, batch map[bufferKey]*Update) ([]store.FlowDelta, error) {
+	forwards := make(map[int64]*store.Forward)
+	tunnels := make(map[int64]*store.Tunnel)
+	deltas := make([]store.FlowDelta, 0, len(batch))
+	for _, update := range batch {
+		fw, ok := forwards[update.ForwardID]
+		if !ok {
+			var err error
+			fw, err = s.store.GetForwardByID(ctx, update.ForwardID)
+			if err != nil && !errors.Is(err, store.ErrNotFound) {
+				return nil, err
+			}
+			forwards[update.ForwardID] = fw
+		}
+		if fw == nil {
+			continue
+		}
+		tunnel, ok := tunnels[fw.TunnelID]
+		if !ok {
+			var err error
+			tunnel, err = s.store.GetTunnelByID(ctx, fw.TunnelID)
+			if err != nil && !errors.Is(err, store.ErrNotFound) {
+				return nil, err
+			}
+			tunnels[fw.TunnelID] = tunnel
+		}
+		if tunnel == nil {
+			continue
+		}
+
+		nodeID := update.NodeID
+		if nodeID == 0 {
+			nodeID = tunnel.InNodeID
+		}
+		billedDown, billedUp := Bill(tunnel, update.Down, update.Up)
+		deltas = append(deltas, store.FlowDelta{
+			ForwardID:    update.ForwardID,
+			UserID:       update.UserID,
+			UserTunnelID: update.UserTunnelID,
+			TunnelID:     tunnel.ID,
+			NodeID:       nodeID,
+			Down:         update.Down,
+			Up:           update.Up,
+			BilledDown:   billedDown,
+			BilledUp:     billedUp,
+			Time:         update.Time,
+		})
+	}
+	return deltas, nil
+}
+
+// requeue puts a failed batch back into the buffer, merging it with updates
+// that arrived meanwhile. Entries beyond MaxBuffered are dropped and logged.
+func (s *Service) requeue(batch map[bufferKey]*Update) {
+	s.mu.Lock()
+	dropped := 0
+	for key, update := range batch {
+		if cur, ok := s.pending[key]; ok {
+			cur.Down += update.Down
+			cur.Up += update.Up
+			continue
+		}
+		if len(s.pending) >= s.opts.MaxBuffered {
+			dropped++
+			continue
+		}
+		s.pending[key] = update
+	}
+	s.mu.Unlock()
+	if dropped > 0 {
+		log.Printf("flow buffer full, dropped %d updates", dropped)
+	}
+}
+
+// Bill applies the tunnel traffic ratio and billing direction to raw bytes.
+func Bill(tunnel *store.Tunnel, down, up int64) (int64, int64) {
+	if tunnel == nil {
+		return down, up
+	}
+	ratio := tunnel.TrafficRatio
+	if ratio <= 0 {
+		ratio = 1
+	}
+	billedDown := scale(down, ratio)
+	billedUp := scale(up, ratio)
+	if tunnel.Flow == BillingSingle {
+		billedUp = 0
+	}
+	return billedDown, billedUp
+}
+
+func scale(v int64, ratio float64) int64 {
+	if ratio == 1 {
+		return v
+	}
+	return int64(math.Round(float64(v) * ratio))
 }
@@ -0,0 +1,240 @@
+package flow
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"pixia-panel/internal/db"
+	"pixia-panel/internal/migrate"
+	"pixia-panel/internal/store"
+)
+
+// newTestService returns a service on a fresh database holding tunnel 1 from
+// node 1 to node 2, billed in both directions at ratio 2, and forward 1 of
+// user 1 on it with user tunnel 1.
+func newTestService(t *testing.T, opts Options) (*Service, *store.Store) {
+	t.Helper()
+	conn, err := db.Open(filepath.Join(t.TempDir(), "panel.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if err := migrate.Apply(conn, filepath.Join("..", "..", "migrations")); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO node(id, name, secret, server_ip, port_sta, port_end, created_time, status) VALUES (1, 'in', 's1', '192.0.2.1', 1000, 2000, 0, 1), (2, 'out', 's2', '192.0.2.2', 1000, 2000, 0, 1)`,
+		`INSERT INTO tunnel(id, name, traffic_ratio, in_node_id, in_ip, out_node_id, out_ip, type, flow, created_time, updated_time, status) VALUES (1, 't', 2, 1, '192.0.2.1', 2, '192.0.2.2', 2, 2, 0, 0, 1)`,
+		`INSERT INTO user_tunnel(id, user_id, tunnel_id, num, flow, flow_reset_time, exp_time, status) VALUES (1, 1, 1, 10, 100, 0, 0, 1)`,
+		`INSERT INTO forward(id, user_id, user_name, name, tunnel_id, in_port, remote_addr, created_time, updated_time, status) VALUES (1, 1, 'admin_user', 'f', 1, 1500, '198.51.100.1:80', 0, 0, 1), (2, 1, 'admin_user', 'g', 1, 1501, '198.51.100.1:80', 0, 0, 1), (3, 1, 'admin_user', 'h', 1, 1502, '198.51.100.1:80', 0, 0, 1)`,
+	} {
+		if _, err := conn.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	st := store.New(conn)
+	return New(st, opts), st
+}
+
+func traffic(t *testing.T, st *store.Store, start time.Time) []store.TrafficPoint {
+	t.Helper()
+	points, err := st.QueryTraffic(context.Background(), store.TrafficQuery{
+		Granularity: store.GranularityHour,
+		GroupBy:     store.TrafficGroupNode,
+		Start:       start.UnixMilli(),
+		End:         start.Add(time.Hour).UnixMilli(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return points
+}
+
+func TestBill(t *testing.T) {
+	cases := []struct {
+		name             string
+		tunnel           *store.Tunnel
+		down, up         int64
+		wantDown, wantUp int64
+	}{
+		{"no tunnel", nil, 100, 50, 100, 50},
+		{"double", &store.Tunnel{Flow: BillingDouble, TrafficRatio: 1}, 100, 50, 100, 50},
+		{"single bills upload only", &store.Tunnel{Flow: BillingSingle, TrafficRatio: 1}, 100, 50, 100, 0},
+		{"double with ratio", &store.Tunnel{Flow: BillingDouble, TrafficRatio: 1.5}, 100, 50, 150, 75},
+		{"single with ratio", &store.Tunnel{Flow: BillingSingle, TrafficRatio: 0.5}, 101, 50, 51, 0},
+		{"unset ratio counts as 1", &store.Tunnel{Flow: BillingDouble}, 100, 50, 100, 50},
+		{"negative ratio counts as 1", &store.Tunnel{Flow: BillingDouble, TrafficRatio: -2}, 100, 50, 100, 50},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			down, up := Bill(tc.tunnel, tc.down, tc.up)
+			if down != tc.wantDown || up != tc.wantUp {
+				t.Fatalf("Bill = (%d, %d), want (%d, %d)", down, up, tc.wantDown, tc.wantUp)
+			}
+		})
+	}
+}
+
+func TestFlushMergesAndBills(t *testing.T) {
+	svc, st := newTestService(t, Options{})
+	ctx := context.Background()
+	now := time.Now()
+	for _, u := range []Update{
+		{ForwardID: 1, UserID: 1, UserTunnelID: 1, Down: 100, Up: 10, Time: now.UnixMilli()},
+		{ForwardID: 1, UserID: 1, UserTunnelID: 1, Down: 50, Up: 5, Time: now.UnixMilli()},
+		{ForwardID: 1, UserID: 1, UserTunnelID: 1, NodeID: 2, Down: 7, Up: 3, Time: now.UnixMilli()},
+	} {
+		if err := svc.Add(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(svc.pending) != 2 {
+		t.Fatalf("pending = %d entries, want 2 (one per node)", len(svc.pending))
+	}
+
+	var applied []store.FlowDelta
+	svc.SetFlushHook(func(ctx context.Context, deltas []store.FlowDelta) { applied = deltas })
+	if err := svc.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 2 || len(svc.pending) != 0 {
+		t.Fatalf("applied %d deltas, %d left pending", len(applied), len(svc.pending))
+	}
+
+	fw, err := st.GetForwardByID(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fw.InFlow != 157 || fw.OutFlow != 18 {
+		t.Fatalf("forward flow = (%d, %d), want raw (157, 18)", fw.InFlow, fw.OutFlow)
+	}
+	user, err := st.GetUserByID(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.InFlow != 314 || user.OutFlow != 36 {
+		t.Fatalf("user flow = (%d, %d), want billed (314, 36)", user.InFlow, user.OutFlow)
+	}
+
+	// Traffic without a node is attributed to the tunnel's entry node.
+	points := traffic(t, st, store.HourStart(now))
+	if len(points) != 2 || points[0].ID != 1 || points[0].InFlow != 150 || points[1].ID != 2 || points[1].InFlow != 7 {
+		t.Fatalf("hourly traffic by node = %+v", points)
+	}
+}
+
+func TestFlushDropsUnknownForward(t *testing.T) {
+	svc, st := newTestService(t, Options{})
+	ctx := context.Background()
+	if err := svc.Add(Update{ForwardID: 99, UserID: 1, Down: 100}); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(svc.pending) != 0 {
+		t.Fatal("update for a deleted forward kept in the buffer")
+	}
+	if user, _ := st.GetUserByID(ctx, 1); user.InFlow != 0 {
+		t.Fatalf("user billed %d for a deleted forward", user.InFlow)
+	}
+}
+
+func TestAddRejectsMissingIDs(t *testing.T) {
+	svc, _ := newTestService(t, Options{})
+	if err := svc.Add(Update{UserID: 1, Down: 1}); err == nil {
+		t.Fatal("update without forward accepted")
+	}
+	if err := svc.Add(Update{ForwardID: 1, Down: 1}); err == nil {
+		t.Fatal("update without user accepted")
+	}
+}
+
+func TestFlushRequeuesOnError(t *testing.T) {
+	svc, st := newTestService(t, Options{})
+	if err := svc.Add(Update{ForwardID: 1, UserID: 1, UserTunnelID: 1, Down: 100}); err != nil {
+		t.Fatal(err)
+	}
+	failed, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := svc.Flush(failed); err == nil {
+		t.Fatal("Flush succeeded with a cancelled context")
+	}
+
+	// Updates arriving after the failure merge with the requeued ones.
+	if err := svc.Add(Update{ForwardID: 1, UserID: 1, UserTunnelID: 1, Down: 20}); err != nil {
+		t.Fatal(err)
+	}
+	if len(svc.pending) != 1 {
+		t.Fatalf("pending = %d entries, want 1", len(svc.pending))
+	}
+	if err := svc.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	fw, err := st.GetForwardByID(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fw.InFlow != 120 {
+		t.Fatalf("forward in_flow = %d, want 120", fw.InFlow)
+	}
+}
+
+func TestRequeueBounded(t *testing.T) {
+	svc, _ := newTestService(t, Options{MaxPending: 2, MaxBuffered: 2})
+	for id := int64(1); id <= 3; id++ {
+		if err := svc.Add(Update{ForwardID: id, UserID: 1, Down: 10}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	failed, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := svc.Flush(failed); err == nil {
+		t.Fatal("Flush succeeded with a cancelled context")
+	}
+	if len(svc.pending) != 2 {
+		t.Fatalf("pending after requeue = %d entries, want MaxBuffered 2", len(svc.pending))
+	}
+}
+
+func TestRunFlushesOnShutdown(t *testing.T) {
+	svc, st := newTestService(t, Options{FlushInterval: time.Hour})
+	if err := svc.Add(Update{ForwardID: 1, UserID: 1, Down: 5}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- svc.Run(ctx) }()
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run = %v", err)
+	}
+	fw, err := st.GetForwardByID(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fw.InFlow != 5 {
+		t.Fatalf("forward in_flow = %d after shutdown, want 5", fw.InFlow)
+	}
+}
+
+func TestResetDropsBufferOnSuccess(t *testing.T) {
+	svc, _ := newTestService(t, Options{})
+	if err := svc.Add(Update{ForwardID: 1, UserID: 1, Down: 5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Reset(func() error { return context.Canceled }); err == nil {
+		t.Fatal("Reset hid the error of fn")
+	}
+	if len(svc.pending) != 1 {
+		t.Fatal("failed Reset dropped the buffer")
+	}
+	if err := svc.Reset(func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if len(svc.pending) != 0 {
+		t.Fatal("Reset kept the buffer")
+	}
+}
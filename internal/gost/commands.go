@@ -22,6 +22,14 @@ func TcpPingData(ip string, port int) json.RawMessage {
 	})
 }
 
+// RotateSecretData carries a node's new secret; the agent stores it in its
+// config.json and switches to it after acknowledging.
+func RotateSecretData(secret string) json.RawMessage {
+	return mustJSON(map[string]any{
+		"secret": secret,
+	})
+}
+
 func AddLimitersData(name int64, speed int64) json.RawMessage {
 	limit := limiterValue(speed)
 	return mustJSON(map[string]any{
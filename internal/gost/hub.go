@@ -7,20 +7,27 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 
-	"pixia-panel/internal/auth"
 	"pixia-panel/internal/crypto"
 )
 
 var (
 	ErrNodeNotConnected = errors.New("node not connected")
 	ErrResponseTimeout  = errors.New("response timeout")
+	// ErrAdminForbidden is returned by an AdminAuthorizer for a valid login
+	// that may not watch the nodes.
+	ErrAdminForbidden = errors.New("admin socket forbidden")
 )
 
+// AdminAuthorizer checks the login token an admin websocket connects with.
+// ErrAdminForbidden rejects the connection with 403, any other error with 401.
+type AdminAuthorizer func(r *http.Request, token string) error
+
 type Response struct {
 	Type    string
 	Success bool
@@ -30,13 +37,16 @@ type Response struct {
 
 type Hub struct {
 	mu      sync.RWMutex
-	conns   map[int64]*websocket.Conn
+	conns   map[int64]*wsWriter
 	secrets map[int64]string
+	// prevSecrets holds the other secret a node may encrypt with while its
+	// secret is being rotated; see RotateSecret.
+	prevSecrets map[int64]string
 
 	adminMu sync.Mutex
-	admins  map[*websocket.Conn]struct{}
+	admins  map[*websocket.Conn]*wsWriter
 
-	jwtSecret []byte
+	adminAuth AdminAuthorizer
 
 	pendingMu sync.Mutex
 	pending   map[string]chan Response
@@ -44,33 +54,130 @@ type Hub struct {
 
 func NewHub() *Hub {
 	return &Hub{
-		conns:   make(map[int64]*websocket.Conn),
-		secrets: make(map[int64]string),
-		admins:  make(map[*websocket.Conn]struct{}),
-		pending: make(map[string]chan Response),
+		conns:       make(map[int64]*wsWriter),
+		secrets:     make(map[int64]string),
+		prevSecrets: make(map[int64]string),
+		admins:      make(map[*websocket.Conn]*wsWriter),
+		pending:     make(map[string]chan Response),
 	}
 }
 
+// Register makes conn the node's connection and starts its writer. A
+// previous connection of the node is closed.
 func (h *Hub) Register(nodeID int64, conn *websocket.Conn, secret string) {
 	h.mu.Lock()
-	if old, ok := h.conns[nodeID]; ok && old != conn {
-		_ = old.Close()
+	if old, ok := h.conns[nodeID]; !ok || old.conn != conn {
+		if ok {
+			old.close()
+		}
+		h.conns[nodeID] = newWSWriter(conn)
 	}
-	h.conns[nodeID] = conn
 	h.secrets[nodeID] = secret
+	delete(h.prevSecrets, nodeID)
 	h.mu.Unlock()
 }
 
 func (h *Hub) Unregister(nodeID int64) {
 	h.mu.Lock()
-	if conn, ok := h.conns[nodeID]; ok {
-		_ = conn.Close()
+	if w, ok := h.conns[nodeID]; ok {
+		w.close()
 		delete(h.conns, nodeID)
 	}
 	delete(h.secrets, nodeID)
+	delete(h.prevSecrets, nodeID)
 	h.mu.Unlock()
 }
 
+// unregister removes conn if it is still the node's connection, and reports
+// whether it was. A node that reconnected keeps its newer connection.
+func (h *Hub) unregister(nodeID int64, conn *websocket.Conn) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	w, ok := h.conns[nodeID]
+	if !ok || w.conn != conn {
+		_ = conn.Close()
+		return false
+	}
+	w.close()
+	delete(h.conns, nodeID)
+	delete(h.secrets, nodeID)
+	delete(h.prevSecrets, nodeID)
+	return true
+}
+
+// Secret returns the secret the connected node currently uses.
+func (h *Hub) Secret(nodeID int64) string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.secrets[nodeID]
+}
+
+// RotateSecret sends secret to the connected node and switches to it once
+// the node confirms. Until RetireSecret the previous secret is still accepted
+// for the node's messages, which may have been encrypted before the switch.
+func (h *Hub) RotateSecret(ctx context.Context, nodeID int64, secret string, timeout time.Duration) error {
+	h.mu.Lock()
+	if _, ok := h.conns[nodeID]; !ok {
+		h.mu.Unlock()
+		return ErrNodeNotConnected
+	}
+	old := h.secrets[nodeID]
+	// The node encrypts its reply with the new secret already.
+	h.prevSecrets[nodeID] = secret
+	h.mu.Unlock()
+
+	resp, err := h.SendAndWait(ctx, nodeID, "RotateSecret", RotateSecretData(secret), timeout)
+	if err == nil && !resp.Success {
+		err = errors.New(resp.Message)
+	}
+
+	if err != nil {
+		// The node may have switched even though its reply was lost, so the
+		// new secret stays accepted until it reconnects.
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.secrets[nodeID] = secret
+	h.prevSecrets[nodeID] = old
+	return nil
+}
+
+// RetireSecret stops accepting the node's previous secret. A connection still
+// using a secret other than current is closed so the node has to reconnect.
+func (h *Hub) RetireSecret(nodeID int64, current string) {
+	h.mu.Lock()
+	delete(h.prevSecrets, nodeID)
+	w, ok := h.conns[nodeID]
+	stale := ok && !strings.EqualFold(h.secrets[nodeID], current)
+	h.mu.Unlock()
+	if stale {
+		w.close()
+	}
+}
+
+// Disconnect closes the node's connection, so it has to reconnect and
+// authenticate again.
+func (h *Hub) Disconnect(nodeID int64) {
+	h.mu.RLock()
+	w, ok := h.conns[nodeID]
+	h.mu.RUnlock()
+	if ok {
+		w.close()
+	}
+}
+
+// ConnectedNodes returns the IDs of the connected nodes.
+func (h *Hub) ConnectedNodes() []int64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	ids := make([]int64, 0, len(h.conns))
+	for id := range h.conns {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
 func (h *Hub) Connected(nodeID int64) bool {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
@@ -78,8 +185,10 @@ func (h *Hub) Connected(nodeID int64) bool {
 	return ok
 }
 
-func (h *Hub) SetJWTSecret(secret []byte) {
-	h.jwtSecret = secret
+// SetAdminAuth sets the check for admin websockets. Until it is set they are
+// rejected.
+func (h *Hub) SetAdminAuth(fn AdminAuthorizer) {
+	h.adminAuth = fn
 }
 
 func (h *Hub) Send(ctx context.Context, nodeID int64, action string, data json.RawMessage) error {
@@ -87,7 +196,7 @@ func (h *Hub) Send(ctx context.Context, nodeID int64, action string, data json.R
 		"type": action,
 		"data": json.RawMessage(data),
 	}
-	return h.send(nodeID, msg)
+	return h.send(ctx, nodeID, msg)
 }
 
 func (h *Hub) SendAndWait(ctx context.Context, nodeID int64, action string, data json.RawMessage, timeout time.Duration) (Response, error) {
@@ -102,7 +211,7 @@ func (h *Hub) SendAndWait(ctx context.Context, nodeID int64, action string, data
 		"data":      json.RawMessage(data),
 		"requestId": reqID,
 	}
-	if err := h.send(nodeID, msg); err != nil {
+	if err := h.send(ctx, nodeID, msg); err != nil {
 		h.pendingMu.Lock()
 		delete(h.pending, reqID)
 		h.pendingMu.Unlock()
@@ -128,18 +237,59 @@ func (h *Hub) SendAndWait(ctx context.Context, nodeID int64, action string, data
 	}
 }
 
-func (h *Hub) send(nodeID int64, msg map[string]any) error {
+// send queues msg on the node's writer and waits until it is written or
+// fails. The write must finish within writeTimeout and ctx's deadline.
+func (h *Hub) send(ctx context.Context, nodeID int64, msg map[string]any) error {
+	w, payload, err := h.encode(nodeID, msg)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(writeTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	result, err := w.enqueue(payload, deadline, true)
+	if err != nil {
+		return nodeSendError(err)
+	}
+	select {
+	case err := <-result:
+		return err
+	case <-w.closed():
+		select {
+		case err := <-result:
+			return err
+		default:
+			return ErrNodeNotConnected
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// post queues msg on the node's writer without waiting for the write.
+func (h *Hub) post(nodeID int64, msg map[string]any) error {
+	w, payload, err := h.encode(nodeID, msg)
+	if err != nil {
+		return err
+	}
+	_, err = w.enqueue(payload, time.Now().Add(writeTimeout), false)
+	return nodeSendError(err)
+}
+
+// encode returns the node's writer and msg encrypted with the node's secret.
+func (h *Hub) encode(nodeID int64, msg map[string]any) (*wsWriter, []byte, error) {
 	h.mu.RLock()
-	conn, ok := h.conns[nodeID]
+	w, ok := h.conns[nodeID]
 	secret := h.secrets[nodeID]
 	h.mu.RUnlock()
 	if !ok {
-		return ErrNodeNotConnected
+		return nil, nil, ErrNodeNotConnected
 	}
 
 	payload, err := json.Marshal(msg)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	if secret != "" {
@@ -154,9 +304,14 @@ func (h *Hub) send(nodeID int64, msg map[string]any) error {
 			}
 		}
 	}
+	return w, payload, nil
+}
 
-	conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
-	return conn.WriteMessage(websocket.TextMessage, payload)
+func nodeSendError(err error) error {
+	if errors.Is(err, errWriterClosed) {
+		return ErrNodeNotConnected
+	}
+	return err
 }
 
 type NodeLookup interface {
@@ -167,8 +322,11 @@ type NodeStatusUpdater interface {
 	UpdateNodeStatus(ctx context.Context, nodeID int64, status int64, version *string, http, tls, socks *int64) error
 }
 
+// NodeResyncer brings a connecting node's config up to date. configHash is
+// the hash of the config the node runs as sent when connecting (see
+// ConfigHash); it is empty for nodes too old to send one.
 type NodeResyncer interface {
-	ResyncNode(ctx context.Context, nodeID int64)
+	ResyncNode(ctx context.Context, nodeID int64, configHash string)
 }
 
 // ServeWS upgrades and registers a websocket connection for a node.
@@ -185,11 +343,17 @@ func (h *Hub) ServeWS(lookup NodeLookup) http.HandlerFunc {
 				http.Error(w, "missing token", http.StatusUnauthorized)
 				return
 			}
-			if len(h.jwtSecret) > 0 {
-				if _, err := auth.Parse(h.jwtSecret, token); err != nil {
-					http.Error(w, "invalid token", http.StatusUnauthorized)
+			if h.adminAuth == nil {
+				http.Error(w, "invalid token", http.StatusUnauthorized)
+				return
+			}
+			if err := h.adminAuth(r, token); err != nil {
+				if errors.Is(err, ErrAdminForbidden) {
+					http.Error(w, "forbidden", http.StatusForbidden)
 					return
 				}
+				http.Error(w, "invalid token", http.StatusUnauthorized)
+				return
 			}
 			conn, err := upgrader.Upgrade(w, r, nil)
 			if err != nil {
@@ -225,12 +389,13 @@ func (h *Hub) ServeWS(lookup NodeLookup) http.HandlerFunc {
 		h.updateNodeStatus(r, lookup, nodeID, 1)
 		h.broadcastStatus(nodeID, 1)
 		if resyncer, ok := lookup.(NodeResyncer); ok {
-			go resyncer.ResyncNode(r.Context(), nodeID)
+			go resyncer.ResyncNode(r.Context(), nodeID, r.URL.Query().Get("config_hash"))
 		}
 		defer func() {
-			h.updateNodeStatus(r, lookup, nodeID, 0)
-			h.broadcastStatus(nodeID, 0)
-			h.Unregister(nodeID)
+			if h.unregister(nodeID, conn) {
+				h.updateNodeStatus(r, lookup, nodeID, 0)
+				h.broadcastStatus(nodeID, 0)
+			}
 		}()
 
 		for {
@@ -238,7 +403,7 @@ func (h *Hub) ServeWS(lookup NodeLookup) http.HandlerFunc {
 			if err != nil {
 				return
 			}
-			h.handleMessage(nodeID, secret, payload)
+			h.handleMessage(nodeID, payload)
 		}
 	}
 }
@@ -282,15 +447,24 @@ func parseQueryInt64(r *http.Request, key string) *int64 {
 	return &n
 }
 
-func (h *Hub) handleMessage(nodeID int64, secret string, payload []byte) {
+func (h *Hub) handleMessage(nodeID int64, payload []byte) {
 	msg := payload
 	var wrapper struct {
 		Encrypted bool   `json:"encrypted"`
 		Data      string `json:"data"`
 	}
 	if err := json.Unmarshal(payload, &wrapper); err == nil && wrapper.Encrypted && wrapper.Data != "" {
-		if plain, err := crypto.Decrypt(secret, wrapper.Data); err == nil {
-			msg = plain
+		h.mu.RLock()
+		secrets := []string{h.secrets[nodeID], h.prevSecrets[nodeID]}
+		h.mu.RUnlock()
+		for _, secret := range secrets {
+			if secret == "" {
+				continue
+			}
+			if plain, err := crypto.Decrypt(secret, wrapper.Data); err == nil {
+				msg = plain
+				break
+			}
 		}
 	}
 
@@ -298,7 +472,7 @@ func (h *Hub) handleMessage(nodeID int64, secret string, payload []byte) {
 	if err := json.Unmarshal(msg, &sysInfo); err == nil {
 		if _, ok := sysInfo["memory_usage"]; ok {
 			h.broadcastInfo(nodeID, sysInfo)
-			_ = h.send(nodeID, map[string]any{"type": "call"})
+			_ = h.post(nodeID, map[string]any{"type": "call"})
 			return
 		}
 	}
@@ -341,13 +515,16 @@ func randomID() string {
 
 func (h *Hub) registerAdmin(conn *websocket.Conn) {
 	h.adminMu.Lock()
-	h.admins[conn] = struct{}{}
+	h.admins[conn] = newWSWriter(conn)
 	h.adminMu.Unlock()
 }
 
 func (h *Hub) unregisterAdmin(conn *websocket.Conn) {
 	h.adminMu.Lock()
-	delete(h.admins, conn)
+	if w, ok := h.admins[conn]; ok {
+		w.close()
+		delete(h.admins, conn)
+	}
 	h.adminMu.Unlock()
 	_ = conn.Close()
 }
@@ -373,12 +550,14 @@ func (h *Hub) broadcast(payload map[string]any) {
 	if err != nil {
 		return
 	}
+	deadline := time.Now().Add(writeTimeout)
 	h.adminMu.Lock()
-	for conn := range h.admins {
-		conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
-		if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+	for conn, w := range h.admins {
+		// An admin page that cannot keep up is disconnected, as one whose
+		// write fails always was.
+		if _, err := w.enqueue(msg, deadline, false); err != nil {
 			delete(h.admins, conn)
-			_ = conn.Close()
+			w.close()
 		}
 	}
 	h.adminMu.Unlock()
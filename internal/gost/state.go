@@ -0,0 +1,267 @@
+package gost
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Kinds of objects in a node's gost config.
+const (
+	KindService = "service"
+	KindChain   = "chain"
+	KindLimiter = "limiter"
+)
+
+// Object is a named service, chain or limiter reduced to a digest of the
+// fields the panel sets, so an object built by the command builders and the
+// same object reported back by a node compare equal.
+type Object struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Digest string `json:"digest"`
+	Paused bool   `json:"paused,omitempty"`
+	// Data is the object's config as sent to the node. It is only kept for
+	// objects built by the panel.
+	Data json.RawMessage `json:"-"`
+}
+
+// Key identifies the object within a node, e.g. "service:1_2_3_tcp".
+func (o Object) Key() string {
+	return o.Kind + ":" + o.Name
+}
+
+type serviceSpec struct {
+	Name      string         `json:"name"`
+	Addr      string         `json:"addr,omitempty"`
+	Limiter   string         `json:"limiter,omitempty"`
+	Handler   *handlerSpec   `json:"handler,omitempty"`
+	Listener  *listenerSpec  `json:"listener,omitempty"`
+	Forwarder *forwarderSpec `json:"forwarder,omitempty"`
+	Metadata  map[string]any `json:"metadata,omitempty"`
+}
+
+type handlerSpec struct {
+	Type    string `json:"type"`
+	Chain   string `json:"chain,omitempty"`
+	Limiter string `json:"limiter,omitempty"`
+}
+
+type listenerSpec struct {
+	Type     string         `json:"type"`
+	Metadata map[string]any `json:"metadata,omitempty"`
+}
+
+type forwarderSpec struct {
+	Nodes    []forwardNodeSpec `json:"nodes"`
+	Selector *selectorSpec     `json:"selector,omitempty"`
+}
+
+type forwardNodeSpec struct {
+	Name string `json:"name,omitempty"`
+	Addr string `json:"addr,omitempty"`
+}
+
+// selectorSpec.FailTimeout is sent as a duration string but reported in
+// nanoseconds; digest normalizes it.
+type selectorSpec struct {
+	Strategy    string `json:"strategy"`
+	MaxFails    int    `json:"maxFails"`
+	FailTimeout any    `json:"failTimeout,omitempty"`
+}
+
+type chainSpec struct {
+	Name string    `json:"name"`
+	Hops []hopSpec `json:"hops"`
+}
+
+type hopSpec struct {
+	Name  string          `json:"name"`
+	Nodes []chainNodeSpec `json:"nodes,omitempty"`
+}
+
+type chainNodeSpec struct {
+	Name      string    `json:"name"`
+	Addr      string    `json:"addr,omitempty"`
+	Interface string    `json:"interface,omitempty"`
+	Connector *typeSpec `json:"connector,omitempty"`
+	Dialer    *typeSpec `json:"dialer,omitempty"`
+}
+
+type typeSpec struct {
+	Type     string         `json:"type"`
+	Metadata map[string]any `json:"metadata,omitempty"`
+}
+
+type limiterSpec struct {
+	Name   string   `json:"name"`
+	Limits []string `json:"limits,omitempty"`
+}
+
+// ServiceObjects returns the services of an AddService or UpdateService
+// payload.
+func ServiceObjects(data json.RawMessage) ([]Object, error) {
+	var raws []json.RawMessage
+	if err := json.Unmarshal(data, &raws); err != nil {
+		return nil, err
+	}
+	objects := make([]Object, 0, len(raws))
+	for _, raw := range raws {
+		obj, err := serviceObject(raw)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, obj)
+	}
+	return objects, nil
+}
+
+// ChainObject returns the chain of an AddChains payload.
+func ChainObject(data json.RawMessage) (Object, error) {
+	var spec chainSpec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return Object{}, err
+	}
+	return chainObject(spec, data), nil
+}
+
+// LimiterObject returns the limiter of an AddLimiters payload.
+func LimiterObject(data json.RawMessage) (Object, error) {
+	var spec limiterSpec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return Object{}, err
+	}
+	return Object{Kind: KindLimiter, Name: spec.Name, Digest: digest(spec), Data: data}, nil
+}
+
+// ParseConfig reduces a gost config as reported by a node to its services,
+// chains and limiters.
+func ParseConfig(data []byte) ([]Object, error) {
+	var cfg struct {
+		Services []json.RawMessage `json:"services"`
+		Chains   []chainSpec       `json:"chains"`
+		Limiters []limiterSpec     `json:"limiters"`
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	objects := make([]Object, 0, len(cfg.Services)+len(cfg.Chains)+len(cfg.Limiters))
+	for _, raw := range cfg.Services {
+		obj, err := serviceObject(raw)
+		if err != nil {
+			return nil, err
+		}
+		obj.Data = nil
+		objects = append(objects, obj)
+	}
+	for _, spec := range cfg.Chains {
+		objects = append(objects, chainObject(spec, nil))
+	}
+	for _, spec := range cfg.Limiters {
+		objects = append(objects, Object{Kind: KindLimiter, Name: spec.Name, Digest: digest(spec)})
+	}
+	return objects, nil
+}
+
+// ConfigHash hashes objects regardless of their order. Nodes send the same
+// hash of the objects they run when connecting (socket/config_hash.go in the
+// agent), so both sides must digest objects identically.
+func ConfigHash(objects []Object) string {
+	lines := make([]string, 0, len(objects))
+	for _, o := range objects {
+		line := o.Key() + " " + o.Digest
+		if o.Paused {
+			line += " paused"
+		}
+		lines = append(lines, line)
+	}
+	sort.Strings(lines)
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// ServiceListData builds an AddService or UpdateService payload from
+// service configs.
+func ServiceListData(services []json.RawMessage) json.RawMessage {
+	return mustJSON(services)
+}
+
+// ServiceNamesData builds a DeleteService, PauseService or ResumeService
+// payload for the named services.
+func ServiceNamesData(names []string) json.RawMessage {
+	return mustJSON(map[string]any{"services": names})
+}
+
+func chainObject(spec chainSpec, data json.RawMessage) Object {
+	for _, hop := range spec.Hops {
+		for _, node := range hop.Nodes {
+			for _, t := range []*typeSpec{node.Connector, node.Dialer} {
+				if t != nil {
+					t.Metadata = lowerKeys(t.Metadata)
+				}
+			}
+		}
+	}
+	return Object{Kind: KindChain, Name: spec.Name, Digest: digest(spec), Data: data}
+}
+
+// serviceObject digests a service. The agent marks paused services in their
+// metadata; that flag is reported separately rather than digested.
+func serviceObject(raw json.RawMessage) (Object, error) {
+	var spec serviceSpec
+	if err := json.Unmarshal(raw, &spec); err != nil {
+		return Object{}, err
+	}
+	// Keys are lowercased before the paused flag is taken out, as the agent
+	// does, so "Paused" is treated as the flag too.
+	spec.Metadata = lowerKeys(spec.Metadata)
+	paused, _ := spec.Metadata["paused"].(bool)
+	if _, ok := spec.Metadata["paused"]; ok {
+		delete(spec.Metadata, "paused")
+		if len(spec.Metadata) == 0 {
+			spec.Metadata = nil
+		}
+	}
+	if spec.Listener != nil {
+		spec.Listener.Metadata = lowerKeys(spec.Listener.Metadata)
+	}
+	if spec.Forwarder != nil && spec.Forwarder.Selector != nil {
+		spec.Forwarder.Selector.FailTimeout = durationNanos(spec.Forwarder.Selector.FailTimeout)
+	}
+	return Object{Kind: KindService, Name: spec.Name, Digest: digest(spec), Paused: paused, Data: raw}, nil
+}
+
+// lowerKeys lowercases metadata keys. gost reads metadata case-insensitively
+// and a node that loaded its config from file reports the keys lowercased.
+func lowerKeys(m map[string]any) map[string]any {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]any, len(m))
+	for k, v := range m {
+		out[strings.ToLower(k)] = v
+	}
+	return out
+}
+
+func durationNanos(v any) any {
+	switch t := v.(type) {
+	case string:
+		if d, err := time.ParseDuration(strings.TrimSpace(t)); err == nil {
+			return int64(d)
+		}
+	case float64:
+		return int64(t)
+	}
+	return v
+}
+
+// digest hashes the canonical JSON of spec; maps marshal with sorted keys.
+func digest(spec any) string {
+	b, _ := json.Marshal(spec)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,77 @@
+package gost
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+// configHashGolden is the hash of testdata/config_hash.json. The agent's
+// socket/config_hash_test.go checks the same file against the same value, so
+// a change on either side that breaks parity fails one of the two tests.
+const configHashGolden = "d5ad552d83f7a7a25c698e31d3786e50e84145ade22e2ff9864ac79048e6ccdf"
+
+func TestConfigHashGolden(t *testing.T) {
+	data, err := os.ReadFile("testdata/config_hash.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects, err := ParseConfig(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ConfigHash(objects); got != configHashGolden {
+		t.Fatalf("ConfigHash = %s, want %s", got, configHashGolden)
+	}
+
+	reversed := make([]Object, len(objects))
+	for i, o := range objects {
+		reversed[len(objects)-1-i] = o
+	}
+	if got := ConfigHash(reversed); got != configHashGolden {
+		t.Fatalf("ConfigHash depends on object order: %s", got)
+	}
+}
+
+func TestServiceObjectPausedFlag(t *testing.T) {
+	plain := json.RawMessage(`{"name":"1_2_3_tcp","addr":":10001","metadata":{"interface":"eth0"}}`)
+	cases := []struct {
+		name string
+		raw  json.RawMessage
+	}{
+		{"lower", json.RawMessage(`{"name":"1_2_3_tcp","addr":":10001","metadata":{"interface":"eth0","paused":true}}`)},
+		{"mixed case", json.RawMessage(`{"name":"1_2_3_tcp","addr":":10001","metadata":{"Interface":"eth0","Paused":true}}`)},
+	}
+	want, err := serviceObject(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			obj, err := serviceObject(tc.raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !obj.Paused {
+				t.Fatal("paused flag not detected")
+			}
+			if obj.Digest != want.Digest {
+				t.Fatalf("digest = %s, want %s", obj.Digest, want.Digest)
+			}
+		})
+	}
+}
+
+func TestServiceObjectFailTimeout(t *testing.T) {
+	sent, err := serviceObject(json.RawMessage(`{"name":"1_2_3_tcp","forwarder":{"nodes":[{"name":"target","addr":"1.1.1.1:443"}],"selector":{"strategy":"fifo","maxFails":1,"failTimeout":"10m"}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reported, err := serviceObject(json.RawMessage(`{"name":"1_2_3_tcp","forwarder":{"nodes":[{"name":"target","addr":"1.1.1.1:443"}],"selector":{"strategy":"fifo","maxFails":1,"failTimeout":600000000000}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent.Digest != reported.Digest {
+		t.Fatalf("digest of sent config %s differs from reported %s", sent.Digest, reported.Digest)
+	}
+}
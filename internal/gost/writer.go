@@ -0,0 +1,114 @@
+package gost
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// sendQueueSize bounds the messages waiting for a connection's writer.
+	sendQueueSize = 64
+	// writeTimeout bounds how long a message may wait in the queue and be
+	// written.
+	writeTimeout = 5 * time.Second
+)
+
+var (
+	ErrSendQueueFull = errors.New("send queue full")
+	ErrSendTimeout   = errors.New("send timeout")
+
+	errWriterClosed = errors.New("connection closed")
+)
+
+// wsWriter owns all writes to a websocket connection. gorilla connections
+// allow one writer at a time, so messages are queued and written by a single
+// goroutine, each before its own deadline.
+type wsWriter struct {
+	conn  *websocket.Conn
+	queue chan wsMessage
+	done  chan struct{}
+	once  sync.Once
+}
+
+type wsMessage struct {
+	payload  []byte
+	deadline time.Time
+	// result receives the write error; nil when the sender does not wait.
+	result chan error
+}
+
+func newWSWriter(conn *websocket.Conn) *wsWriter {
+	w := &wsWriter{
+		conn:  conn,
+		queue: make(chan wsMessage, sendQueueSize),
+		done:  make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// enqueue queues payload to be written before deadline. It fails with
+// ErrSendQueueFull rather than block when the writer falls behind. With wait
+// set, the returned channel receives the outcome of the write.
+func (w *wsWriter) enqueue(payload []byte, deadline time.Time, wait bool) (<-chan error, error) {
+	msg := wsMessage{payload: payload, deadline: deadline}
+	if wait {
+		msg.result = make(chan error, 1)
+	}
+	select {
+	case <-w.done:
+		return nil, errWriterClosed
+	default:
+	}
+	select {
+	case w.queue <- msg:
+		return msg.result, nil
+	default:
+		return nil, ErrSendQueueFull
+	}
+}
+
+func (w *wsWriter) run() {
+	for {
+		select {
+		case <-w.done:
+			return
+		case msg := <-w.queue:
+			err := w.write(msg)
+			if msg.result != nil {
+				msg.result <- err
+			}
+			if err != nil && !errors.Is(err, ErrSendTimeout) {
+				// The connection is unusable after a failed write; closing
+				// it ends the reader, which unregisters it.
+				w.close()
+				return
+			}
+		}
+	}
+}
+
+func (w *wsWriter) write(msg wsMessage) error {
+	if time.Now().After(msg.deadline) {
+		return ErrSendTimeout
+	}
+	_ = w.conn.SetWriteDeadline(msg.deadline)
+	return w.conn.WriteMessage(websocket.TextMessage, msg.payload)
+}
+
+// close stops the writer and closes the connection. Messages still queued
+// are dropped; their senders see the connection closed.
+func (w *wsWriter) close() {
+	w.once.Do(func() {
+		close(w.done)
+		_ = w.conn.Close()
+	})
+}
+
+// closed is closed once the writer has stopped.
+func (w *wsWriter) closed() <-chan struct{} {
+	return w.done
+}
@@ -0,0 +1,143 @@
+package gost
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsPair returns the server side of a websocket connection and the client
+// side reading from it.
+func wsPair(t *testing.T) (server, client *websocket.Conn) {
+	t.Helper()
+	conns := make(chan *websocket.Conn, 1)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conns <- conn
+	}))
+	t.Cleanup(srv.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	server = <-conns
+	t.Cleanup(func() { server.Close() })
+	return server, client
+}
+
+func readText(t *testing.T, conn *websocket.Conn) string {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestWriterQueueFull(t *testing.T) {
+	// A writer whose goroutine is not running stands in for one stuck on a
+	// slow connection.
+	w := &wsWriter{queue: make(chan wsMessage, sendQueueSize), done: make(chan struct{})}
+	deadline := time.Now().Add(time.Minute)
+	for i := 0; i < sendQueueSize; i++ {
+		if _, err := w.enqueue([]byte("x"), deadline, false); err != nil {
+			t.Fatalf("enqueue %d: %v", i, err)
+		}
+	}
+	if _, err := w.enqueue([]byte("x"), deadline, true); !errors.Is(err, ErrSendQueueFull) {
+		t.Fatalf("enqueue on a full queue = %v, want ErrSendQueueFull", err)
+	}
+}
+
+func TestWriterTimeout(t *testing.T) {
+	server, client := wsPair(t)
+	w := newWSWriter(server)
+	defer w.close()
+
+	result, err := w.enqueue([]byte("late"), time.Now().Add(-time.Second), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-result; !errors.Is(err, ErrSendTimeout) {
+		t.Fatalf("expired write = %v, want ErrSendTimeout", err)
+	}
+
+	// A timed out message is dropped unwritten and the connection stays up.
+	result, err = w.enqueue([]byte("next"), time.Now().Add(time.Minute), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-result; err != nil {
+		t.Fatalf("write after timeout: %v", err)
+	}
+	if got := readText(t, client); got != "next" {
+		t.Fatalf("client read %q, want next", got)
+	}
+}
+
+func TestWriterClosesOnWriteError(t *testing.T) {
+	server, _ := wsPair(t)
+	w := newWSWriter(server)
+	_ = server.Close()
+
+	result, err := w.enqueue([]byte("x"), time.Now().Add(time.Minute), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-result; err == nil {
+		t.Fatal("write on a closed connection succeeded")
+	}
+	select {
+	case <-w.closed():
+	case <-time.After(5 * time.Second):
+		t.Fatal("writer still running after a failed write")
+	}
+	if _, err := w.enqueue([]byte("x"), time.Now().Add(time.Minute), false); !errors.Is(err, errWriterClosed) {
+		t.Fatalf("enqueue after close = %v, want errWriterClosed", err)
+	}
+}
+
+func TestHubSend(t *testing.T) {
+	server, client := wsPair(t)
+	h := NewHub()
+	h.Register(1, server, "")
+
+	if err := h.send(context.Background(), 1, map[string]any{"type": "ping"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readText(t, client); got != `{"type":"ping"}` {
+		t.Fatalf("client read %s", got)
+	}
+	if err := h.post(1, map[string]any{"type": "pong"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readText(t, client); got != `{"type":"pong"}` {
+		t.Fatalf("client read %s", got)
+	}
+
+	h.mu.RLock()
+	w := h.conns[1]
+	h.mu.RUnlock()
+	w.close()
+	if err := h.post(1, map[string]any{"type": "ping"}); !errors.Is(err, ErrNodeNotConnected) {
+		t.Fatalf("post on a closed writer = %v, want ErrNodeNotConnected", err)
+	}
+	h.Unregister(1)
+	if err := h.send(context.Background(), 1, map[string]any{"type": "ping"}); !errors.Is(err, ErrNodeNotConnected) {
+		t.Fatalf("send after unregister = %v, want ErrNodeNotConnected", err)
+	}
+}
@@ -0,0 +1,146 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"pixia-panel/internal/store"
+)
+
+// Audited entity types.
+const (
+	auditEntityUser       = "user"
+	auditEntityTunnel     = "tunnel"
+	auditEntityUserTunnel = "user_tunnel"
+	auditEntityForward    = "forward"
+	auditEntityNode       = "node"
+	auditEntitySpeedLimit = "speed_limit"
+	auditEntityConfig     = "config"
+	auditEntityBackup     = "backup"
+	auditEntityAPIToken   = "api_token"
+	auditEntityRole       = "role"
+	auditEntityLogin      = "login"
+	auditEntityNodeEnroll = "node_enrollment"
+	auditEntityOutbox     = "outbox"
+)
+
+// Audited actions.
+const (
+	auditActionCreate           = "create"
+	auditActionUpdate           = "update"
+	auditActionDelete           = "delete"
+	auditActionForceDelete      = "force_delete"
+	auditActionPause            = "pause"
+	auditActionResume           = "resume"
+	auditActionResetFlow        = "reset_flow"
+	auditActionUpdatePassword   = "update_password"
+	auditActionReorder          = "reorder"
+	auditActionRotateSecret     = "rotate_secret"
+	auditActionEnroll           = "enroll"
+	auditActionRequeue          = "requeue"
+	auditActionReconcile        = "reconcile"
+	auditActionRevoke           = "revoke"
+	auditActionRestore          = "restore"
+	auditActionEnableTwoFactor  = "enable_2fa"
+	auditActionDisableTwoFactor = "disable_2fa"
+	auditActionResetTwoFactor   = "reset_2fa"
+	auditActionUpdateQuota      = "update_quota"
+	auditActionUpdateRole       = "update_role"
+	auditActionLockout          = "lockout"
+	auditActionUnlock           = "unlock"
+	auditActionLinkOIDC         = "link_oidc"
+	auditActionUnlinkOIDC       = "unlink_oidc"
+)
+
+// auditRedactedKeys are top-level JSON keys never written to the audit log.
+var auditRedactedKeys = []string{"pwd", "password", "secret"}
+
+// audit records an action of the request's actor. before and after are
+// entity snapshots and may be nil. Failures never affect the request.
+func (s *Server) audit(r *http.Request, action, entityType string, entityID int64, before, after any) {
+	actorRole, ok := roleIDFromCtx(r)
+	if !ok {
+		actorRole = store.AuditNoActorRole
+	}
+	entry := &store.AuditLog{
+		ActorID:     userIDFromCtx(r),
+		ActorRole:   actorRole,
+		ClientIP:    s.clientIP(r),
+		Action:      action,
+		EntityType:  entityType,
+		EntityID:    entityID,
+		Before:      auditJSON(before),
+		After:       auditJSON(after),
+		CreatedTime: time.Now().UnixMilli(),
+	}
+	_ = s.store.InsertAuditLog(r.Context(), entry)
+}
+
+func auditJSON(v any) *string {
+	if v == nil {
+		return nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil || string(raw) == "null" {
+		return nil
+	}
+	var obj map[string]json.RawMessage
+	if json.Unmarshal(raw, &obj) == nil {
+		for _, key := range auditRedactedKeys {
+			if _, ok := obj[key]; ok {
+				obj[key] = json.RawMessage(`"***"`)
+			}
+		}
+		raw, _ = json.Marshal(obj)
+	}
+	out := string(raw)
+	return &out
+}
+
+type auditListRequest struct {
+	ActorID    int64  `json:"actorId"`
+	EntityType string `json:"entityType"`
+	EntityID   int64  `json:"entityId"`
+	Action     string `json:"action"`
+	Start      int64  `json:"start"`
+	End        int64  `json:"end"`
+	Page       int    `json:"page"`
+	Size       int    `json:"size"`
+}
+
+func (s *Server) handleAuditList(w http.ResponseWriter, r *http.Request) {
+	var req auditListRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("参数错误"))
+		return
+	}
+	if req.Page <= 0 {
+		req.Page = 1
+	}
+	if req.Size <= 0 || req.Size > 200 {
+		req.Size = 50
+	}
+
+	list, total, err := s.store.ListAuditLogs(r.Context(), store.AuditFilter{
+		ActorID:    req.ActorID,
+		EntityType: req.EntityType,
+		EntityID:   req.EntityID,
+		Action:     req.Action,
+		Start:      req.Start,
+		End:        req.End,
+		Limit:      req.Size,
+		Offset:     (req.Page - 1) * req.Size,
+	})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("获取失败"))
+		return
+	}
+	if list == nil {
+		list = []store.AuditLog{}
+	}
+	writeJSON(w, http.StatusOK, OK(map[string]any{
+		"list":  list,
+		"total": total,
+	}))
+}
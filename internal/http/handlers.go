@@ -43,7 +43,7 @@ func (h *FlowHandler) Upload(w http.ResponseWriter, r *http.Request) {
 		Up:           req.U,
 	}
 
-	if err := h.service.Apply(r.Context(), update); err != nil {
+	if err := h.service.Add(update); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
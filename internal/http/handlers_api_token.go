@@ -0,0 +1,183 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"pixia-panel/internal/auth"
+	"pixia-panel/internal/store"
+)
+
+// apiTokenTouchInterval limits how often last-used tracking writes to the database.
+const apiTokenTouchInterval = time.Minute
+
+var apiTokenScopes = map[string]bool{
+	scopeRead:          true,
+	scopeForwardsWrite: true,
+	scopeAdmin:         true,
+}
+
+type apiTokenCreateRequest struct {
+	Name    string   `json:"name"`
+	Scopes  []string `json:"scopes"`
+	ExpTime int64    `json:"expTime"`
+}
+
+type apiTokenListRequest struct {
+	UserID int64 `json:"userId"`
+}
+
+type apiTokenRevokeRequest struct {
+	ID int64 `json:"id"`
+}
+
+func (s *Server) handleAPITokenCreate(w http.ResponseWriter, r *http.Request) {
+	var req apiTokenCreateRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("参数错误"))
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		writeJSON(w, http.StatusBadRequest, Err("令牌名称不能为空"))
+		return
+	}
+	if len(req.Scopes) == 0 {
+		writeJSON(w, http.StatusBadRequest, Err("请选择令牌权限"))
+		return
+	}
+	seen := make(map[string]bool, len(req.Scopes))
+	scopes := make([]string, 0, len(req.Scopes))
+	for _, scope := range req.Scopes {
+		if !apiTokenScopes[scope] {
+			writeJSON(w, http.StatusBadRequest, Err("未知的令牌权限: "+scope))
+			return
+		}
+		if scope == scopeAdmin && !s.hasAnyPermission(r) {
+			writeJSON(w, http.StatusForbidden, Err("权限不足"))
+			return
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	now := time.Now().UnixMilli()
+	if req.ExpTime <= now {
+		writeJSON(w, http.StatusBadRequest, Err("过期时间必须晚于当前时间"))
+		return
+	}
+
+	plain, prefix, err := auth.GenerateAPIToken()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("创建失败"))
+		return
+	}
+	token := &store.APIToken{
+		UserID:      userIDFromCtx(r),
+		Name:        req.Name,
+		TokenHash:   auth.HashToken(plain),
+		Prefix:      prefix,
+		Scopes:      scopes,
+		ExpTime:     req.ExpTime,
+		CreatedTime: now,
+	}
+	id, err := s.store.InsertAPIToken(r.Context(), token)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("创建失败"))
+		return
+	}
+	token.ID = id
+	s.audit(r, auditActionCreate, auditEntityAPIToken, id, nil, token)
+
+	// The plain token is only ever returned here.
+	writeJSON(w, http.StatusOK, OK(map[string]any{
+		"token": plain,
+		"info":  token,
+	}))
+}
+
+func (s *Server) handleAPITokenList(w http.ResponseWriter, r *http.Request) {
+	var req apiTokenListRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("参数错误"))
+		return
+	}
+	if !s.can(r, permUserRead) {
+		req.UserID = userIDFromCtx(r)
+	}
+	list, err := s.store.ListAPITokens(r.Context(), req.UserID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("获取失败"))
+		return
+	}
+	if list == nil {
+		list = []store.APIToken{}
+	}
+	writeJSON(w, http.StatusOK, OK(list))
+}
+
+func (s *Server) handleAPITokenRevoke(w http.ResponseWriter, r *http.Request) {
+	var req apiTokenRevokeRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("参数错误"))
+		return
+	}
+	token, err := s.store.GetAPITokenByID(r.Context(), req.ID)
+	if err != nil || (!s.can(r, permUserWrite) && token.UserID != userIDFromCtx(r)) {
+		writeJSON(w, http.StatusBadRequest, Err("令牌不存在"))
+		return
+	}
+	if err := s.store.RevokeAPIToken(r.Context(), token.ID, time.Now().UnixMilli()); err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("吊销失败"))
+		return
+	}
+	s.audit(r, auditActionRevoke, auditEntityAPIToken, token.ID, token, nil)
+	writeJSON(w, http.StatusOK, OK("令牌已吊销"))
+}
+
+// authenticateAPIToken resolves a personal API token to its owner. Revoked
+// and expired tokens, and tokens of disabled users, are rejected.
+func (s *Server) authenticateAPIToken(r *http.Request, plain string) (*store.APIToken, *store.User, error) {
+	token, err := s.store.GetAPITokenByHash(r.Context(), auth.HashToken(plain))
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now().UnixMilli()
+	if token.RevokedTime != nil {
+		return nil, nil, errors.New("token revoked")
+	}
+	if token.ExpTime <= now {
+		return nil, nil, errors.New("token expired")
+	}
+	user, err := s.store.GetUserByID(r.Context(), token.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user.Status == 0 {
+		return nil, nil, errors.New("user disabled")
+	}
+	if token.LastUsedTime == nil || now-*token.LastUsedTime >= apiTokenTouchInterval.Milliseconds() {
+		_ = s.store.TouchAPIToken(r.Context(), token.ID, now, s.clientIP(r))
+	}
+	return token, user, nil
+}
+
+// tokenAllows reports whether a token with scopes may call a route requiring
+// required. admin covers everything and any write scope implies read.
+func tokenAllows(scopes []string, required string) bool {
+	if required == scopeSession {
+		return false
+	}
+	for _, scope := range scopes {
+		switch {
+		case scope == scopeAdmin, scope == required:
+			return true
+		case required == scopeRead && scope == scopeForwardsWrite:
+			return true
+		}
+	}
+	return false
+}
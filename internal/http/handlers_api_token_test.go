@@ -0,0 +1,132 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"pixia-panel/internal/auth"
+	"pixia-panel/internal/store"
+)
+
+// newTestAPIToken stores a token of the seeded admin with scopes and returns
+// it in plain text.
+func newTestAPIToken(t *testing.T, s *Server, scopes ...string) (string, int64) {
+	t.Helper()
+	plain, prefix, err := auth.GenerateAPIToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	id, err := s.store.InsertAPIToken(context.Background(), &store.APIToken{
+		UserID:      1,
+		Name:        "test",
+		TokenHash:   auth.HashToken(plain),
+		Prefix:      prefix,
+		Scopes:      scopes,
+		ExpTime:     now.Add(time.Hour).UnixMilli(),
+		CreatedTime: now.UnixMilli(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return plain, id
+}
+
+// callWithAuth runs a route requiring scope with the bearer token and returns
+// the status and whether the handler ran.
+func callWithAuth(s *Server, scope, token string) (int, bool) {
+	reached := false
+	h := s.withAuth(scope, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+		w.WriteHeader(http.StatusOK)
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Code, reached
+}
+
+func TestAPITokenScopes(t *testing.T) {
+	s := newTestServer(t)
+	read, _ := newTestAPIToken(t, s, scopeRead)
+	write, _ := newTestAPIToken(t, s, scopeForwardsWrite)
+	admin, _ := newTestAPIToken(t, s, scopeAdmin)
+
+	cases := []struct {
+		name   string
+		token  string
+		scope  string
+		status int
+	}{
+		{"read on read route", read, scopeRead, http.StatusOK},
+		{"read on forward write route", read, scopeForwardsWrite, http.StatusForbidden},
+		{"read on admin route", read, scopeAdmin, http.StatusForbidden},
+		{"forward write on read route", write, scopeRead, http.StatusOK},
+		{"forward write on forward write route", write, scopeForwardsWrite, http.StatusOK},
+		{"forward write on admin route", write, scopeAdmin, http.StatusForbidden},
+		{"admin on admin route", admin, scopeAdmin, http.StatusOK},
+		{"admin on forward write route", admin, scopeForwardsWrite, http.StatusOK},
+		{"read on session route", read, scopeSession, http.StatusForbidden},
+		{"admin on session route", admin, scopeSession, http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		status, reached := callWithAuth(s, tc.scope, tc.token)
+		if status != tc.status || reached != (tc.status == http.StatusOK) {
+			t.Errorf("%s: status %d, handler ran %v; want %d", tc.name, status, reached, tc.status)
+		}
+	}
+}
+
+func TestAPITokenRejectedAfterRevokeOrExpiry(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	revoked, id := newTestAPIToken(t, s, scopeRead)
+	if err := s.store.RevokeAPIToken(ctx, id, time.Now().UnixMilli()); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := callWithAuth(s, scopeRead, revoked); status != http.StatusUnauthorized {
+		t.Fatalf("revoked token: status %d, want 401", status)
+	}
+
+	expired, id := newTestAPIToken(t, s, scopeRead)
+	if _, err := s.store.DB().Exec(`UPDATE api_token SET exp_time = ? WHERE id = ?`, time.Now().Add(-time.Minute).UnixMilli(), id); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := callWithAuth(s, scopeRead, expired); status != http.StatusUnauthorized {
+		t.Fatalf("expired token: status %d, want 401", status)
+	}
+}
+
+func TestSessionRoutesAcceptLogins(t *testing.T) {
+	s := newTestServer(t)
+	token, _ := newTestSession(t, s, 1)
+	if status, reached := callWithAuth(s, scopeSession, token); status != http.StatusOK || !reached {
+		t.Fatalf("login token on session route: status %d, handler ran %v", status, reached)
+	}
+}
+
+func TestAPITokenScopesOnRoutes(t *testing.T) {
+	s := newTestServer(t)
+	mux := http.NewServeMux()
+	s.Register(mux)
+	read, _ := newTestAPIToken(t, s, scopeRead)
+	call := func(path string) int {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.Header.Set("Authorization", "Bearer "+read)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	for _, path := range []string{"/api/v1/forward/create", "/api/v1/user/create", "/api/v1/user/updatePassword", "/api/v1/token/create"} {
+		if status := call(path); status != http.StatusForbidden {
+			t.Errorf("read token on %s: status %d, want 403", path, status)
+		}
+	}
+	if status := call("/api/v1/forward/list"); status != http.StatusOK {
+		t.Errorf("read token on /api/v1/forward/list: status %d, want 200", status)
+	}
+}
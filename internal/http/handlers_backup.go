@@ -0,0 +1,125 @@
+package httpapi
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"pixia-panel/internal/store"
+)
+
+const maxBackupUploadSize = 1 << 30
+
+func (s *Server) handleBackupDownload(w http.ResponseWriter, r *http.Request) {
+	dir, err := os.MkdirTemp("", "pixia-backup-")
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("备份失败"))
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	name := "pixia-backup-" + time.Now().Format("20060102150405") + ".db"
+	path := filepath.Join(dir, name)
+	if err := s.store.Snapshot(r.Context(), path); err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("备份失败"))
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("备份失败"))
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	if info, err := f.Stat(); err == nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.Copy(w, f)
+}
+
+func (s *Server) handleBackupRestore(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBackupUploadSize)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("请上传备份文件"))
+		return
+	}
+	defer file.Close()
+
+	tmp, err := os.CreateTemp("", "pixia-restore-*.db")
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("恢复失败"))
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, file)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("备份文件上传失败"))
+		return
+	}
+
+	// Buffered traffic was counted against the replaced database and is
+	// dropped with it.
+	err = s.flow.Reset(func() error { return s.store.Restore(r.Context(), tmp.Name()) })
+	if err != nil {
+		if errors.Is(err, store.ErrInvalidBackup) {
+			writeJSON(w, http.StatusBadRequest, Err("备份文件无效或与当前版本不兼容"))
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, Err("恢复失败"))
+		return
+	}
+	// The restored database carries its own audit history; record the restore in it.
+	s.audit(r, auditActionRestore, auditEntityBackup, 0, nil, map[string]any{
+		"filename": header.Filename,
+		"size":     header.Size,
+	})
+
+	// Connected nodes still run the old configuration; push the restored one.
+	if nodes, err := s.store.ListNodes(r.Context()); err == nil {
+		dropped := s.dropStaleNodeConnections(nodes)
+		for _, node := range nodes {
+			if s.hub.Connected(node.ID) && !dropped[node.ID] {
+				s.ResyncNode(r.Context(), node.ID, "")
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, OK("恢复成功"))
+}
+
+// dropStaleNodeConnections closes the connections nodes authenticated with a
+// secret the restored database no longer accepts, or whose node it does not
+// have, and returns their IDs. The nodes reconnect and are checked against the
+// restored secrets.
+func (s *Server) dropStaleNodeConnections(nodes []store.Node) map[int64]bool {
+	restored := make(map[int64]store.Node, len(nodes))
+	for _, node := range nodes {
+		restored[node.ID] = node
+	}
+	now := time.Now().UnixMilli()
+	dropped := make(map[int64]bool)
+	for _, id := range s.hub.ConnectedNodes() {
+		node, ok := restored[id]
+		secret := s.hub.Secret(id)
+		if ok && (strings.EqualFold(secret, node.Secret) ||
+			(node.OldSecret != "" && node.OldSecretExpires > now && strings.EqualFold(secret, node.OldSecret))) {
+			continue
+		}
+		s.hub.Disconnect(id)
+		dropped[id] = true
+	}
+	return dropped
+}
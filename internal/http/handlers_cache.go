@@ -0,0 +1,7 @@
+package httpapi
+
+import "net/http"
+
+func (s *Server) handleCacheStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, OK(s.store.CacheStats()))
+}
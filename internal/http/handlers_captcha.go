@@ -1,8 +1,31 @@
 package httpapi
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"net"
 	"net/http"
+	"strings"
+	"time"
+
+	"pixia-panel/internal/captcha"
+)
+
+// Values of the captcha_type config. Turnstile is verified by Cloudflare; the
+// image captcha is generated and checked by the panel itself.
+const (
+	captchaTypeTurnstile = "TURNSTILE"
+	captchaTypeImage     = "IMAGE"
+)
+
+// captchaTTL is how long an image challenge or a solved-challenge token stays
+// valid; maxCaptchas bounds how many may be outstanding at once, and
+// maxClientCaptchas how many of them one client may hold.
+const (
+	captchaTTL        = 2 * time.Minute
+	maxCaptchas       = 10000
+	maxClientCaptchas = 10
 )
 
 type captchaVerifyRequest struct {
@@ -21,11 +44,116 @@ func (s *Server) handleCaptchaCheck(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleCaptchaGenerate(w http.ResponseWriter, r *http.Request) {
-	writeJSON(w, http.StatusBadRequest, Err("仅支持Cloudflare验证码"))
+	if s.captchaType(r) != captchaTypeImage {
+		writeJSON(w, http.StatusBadRequest, Err("仅支持Cloudflare验证码"))
+		return
+	}
+	code, err := captcha.RandomCode()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("生成验证码失败"))
+		return
+	}
+	img, err := captcha.RenderPNG(code)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("生成验证码失败"))
+		return
+	}
+	id, err := s.captchas.Put(s.captchaClient(r), code)
+	if errors.Is(err, captcha.ErrClientLimit) {
+		writeJSON(w, http.StatusTooManyRequests, Err("验证码请求过于频繁，请稍后再试"))
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, Err("验证码请求过多，请稍后再试"))
+		return
+	}
+	writeJSON(w, http.StatusOK, OK(map[string]any{
+		"id":         id,
+		"image":      "data:image/png;base64," + base64.StdEncoding.EncodeToString(img),
+		"width":      captcha.ImageWidth,
+		"height":     captcha.ImageHeight,
+		"expireTime": time.Now().Add(s.captchas.TTL()).UnixMilli(),
+	}))
 }
 
+// handleCaptchaVerify checks an image challenge ahead of the login. On success
+// it returns a single-use validToken to send as captchaId with the login.
 func (s *Server) handleCaptchaVerify(w http.ResponseWriter, r *http.Request) {
-	writeJSON(w, http.StatusBadRequest, Err("仅支持Cloudflare验证码"))
+	if s.captchaType(r) != captchaTypeImage {
+		writeJSON(w, http.StatusBadRequest, Err("仅支持Cloudflare验证码"))
+		return
+	}
+	var req captchaVerifyRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("参数错误"))
+		return
+	}
+	id := req.ID
+	if id == "" {
+		id = req.CaptchaID
+	}
+	var code string
+	if len(req.Data) > 0 {
+		_ = json.Unmarshal(req.Data, &code)
+	}
+	if !s.captchas.Check(id, code) {
+		writeCaptchaVerify(w, false, "")
+		return
+	}
+	token, err := s.captchas.Pass(s.captchaClient(r))
+	if err != nil {
+		writeCaptchaVerify(w, false, "")
+		return
+	}
+	writeCaptchaVerify(w, true, token)
+}
+
+// captchaClient identifies the client whose challenges are counted together:
+// its IP, or its /64 for IPv6 where a client usually holds the whole prefix.
+func (s *Server) captchaClient(r *http.Request) string {
+	ip := net.ParseIP(s.clientIP(r))
+	if ip == nil || ip.To4() != nil {
+		return s.clientIP(r)
+	}
+	return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+}
+
+// captchaType returns the configured captcha provider, defaulting to Turnstile.
+func (s *Server) captchaType(r *http.Request) string {
+	if cfg, err := s.store.GetConfigByName(r.Context(), "captcha_type"); err == nil {
+		if strings.EqualFold(cfg.Value, captchaTypeImage) {
+			return captchaTypeImage
+		}
+	}
+	return captchaTypeTurnstile
+}
+
+// checkLoginCaptcha verifies the captcha sent with a login and returns a
+// message if it fails. The image captcha accepts either a challenge ID with
+// its code or a validToken from handleCaptchaVerify in captchaId.
+func (s *Server) checkLoginCaptcha(r *http.Request, req *loginRequest) string {
+	if s.captchaType(r) == captchaTypeImage {
+		if req.CaptchaID == "" {
+			return "验证码不能为空"
+		}
+		if !s.captchas.Check(req.CaptchaID, req.CaptchaCode) {
+			return "验证码校验失败"
+		}
+		return ""
+	}
+
+	turnstileEnabled, turnstileSecret := s.turnstileConfig(r)
+	if !turnstileEnabled {
+		return "请先配置Cloudflare验证码"
+	}
+	if req.TurnstileToken == "" {
+		return "验证码不能为空"
+	}
+	resp, err := captcha.VerifyTurnstile(r.Context(), turnstileSecret, req.TurnstileToken, r.RemoteAddr)
+	if err != nil || !resp.Success {
+		return "验证码校验失败"
+	}
+	return ""
 }
 
 func writeCaptchaVerify(w http.ResponseWriter, ok bool, token string) {
@@ -2,7 +2,6 @@ package httpapi
 
 import (
 	"net/http"
-	"strings"
 
 	"pixia-panel/internal/auth"
 )
@@ -18,9 +17,9 @@ func (s *Server) handleConfigList(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	cfg := make(map[string]string, len(list))
-	includeSecret := s.isAdminRequest(r)
+	includeSecret := s.canReadSecrets(r)
 	for _, item := range list {
-		if item.Name == "turnstile_secret_key" && !includeSecret {
+		if isSecretConfig(item.Name) && !includeSecret {
 			continue
 		}
 		cfg[item.Name] = item.Value
@@ -34,7 +33,7 @@ func (s *Server) handleConfigGet(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, Err("参数错误"))
 		return
 	}
-	if req.Name == "turnstile_secret_key" && !s.isAdminRequest(r) {
+	if isSecretConfig(req.Name) && !s.canReadSecrets(r) {
 		writeJSON(w, http.StatusForbidden, Err("权限不足"))
 		return
 	}
@@ -53,7 +52,7 @@ func (s *Server) handleConfigUpdateBatch(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	for k, v := range payload {
-		_ = s.store.UpsertConfig(r.Context(), k, v)
+		s.updateConfig(r, k, v)
 	}
 	writeJSON(w, http.StatusOK, OK("更新成功"))
 }
@@ -70,25 +69,51 @@ func (s *Server) handleConfigUpdateSingle(w http.ResponseWriter, r *http.Request
 		writeJSON(w, http.StatusBadRequest, Err("name不能为空"))
 		return
 	}
-	_ = s.store.UpsertConfig(r.Context(), name, value)
+	s.updateConfig(r, name, value)
 	writeJSON(w, http.StatusOK, OK("更新成功"))
 }
 
-func (s *Server) isAdminRequest(r *http.Request) bool {
+func (s *Server) updateConfig(r *http.Request, name, value string) {
+	var before any
+	if cfg, err := s.store.GetConfigByName(r.Context(), name); err == nil {
+		before = configAuditValue(cfg.Name, cfg.Value)
+	}
+	if err := s.store.UpsertConfig(r.Context(), name, value); err != nil {
+		return
+	}
+	s.audit(r, auditActionUpdate, auditEntityConfig, 0, before, configAuditValue(name, value))
+}
+
+// isSecretConfig reports whether the config value is a credential hidden from
+// the public config endpoints and the audit log.
+func isSecretConfig(name string) bool {
+	return name == "turnstile_secret_key" || name == "oidc_client_secret"
+}
+
+func configAuditValue(name, value string) map[string]string {
+	if isSecretConfig(name) {
+		value = "***"
+	}
+	return map[string]string{"name": name, "value": value}
+}
+
+// canReadSecrets reports whether the request may see secret configuration
+// values. Config routes are public, so the token is checked here directly.
+func (s *Server) canReadSecrets(r *http.Request) bool {
 	if role, ok := r.Context().Value(ctxRoleID).(int64); ok {
-		return role == 0
+		return s.roleHas(r.Context(), role, permConfigWrite)
 	}
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
+	tokenStr := bearerToken(r)
+	if tokenStr == "" {
 		return false
 	}
-	tokenStr := authHeader
-	if strings.HasPrefix(authHeader, "Bearer ") {
-		tokenStr = strings.TrimSpace(strings.TrimPrefix(authHeader, "Bearer "))
+	if auth.IsAPIToken(tokenStr) {
+		token, user, err := s.authenticateAPIToken(r, tokenStr)
+		return err == nil && !user.MustChangePassword && tokenAllows(token.Scopes, scopeRead) && s.roleHas(r.Context(), user.RoleID, permConfigWrite)
 	}
-	claims, err := auth.Parse(s.jwtSecret, tokenStr)
-	if err != nil {
+	claims, err := s.authenticateSession(r, tokenStr)
+	if err != nil || claims.MustChangePassword {
 		return false
 	}
-	return claims.RoleID == 0
+	return s.roleHas(r.Context(), claims.RoleID, permConfigWrite)
 }
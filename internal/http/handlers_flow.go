@@ -3,13 +3,13 @@ package httpapi
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"io"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
+	"pixia-panel/internal/auth"
 	"pixia-panel/internal/crypto"
 	"pixia-panel/internal/flow"
 	"pixia-panel/internal/gost"
@@ -28,16 +28,6 @@ type encryptedMessage struct {
 	Timestamp int64  `json:"timestamp"`
 }
 
-type configItem struct {
-	Name string `json:"name"`
-}
-
-type gostConfig struct {
-	Services []configItem `json:"services"`
-	Chains   []configItem `json:"chains"`
-	Limiters []configItem `json:"limiters"`
-}
-
 func (s *Server) handleFlowTest(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write([]byte("test"))
 }
@@ -75,19 +65,54 @@ func (s *Server) handleFlowConfig(w http.ResponseWriter, r *http.Request) {
 		payload = plain
 	}
 
-	var cfg gostConfig
-	if err := json.Unmarshal(payload, &cfg); err != nil {
+	objects, err := gost.ParseConfig(payload)
+	if err != nil {
 		writeJSON(w, http.StatusBadRequest, Err("解析失败"))
 		return
 	}
-
-	s.cleanOrphanedServices(r, node.ID, cfg.Services)
-	s.cleanOrphanedChains(r, node.ID, cfg.Chains)
-	s.cleanOrphanedLimiters(r, node.ID, cfg.Limiters)
+	encoded, err := json.Marshal(objects)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("保存失败"))
+		return
+	}
+	report := &storepkg.NodeConfigReport{NodeID: node.ID, Objects: encoded, ReportedTime: time.Now().UnixMilli()}
+	if err := s.store.SaveNodeConfigReport(r.Context(), report); err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("保存失败"))
+		return
+	}
+	if drift, err := s.nodeDrift(r.Context(), report); err == nil {
+		s.reconcileNode(r.Context(), drift)
+	}
 
 	_, _ = w.Write([]byte("ok"))
 }
 
+type flowEnrollRequest struct {
+	Token string `json:"token"`
+}
+
+// handleFlowEnroll exchanges a one-time install token for the node secret.
+// The token is consumed and the enrolling host's IP recorded.
+func (s *Server) handleFlowEnroll(w http.ResponseWriter, r *http.Request) {
+	var req flowEnrollRequest
+	if err := decodeJSON(r, &req); err != nil || strings.TrimSpace(req.Token) == "" {
+		writeJSON(w, http.StatusBadRequest, Err("参数错误"))
+		return
+	}
+	enrollment, err := s.store.ConsumeNodeEnrollment(r.Context(), auth.HashToken(strings.TrimSpace(req.Token)), time.Now().UnixMilli(), s.clientIP(r))
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, Err("安装令牌无效或已过期"))
+		return
+	}
+	node, err := s.store.GetNodeByID(r.Context(), enrollment.NodeID)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, Err("节点不存在"))
+		return
+	}
+	s.audit(r, auditActionEnroll, auditEntityNode, node.ID, nil, enrollment)
+	writeJSON(w, http.StatusOK, OK(map[string]string{"secret": node.Secret}))
+}
+
 func (s *Server) handleFlowUpload(w http.ResponseWriter, r *http.Request) {
 	secret := r.URL.Query().Get("secret")
 	if secret == "" {
@@ -95,7 +120,7 @@ func (s *Server) handleFlowUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err := s.store.GetNodeBySecret(r.Context(), secret)
+	node, err := s.store.GetNodeBySecret(r.Context(), secret)
 	if err != nil {
 		writeJSON(w, http.StatusUnauthorized, Err("节点不存在"))
 		return
@@ -141,10 +166,11 @@ func (s *Server) handleFlowUpload(w http.ResponseWriter, r *http.Request) {
 	userID, _ := strconv.ParseInt(parts[1], 10, 64)
 	userTunnelID, _ := strconv.ParseInt(parts[2], 10, 64)
 
-	if err := s.flow.Apply(r.Context(), flow.Update{
+	if err := s.flow.Add(flow.Update{
 		ForwardID:    forwardID,
 		UserID:       userID,
 		UserTunnelID: userTunnelID,
+		NodeID:       node.ID,
 		Down:         dto.D,
 		Up:           dto.U,
 	}); err != nil {
@@ -152,65 +178,9 @@ func (s *Server) handleFlowUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s.checkAndPauseIfNeeded(r, forwardID, userID, userTunnelID)
-
 	_, _ = w.Write([]byte("ok"))
 }
 
-func (s *Server) cleanOrphanedServices(r *http.Request, nodeID int64, services []configItem) {
-	for _, svc := range services {
-		if svc.Name == "" || svc.Name == "web_api" {
-			continue
-		}
-		forwardID, base, typ, ok := parseManagedConfigName(svc.Name)
-		if !ok {
-			continue
-		}
-		if !s.shouldDeleteOrphanedForwardConfig(r.Context(), forwardID, base) {
-			continue
-		}
-
-		switch typ {
-		case "tcp":
-			_ = s.enqueueGost(r, nodeID, "DeleteService", gost.DeleteServiceData(base))
-		case "tls":
-			_ = s.enqueueGost(r, nodeID, "DeleteService", gost.DeleteRemoteServiceData(base))
-		}
-	}
-}
-
-func (s *Server) cleanOrphanedChains(r *http.Request, nodeID int64, chains []configItem) {
-	for _, chain := range chains {
-		if chain.Name == "" {
-			continue
-		}
-		forwardID, base, typ, ok := parseManagedConfigName(chain.Name)
-		if !ok || typ != "chains" {
-			continue
-		}
-		if !s.shouldDeleteOrphanedForwardConfig(r.Context(), forwardID, base) {
-			continue
-		}
-		_ = s.enqueueGost(r, nodeID, "DeleteChains", gost.DeleteChainsData(base))
-	}
-}
-
-func (s *Server) cleanOrphanedLimiters(r *http.Request, nodeID int64, limiters []configItem) {
-	for _, limiter := range limiters {
-		if limiter.Name == "" {
-			continue
-		}
-		id, err := strconv.ParseInt(limiter.Name, 10, 64)
-		if err != nil {
-			continue
-		}
-		if _, err := s.store.GetSpeedLimitByID(r.Context(), id); err == nil {
-			continue
-		}
-		_ = s.enqueueGost(r, nodeID, "DeleteLimiters", gost.DeleteLimitersData(id))
-	}
-}
-
 func parseManagedConfigName(name string) (forwardID int64, base string, typ string, ok bool) {
 	parts := strings.Split(name, "_")
 	if len(parts) < 4 {
@@ -223,63 +193,81 @@ func parseManagedConfigName(name string) (forwardID int64, base string, typ stri
 	return parsedID, strings.Join(parts[:3], "_"), parts[3], true
 }
 
-func (s *Server) shouldDeleteOrphanedForwardConfig(ctx context.Context, forwardID int64, currentBase string) bool {
-	fw, err := s.store.GetForwardByID(ctx, forwardID)
-	if err != nil {
-		return errors.Is(err, storepkg.ErrNotFound)
-	}
-	expectedBase := buildServiceName(fw.ID, fw.UserID, s.resolveUserTunnelIDCtx(ctx, fw.UserID, fw.TunnelID))
-	return expectedBase != currentBase
-}
-
-func (s *Server) checkAndPauseIfNeeded(r *http.Request, forwardID, userID, userTunnelID int64) {
-	user, err := s.store.GetUserByID(r.Context(), userID)
-	if err != nil {
-		return
-	}
-	userFlowLimit := user.Flow * 1024 * 1024 * 1024
-	userCurrent := user.InFlow + user.OutFlow
-	if userFlowLimit < userCurrent || (user.ExpTime != 0 && user.ExpTime <= time.Now().UnixMilli()) || user.Status != 1 {
-		s.pauseAllUserForwards(r, userID)
-		return
-	}
+// enforceQuotas runs once per flow flush and pauses forwards of users, user
+// tunnels or forwards that received traffic but are no longer allowed to,
+// including because a reseller above the user ran out.
+func (s *Server) enforceQuotas(ctx context.Context, applied []storepkg.FlowDelta) {
+	now := time.Now().UnixMilli()
+	users := make(map[int64]bool)
+	userTunnels := make(map[int64]bool)
+	pausedTunnels := make(map[[2]int64]bool)
+	parentChecked := make(map[[2]int64]bool)
+	forwards := make(map[int64]bool)
+
+	for _, delta := range applied {
+		paused, seen := users[delta.UserID]
+		if !seen {
+			if user, err := s.store.GetUserByID(ctx, delta.UserID); err == nil {
+				if reason := userPauseReason(user, now); reason != "" {
+					s.pauseAllUserForwards(ctx, delta.UserID, reason)
+					paused = true
+				}
+			}
+			users[delta.UserID] = paused
+		}
+		if paused {
+			continue
+		}
 
-	if userTunnelID != 0 {
-		ut, err := s.store.GetUserTunnelByID(r.Context(), userTunnelID)
-		if err == nil {
-			utLimit := ut.Flow * 1024 * 1024 * 1024
-			utCurrent := ut.InFlow + ut.OutFlow
-			if utLimit <= utCurrent || (ut.ExpTime != 0 && ut.ExpTime <= time.Now().UnixMilli()) || ut.Status != 1 {
-				s.pauseSpecificForward(r, ut.UserID, ut.TunnelID)
-				return
+		if delta.UserTunnelID != 0 && !userTunnels[delta.UserTunnelID] {
+			userTunnels[delta.UserTunnelID] = true
+			if ut, err := s.store.GetUserTunnelByID(ctx, delta.UserTunnelID); err == nil {
+				if reason := userTunnelPauseReason(ut, now); reason != "" {
+					s.pauseSpecificForward(ctx, ut.UserID, ut.TunnelID, reason)
+					pausedTunnels[[2]int64{ut.UserID, ut.TunnelID}] = true
+				}
 			}
 		}
-	}
+		pair := [2]int64{delta.UserID, delta.TunnelID}
+		if !parentChecked[pair] {
+			parentChecked[pair] = true
+			if user, err := s.store.GetUserByID(ctx, delta.UserID); err == nil {
+				if reason := s.parentPauseReason(ctx, user, delta.TunnelID, now); reason != "" {
+					s.pauseSpecificForward(ctx, delta.UserID, delta.TunnelID, reason)
+					pausedTunnels[pair] = true
+				}
+			}
+		}
+		if pausedTunnels[pair] || forwards[delta.ForwardID] {
+			continue
+		}
+		forwards[delta.ForwardID] = true
 
-	// forward status check
-	forward, err := s.store.GetForwardByID(r.Context(), forwardID)
-	if err == nil && forward.Status != 1 {
-		s.pauseForwardByID(r, forwardID)
+		// forward status check
+		forward, err := s.store.GetForwardByID(ctx, delta.ForwardID)
+		if err == nil && forward.Status != 1 {
+			s.pauseForwardByID(ctx, delta.ForwardID)
+		}
 	}
 }
 
-func (s *Server) pauseAllUserForwards(r *http.Request, userID int64) {
-	forwards, err := s.store.ListForwardsByUser(r.Context(), userID)
+func (s *Server) pauseAllUserForwards(ctx context.Context, userID int64, reason string) {
+	forwards, err := s.store.ListForwardsByUser(ctx, userID)
 	if err != nil {
 		return
 	}
 	for _, fw := range forwards {
-		name := buildServiceName(fw.ID, fw.UserID, s.resolveUserTunnelID(r, fw.UserID, fw.TunnelID))
-		_ = s.enqueueGost(r, fw.InNodeID, "PauseService", gost.PauseServiceData(name))
+		name := buildServiceName(fw.ID, fw.UserID, s.resolveUserTunnelIDCtx(ctx, fw.UserID, fw.TunnelID))
+		_ = s.enqueueGostCtx(ctx, fw.InNodeID, "PauseService", gost.PauseServiceData(name))
 		if fw.TunnelType == 2 {
-			_ = s.enqueueGost(r, fw.OutNodeID, "PauseService", gost.PauseRemoteServiceData(name))
+			_ = s.enqueueGostCtx(ctx, fw.OutNodeID, "PauseService", gost.PauseRemoteServiceData(name))
 		}
-		_ = s.store.UpdateForwardStatus(r.Context(), fw.ID, 0, "paused", time.Now().UnixMilli())
+		_ = s.store.PauseForward(ctx, fw.ID, reason, time.Now().UnixMilli())
 	}
 }
 
-func (s *Server) pauseSpecificForward(r *http.Request, userID, tunnelID int64) {
-	forwards, err := s.store.ListForwardsByUser(r.Context(), userID)
+func (s *Server) pauseSpecificForward(ctx context.Context, userID, tunnelID int64, reason string) {
+	forwards, err := s.store.ListForwardsByUser(ctx, userID)
 	if err != nil {
 		return
 	}
@@ -287,31 +275,32 @@ func (s *Server) pauseSpecificForward(r *http.Request, userID, tunnelID int64) {
 		if fw.TunnelID != tunnelID {
 			continue
 		}
-		name := buildServiceName(fw.ID, fw.UserID, s.resolveUserTunnelID(r, fw.UserID, fw.TunnelID))
-		_ = s.enqueueGost(r, fw.InNodeID, "PauseService", gost.PauseServiceData(name))
+		name := buildServiceName(fw.ID, fw.UserID, s.resolveUserTunnelIDCtx(ctx, fw.UserID, fw.TunnelID))
+		_ = s.enqueueGostCtx(ctx, fw.InNodeID, "PauseService", gost.PauseServiceData(name))
 		if fw.TunnelType == 2 {
-			_ = s.enqueueGost(r, fw.OutNodeID, "PauseService", gost.PauseRemoteServiceData(name))
+			_ = s.enqueueGostCtx(ctx, fw.OutNodeID, "PauseService", gost.PauseRemoteServiceData(name))
 		}
-		_ = s.store.UpdateForwardStatus(r.Context(), fw.ID, 0, "paused", time.Now().UnixMilli())
+		_ = s.store.PauseForward(ctx, fw.ID, reason, time.Now().UnixMilli())
 	}
 }
 
-func (s *Server) pauseForwardByID(r *http.Request, forwardID int64) {
-	fw, err := s.store.GetForwardByID(r.Context(), forwardID)
+// pauseForwardByID re-sends the pause for a forward that is already paused, keeping its reason.
+func (s *Server) pauseForwardByID(ctx context.Context, forwardID int64) {
+	fw, err := s.store.GetForwardByID(ctx, forwardID)
 	if err != nil {
 		return
 	}
-	tunnel, err := s.store.GetTunnelByID(r.Context(), fw.TunnelID)
+	tunnel, err := s.store.GetTunnelByID(ctx, fw.TunnelID)
 	if err != nil {
 		return
 	}
-	userTunnelID := s.resolveUserTunnelID(r, fw.UserID, fw.TunnelID)
+	userTunnelID := s.resolveUserTunnelIDCtx(ctx, fw.UserID, fw.TunnelID)
 	name := buildServiceName(fw.ID, fw.UserID, userTunnelID)
-	_ = s.enqueueGost(r, tunnel.InNodeID, "PauseService", gost.PauseServiceData(name))
+	_ = s.enqueueGostCtx(ctx, tunnel.InNodeID, "PauseService", gost.PauseServiceData(name))
 	if tunnel.Type == 2 {
-		_ = s.enqueueGost(r, tunnel.OutNodeID, "PauseService", gost.PauseRemoteServiceData(name))
+		_ = s.enqueueGostCtx(ctx, tunnel.OutNodeID, "PauseService", gost.PauseRemoteServiceData(name))
 	}
-	_ = s.store.UpdateForwardStatus(r.Context(), fw.ID, 0, "paused", time.Now().UnixMilli())
+	_ = s.store.PauseForward(ctx, fw.ID, fw.PauseReason, time.Now().UnixMilli())
 }
 
 func (s *Server) resolveUserTunnelID(r *http.Request, userID, tunnelID int64) int64 {
@@ -63,7 +63,6 @@ func (s *Server) handleForwardCreate(w http.ResponseWriter, r *http.Request) {
 		req.Strategy = "fifo"
 	}
 	currentUserID := userIDFromCtx(r)
-	roleID := roleIDFromCtx(r)
 
 	user, err := s.store.GetUserByID(r.Context(), currentUserID)
 	if err != nil {
@@ -71,7 +70,7 @@ func (s *Server) handleForwardCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if roleID != 0 {
+	if !s.can(r, permForwardWrite) {
 		if user.Status == 0 || (user.ExpTime != 0 && user.ExpTime <= time.Now().UnixMilli()) {
 			writeJSON(w, http.StatusBadRequest, Err("用户已到期或被禁用"))
 			return
@@ -89,7 +88,7 @@ func (s *Server) handleForwardCreate(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var userTunnel *store.UserTunnel
-	if roleID != 0 {
+	if !s.can(r, permForwardWrite) {
 		ut, err := s.store.GetUserTunnelByUserAndTunnel(r.Context(), currentUserID, req.TunnelID)
 		if err != nil {
 			writeJSON(w, http.StatusBadRequest, Err("用户没有该隧道权限"))
@@ -110,16 +109,21 @@ func (s *Server) handleForwardCreate(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		count, _ := s.store.CountForwardsByUser(r.Context(), currentUserID)
+		// Forwards of sub-users count against their reseller's limits.
+		count, _ := s.store.CountSubtreeForwards(r.Context(), currentUserID, 0)
 		if count >= user.Num {
 			writeJSON(w, http.StatusBadRequest, Err("用户转发数量已满"))
 			return
 		}
-		count, _ = s.store.CountForwardsByUserTunnel(r.Context(), currentUserID, req.TunnelID)
+		count, _ = s.store.CountSubtreeForwards(r.Context(), currentUserID, req.TunnelID)
 		if count >= userTunnel.Num {
 			writeJSON(w, http.StatusBadRequest, Err("隧道转发数量已满"))
 			return
 		}
+		if msg := s.checkResellerPools(r.Context(), user, req.TunnelID, time.Now().UnixMilli()); msg != "" {
+			writeJSON(w, http.StatusBadRequest, Err(msg))
+			return
+		}
 	}
 
 	inPort, outPort, err := s.allocatePorts(r, tunnel, req.InPort, nil)
@@ -144,7 +148,7 @@ func (s *Server) handleForwardCreate(w http.ResponseWriter, r *http.Request) {
 		UpdatedTime:   time.Now().UnixMilli(),
 		Status:        1,
 		Inx:           0,
-		Lifecycle:     "creating",
+		Lifecycle:     store.LifecycleCreating,
 	}
 
 	id, err := s.store.InsertForward(r.Context(), fw)
@@ -153,6 +157,7 @@ func (s *Server) handleForwardCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	fw.ID = id
+	s.audit(r, auditActionCreate, auditEntityForward, id, nil, fw)
 
 	limiter := s.resolveSpeedLimiter(r, currentUserID, req.TunnelID)
 	s.enqueueForwardGost(r, fw, tunnel, limiter, "AddService")
@@ -161,12 +166,13 @@ func (s *Server) handleForwardCreate(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleForwardList(w http.ResponseWriter, r *http.Request) {
-	role := roleIDFromCtx(r)
 	userID := userIDFromCtx(r)
 	var list []store.ForwardWithTunnel
 	var err error
-	if role == 0 {
+	if s.can(r, permForwardRead) {
 		list, err = s.store.ListForwardsAll(r.Context())
+	} else if s.can(r, permSubUserWrite) {
+		list, err = s.store.ListForwardsBySubtree(r.Context(), userID)
 	} else {
 		list, err = s.store.ListForwardsByUser(r.Context(), userID)
 	}
@@ -203,16 +209,13 @@ func (s *Server) handleForwardUpdate(w http.ResponseWriter, r *http.Request) {
 	if strings.TrimSpace(req.Strategy) == "" {
 		req.Strategy = "fifo"
 	}
-	role := roleIDFromCtx(r)
-	currentUserID := userIDFromCtx(r)
-
 	fw, err := s.store.GetForwardByID(r.Context(), req.ID)
 	if err != nil {
 		writeJSON(w, http.StatusBadRequest, Err("转发不存在"))
 		return
 	}
 
-	if role != 0 && fw.UserID != currentUserID {
+	if !s.can(r, permForwardWrite) && !s.ownsUser(r, fw.UserID) {
 		writeJSON(w, http.StatusForbidden, Err("无权限"))
 		return
 	}
@@ -227,6 +230,7 @@ func (s *Server) handleForwardUpdate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	before := *fw
 	var inPort = fw.InPort
 	var outPort = fw.OutPort
 	if req.TunnelID != fw.TunnelID || (req.InPort != nil && *req.InPort != fw.InPort) {
@@ -247,12 +251,14 @@ func (s *Server) handleForwardUpdate(w http.ResponseWriter, r *http.Request) {
 	fw.OutPort = outPort
 	fw.InterfaceName = req.InterfaceName
 	fw.UpdatedTime = time.Now().UnixMilli()
-	fw.Lifecycle = "updating"
+	fw.Lifecycle = store.LifecycleUpdating
+	fw.LastError = nil
 
 	if err := s.store.UpdateForward(r.Context(), fw); err != nil {
 		writeJSON(w, http.StatusInternalServerError, Err("更新失败"))
 		return
 	}
+	s.audit(r, auditActionUpdate, auditEntityForward, fw.ID, &before, fw)
 
 	limiter := s.resolveSpeedLimiter(r, fw.UserID, fw.TunnelID)
 	s.enqueueForwardGost(r, fw, tunnel, limiter, "UpdateService")
@@ -271,8 +277,7 @@ func (s *Server) handleForwardDelete(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, Err("转发不存在"))
 		return
 	}
-	role := roleIDFromCtx(r)
-	if role != 0 && fw.UserID != userIDFromCtx(r) {
+	if !s.can(r, permForwardWrite) && !s.ownsUser(r, fw.UserID) {
 		writeJSON(w, http.StatusForbidden, Err("无权限"))
 		return
 	}
@@ -289,6 +294,7 @@ func (s *Server) handleForwardDelete(w http.ResponseWriter, r *http.Request) {
 		_ = s.enqueueGost(r, tunnel.OutNodeID, "DeleteService", gost.DeleteRemoteServiceData(name))
 	}
 	_ = s.store.DeleteForward(r.Context(), fw.ID)
+	s.audit(r, auditActionDelete, auditEntityForward, fw.ID, fw, nil)
 	writeJSON(w, http.StatusOK, OK("端口转发删除成功"))
 }
 
@@ -303,12 +309,12 @@ func (s *Server) handleForwardForceDelete(w http.ResponseWriter, r *http.Request
 		writeJSON(w, http.StatusBadRequest, Err("转发不存在"))
 		return
 	}
-	role := roleIDFromCtx(r)
-	if role != 0 && fw.UserID != userIDFromCtx(r) {
+	if !s.can(r, permForwardWrite) && !s.ownsUser(r, fw.UserID) {
 		writeJSON(w, http.StatusForbidden, Err("无权限"))
 		return
 	}
 	_ = s.store.DeleteForward(r.Context(), req.ID)
+	s.audit(r, auditActionForceDelete, auditEntityForward, req.ID, fw, nil)
 	writeJSON(w, http.StatusOK, OK("端口转发强制删除成功"))
 }
 
@@ -323,8 +329,7 @@ func (s *Server) handleForwardPause(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, Err("转发不存在"))
 		return
 	}
-	role := roleIDFromCtx(r)
-	if role != 0 && fw.UserID != userIDFromCtx(r) {
+	if !s.can(r, permForwardWrite) && !s.ownsUser(r, fw.UserID) {
 		writeJSON(w, http.StatusForbidden, Err("无权限"))
 		return
 	}
@@ -338,7 +343,13 @@ func (s *Server) handleForwardPause(w http.ResponseWriter, r *http.Request) {
 	if tunnel.Type == 2 {
 		_ = s.enqueueGost(r, tunnel.OutNodeID, "PauseService", gost.PauseRemoteServiceData(name))
 	}
-	_ = s.store.UpdateForwardStatus(r.Context(), fw.ID, 0, "paused", time.Now().UnixMilli())
+	reason := store.PauseReasonUser
+	if fw.UserID != userIDFromCtx(r) {
+		reason = store.PauseReasonAdmin
+	}
+	_ = s.store.PauseForward(r.Context(), fw.ID, reason, time.Now().UnixMilli())
+	after, _ := s.store.GetForwardByID(r.Context(), fw.ID)
+	s.audit(r, auditActionPause, auditEntityForward, fw.ID, fw, after)
 	writeJSON(w, http.StatusOK, OK("服务已暂停"))
 }
 
@@ -353,11 +364,16 @@ func (s *Server) handleForwardResume(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, Err("转发不存在"))
 		return
 	}
-	role := roleIDFromCtx(r)
-	if role != 0 && fw.UserID != userIDFromCtx(r) {
+	if !s.can(r, permForwardWrite) && !s.ownsUser(r, fw.UserID) {
 		writeJSON(w, http.StatusForbidden, Err("无权限"))
 		return
 	}
+	// Forwards paused by someone else stay paused until an administrator or,
+	// for sub-users, their reseller resumes them.
+	if fw.PauseReason == store.PauseReasonAdmin && !s.can(r, permForwardWrite) && !(s.can(r, permSubUserWrite) && s.isSubUser(r, fw.UserID)) {
+		writeJSON(w, http.StatusForbidden, Err("转发已被管理员暂停"))
+		return
+	}
 	tunnel, err := s.store.GetTunnelByID(r.Context(), fw.TunnelID)
 	if err != nil {
 		writeJSON(w, http.StatusBadRequest, Err("隧道不存在"))
@@ -368,7 +384,9 @@ func (s *Server) handleForwardResume(w http.ResponseWriter, r *http.Request) {
 	if tunnel.Type == 2 {
 		_ = s.enqueueGost(r, tunnel.OutNodeID, "ResumeService", gost.ResumeRemoteServiceData(name))
 	}
-	_ = s.store.UpdateForwardStatus(r.Context(), fw.ID, 1, "active", time.Now().UnixMilli())
+	_ = s.store.ResumeForward(r.Context(), fw.ID, time.Now().UnixMilli())
+	after, _ := s.store.GetForwardByID(r.Context(), fw.ID)
+	s.audit(r, auditActionResume, auditEntityForward, fw.ID, fw, after)
 	writeJSON(w, http.StatusOK, OK("服务已恢复"))
 }
 
@@ -383,8 +401,7 @@ func (s *Server) handleForwardDiagnose(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, Err("转发不存在"))
 		return
 	}
-	role := roleIDFromCtx(r)
-	if role != 0 && fw.UserID != userIDFromCtx(r) {
+	if !s.can(r, permForwardRead) && !s.ownsUser(r, fw.UserID) {
 		writeJSON(w, http.StatusForbidden, Err("无权限"))
 		return
 	}
@@ -447,16 +464,14 @@ func (s *Server) handleForwardUpdateOrder(w http.ResponseWriter, r *http.Request
 		writeJSON(w, http.StatusBadRequest, Err("参数错误"))
 		return
 	}
-	role := roleIDFromCtx(r)
-	if role != 0 {
-		userID := userIDFromCtx(r)
+	if !s.can(r, permForwardWrite) {
 		for _, fw := range req.Forwards {
 			item, err := s.store.GetForwardByID(r.Context(), fw.ID)
 			if err != nil {
 				writeJSON(w, http.StatusBadRequest, Err("转发不存在"))
 				return
 			}
-			if item.UserID != userID {
+			if !s.ownsUser(r, item.UserID) {
 				writeJSON(w, http.StatusForbidden, Err("无权限"))
 				return
 			}
@@ -465,6 +480,7 @@ func (s *Server) handleForwardUpdateOrder(w http.ResponseWriter, r *http.Request
 	for _, fw := range req.Forwards {
 		_ = s.store.UpdateForwardOrder(r.Context(), fw.ID, fw.Inx)
 	}
+	s.audit(r, auditActionReorder, auditEntityForward, 0, nil, req.Forwards)
 	writeJSON(w, http.StatusOK, OK("更新成功"))
 }
 
@@ -578,7 +594,7 @@ func (s *Server) enqueueForwardGostCtx(ctx context.Context, fw *store.Forward, t
 	if action == "UpdateService" {
 		data = gost.UpdateServiceData(name, fw.InPort, limiter, fw.RemoteAddr, gost.TunnelConfig{Type: tunnel.Type, Protocol: tunnel.Protocol, TCPListenAddr: tunnel.TCPListenAddr, UDPListenAddr: tunnel.UDPListenAddr}, fw.Strategy, fw.InterfaceName)
 	}
-	_ = s.enqueueGostCtx(ctx, tunnel.InNodeID, action, data)
+	_ = s.enqueueForwardCommandCtx(ctx, fw.ID, tunnel.InNodeID, action, data)
 
 	if tunnel.Type == 2 && fw.OutPort != nil {
 		s.ensureLimiterConfig(ctx, tunnel.OutNodeID, limiter)
@@ -586,17 +602,23 @@ func (s *Server) enqueueForwardGostCtx(ctx context.Context, fw *store.Forward, t
 		if action == "UpdateService" {
 			remote = gost.UpdateRemoteServiceData(name, *fw.OutPort, fw.RemoteAddr, tunnel.Protocol, fw.Strategy, fw.InterfaceName, limiter)
 		}
-		_ = s.enqueueGostCtx(ctx, tunnel.OutNodeID, action, remote)
-		outIP := tunnel.OutIP
-		if outNode, err := s.store.GetNodeByID(ctx, tunnel.OutNodeID); err == nil {
-			outIP = pickNodeEntryIP(derefString(outNode.IP), outNode.ServerIP)
-		}
+		_ = s.enqueueForwardCommandCtx(ctx, fw.ID, tunnel.OutNodeID, action, remote)
+		outIP := s.tunnelOutIP(ctx, tunnel)
 		chains := gost.AddChainsData(name, outIP+":"+strconv.FormatInt(*fw.OutPort, 10), tunnel.Protocol, fw.InterfaceName)
 		if action == "UpdateService" {
 			chains = gost.UpdateChainsData(name, outIP+":"+strconv.FormatInt(*fw.OutPort, 10), tunnel.Protocol, fw.InterfaceName)
 		}
-		_ = s.enqueueGostCtx(ctx, tunnel.InNodeID, map[string]string{"AddService": "AddChains", "UpdateService": "UpdateChains"}[action], chains)
+		_ = s.enqueueForwardCommandCtx(ctx, fw.ID, tunnel.InNodeID, map[string]string{"AddService": "AddChains", "UpdateService": "UpdateChains"}[action], chains)
+	}
+}
+
+// tunnelOutIP returns the address in-node chains dial to reach the tunnel's
+// out node.
+func (s *Server) tunnelOutIP(ctx context.Context, tunnel *store.Tunnel) string {
+	if outNode, err := s.store.GetNodeByID(ctx, tunnel.OutNodeID); err == nil {
+		return pickNodeEntryIP(derefString(outNode.IP), outNode.ServerIP)
 	}
+	return tunnel.OutIP
 }
 
 func (s *Server) ensureLimiterConfig(ctx context.Context, nodeID int64, limiterID *int64) {
@@ -4,10 +4,12 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strings"
 	"time"
 
+	"pixia-panel/internal/auth"
 	"pixia-panel/internal/store"
 )
 
@@ -42,6 +44,14 @@ type nodeInstallRequest struct {
 	ID int64 `json:"id"`
 }
 
+// nodeEnrollTTL is how long an install command can be used.
+const nodeEnrollTTL = 30 * time.Minute
+
+type nodeRotateSecretRequest struct {
+	ID           int64 `json:"id"`
+	GraceSeconds int64 `json:"graceSeconds"`
+}
+
 type nodeCheckStatusRequest struct {
 	NodeID *int64 `json:"nodeId"`
 }
@@ -78,10 +88,13 @@ func (s *Server) handleNodeCreate(w http.ResponseWriter, r *http.Request) {
 		CreatedTime: time.Now().UnixMilli(),
 		Status:      0,
 	}
-	if _, err := s.store.InsertNode(r.Context(), node); err != nil {
+	id, err := s.store.InsertNode(r.Context(), node)
+	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, Err("创建失败"))
 		return
 	}
+	node.ID = id
+	s.audit(r, auditActionCreate, auditEntityNode, id, nil, node)
 	writeJSON(w, http.StatusOK, OK("节点创建成功"))
 }
 
@@ -105,6 +118,7 @@ func (s *Server) handleNodeUpdate(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, Err("节点不存在"))
 		return
 	}
+	before := *node
 
 	if req.HTTP != nil {
 		node.HTTP = *req.HTTP
@@ -130,6 +144,7 @@ func (s *Server) handleNodeUpdate(w http.ResponseWriter, r *http.Request) {
 
 	_ = s.store.UpdateTunnelsInIP(r.Context(), node.ID, req.IP)
 	_ = s.store.UpdateTunnelsOutIP(r.Context(), node.ID, pickNodeEntryIP(req.IP, req.ServerIP))
+	s.audit(r, auditActionUpdate, auditEntityNode, node.ID, &before, node)
 
 	// notify node if online
 	if node.Status == 1 {
@@ -161,11 +176,13 @@ func (s *Server) handleNodeDelete(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, Err("该节点还有隧道作为出口使用"))
 		return
 	}
+	before, _ := s.store.GetNodeByID(r.Context(), req.ID)
 	if err := s.store.DeleteNode(r.Context(), req.ID); err != nil {
 		writeJSON(w, http.StatusInternalServerError, Err("删除失败"))
 		return
 	}
 	_, _ = s.store.MarkOutboxDeadByNodeID(r.Context(), req.ID)
+	s.audit(r, auditActionDelete, auditEntityNode, req.ID, before, nil)
 	writeJSON(w, http.StatusOK, OK("节点删除成功"))
 }
 
@@ -186,10 +203,149 @@ func (s *Server) handleNodeInstall(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	addr := formatPanelAddr(cfg.Value)
-	cmd := "curl -fsSL https://raw.githubusercontent.com/pixia1234/pixia-panel/main/node_install.sh -o ./node_install.sh && chmod +x ./node_install.sh && ./node_install.sh -a " + addr + " -s " + node.Secret
+
+	// The command carries a one-time token instead of the secret, so a
+	// leaked command stops working once the node has enrolled.
+	token, err := auth.GenerateEnrollToken()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("生成失败"))
+		return
+	}
+	now := time.Now()
+	if err := s.store.ExpireNodeEnrollments(r.Context(), node.ID, now.UnixMilli()); err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("生成失败"))
+		return
+	}
+	enrollment := &store.NodeEnrollment{
+		NodeID:      node.ID,
+		TokenHash:   auth.HashToken(token),
+		CreatedBy:   userIDFromCtx(r),
+		CreatedTime: now.UnixMilli(),
+		ExpTime:     now.Add(nodeEnrollTTL).UnixMilli(),
+	}
+	id, err := s.store.InsertNodeEnrollment(r.Context(), enrollment)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("生成失败"))
+		return
+	}
+	enrollment.ID = id
+	s.audit(r, auditActionCreate, auditEntityNodeEnroll, id, nil, enrollment)
+
+	cmd := "curl -fsSL https://raw.githubusercontent.com/pixia1234/pixia-panel/main/node_install.sh -o ./node_install.sh && chmod +x ./node_install.sh && ./node_install.sh -a " + addr + " -t " + token
 	writeJSON(w, http.StatusOK, OK(cmd))
 }
 
+// handleNodeEnrollments lists the install tokens issued for a node, including
+// when and from which IP each was used.
+func (s *Server) handleNodeEnrollments(w http.ResponseWriter, r *http.Request) {
+	var req nodeInstallRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("参数错误"))
+		return
+	}
+	list, err := s.store.ListNodeEnrollments(r.Context(), req.ID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("获取失败"))
+		return
+	}
+	writeJSON(w, http.StatusOK, OK(list))
+}
+
+// handleNodeDrift compares the config a node last reported with the config
+// the panel expects it to run.
+func (s *Server) handleNodeDrift(w http.ResponseWriter, r *http.Request) {
+	var req nodeInstallRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("参数错误"))
+		return
+	}
+	drift, ok := s.loadNodeDrift(w, r, req.ID)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, OK(drift))
+}
+
+// handleNodeReconcile enqueues the commands that clear a node's drift.
+func (s *Server) handleNodeReconcile(w http.ResponseWriter, r *http.Request) {
+	var req nodeInstallRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("参数错误"))
+		return
+	}
+	drift, ok := s.loadNodeDrift(w, r, req.ID)
+	if !ok {
+		return
+	}
+	s.reconcileNode(r.Context(), drift)
+	s.audit(r, auditActionReconcile, auditEntityNode, req.ID, nil, drift)
+	writeJSON(w, http.StatusOK, OK(drift))
+}
+
+func (s *Server) loadNodeDrift(w http.ResponseWriter, r *http.Request, nodeID int64) (*nodeDrift, bool) {
+	if _, err := s.store.GetNodeByID(r.Context(), nodeID); err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("节点不存在"))
+		return nil, false
+	}
+	report, err := s.store.GetNodeConfigReport(r.Context(), nodeID)
+	if errors.Is(err, store.ErrNotFound) {
+		writeJSON(w, http.StatusBadRequest, Err("节点尚未上报配置"))
+		return nil, false
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("获取失败"))
+		return nil, false
+	}
+	drift, err := s.nodeDrift(r.Context(), report)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("配置比对失败"))
+		return nil, false
+	}
+	return drift, true
+}
+
+// handleNodeRotateSecret replaces a node's secret and pushes it to the
+// connected node. The old secret keeps working for the grace window so
+// in-flight traffic reports and reconnects are not rejected.
+func (s *Server) handleNodeRotateSecret(w http.ResponseWriter, r *http.Request) {
+	var req nodeRotateSecretRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("参数错误"))
+		return
+	}
+	node, err := s.store.GetNodeByID(r.Context(), req.ID)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("节点不存在"))
+		return
+	}
+	if !s.hub.Connected(node.ID) {
+		writeJSON(w, http.StatusBadRequest, Err("节点不在线，无法下发新密钥"))
+		return
+	}
+	now := time.Now()
+	if node.OldSecret != "" && node.OldSecretExpires > now.UnixMilli() {
+		writeJSON(w, http.StatusBadRequest, Err("上一次密钥轮换的宽限期尚未结束"))
+		return
+	}
+	grace := defaultNodeSecretGrace
+	if req.GraceSeconds > 0 {
+		grace = min(time.Duration(req.GraceSeconds)*time.Second, maxNodeSecretGrace)
+	}
+
+	secret := randomHex(16)
+	if err := s.store.RotateNodeSecret(r.Context(), node.ID, secret, now.Add(grace).UnixMilli()); err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("更新失败"))
+		return
+	}
+	after, _ := s.store.GetNodeByID(r.Context(), node.ID)
+	s.audit(r, auditActionRotateSecret, auditEntityNode, node.ID, node, after)
+	if err := s.hub.RotateSecret(r.Context(), node.ID, secret, nodeSecretPushTimeout); err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("新密钥下发失败，面板会在节点确认前自动重试"))
+		return
+	}
+	writeJSON(w, http.StatusOK, OK("节点密钥已更新"))
+}
+
 func (s *Server) handleNodeCheckStatus(w http.ResponseWriter, r *http.Request) {
 	var req nodeCheckStatusRequest
 	if err := decodeJSON(r, &req); err != nil {
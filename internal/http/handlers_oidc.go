@@ -0,0 +1,371 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"pixia-panel/internal/oidc"
+	"pixia-panel/internal/store"
+)
+
+// oidcLoginTTL is how long a login started at the provider may take to come
+// back; maxOIDCPending bounds how many may be in progress at once.
+const (
+	oidcLoginTTL    = 10 * time.Minute
+	maxOIDCPending  = 10000
+	oidcHTTPTimeout = 10 * time.Second
+)
+
+type oidcLoginRequest struct {
+	Code  string `json:"code"`
+	State string `json:"state"`
+}
+
+// oidcSettings is the single sign-on configuration from vite_config.
+type oidcSettings struct {
+	client        oidc.Config
+	usernameClaim string
+	roleClaim     string
+	defaultRole   string
+	autoProvision bool
+}
+
+// oidcSettings returns the single sign-on configuration, or nil if it is
+// disabled or incomplete.
+func (s *Server) oidcSettings(ctx context.Context) *oidcSettings {
+	if s.configValue(ctx, "oidc_enabled") != "true" {
+		return nil
+	}
+	cfg := &oidcSettings{
+		client: oidc.Config{
+			Issuer:       s.configValue(ctx, "oidc_issuer"),
+			ClientID:     s.configValue(ctx, "oidc_client_id"),
+			ClientSecret: s.configValue(ctx, "oidc_client_secret"),
+			RedirectURL:  s.configValue(ctx, "oidc_redirect_uri"),
+			Scopes:       strings.Fields(s.configValue(ctx, "oidc_scopes")),
+		},
+		usernameClaim: s.configValue(ctx, "oidc_username_claim"),
+		roleClaim:     s.configValue(ctx, "oidc_role_claim"),
+		defaultRole:   s.configValue(ctx, "oidc_default_role"),
+		autoProvision: s.configValue(ctx, "oidc_auto_provision") == "true",
+	}
+	if cfg.client.Issuer == "" || cfg.client.ClientID == "" || cfg.client.RedirectURL == "" {
+		return nil
+	}
+	if len(cfg.client.Scopes) == 0 {
+		cfg.client.Scopes = []string{"openid"}
+	}
+	if cfg.usernameClaim == "" {
+		cfg.usernameClaim = "preferred_username"
+	}
+	return cfg
+}
+
+func (s *Server) configValue(ctx context.Context, name string) string {
+	cfg, err := s.store.GetConfigByName(ctx, name)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(cfg.Value)
+}
+
+// passwordLoginDisabled reports whether user must log in through single
+// sign-on because password login is disabled for accounts with permissions.
+// Accounts not yet bound to an identity keep their password so that they are
+// not locked out before an admin links them.
+func (s *Server) passwordLoginDisabled(ctx context.Context, user *store.User) bool {
+	if s.configValue(ctx, "oidc_disable_admin_password") != "true" || s.oidcSettings(ctx) == nil {
+		return false
+	}
+	if _, err := s.store.GetUserOIDC(ctx, user.ID); err != nil {
+		return false
+	}
+	role, err := s.store.GetRoleByID(ctx, user.RoleID)
+	return err == nil && len(role.Permissions) > 0
+}
+
+// handleOIDCStart begins a single sign-on login and returns the provider URL
+// to send the browser to. The provider redirects back to oidc_redirect_uri,
+// whose page posts the code and state to handleOIDCLogin.
+func (s *Server) handleOIDCStart(w http.ResponseWriter, r *http.Request) {
+	cfg := s.oidcSettings(r.Context())
+	if cfg == nil {
+		writeJSON(w, http.StatusBadRequest, Err("未启用单点登录"))
+		return
+	}
+	var pending oidc.Pending
+	state, err := oidc.NewVerifier()
+	if err == nil {
+		pending.Nonce, err = oidc.NewVerifier()
+	}
+	if err == nil {
+		pending.Verifier, err = oidc.NewVerifier()
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("登录失败"))
+		return
+	}
+	authURL, err := s.oidc.AuthURL(r.Context(), cfg.client, state, pending.Nonce, pending.Verifier)
+	if err != nil {
+		log.Printf("oidc: %v", err)
+		writeJSON(w, http.StatusBadGateway, Err("无法连接身份提供方"))
+		return
+	}
+	if err := s.oidcPending.Put(state, pending); err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, Err("登录请求过多，请稍后再试"))
+		return
+	}
+	writeJSON(w, http.StatusOK, OK(map[string]any{
+		"url":   authURL,
+		"state": state,
+	}))
+}
+
+// handleOIDCLogin completes a single sign-on login with the code returned by
+// the provider and issues the normal session tokens.
+func (s *Server) handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	var req oidcLoginRequest
+	if err := decodeJSON(r, &req); err != nil || req.Code == "" || req.State == "" {
+		writeJSON(w, http.StatusBadRequest, Err("参数错误"))
+		return
+	}
+	cfg := s.oidcSettings(r.Context())
+	if cfg == nil {
+		writeJSON(w, http.StatusBadRequest, Err("未启用单点登录"))
+		return
+	}
+	pending, ok := s.oidcPending.Take(req.State)
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, Err("登录已过期，请重新登录"))
+		return
+	}
+	claims, err := s.oidc.Exchange(r.Context(), cfg.client, req.Code, pending.Verifier, pending.Nonce)
+	if err != nil {
+		log.Printf("oidc: %v", err)
+		writeJSON(w, http.StatusUnauthorized, Err("单点登录校验失败"))
+		return
+	}
+
+	issuer, subject := oidcIdentity(claims)
+	if subject == "" {
+		writeJSON(w, http.StatusBadRequest, Err("身份信息缺少用户标识"))
+		return
+	}
+	user, err := s.oidcUser(r, cfg, issuer, subject, claims)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, Err(err.Error()))
+		return
+	}
+	if user.Status == 0 {
+		writeJSON(w, http.StatusBadRequest, Err("账户停用"))
+		return
+	}
+	data, err := s.loginData(r, user)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("登录失败"))
+		return
+	}
+	writeJSON(w, http.StatusOK, OK(data))
+}
+
+// oidcIdentity returns the verified issuer and subject of an ID token,
+// which identify the person at the provider.
+func oidcIdentity(claims map[string]any) (issuer, subject string) {
+	issuer, _ = claims["iss"].(string)
+	subject, _ = claims["sub"].(string)
+	return normalizeIssuer(issuer), strings.TrimSpace(subject)
+}
+
+func normalizeIssuer(issuer string) string {
+	return strings.TrimSuffix(strings.TrimSpace(issuer), "/")
+}
+
+// oidcUser returns the user bound to the identity (issuer, subject). An
+// unbound identity gets a new account when auto-provisioning is on, but is
+// never bound to an existing account by username, or anyone able to pick
+// their username at the provider could take that account over; an admin
+// links existing accounts through handleUserOIDCLink. With a role claim
+// configured the user's role follows the provider.
+func (s *Server) oidcUser(r *http.Request, cfg *oidcSettings, issuer, subject string, claims map[string]any) (*store.User, error) {
+	role := s.oidcRole(r.Context(), cfg, claims)
+	now := time.Now().UnixMilli()
+
+	user, err := s.store.GetUserByOIDC(r.Context(), issuer, subject)
+	if errors.Is(err, store.ErrNotFound) {
+		return s.provisionOIDCUser(r, cfg, issuer, subject, role, claims)
+	}
+	if err != nil {
+		return nil, errors.New("登录失败")
+	}
+
+	if role != nil && role.ID != user.RoleID && user.Status != 0 {
+		if err := s.store.UpdateUserRole(r.Context(), user.ID, role.ID, now); err != nil {
+			return nil, errors.New("登录失败")
+		}
+		after, err := s.store.GetUserByID(r.Context(), user.ID)
+		if err != nil {
+			return nil, errors.New("登录失败")
+		}
+		s.audit(r, auditActionUpdateRole, auditEntityUser, user.ID, user, after)
+		user = after
+	}
+	return user, nil
+}
+
+// provisionOIDCUser creates an account bound to a new identity, named by the
+// username claim.
+func (s *Server) provisionOIDCUser(r *http.Request, cfg *oidcSettings, issuer, subject string, role *store.Role, claims map[string]any) (*store.User, error) {
+	if !cfg.autoProvision {
+		return nil, fmt.Errorf("账号未关联单点登录，请联系管理员开通（身份标识 %s）", subject)
+	}
+	username, _ := claims[cfg.usernameClaim].(string)
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return nil, errors.New("身份信息缺少用户名")
+	}
+	if _, err := s.store.GetUserByName(r.Context(), username); err == nil {
+		return nil, fmt.Errorf("用户名 %s 已被本地账号使用，请联系管理员关联单点登录（身份标识 %s）", username, subject)
+	} else if !errors.Is(err, store.ErrNotFound) {
+		return nil, errors.New("登录失败")
+	}
+	if role == nil {
+		var err error
+		role, err = s.store.GetRoleByName(r.Context(), cfg.defaultRole)
+		if err != nil {
+			return nil, errors.New("默认角色不存在")
+		}
+	}
+	// Provisioned accounts get an unknown random password so they can
+	// only log in through the provider until an admin sets one.
+	secret, err := oidc.NewVerifier()
+	if err != nil {
+		return nil, errors.New("登录失败")
+	}
+	hashed, err := hashPassword(secret)
+	if err != nil {
+		return nil, errors.New("登录失败")
+	}
+	user := &store.User{
+		User:        username,
+		Pwd:         hashed,
+		RoleID:      role.ID,
+		CreatedTime: time.Now().UnixMilli(),
+		Status:      1,
+	}
+	id, err := s.store.InsertOIDCUser(r.Context(), user, issuer, subject)
+	if err != nil {
+		return nil, errors.New("创建账号失败")
+	}
+	user.ID = id
+	s.audit(r, auditActionCreate, auditEntityUser, id, nil, user)
+	return user, nil
+}
+
+type userOIDCLinkRequest struct {
+	ID      int64  `json:"id"`
+	Subject string `json:"subject"`
+}
+
+// handleUserOIDCLink binds an existing account to the identity subject at the
+// configured provider, after which that identity logs in as the account.
+func (s *Server) handleUserOIDCLink(w http.ResponseWriter, r *http.Request) {
+	var req userOIDCLinkRequest
+	if err := decodeJSON(r, &req); err != nil || strings.TrimSpace(req.Subject) == "" {
+		writeJSON(w, http.StatusBadRequest, Err("参数错误"))
+		return
+	}
+	cfg := s.oidcSettings(r.Context())
+	if cfg == nil {
+		writeJSON(w, http.StatusBadRequest, Err("未启用单点登录"))
+		return
+	}
+	user, err := s.store.GetUserByID(r.Context(), req.ID)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("用户不存在"))
+		return
+	}
+	if !s.canManageUser(r, user) {
+		writeJSON(w, http.StatusForbidden, Err("权限不足"))
+		return
+	}
+	before, _ := s.store.GetUserOIDC(r.Context(), user.ID)
+	err = s.store.LinkUserOIDC(r.Context(), user.ID, normalizeIssuer(cfg.client.Issuer), strings.TrimSpace(req.Subject))
+	if errors.Is(err, store.ErrOIDCIdentityTaken) {
+		writeJSON(w, http.StatusBadRequest, Err("该身份已关联其他账号"))
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("关联失败"))
+		return
+	}
+	after, _ := s.store.GetUserOIDC(r.Context(), user.ID)
+	s.audit(r, auditActionLinkOIDC, auditEntityUser, user.ID, before, after)
+	writeJSON(w, http.StatusOK, OK(after))
+}
+
+type userOIDCUnlinkRequest struct {
+	ID int64 `json:"id"`
+}
+
+// handleUserOIDCUnlink removes an account's single sign-on binding.
+func (s *Server) handleUserOIDCUnlink(w http.ResponseWriter, r *http.Request) {
+	var req userOIDCUnlinkRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("参数错误"))
+		return
+	}
+	user, err := s.store.GetUserByID(r.Context(), req.ID)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("用户不存在"))
+		return
+	}
+	if !s.canManageUser(r, user) {
+		writeJSON(w, http.StatusForbidden, Err("权限不足"))
+		return
+	}
+	before, err := s.store.GetUserOIDC(r.Context(), user.ID)
+	if errors.Is(err, store.ErrNotFound) {
+		writeJSON(w, http.StatusBadRequest, Err("账号未关联单点登录"))
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("解除关联失败"))
+		return
+	}
+	if err := s.store.UnlinkUserOIDC(r.Context(), user.ID); err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("解除关联失败"))
+		return
+	}
+	s.audit(r, auditActionUnlinkOIDC, auditEntityUser, user.ID, before, nil)
+	writeJSON(w, http.StatusOK, OK("已解除单点登录关联"))
+}
+
+// oidcRole returns the first role named by the configured role claim, which
+// may be a string or a list of strings, or nil if none matches.
+func (s *Server) oidcRole(ctx context.Context, cfg *oidcSettings, claims map[string]any) *store.Role {
+	if cfg.roleClaim == "" {
+		return nil
+	}
+	var names []string
+	switch v := claims[cfg.roleClaim].(type) {
+	case string:
+		names = []string{v}
+	case []any:
+		for _, item := range v {
+			if name, ok := item.(string); ok {
+				names = append(names, name)
+			}
+		}
+	}
+	for _, name := range names {
+		if role, err := s.store.GetRoleByName(ctx, name); err == nil {
+			return role
+		}
+	}
+	return nil
+}
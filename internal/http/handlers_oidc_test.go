@@ -0,0 +1,185 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"pixia-panel/internal/store"
+)
+
+// oidcProvider is a mock issuer whose token endpoint returns an ID token for
+// the identity set by the test, carrying the nonce of the last started login.
+type oidcProvider struct {
+	*httptest.Server
+	key      *rsa.PrivateKey
+	nonce    string
+	subject  string
+	username string
+}
+
+func newOIDCProvider(t *testing.T) *oidcProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &oidcProvider{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+			"jwks_uri":               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "k1",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":                p.URL,
+			"sub":                p.subject,
+			"aud":                "panel",
+			"exp":                time.Now().Add(time.Hour).Unix(),
+			"nonce":              p.nonce,
+			"preferred_username": p.username,
+		})
+		token.Header["kid"] = "k1"
+		signed, err := token.SignedString(key)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": signed})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func enableOIDC(t *testing.T, s *Server, issuer string, autoProvision bool) {
+	t.Helper()
+	settings := map[string]string{
+		"oidc_enabled":        "true",
+		"oidc_issuer":         issuer,
+		"oidc_client_id":      "panel",
+		"oidc_redirect_uri":   "https://panel.example/callback",
+		"oidc_auto_provision": "false",
+	}
+	if autoProvision {
+		settings["oidc_auto_provision"] = "true"
+	}
+	for name, value := range settings {
+		if err := s.store.UpsertConfig(context.Background(), name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// oidcLogin runs a full single sign-on login as the provider's current
+// identity and returns the response.
+func oidcLogin(t *testing.T, s *Server, p *oidcProvider) (int, Response) {
+	t.Helper()
+	var start struct {
+		URL   string `json:"url"`
+		State string `json:"state"`
+	}
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	rec := httptest.NewRecorder()
+	s.handleOIDCStart(rec, req)
+	var resp Response
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Code != 0 {
+		t.Fatalf("start: %s", rec.Body.String())
+	}
+	b, _ := json.Marshal(resp.Data)
+	_ = json.Unmarshal(b, &start)
+	u, err := url.Parse(start.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.nonce = u.Query().Get("nonce")
+	return postJSON(t, s.handleOIDCLogin, oidcLoginRequest{Code: "code", State: start.State}, nil)
+}
+
+func TestOIDCLoginDoesNotTakeOverPasswordAccount(t *testing.T) {
+	s := newTestServer(t)
+	p := newOIDCProvider(t)
+	enableOIDC(t, s, p.URL, true)
+	p.subject, p.username = "attacker", "admin_user"
+
+	status, resp := oidcLogin(t, s, p)
+	if status != http.StatusBadRequest || !strings.Contains(resp.Msg, "attacker") {
+		t.Fatalf("login as existing username = %d %q, want refusal naming the subject", status, resp.Msg)
+	}
+	if _, err := s.store.GetUserOIDC(context.Background(), 1); err == nil {
+		t.Fatal("admin account was bound to the identity")
+	}
+}
+
+func TestOIDCLoginLinkedAccount(t *testing.T) {
+	s := newTestServer(t)
+	p := newOIDCProvider(t)
+	enableOIDC(t, s, p.URL, false)
+	ctx := context.Background()
+	if err := s.store.LinkUserOIDC(ctx, 1, p.URL, "admin-subject"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The provider's username no longer matters once linked.
+	p.subject, p.username = "admin-subject", "someone"
+	if status, resp := oidcLogin(t, s, p); status != http.StatusOK || resp.Code != 0 {
+		t.Fatalf("linked login = %d %q", status, resp.Msg)
+	}
+
+	p.subject, p.username = "other-subject", "admin_user"
+	if status, _ := oidcLogin(t, s, p); status != http.StatusBadRequest {
+		t.Fatalf("unlinked login without auto-provisioning = %d, want 400", status)
+	}
+
+	if err := s.store.LinkUserOIDC(ctx, 2, p.URL, "admin-subject"); err != store.ErrOIDCIdentityTaken {
+		t.Fatalf("linking a bound identity to another user: %v", err)
+	}
+}
+
+func TestOIDCLoginProvisionsBoundAccount(t *testing.T) {
+	s := newTestServer(t)
+	p := newOIDCProvider(t)
+	enableOIDC(t, s, p.URL, true)
+	ctx := context.Background()
+
+	p.subject, p.username = "new-subject", "alice"
+	if status, resp := oidcLogin(t, s, p); status != http.StatusOK {
+		t.Fatalf("first login = %d %q", status, resp.Msg)
+	}
+	user, err := s.store.GetUserByOIDC(ctx, p.URL, "new-subject")
+	if err != nil || user.User != "alice" {
+		t.Fatalf("provisioned user = %+v, %v", user, err)
+	}
+
+	// A renamed identity still logs into the same account.
+	p.username = "alice-renamed"
+	if status, resp := oidcLogin(t, s, p); status != http.StatusOK {
+		t.Fatalf("second login = %d %q", status, resp.Msg)
+	}
+	if _, err := s.store.GetUserByName(ctx, "alice-renamed"); err != store.ErrNotFound {
+		t.Fatalf("second login created another account: %v", err)
+	}
+}
@@ -4,37 +4,20 @@ import (
 	"net/http"
 	"strconv"
 	"time"
+
+	"pixia-panel/internal/store"
 )
 
 func (s *Server) handleOpenAPISubStore(w http.ResponseWriter, r *http.Request) {
-	user := r.URL.Query().Get("user")
-	pwd := r.URL.Query().Get("pwd")
 	tunnel := r.URL.Query().Get("tunnel")
 	if tunnel == "" {
 		tunnel = "-1"
 	}
 
-	if user == "" || pwd == "" {
-		writeJSON(w, http.StatusBadRequest, Err("用户或密码不能为空"))
-		return
-	}
-
-	userInfo, err := s.store.GetUserByName(r.Context(), user)
-	if err != nil {
-		writeJSON(w, http.StatusUnauthorized, Err("鉴权失败"))
-		return
-	}
-	ok, upgrade, vErr := verifyPassword(userInfo.Pwd, pwd)
-	if vErr != nil || !ok {
-		writeJSON(w, http.StatusUnauthorized, Err("鉴权失败"))
+	userInfo, ok := s.subStoreUser(w, r)
+	if !ok {
 		return
 	}
-	if upgrade {
-		if hashed, err := hashPassword(pwd); err == nil {
-			_ = s.store.UpdateUserFields(r.Context(), userInfo.ID, userInfo.User, &hashed, userInfo.Flow, userInfo.Num, userInfo.ExpTime, userInfo.FlowResetTime, userInfo.Status, time.Now().UnixMilli())
-			userInfo.Pwd = hashed
-		}
-	}
 
 	const giga = 1024 * 1024 * 1024
 	var header string
@@ -55,6 +38,65 @@ func (s *Server) handleOpenAPISubStore(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write([]byte(header))
 }
 
+// subStoreUser authenticates a subscription request with an API token in the
+// token query parameter, or with the legacy user and pwd parameters. The
+// legacy form is refused for accounts that must use single sign-on or a
+// second factor.
+func (s *Server) subStoreUser(w http.ResponseWriter, r *http.Request) (*store.User, bool) {
+	if tokenStr := r.URL.Query().Get("token"); tokenStr != "" {
+		token, userInfo, err := s.authenticateAPIToken(r, tokenStr)
+		if err != nil || !tokenAllows(token.Scopes, scopeRead) {
+			writeJSON(w, http.StatusUnauthorized, Err("鉴权失败"))
+			return nil, false
+		}
+		return userInfo, true
+	}
+
+	user := r.URL.Query().Get("user")
+	pwd := r.URL.Query().Get("pwd")
+	if user == "" || pwd == "" {
+		writeJSON(w, http.StatusBadRequest, Err("用户或密码不能为空"))
+		return nil, false
+	}
+
+	if wait := s.loginLockedFor(r, user); wait > 0 {
+		writeLoginLocked(w, wait)
+		return nil, false
+	}
+
+	userInfo, err := s.store.GetUserByName(r.Context(), user)
+	if err != nil {
+		s.recordLoginFailure(r, user)
+		writeJSON(w, http.StatusUnauthorized, Err("鉴权失败"))
+		return nil, false
+	}
+	ok, upgrade, vErr := verifyPassword(userInfo.Pwd, pwd)
+	if vErr != nil || !ok {
+		if vErr == nil {
+			s.recordLoginFailure(r, user)
+		}
+		writeJSON(w, http.StatusUnauthorized, Err("鉴权失败"))
+		return nil, false
+	}
+	if s.passwordLoginDisabled(r.Context(), userInfo) {
+		writeJSON(w, http.StatusForbidden, Err("管理员账号请使用单点登录"))
+		return nil, false
+	}
+	// The password alone cannot satisfy a second factor here.
+	if s.twoFactorPurpose(r, userInfo) != "" {
+		writeJSON(w, http.StatusForbidden, Err("已启用两步验证的账号请使用 API 令牌订阅"))
+		return nil, false
+	}
+	s.clearLoginFailures(r, user)
+	if upgrade {
+		if hashed, err := hashPassword(pwd); err == nil {
+			_ = s.store.UpdateUserFields(r.Context(), userInfo.ID, userInfo.User, &hashed, userInfo.Flow, userInfo.Num, userInfo.ExpTime, userInfo.FlowResetTime, userInfo.Status, time.Now().UnixMilli())
+			userInfo.Pwd = hashed
+		}
+	}
+	return userInfo, true
+}
+
 func buildSubscriptionHeader(upload, download, total, expire int64) string {
 	return "upload=" + strconv.FormatInt(download, 10) + "; download=" + strconv.FormatInt(upload, 10) + "; total=" + strconv.FormatInt(total, 10) + "; expire=" + strconv.FormatInt(expire, 10)
 }
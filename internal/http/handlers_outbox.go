@@ -0,0 +1,142 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"pixia-panel/internal/store"
+)
+
+// outboxFilterRequest selects outbox items. Ages are in seconds since the
+// item was enqueued.
+type outboxFilterRequest struct {
+	Status        string `json:"status"`
+	NodeID        int64  `json:"nodeId"`
+	Action        string `json:"action"`
+	MinAgeSeconds int64  `json:"minAgeSeconds"`
+	MaxAgeSeconds int64  `json:"maxAgeSeconds"`
+}
+
+func (req outboxFilterRequest) filter() store.OutboxFilter {
+	f := store.OutboxFilter{Status: req.Status, NodeID: req.NodeID, Action: req.Action}
+	now := time.Now()
+	if req.MinAgeSeconds > 0 {
+		f.CreatedBefore = now.Add(-time.Duration(req.MinAgeSeconds) * time.Second).UnixMilli()
+	}
+	if req.MaxAgeSeconds > 0 {
+		f.CreatedAfter = now.Add(-time.Duration(req.MaxAgeSeconds) * time.Second).UnixMilli()
+	}
+	return f
+}
+
+type outboxListRequest struct {
+	outboxFilterRequest
+	Page int `json:"page"`
+	Size int `json:"size"`
+}
+
+// outboxBulkRequest names dead items by ID or by filter. A request naming
+// neither must set All to act on every dead item.
+type outboxBulkRequest struct {
+	outboxFilterRequest
+	IDs []int64 `json:"ids"`
+	All bool    `json:"all"`
+}
+
+func (req outboxBulkRequest) empty() bool {
+	return len(req.IDs) == 0 && req.NodeID == 0 && req.Action == "" && req.MinAgeSeconds == 0 && req.MaxAgeSeconds == 0
+}
+
+type outboxGetRequest struct {
+	ID int64 `json:"id"`
+}
+
+func (s *Server) handleOutboxList(w http.ResponseWriter, r *http.Request) {
+	var req outboxListRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("参数错误"))
+		return
+	}
+	if req.Page <= 0 {
+		req.Page = 1
+	}
+	if req.Size <= 0 || req.Size > 200 {
+		req.Size = 50
+	}
+
+	filter := req.filter()
+	filter.Limit = req.Size
+	filter.Offset = (req.Page - 1) * req.Size
+	list, total, err := s.store.ListOutbox(r.Context(), filter)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("获取失败"))
+		return
+	}
+	if list == nil {
+		list = []store.OutboxItem{}
+	}
+	writeJSON(w, http.StatusOK, OK(map[string]any{
+		"list":  list,
+		"total": total,
+	}))
+}
+
+// handleOutboxGet returns an item with its payload and failure history.
+func (s *Server) handleOutboxGet(w http.ResponseWriter, r *http.Request) {
+	var req outboxGetRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("参数错误"))
+		return
+	}
+	item, err := s.store.GetOutboxItem(r.Context(), req.ID)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("消息不存在"))
+		return
+	}
+	attempts, err := s.store.ListOutboxAttempts(r.Context(), item.ID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("获取失败"))
+		return
+	}
+	if attempts == nil {
+		attempts = []store.OutboxAttempt{}
+	}
+	writeJSON(w, http.StatusOK, OK(map[string]any{
+		"item":     item,
+		"attempts": attempts,
+	}))
+}
+
+// handleOutboxRequeue sends dead items again with a fresh retry budget.
+func (s *Server) handleOutboxRequeue(w http.ResponseWriter, r *http.Request) {
+	s.outboxBulk(w, r, auditActionRequeue, s.store.RequeueDeadOutbox)
+}
+
+// handleOutboxDiscard deletes dead items.
+func (s *Server) handleOutboxDiscard(w http.ResponseWriter, r *http.Request) {
+	s.outboxBulk(w, r, auditActionDelete, s.store.DeleteDeadOutbox)
+}
+
+func (s *Server) outboxBulk(w http.ResponseWriter, r *http.Request, action string, apply func(ctx context.Context, f store.OutboxFilter) ([]int64, error)) {
+	var req outboxBulkRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("参数错误"))
+		return
+	}
+	if req.empty() && !req.All {
+		writeJSON(w, http.StatusBadRequest, Err("请指定要处理的消息"))
+		return
+	}
+	filter := req.filter()
+	filter.IDs = req.IDs
+	ids, err := apply(r.Context(), filter)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("操作失败"))
+		return
+	}
+	if len(ids) > 0 {
+		s.audit(r, action, auditEntityOutbox, 0, nil, map[string]any{"ids": ids})
+	}
+	writeJSON(w, http.StatusOK, OK(map[string]any{"count": len(ids)}))
+}
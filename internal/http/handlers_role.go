@@ -0,0 +1,166 @@
+package httpapi
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"pixia-panel/internal/store"
+)
+
+type roleRequest struct {
+	ID          int64    `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+type roleDeleteRequest struct {
+	ID int64 `json:"id"`
+}
+
+func (s *Server) handleRoleList(w http.ResponseWriter, r *http.Request) {
+	roles, err := s.store.ListRoles(r.Context())
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("获取失败"))
+		return
+	}
+	if roles == nil {
+		roles = []store.Role{}
+	}
+	writeJSON(w, http.StatusOK, OK(roles))
+}
+
+func (s *Server) handleRolePermissions(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, OK(allPermissions))
+}
+
+func (s *Server) handleRoleCreate(w http.ResponseWriter, r *http.Request) {
+	var req roleRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("参数错误"))
+		return
+	}
+	req.ID = 0
+	perms, msg := s.validateRole(r, &req)
+	if msg != "" {
+		writeJSON(w, http.StatusBadRequest, Err(msg))
+		return
+	}
+	role := &store.Role{
+		Name:        req.Name,
+		Description: req.Description,
+		Permissions: perms,
+		CreatedTime: time.Now().UnixMilli(),
+	}
+	id, err := s.store.InsertRole(r.Context(), role)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("创建失败"))
+		return
+	}
+	role.ID = id
+	s.audit(r, auditActionCreate, auditEntityRole, id, nil, role)
+	writeJSON(w, http.StatusOK, OK(role))
+}
+
+func (s *Server) handleRoleUpdate(w http.ResponseWriter, r *http.Request) {
+	var req roleRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("参数错误"))
+		return
+	}
+	role, err := s.store.GetRoleByID(r.Context(), req.ID)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("角色不存在"))
+		return
+	}
+	// The administrator role must always keep every permission.
+	if role.ID == 0 {
+		writeJSON(w, http.StatusBadRequest, Err("不能修改管理员角色"))
+		return
+	}
+	if role.Builtin && req.Name != role.Name {
+		writeJSON(w, http.StatusBadRequest, Err("不能重命名内置角色"))
+		return
+	}
+	if !s.canGrant(r, role.Permissions) {
+		writeJSON(w, http.StatusForbidden, Err("不能修改权限高于自身的角色"))
+		return
+	}
+	perms, msg := s.validateRole(r, &req)
+	if msg != "" {
+		writeJSON(w, http.StatusBadRequest, Err(msg))
+		return
+	}
+	before := *role
+	role.Name = req.Name
+	role.Description = req.Description
+	role.Permissions = perms
+	role.UpdatedTime = ptrInt64(time.Now().UnixMilli())
+	if err := s.store.UpdateRole(r.Context(), role); err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("更新失败"))
+		return
+	}
+	s.audit(r, auditActionUpdate, auditEntityRole, role.ID, &before, role)
+	writeJSON(w, http.StatusOK, OK("角色更新成功"))
+}
+
+func (s *Server) handleRoleDelete(w http.ResponseWriter, r *http.Request) {
+	var req roleDeleteRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("参数错误"))
+		return
+	}
+	role, err := s.store.GetRoleByID(r.Context(), req.ID)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("角色不存在"))
+		return
+	}
+	if role.Builtin {
+		writeJSON(w, http.StatusBadRequest, Err("不能删除内置角色"))
+		return
+	}
+	count, err := s.store.CountUsersByRole(r.Context(), role.ID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("删除失败"))
+		return
+	}
+	if count > 0 {
+		writeJSON(w, http.StatusBadRequest, Err("该角色下还有用户，无法删除"))
+		return
+	}
+	if err := s.store.DeleteRole(r.Context(), role.ID); err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("删除失败"))
+		return
+	}
+	s.audit(r, auditActionDelete, auditEntityRole, role.ID, role, nil)
+	writeJSON(w, http.StatusOK, OK("角色删除成功"))
+}
+
+// validateRole normalizes req and returns its deduplicated permissions, or a
+// message describing why the role is invalid.
+func (s *Server) validateRole(r *http.Request, req *roleRequest) ([]string, string) {
+	req.Name = strings.TrimSpace(req.Name)
+	req.Description = strings.TrimSpace(req.Description)
+	if req.Name == "" {
+		return nil, "角色名称不能为空"
+	}
+	if existing, err := s.store.GetRoleByName(r.Context(), req.Name); err == nil && existing.ID != req.ID {
+		return nil, "角色名称已存在"
+	}
+	seen := make(map[string]bool, len(req.Permissions))
+	perms := make([]string, 0, len(req.Permissions))
+	for _, perm := range req.Permissions {
+		if !isKnownPermission(perm) {
+			return nil, "未知的权限: " + perm
+		}
+		if !seen[perm] {
+			seen[perm] = true
+			perms = append(perms, perm)
+		}
+	}
+	if !s.canGrant(r, perms) {
+		return nil, "不能授予超出自身的权限"
+	}
+	return perms, ""
+}
@@ -0,0 +1,252 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"pixia-panel/internal/auth"
+	"pixia-panel/internal/gost"
+	"pixia-panel/internal/store"
+)
+
+// sessionTouchInterval limits how often last-seen tracking writes to the database.
+const sessionTouchInterval = time.Minute
+
+// maxUserAgentLength caps the user agent stored per session.
+const maxUserAgentLength = 256
+
+type refreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+type sessionListRequest struct {
+	UserID int64 `json:"userId"`
+}
+
+type sessionRevokeRequest struct {
+	ID int64 `json:"id"`
+}
+
+// startSession records a new login session for user and returns its access
+// and refresh tokens.
+func (s *Server) startSession(r *http.Request, user *store.User) (map[string]any, error) {
+	refresh, err := auth.GenerateRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	session := &store.UserSession{
+		UserID:       user.ID,
+		RefreshHash:  auth.HashToken(refresh),
+		IP:           s.clientIP(r),
+		UserAgent:    userAgent(r),
+		CreatedTime:  now.UnixMilli(),
+		LastSeenTime: now.UnixMilli(),
+		ExpTime:      now.Add(s.sessionTTL).UnixMilli(),
+	}
+	id, err := s.store.InsertSession(r.Context(), session)
+	if err != nil {
+		return nil, err
+	}
+	return s.sessionTokens(user, id, refresh)
+}
+
+func (s *Server) sessionTokens(user *store.User, sessionID int64, refresh string) (map[string]any, error) {
+	token, err := auth.Sign(s.jwtSecret, user.ID, user.RoleID, sessionID, user.MustChangePassword, s.accessTTL)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{
+		"token":        token,
+		"refreshToken": refresh,
+		"expiresIn":    int64(s.accessTTL.Seconds()),
+		"name":         user.User,
+		"role_id":      user.RoleID,
+		// requirePasswordChange tells the client to show the password form;
+		// until then every other endpoint is refused.
+		"requirePasswordChange": user.MustChangePassword,
+	}, nil
+}
+
+// authenticateSession parses an access token and checks that its session is
+// still active and its user still enabled.
+func (s *Server) authenticateSession(r *http.Request, tokenStr string) (*auth.Claims, error) {
+	claims, err := auth.Parse(s.jwtSecret, tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	session, err := s.store.GetSessionByID(r.Context(), claims.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UnixMilli()
+	if session.UserID != claims.UserID || session.RevokedTime != nil || session.ExpTime <= now {
+		return nil, errors.New("session inactive")
+	}
+	user, err := s.store.GetUserByID(r.Context(), claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Status != 1 {
+		return nil, errors.New("user disabled")
+	}
+	if now-session.LastSeenTime >= sessionTouchInterval.Milliseconds() {
+		_ = s.store.TouchSession(r.Context(), session.ID, now, s.clientIP(r))
+	}
+	// The role and password state may have changed since the token was signed.
+	claims.RoleID = user.RoleID
+	claims.MustChangePassword = user.MustChangePassword
+	return claims, nil
+}
+
+// AuthorizeAdminSocket checks the access token the admin websocket connects
+// with against its login session, like withAuth, and requires permission to
+// read nodes since the socket streams node status. Like every other route it
+// is closed until a required password change is done.
+func (s *Server) AuthorizeAdminSocket(r *http.Request, token string) error {
+	claims, err := s.authenticateSession(r, token)
+	if err != nil {
+		return err
+	}
+	if claims.MustChangePassword || !s.roleHas(r.Context(), claims.RoleID, permNodeRead) {
+		return gost.ErrAdminForbidden
+	}
+	return nil
+}
+
+// handleUserRefresh exchanges a refresh token for a new access token and a
+// new refresh token. Presenting an already rotated refresh token means it
+// leaked, so the whole session is revoked.
+func (s *Server) handleUserRefresh(w http.ResponseWriter, r *http.Request) {
+	var req refreshRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("参数错误"))
+		return
+	}
+	if req.RefreshToken == "" {
+		writeJSON(w, http.StatusUnauthorized, Err("登录已失效"))
+		return
+	}
+	hash := auth.HashToken(req.RefreshToken)
+	session, err := s.store.GetSessionByRefreshHash(r.Context(), hash)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, Err("登录已失效"))
+		return
+	}
+	now := time.Now()
+	if session.RefreshHash != hash {
+		_ = s.store.RevokeSession(r.Context(), session.ID, now.UnixMilli())
+		writeJSON(w, http.StatusUnauthorized, Err("登录已失效"))
+		return
+	}
+	user, err := s.store.GetUserByID(r.Context(), session.UserID)
+	if err != nil || user.Status != 1 {
+		writeJSON(w, http.StatusUnauthorized, Err("登录已失效"))
+		return
+	}
+
+	refresh, err := auth.GenerateRefreshToken()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("刷新失败"))
+		return
+	}
+	ok, err := s.store.RotateSession(r.Context(), session.ID, hash, auth.HashToken(refresh),
+		now.Add(s.sessionTTL).UnixMilli(), now.UnixMilli(), s.clientIP(r), userAgent(r))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("刷新失败"))
+		return
+	}
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, Err("登录已失效"))
+		return
+	}
+	data, err := s.sessionTokens(user, session.ID, refresh)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("刷新失败"))
+		return
+	}
+	writeJSON(w, http.StatusOK, OK(data))
+}
+
+func (s *Server) handleUserLogout(w http.ResponseWriter, r *http.Request) {
+	if err := s.store.RevokeSession(r.Context(), sessionIDFromCtx(r), time.Now().UnixMilli()); err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("退出失败"))
+		return
+	}
+	writeJSON(w, http.StatusOK, OK("已退出登录"))
+}
+
+func (s *Server) handleUserLogoutAll(w http.ResponseWriter, r *http.Request) {
+	if err := s.store.RevokeUserSessions(r.Context(), userIDFromCtx(r), 0, time.Now().UnixMilli()); err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("退出失败"))
+		return
+	}
+	writeJSON(w, http.StatusOK, OK("已退出全部登录"))
+}
+
+func (s *Server) handleSessionList(w http.ResponseWriter, r *http.Request) {
+	var req sessionListRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("参数错误"))
+		return
+	}
+	if req.UserID == 0 || !s.can(r, permUserRead) {
+		req.UserID = userIDFromCtx(r)
+	}
+	list, err := s.store.ListActiveSessions(r.Context(), req.UserID, time.Now().UnixMilli())
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("获取失败"))
+		return
+	}
+	current := sessionIDFromCtx(r)
+	items := make([]map[string]any, 0, len(list))
+	for _, session := range list {
+		items = append(items, map[string]any{
+			"id":           session.ID,
+			"ip":           session.IP,
+			"userAgent":    session.UserAgent,
+			"createdTime":  session.CreatedTime,
+			"lastSeenTime": session.LastSeenTime,
+			"expTime":      session.ExpTime,
+			"current":      session.ID == current,
+		})
+	}
+	writeJSON(w, http.StatusOK, OK(items))
+}
+
+func (s *Server) handleSessionRevoke(w http.ResponseWriter, r *http.Request) {
+	var req sessionRevokeRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("参数错误"))
+		return
+	}
+	session, err := s.store.GetSessionByID(r.Context(), req.ID)
+	if err != nil || (!s.can(r, permUserWrite) && session.UserID != userIDFromCtx(r)) {
+		writeJSON(w, http.StatusBadRequest, Err("会话不存在"))
+		return
+	}
+	if err := s.store.RevokeSession(r.Context(), session.ID, time.Now().UnixMilli()); err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("注销失败"))
+		return
+	}
+	writeJSON(w, http.StatusOK, OK("会话已注销"))
+}
+
+// revokeUserSessions ends all sessions of userID after a password or status
+// change. The caller's own session survives a change to their own password.
+func (s *Server) revokeUserSessions(r *http.Request, userID int64) {
+	except := int64(0)
+	if userID == userIDFromCtx(r) {
+		except = sessionIDFromCtx(r)
+	}
+	_ = s.store.RevokeUserSessions(r.Context(), userID, except, time.Now().UnixMilli())
+}
+
+func userAgent(r *http.Request) string {
+	ua := r.UserAgent()
+	if len(ua) > maxUserAgentLength {
+		ua = ua[:maxUserAgentLength]
+	}
+	return ua
+}
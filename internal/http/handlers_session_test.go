@@ -0,0 +1,176 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newTestSession logs userID in and returns the access and refresh tokens.
+func newTestSession(t *testing.T, s *Server, userID int64) (string, string) {
+	t.Helper()
+	user, err := s.store.GetUserByID(context.Background(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := s.startSession(httptest.NewRequest(http.MethodPost, "/", nil), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data["token"].(string), data["refreshToken"].(string)
+}
+
+type refreshData struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+}
+
+func refresh(t *testing.T, s *Server, token string) (int, refreshData) {
+	t.Helper()
+	var data refreshData
+	status, _ := postJSON(t, s.handleUserRefresh, refreshRequest{RefreshToken: token}, &data)
+	return status, data
+}
+
+func TestRefreshRotatesToken(t *testing.T) {
+	s := newTestServer(t)
+	_, first := newTestSession(t, s, 1)
+
+	status, second := refresh(t, s, first)
+	if status != http.StatusOK || second.RefreshToken == "" || second.RefreshToken == first {
+		t.Fatalf("refresh = %d %+v, want a new refresh token", status, second)
+	}
+	if status, _ := callWithAuth(s, scopeSession, second.Token); status != http.StatusOK {
+		t.Fatalf("refreshed access token: status %d", status)
+	}
+	if status, third := refresh(t, s, second.RefreshToken); status != http.StatusOK || third.RefreshToken == second.RefreshToken {
+		t.Fatalf("second refresh = %d %+v, want a new refresh token", status, third)
+	}
+}
+
+func TestRefreshTokenReuseRevokesSession(t *testing.T) {
+	s := newTestServer(t)
+	access, first := newTestSession(t, s, 1)
+	status, second := refresh(t, s, first)
+	if status != http.StatusOK {
+		t.Fatalf("refresh = %d", status)
+	}
+
+	// Presenting the rotated token again means it leaked.
+	if status, _ := refresh(t, s, first); status != http.StatusUnauthorized {
+		t.Fatalf("reused refresh token: status %d, want 401", status)
+	}
+	if status, _ := refresh(t, s, second.RefreshToken); status != http.StatusUnauthorized {
+		t.Fatalf("current refresh token after reuse: status %d, want 401", status)
+	}
+	for _, token := range []string{access, second.Token} {
+		if status, _ := callWithAuth(s, scopeSession, token); status != http.StatusUnauthorized {
+			t.Fatalf("access token after reuse: status %d, want 401", status)
+		}
+	}
+}
+
+func TestRefreshRejectsDisabledUser(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	_, token := newTestSession(t, s, 1)
+	user, err := s.store.GetUserByID(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.store.UpdateUserFields(ctx, 1, user.User, nil, user.Flow, user.Num, user.ExpTime, user.FlowResetTime, 0, time.Now().UnixMilli()); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := refresh(t, s, token); status != http.StatusUnauthorized {
+		t.Fatalf("refresh of a disabled user: status %d, want 401", status)
+	}
+}
+
+func TestLogoutRevokesSession(t *testing.T) {
+	s := newTestServer(t)
+	mux := http.NewServeMux()
+	s.Register(mux)
+	access, refreshToken := newTestSession(t, s, 1)
+	other, _ := newTestSession(t, s, 1)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/user/logout", nil)
+	req.Header.Set("Authorization", "Bearer "+access)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("logout: status %d", rec.Code)
+	}
+	if status, _ := callWithAuth(s, scopeSession, access); status != http.StatusUnauthorized {
+		t.Fatalf("access token after logout: status %d, want 401", status)
+	}
+	if status, _ := refresh(t, s, refreshToken); status != http.StatusUnauthorized {
+		t.Fatalf("refresh after logout: status %d, want 401", status)
+	}
+	if status, _ := callWithAuth(s, scopeSession, other); status != http.StatusOK {
+		t.Fatalf("other session after logout: status %d, want 200", status)
+	}
+}
+
+// dialAdminSocket connects to the hub's admin websocket with token and
+// returns the handshake status.
+func dialAdminSocket(t *testing.T, s *Server, token string) int {
+	t.Helper()
+	srv := httptest.NewServer(s.hub.ServeWS(nil))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/?type=0&secret=" + token
+	conn, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil {
+		conn.Close()
+	}
+	if resp == nil {
+		t.Fatalf("dial admin socket: %v", err)
+	}
+	return resp.StatusCode
+}
+
+func TestAdminSocketChecksSession(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	access, _ := newTestSession(t, s, 1)
+
+	// Without a check the hub accepts no admin sockets.
+	if status := dialAdminSocket(t, s, access); status != http.StatusUnauthorized {
+		t.Fatalf("admin socket without a check: status %d, want 401", status)
+	}
+
+	s.hub.SetAdminAuth(s.AuthorizeAdminSocket)
+	if status := dialAdminSocket(t, s, access); status != http.StatusSwitchingProtocols {
+		t.Fatalf("admin socket with an active session: status %d, want 101", status)
+	}
+	if status := dialAdminSocket(t, s, "not-a-token"); status != http.StatusUnauthorized {
+		t.Fatalf("admin socket with an invalid token: status %d, want 401", status)
+	}
+
+	revoked, _ := newTestSession(t, s, 1)
+	claims, err := s.authenticateSession(httptest.NewRequest(http.MethodGet, "/", nil), revoked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.store.RevokeSession(ctx, claims.SessionID, time.Now().UnixMilli()); err != nil {
+		t.Fatal(err)
+	}
+	if status := dialAdminSocket(t, s, revoked); status != http.StatusUnauthorized {
+		t.Fatalf("admin socket with a revoked session: status %d, want 401", status)
+	}
+
+	user, err := s.store.GetUserByID(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.store.UpdateUserFields(ctx, 1, user.User, nil, user.Flow, user.Num, user.ExpTime, user.FlowResetTime, 0, time.Now().UnixMilli()); err != nil {
+		t.Fatal(err)
+	}
+	if status := dialAdminSocket(t, s, access); status != http.StatusUnauthorized {
+		t.Fatalf("admin socket of a disabled user: status %d, want 401", status)
+	}
+}
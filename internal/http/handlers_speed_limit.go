@@ -57,6 +57,7 @@ func (s *Server) handleSpeedLimitCreate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	limit.ID = id
+	s.audit(r, auditActionCreate, auditEntitySpeedLimit, id, nil, limit)
 
 	if limit.Status == 1 {
 		data := gost.AddLimitersData(limit.ID, limit.Speed)
@@ -87,6 +88,7 @@ func (s *Server) handleSpeedLimitUpdate(w http.ResponseWriter, r *http.Request)
 		writeJSON(w, http.StatusBadRequest, Err("限速规则不存在"))
 		return
 	}
+	before := *limit
 	oldStatus := limit.Status
 	oldTunnelID := limit.TunnelID
 	oldSpeed := limit.Speed
@@ -107,6 +109,7 @@ func (s *Server) handleSpeedLimitUpdate(w http.ResponseWriter, r *http.Request)
 		writeJSON(w, http.StatusInternalServerError, Err("更新失败"))
 		return
 	}
+	s.audit(r, auditActionUpdate, auditEntitySpeedLimit, limit.ID, &before, limit)
 	if oldTunnelID != limit.TunnelID || oldStatus != limit.Status || oldSpeed != limit.Speed {
 		if oldTunnelID != 0 && (oldStatus == 1) {
 			if oldTunnelID != limit.TunnelID || limit.Status == 0 {
@@ -153,6 +156,7 @@ func (s *Server) handleSpeedLimitDelete(w http.ResponseWriter, r *http.Request)
 		}
 	}
 	_ = s.store.DeleteSpeedLimit(r.Context(), req.ID)
+	s.audit(r, auditActionDelete, auditEntitySpeedLimit, req.ID, limit, nil)
 	s.refreshTunnelForwardLimiters(r, limit.TunnelID)
 	writeJSON(w, http.StatusOK, OK("限速规则删除成功"))
 }
@@ -0,0 +1,107 @@
+package httpapi
+
+import (
+	"net/http"
+	"time"
+
+	"pixia-panel/internal/store"
+)
+
+const (
+	maxHourlyTrafficSpan = 31 * 24 * time.Hour
+	maxDailyTrafficSpan  = 366 * 24 * time.Hour
+)
+
+type trafficHistoryRequest struct {
+	Start       int64  `json:"start"`
+	End         int64  `json:"end"`
+	Granularity string `json:"granularity"`
+	GroupBy     string `json:"groupBy"`
+	UserID      int64  `json:"userId"`
+	ForwardID   int64  `json:"forwardId"`
+	TunnelID    int64  `json:"tunnelId"`
+	NodeID      int64  `json:"nodeId"`
+}
+
+func (s *Server) handleTrafficHistory(w http.ResponseWriter, r *http.Request) {
+	var req trafficHistoryRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("参数错误"))
+		return
+	}
+
+	var defaultSpan, maxSpan time.Duration
+	switch req.Granularity {
+	case "", store.GranularityHour:
+		req.Granularity = store.GranularityHour
+		defaultSpan, maxSpan = 24*time.Hour, maxHourlyTrafficSpan
+	case store.GranularityDay:
+		defaultSpan, maxSpan = 30*24*time.Hour, maxDailyTrafficSpan
+	default:
+		writeJSON(w, http.StatusBadRequest, Err("不支持的统计粒度"))
+		return
+	}
+	switch req.GroupBy {
+	case store.TrafficGroupNone, store.TrafficGroupForward, store.TrafficGroupTunnel, store.TrafficGroupNode, store.TrafficGroupUser:
+	default:
+		writeJSON(w, http.StatusBadRequest, Err("不支持的分组方式"))
+		return
+	}
+
+	var subtreeOf int64
+	switch {
+	case s.can(r, permTrafficRead):
+	case s.can(r, permSubUserWrite):
+		// Resellers see their own subtree, per user if they like.
+		if req.GroupBy == store.TrafficGroupNode || req.NodeID != 0 {
+			writeJSON(w, http.StatusForbidden, Err("权限不足"))
+			return
+		}
+		if req.UserID != 0 && req.UserID != userIDFromCtx(r) && !s.isSubUser(r, req.UserID) {
+			writeJSON(w, http.StatusForbidden, Err("权限不足"))
+			return
+		}
+		subtreeOf = userIDFromCtx(r)
+	default:
+		if req.GroupBy == store.TrafficGroupNode || req.GroupBy == store.TrafficGroupUser || req.NodeID != 0 {
+			writeJSON(w, http.StatusForbidden, Err("权限不足"))
+			return
+		}
+		req.UserID = userIDFromCtx(r)
+	}
+
+	if req.End <= 0 {
+		req.End = time.Now().UnixMilli()
+	}
+	if req.Start <= 0 {
+		req.Start = req.End - defaultSpan.Milliseconds()
+	}
+	if req.Start >= req.End {
+		writeJSON(w, http.StatusBadRequest, Err("时间范围错误"))
+		return
+	}
+	if req.End-req.Start > maxSpan.Milliseconds() {
+		writeJSON(w, http.StatusBadRequest, Err("时间范围过大"))
+		return
+	}
+
+	list, err := s.store.QueryTraffic(r.Context(), store.TrafficQuery{
+		Granularity: req.Granularity,
+		GroupBy:     req.GroupBy,
+		Start:       req.Start,
+		End:         req.End,
+		UserID:      req.UserID,
+		ForwardID:   req.ForwardID,
+		TunnelID:    req.TunnelID,
+		NodeID:      req.NodeID,
+		SubtreeOf:   subtreeOf,
+	})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("查询失败"))
+		return
+	}
+	if list == nil {
+		list = []store.TrafficPoint{}
+	}
+	writeJSON(w, http.StatusOK, OK(list))
+}
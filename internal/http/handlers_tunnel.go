@@ -143,10 +143,13 @@ func (s *Server) handleTunnelCreate(w http.ResponseWriter, r *http.Request) {
 		tunnel.Status = *req.Status
 	}
 
-	if _, err := s.store.InsertTunnel(r.Context(), tunnel); err != nil {
+	id, err := s.store.InsertTunnel(r.Context(), tunnel)
+	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, Err("创建失败"))
 		return
 	}
+	tunnel.ID = id
+	s.audit(r, auditActionCreate, auditEntityTunnel, id, nil, tunnel)
 	writeJSON(w, http.StatusOK, OK("隧道创建成功"))
 }
 
@@ -190,6 +193,7 @@ func (s *Server) handleTunnelUpdate(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, Err("隧道不存在"))
 		return
 	}
+	before := *tunnel
 	if req.TrafficRatio == 0 {
 		req.TrafficRatio = tunnel.TrafficRatio
 	}
@@ -234,6 +238,7 @@ func (s *Server) handleTunnelUpdate(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusInternalServerError, Err("更新失败"))
 		return
 	}
+	s.audit(r, auditActionUpdate, auditEntityTunnel, tunnel.ID, &before, tunnel)
 
 	// update related forwards on node
 	forwards, _ := s.store.ListForwardsByTunnel(r.Context(), tunnel.ID)
@@ -242,17 +247,17 @@ func (s *Server) handleTunnelUpdate(w http.ResponseWriter, r *http.Request) {
 		name := buildServiceName(fw.ID, fw.UserID, userTunnelID)
 		limiter := s.resolveSpeedLimiter(r, fw.UserID, fw.TunnelID)
 		data := gost.UpdateServiceData(name, fw.InPort, limiter, fw.RemoteAddr, gost.TunnelConfig{Type: tunnel.Type, Protocol: tunnel.Protocol, TCPListenAddr: tunnel.TCPListenAddr, UDPListenAddr: tunnel.UDPListenAddr}, fw.Strategy, fw.InterfaceName)
-		_ = s.enqueueGost(r, tunnel.InNodeID, "UpdateService", data)
+		_ = s.enqueueForwardCommandCtx(r.Context(), fw.ID, tunnel.InNodeID, "UpdateService", data)
 		if tunnel.Type == 2 && fw.OutPort != nil {
 			s.ensureLimiterConfig(r.Context(), tunnel.OutNodeID, limiter)
 			remote := gost.UpdateRemoteServiceData(name, *fw.OutPort, fw.RemoteAddr, tunnel.Protocol, fw.Strategy, fw.InterfaceName, limiter)
-			_ = s.enqueueGost(r, tunnel.OutNodeID, "UpdateService", remote)
+			_ = s.enqueueForwardCommandCtx(r.Context(), fw.ID, tunnel.OutNodeID, "UpdateService", remote)
 			outIP := tunnel.OutIP
 			if outNode, err := s.store.GetNodeByID(r.Context(), tunnel.OutNodeID); err == nil {
 				outIP = pickNodeEntryIP(derefString(outNode.IP), outNode.ServerIP)
 			}
 			chains := gost.UpdateChainsData(name, outIP+":"+strconv.FormatInt(*fw.OutPort, 10), tunnel.Protocol, fw.InterfaceName)
-			_ = s.enqueueGost(r, tunnel.InNodeID, "UpdateChains", chains)
+			_ = s.enqueueForwardCommandCtx(r.Context(), fw.ID, tunnel.InNodeID, "UpdateChains", chains)
 		}
 	}
 
@@ -275,10 +280,12 @@ func (s *Server) handleTunnelDelete(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, Err("该隧道还有用户权限关联"))
 		return
 	}
+	before, _ := s.store.GetTunnelByID(r.Context(), req.ID)
 	if err := s.store.DeleteTunnel(r.Context(), req.ID); err != nil {
 		writeJSON(w, http.StatusInternalServerError, Err("删除失败"))
 		return
 	}
+	s.audit(r, auditActionDelete, auditEntityTunnel, req.ID, before, nil)
 	writeJSON(w, http.StatusOK, OK("隧道删除成功"))
 }
 
@@ -288,10 +295,27 @@ func (s *Server) handleUserTunnelAssign(w http.ResponseWriter, r *http.Request)
 		writeJSON(w, http.StatusBadRequest, Err("参数错误"))
 		return
 	}
+	user, err := s.store.GetUserByID(r.Context(), req.UserID)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("用户不存在"))
+		return
+	}
+	if !s.canManageUserAs(r, permUserWrite, user) {
+		writeJSON(w, http.StatusForbidden, Err("权限不足"))
+		return
+	}
 	if _, err := s.store.GetUserTunnelByUserAndTunnel(r.Context(), req.UserID, req.TunnelID); err == nil {
 		writeJSON(w, http.StatusBadRequest, Err("该用户已拥有此隧道权限"))
 		return
 	}
+	if !s.can(r, permUserWrite) {
+		parentUT, msg := s.parentUserTunnelLimitError(r, user, req.TunnelID, req.Flow, req.Num, req.ExpTime)
+		if msg != "" {
+			writeJSON(w, http.StatusBadRequest, Err(msg))
+			return
+		}
+		req.SpeedID = parentUT.SpeedID
+	}
 	ut := &store.UserTunnel{
 		UserID:        req.UserID,
 		TunnelID:      req.TunnelID,
@@ -304,10 +328,13 @@ func (s *Server) handleUserTunnelAssign(w http.ResponseWriter, r *http.Request)
 		ExpTime:       req.ExpTime,
 		Status:        1,
 	}
-	if _, err := s.store.InsertUserTunnel(r.Context(), ut); err != nil {
+	id, err := s.store.InsertUserTunnel(r.Context(), ut)
+	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, Err("分配失败"))
 		return
 	}
+	ut.ID = id
+	s.audit(r, auditActionCreate, auditEntityUserTunnel, id, nil, ut)
 	writeJSON(w, http.StatusOK, OK("用户隧道权限分配成功"))
 }
 
@@ -317,6 +344,10 @@ func (s *Server) handleUserTunnelList(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, Err("参数错误"))
 		return
 	}
+	if !s.can(r, permUserRead) && !s.isSubUser(r, req.UserID) {
+		writeJSON(w, http.StatusForbidden, Err("权限不足"))
+		return
+	}
 	list, err := s.store.ListUserTunnelsByUser(r.Context(), req.UserID)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, Err("获取失败"))
@@ -336,7 +367,27 @@ func (s *Server) handleUserTunnelRemove(w http.ResponseWriter, r *http.Request)
 		writeJSON(w, http.StatusBadRequest, Err("用户隧道权限不存在"))
 		return
 	}
+	if user, err := s.store.GetUserByID(r.Context(), ut.UserID); err != nil || !s.canManageUserAs(r, permUserWrite, user) {
+		writeJSON(w, http.StatusForbidden, Err("权限不足"))
+		return
+	}
 
+	if err := s.removeUserTunnel(r, ut); err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("删除失败"))
+		return
+	}
+	// Sub-users can no longer draw from a tunnel their reseller lost.
+	subs, _ := s.store.ListSubUserIDs(r.Context(), ut.UserID)
+	for _, sub := range subs {
+		if subUT, err := s.store.GetUserTunnelByUserAndTunnel(r.Context(), sub, ut.TunnelID); err == nil {
+			_ = s.removeUserTunnel(r, subUT)
+		}
+	}
+	writeJSON(w, http.StatusOK, OK("用户隧道权限删除成功"))
+}
+
+// removeUserTunnel deletes ut together with the user's forwards on its tunnel.
+func (s *Server) removeUserTunnel(r *http.Request, ut *store.UserTunnel) error {
 	forwards, _ := s.store.ListForwardsByUser(r.Context(), ut.UserID)
 	for _, fw := range forwards {
 		if fw.TunnelID != ut.TunnelID {
@@ -352,10 +403,10 @@ func (s *Server) handleUserTunnelRemove(w http.ResponseWriter, r *http.Request)
 	}
 
 	if err := s.store.DeleteUserTunnel(r.Context(), ut.ID); err != nil {
-		writeJSON(w, http.StatusInternalServerError, Err("删除失败"))
-		return
+		return err
 	}
-	writeJSON(w, http.StatusOK, OK("用户隧道权限删除成功"))
+	s.audit(r, auditActionDelete, auditEntityUserTunnel, ut.ID, ut, nil)
+	return nil
 }
 
 func (s *Server) handleUserTunnelUpdate(w http.ResponseWriter, r *http.Request) {
@@ -369,6 +420,24 @@ func (s *Server) handleUserTunnelUpdate(w http.ResponseWriter, r *http.Request)
 		writeJSON(w, http.StatusBadRequest, Err("用户隧道权限不存在"))
 		return
 	}
+	user, err := s.store.GetUserByID(r.Context(), ut.UserID)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("用户不存在"))
+		return
+	}
+	if !s.canManageUserAs(r, permUserQuota, user) {
+		writeJSON(w, http.StatusForbidden, Err("权限不足"))
+		return
+	}
+	if !s.can(r, permUserQuota) {
+		parentUT, msg := s.parentUserTunnelLimitError(r, user, ut.TunnelID, req.Flow, req.Num, req.ExpTime)
+		if msg != "" {
+			writeJSON(w, http.StatusBadRequest, Err(msg))
+			return
+		}
+		req.SpeedID = parentUT.SpeedID
+	}
+	before := *ut
 	oldSpeed := ut.SpeedID
 	ut.Flow = req.Flow
 	ut.Num = req.Num
@@ -380,6 +449,7 @@ func (s *Server) handleUserTunnelUpdate(w http.ResponseWriter, r *http.Request)
 		writeJSON(w, http.StatusInternalServerError, Err("更新失败"))
 		return
 	}
+	s.audit(r, auditActionUpdate, auditEntityUserTunnel, ut.ID, &before, ut)
 
 	if !equalInt64Ptr(oldSpeed, ut.SpeedID) {
 		// update forward limiters
@@ -394,17 +464,17 @@ func (s *Server) handleUserTunnelUpdate(w http.ResponseWriter, r *http.Request)
 			}
 			name := buildServiceName(fw.ID, fw.UserID, ut.ID)
 			data := gost.UpdateServiceData(name, fw.InPort, ut.SpeedID, fw.RemoteAddr, gost.TunnelConfig{Type: tunnel.Type, Protocol: tunnel.Protocol, TCPListenAddr: tunnel.TCPListenAddr, UDPListenAddr: tunnel.UDPListenAddr}, fw.Strategy, fw.InterfaceName)
-			_ = s.enqueueGost(r, tunnel.InNodeID, "UpdateService", data)
+			_ = s.enqueueForwardCommandCtx(r.Context(), fw.ID, tunnel.InNodeID, "UpdateService", data)
 		}
 	}
 
+	s.ResumeQuotaPausedForwards(r.Context(), ut.UserID)
 	writeJSON(w, http.StatusOK, OK("用户隧道权限更新成功"))
 }
 
 func (s *Server) handleUserTunnelAvailable(w http.ResponseWriter, r *http.Request) {
-	role := roleIDFromCtx(r)
 	userID := userIDFromCtx(r)
-	if role == 0 {
+	if s.can(r, permForwardWrite) {
 		tunnels, _ := s.store.ListTunnels(r.Context())
 		writeJSON(w, http.StatusOK, OK(s.decorateTunnels(r.Context(), tunnels)))
 		return
@@ -0,0 +1,344 @@
+package httpapi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"pixia-panel/internal/auth"
+	"pixia-panel/internal/store"
+)
+
+const (
+	twoFactorChallengeTTL = 5 * time.Minute
+	recoveryCodeCount     = 10
+	defaultTOTPIssuer     = "pixia-panel"
+)
+
+type twoFactorLoginRequest struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"`
+}
+
+type twoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type twoFactorResetRequest struct {
+	ID int64 `json:"id"`
+}
+
+// twoFactorPurpose returns the challenge purpose user must pass after the
+// password step: PurposeTwoFactor with TOTP enabled, PurposeTwoFactorSetup for
+// administrators that still have to enroll, or "" when none is needed.
+func (s *Server) twoFactorPurpose(r *http.Request, user *store.User) string {
+	if t, err := s.store.GetUserTOTP(r.Context(), user.ID); err == nil && t.Enabled {
+		return auth.PurposeTwoFactor
+	}
+	if s.isPrivilegedRole(r.Context(), user.RoleID) && s.isAdminTwoFactorRequired(r) {
+		return auth.PurposeTwoFactorSetup
+	}
+	return ""
+}
+
+// startTwoFactorLogin answers the password step with a challenge when user
+// must pass or set up a second factor. It reports whether it wrote a response.
+func (s *Server) startTwoFactorLogin(w http.ResponseWriter, r *http.Request, user *store.User) bool {
+	purpose := s.twoFactorPurpose(r, user)
+	if purpose == "" {
+		return false
+	}
+
+	challenge, err := auth.SignChallenge(s.jwtSecret, user.ID, user.RoleID, purpose, twoFactorChallengeTTL)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("登录失败"))
+		return true
+	}
+	writeJSON(w, http.StatusOK, OK(map[string]any{
+		"twoFactorRequired":      purpose == auth.PurposeTwoFactor,
+		"twoFactorSetupRequired": purpose == auth.PurposeTwoFactorSetup,
+		"challenge":              challenge,
+	}))
+	return true
+}
+
+// handleTwoFactorLogin completes a login with a TOTP or recovery code. For
+// setup challenges the code confirms the secret from handleTwoFactorLoginSetup
+// and the response also carries the new recovery codes.
+func (s *Server) handleTwoFactorLogin(w http.ResponseWriter, r *http.Request) {
+	var req twoFactorLoginRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("参数错误"))
+		return
+	}
+	claims, err := auth.ParseChallenge(s.jwtSecret, req.Challenge, auth.PurposeTwoFactor)
+	setup := false
+	if err != nil {
+		claims, err = auth.ParseChallenge(s.jwtSecret, req.Challenge, auth.PurposeTwoFactorSetup)
+		setup = true
+	}
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, Err("登录已过期，请重新登录"))
+		return
+	}
+	user, err := s.store.GetUserByID(r.Context(), claims.UserID)
+	if err != nil || user.Status == 0 {
+		writeJSON(w, http.StatusBadRequest, Err("账户停用"))
+		return
+	}
+	if wait := s.loginLockedFor(r, user.User); wait > 0 {
+		writeLoginLocked(w, wait)
+		return
+	}
+
+	var recoveryCodes []string
+	if setup {
+		recoveryCodes, err = s.enableTwoFactor(r, user.ID, req.Code)
+		if err != nil {
+			s.recordLoginFailure(r, user.User)
+			writeJSON(w, http.StatusBadRequest, Err(err.Error()))
+			return
+		}
+	} else if !s.verifySecondFactor(r, user.ID, req.Code) {
+		s.recordLoginFailure(r, user.User)
+		writeJSON(w, http.StatusBadRequest, Err("验证码错误"))
+		return
+	}
+
+	data, err := s.loginData(r, user)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("登录失败"))
+		return
+	}
+	if setup {
+		data["recoveryCodes"] = recoveryCodes
+	}
+	writeJSON(w, http.StatusOK, OK(data))
+}
+
+// handleTwoFactorLoginSetup issues a TOTP secret for an account that must
+// enroll before it can log in.
+func (s *Server) handleTwoFactorLoginSetup(w http.ResponseWriter, r *http.Request) {
+	var req twoFactorLoginRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("参数错误"))
+		return
+	}
+	claims, err := auth.ParseChallenge(s.jwtSecret, req.Challenge, auth.PurposeTwoFactorSetup)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, Err("登录已过期，请重新登录"))
+		return
+	}
+	s.writeTwoFactorEnrollment(w, r, claims.UserID)
+}
+
+func (s *Server) handleTwoFactorStatus(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFromCtx(r)
+	enabled := false
+	if t, err := s.store.GetUserTOTP(r.Context(), userID); err == nil {
+		enabled = t.Enabled
+	}
+	var remaining int64
+	if enabled {
+		remaining, _ = s.store.CountUnusedRecoveryCodes(r.Context(), userID)
+	}
+	writeJSON(w, http.StatusOK, OK(map[string]any{
+		"enabled":       enabled,
+		"required":      s.hasAnyPermission(r) && s.isAdminTwoFactorRequired(r),
+		"recoveryCodes": remaining,
+	}))
+}
+
+func (s *Server) handleTwoFactorEnroll(w http.ResponseWriter, r *http.Request) {
+	s.writeTwoFactorEnrollment(w, r, userIDFromCtx(r))
+}
+
+func (s *Server) handleTwoFactorEnable(w http.ResponseWriter, r *http.Request) {
+	var req twoFactorCodeRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("参数错误"))
+		return
+	}
+	userID := userIDFromCtx(r)
+	codes, err := s.enableTwoFactor(r, userID, req.Code)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, Err(err.Error()))
+		return
+	}
+	s.audit(r, auditActionEnableTwoFactor, auditEntityUser, userID, nil, nil)
+	writeJSON(w, http.StatusOK, OK(map[string]any{"recoveryCodes": codes}))
+}
+
+func (s *Server) handleTwoFactorDisable(w http.ResponseWriter, r *http.Request) {
+	var req twoFactorCodeRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("参数错误"))
+		return
+	}
+	if s.hasAnyPermission(r) && s.isAdminTwoFactorRequired(r) {
+		writeJSON(w, http.StatusBadRequest, Err("管理员账号必须启用两步验证"))
+		return
+	}
+	userID := userIDFromCtx(r)
+	if !s.verifySecondFactor(r, userID, req.Code) {
+		writeJSON(w, http.StatusBadRequest, Err("验证码错误"))
+		return
+	}
+	if err := s.store.DeleteUserTOTP(r.Context(), userID); err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("关闭失败"))
+		return
+	}
+	s.audit(r, auditActionDisableTwoFactor, auditEntityUser, userID, nil, nil)
+	writeJSON(w, http.StatusOK, OK("两步验证已关闭"))
+}
+
+func (s *Server) handleTwoFactorRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	var req twoFactorCodeRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("参数错误"))
+		return
+	}
+	userID := userIDFromCtx(r)
+	if !s.verifySecondFactor(r, userID, req.Code) {
+		writeJSON(w, http.StatusBadRequest, Err("验证码错误"))
+		return
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("生成失败"))
+		return
+	}
+	if err := s.store.ReplaceRecoveryCodes(r.Context(), userID, hashes); err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("生成失败"))
+		return
+	}
+	writeJSON(w, http.StatusOK, OK(map[string]any{"recoveryCodes": codes}))
+}
+
+// handleTwoFactorReset lets an admin remove the second factor of a user who
+// lost both the authenticator and the recovery codes.
+func (s *Server) handleTwoFactorReset(w http.ResponseWriter, r *http.Request) {
+	var req twoFactorResetRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("参数错误"))
+		return
+	}
+	user, err := s.store.GetUserByID(r.Context(), req.ID)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("用户不存在"))
+		return
+	}
+	if !s.canManageUser(r, user) {
+		writeJSON(w, http.StatusForbidden, Err("权限不足"))
+		return
+	}
+	if err := s.store.DeleteUserTOTP(r.Context(), req.ID); err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("重置失败"))
+		return
+	}
+	s.audit(r, auditActionResetTwoFactor, auditEntityUser, req.ID, nil, nil)
+	writeJSON(w, http.StatusOK, OK("两步验证已重置"))
+}
+
+func (s *Server) writeTwoFactorEnrollment(w http.ResponseWriter, r *http.Request, userID int64) {
+	user, err := s.store.GetUserByID(r.Context(), userID)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("用户不存在"))
+		return
+	}
+	if t, err := s.store.GetUserTOTP(r.Context(), userID); err == nil && t.Enabled {
+		writeJSON(w, http.StatusBadRequest, Err("已启用两步验证"))
+		return
+	}
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("生成失败"))
+		return
+	}
+	if err := s.store.SavePendingTOTP(r.Context(), userID, secret); err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("生成失败"))
+		return
+	}
+	writeJSON(w, http.StatusOK, OK(map[string]any{
+		"secret": secret,
+		"uri":    auth.TOTPURI(s.totpIssuer(r), user.User, secret),
+	}))
+}
+
+// enableTwoFactor confirms the pending secret with code and returns fresh
+// recovery codes. Errors carry the message shown to the user.
+func (s *Server) enableTwoFactor(r *http.Request, userID int64, code string) ([]string, error) {
+	t, err := s.store.GetUserTOTP(r.Context(), userID)
+	if err != nil {
+		return nil, errors.New("请先获取两步验证密钥")
+	}
+	if t.Enabled {
+		return nil, errors.New("已启用两步验证")
+	}
+	step, ok := auth.VerifyTOTP(t.Secret, code, time.Now())
+	if !ok {
+		return nil, errors.New("验证码错误")
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, errors.New("启用失败")
+	}
+	if err := s.store.EnableTOTP(r.Context(), userID, step, hashes); err != nil {
+		return nil, errors.New("启用失败")
+	}
+	return codes, nil
+}
+
+// verifySecondFactor accepts an unused TOTP step or an unused recovery code.
+func (s *Server) verifySecondFactor(r *http.Request, userID int64, code string) bool {
+	t, err := s.store.GetUserTOTP(r.Context(), userID)
+	if err != nil || !t.Enabled {
+		return false
+	}
+	if step, ok := auth.VerifyTOTP(t.Secret, code, time.Now()); ok {
+		used, err := s.store.UseTOTPStep(r.Context(), userID, step)
+		return err == nil && used
+	}
+	normalized := auth.NormalizeRecoveryCode(code)
+	if normalized == "" {
+		return false
+	}
+	used, err := s.store.UseRecoveryCode(r.Context(), userID, hashRecoveryCode(normalized))
+	return err == nil && used
+}
+
+func (s *Server) isAdminTwoFactorRequired(r *http.Request) bool {
+	cfg, err := s.store.GetConfigByName(r.Context(), "admin_require_2fa")
+	if err != nil {
+		return false
+	}
+	return cfg.Value == "true"
+}
+
+func (s *Server) totpIssuer(r *http.Request) string {
+	if cfg, err := s.store.GetConfigByName(r.Context(), "app_name"); err == nil && cfg.Value != "" {
+		return cfg.Value
+	}
+	return defaultTOTPIssuer
+}
+
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = hashRecoveryCode(auth.NormalizeRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode stores codes as SHA-256; they are random enough that a
+// slow password hash is unnecessary.
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,97 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"pixia-panel/internal/auth"
+)
+
+// enableTestTwoFactor turns on two-factor authentication for the seeded
+// admin and returns its recovery codes.
+func enableTestTwoFactor(t *testing.T, s *Server) []string {
+	t.Helper()
+	ctx := context.Background()
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.store.SavePendingTOTP(ctx, 1, secret); err != nil {
+		t.Fatal(err)
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.store.EnableTOTP(ctx, 1, 0, hashes); err != nil {
+		t.Fatal(err)
+	}
+	return codes
+}
+
+func TestRecoveryCodeSingleUse(t *testing.T) {
+	s := newTestServer(t)
+	codes := enableTestTwoFactor(t, s)
+	challenge, err := auth.SignChallenge(s.jwtSecret, 1, 0, auth.PurposeTwoFactor, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	login := func(code string) (int, Response) {
+		t.Helper()
+		return postJSON(t, s.handleTwoFactorLogin, twoFactorLoginRequest{Challenge: challenge, Code: code}, nil)
+	}
+
+	// Codes are accepted without the separator and in upper case.
+	if status, resp := login(strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))); status != http.StatusOK || resp.Code != 0 {
+		t.Fatalf("first use of a recovery code = %d %q", status, resp.Msg)
+	}
+	if status, resp := login(codes[0]); status != http.StatusBadRequest || resp.Code == 0 {
+		t.Fatalf("second use of a recovery code = %d %q, want rejected", status, resp.Msg)
+	}
+	if status, resp := login(codes[1]); status != http.StatusOK || resp.Code != 0 {
+		t.Fatalf("other recovery code = %d %q", status, resp.Msg)
+	}
+	if n, err := s.store.CountUnusedRecoveryCodes(context.Background(), 1); err != nil || n != recoveryCodeCount-2 {
+		t.Fatalf("unused recovery codes = %d, %v; want %d", n, err, recoveryCodeCount-2)
+	}
+}
+
+func TestReplacedRecoveryCodesStopWorking(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	old := enableTestTwoFactor(t, s)
+	fresh, hashes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.store.ReplaceRecoveryCodes(ctx, 1, hashes); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	if s.verifySecondFactor(r, 1, old[2]) {
+		t.Fatal("replaced recovery code accepted")
+	}
+	if !s.verifySecondFactor(r, 1, fresh[2]) {
+		t.Fatal("new recovery code rejected")
+	}
+}
+
+func TestTOTPStepSingleUse(t *testing.T) {
+	s := newTestServer(t)
+	enableTestTwoFactor(t, s)
+	ctx := context.Background()
+	step := time.Now().Unix() / 30
+	if used, err := s.store.UseTOTPStep(ctx, 1, step); err != nil || !used {
+		t.Fatalf("first use of step = %v, %v", used, err)
+	}
+	if used, err := s.store.UseTOTPStep(ctx, 1, step); err != nil || used {
+		t.Fatalf("replayed step = %v, %v; want rejected", used, err)
+	}
+	if used, err := s.store.UseTOTPStep(ctx, 1, step-1); err != nil || used {
+		t.Fatalf("earlier step after a later one = %v, %v; want rejected", used, err)
+	}
+}
@@ -5,8 +5,6 @@ import (
 	"strings"
 	"time"
 
-	"pixia-panel/internal/auth"
-	"pixia-panel/internal/captcha"
 	"pixia-panel/internal/store"
 )
 
@@ -26,6 +24,8 @@ type userCreateRequest struct {
 	ExpTime       int64  `json:"expTime"`
 	FlowResetTime int64  `json:"flowResetTime"`
 	Status        *int64 `json:"status"`
+	RoleID        *int64 `json:"roleId"`
+	ParentID      int64  `json:"parentId"`
 }
 
 type userUpdateRequest struct {
@@ -39,6 +39,19 @@ type userUpdateRequest struct {
 	Status        *int64 `json:"status"`
 }
 
+type userQuotaRequest struct {
+	ID            int64 `json:"id"`
+	Flow          int64 `json:"flow"`
+	Num           int64 `json:"num"`
+	ExpTime       int64 `json:"expTime"`
+	FlowResetTime int64 `json:"flowResetTime"`
+}
+
+type userRoleRequest struct {
+	ID     int64 `json:"id"`
+	RoleID int64 `json:"roleId"`
+}
+
 type userDeleteRequest struct {
 	ID int64 `json:"id"`
 }
@@ -65,26 +78,21 @@ func (s *Server) handleUserLogin(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, Err("用户名或密码不能为空"))
 		return
 	}
+	if wait := s.loginLockedFor(r, req.Username); wait > 0 {
+		writeLoginLocked(w, wait)
+		return
+	}
 
 	if s.isCaptchaEnabled(r) {
-		turnstileEnabled, turnstileSecret := s.turnstileConfig(r)
-		if !turnstileEnabled {
-			writeJSON(w, http.StatusBadRequest, Err("请先配置Cloudflare验证码"))
-			return
-		}
-		if req.TurnstileToken == "" {
-			writeJSON(w, http.StatusBadRequest, Err("验证码不能为空"))
-			return
-		}
-		resp, err := captcha.VerifyTurnstile(r.Context(), turnstileSecret, req.TurnstileToken, r.RemoteAddr)
-		if err != nil || !resp.Success {
-			writeJSON(w, http.StatusBadRequest, Err("验证码校验失败"))
+		if msg := s.checkLoginCaptcha(r, &req); msg != "" {
+			writeJSON(w, http.StatusBadRequest, Err(msg))
 			return
 		}
 	}
 
 	user, err := s.store.GetUserByName(r.Context(), req.Username)
 	if err != nil {
+		s.recordLoginFailure(r, req.Username)
 		writeJSON(w, http.StatusBadRequest, Err("账号或密码错误"))
 		return
 	}
@@ -95,9 +103,14 @@ func (s *Server) handleUserLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if !ok {
+		s.recordLoginFailure(r, req.Username)
 		writeJSON(w, http.StatusBadRequest, Err("账号或密码错误"))
 		return
 	}
+	if s.passwordLoginDisabled(r.Context(), user) {
+		writeJSON(w, http.StatusForbidden, Err("管理员账号请使用单点登录"))
+		return
+	}
 	if upgrade {
 		if hashed, err := hashPassword(req.Password); err == nil {
 			_ = s.store.UpdateUserFields(r.Context(), user.ID, user.User, &hashed, user.Flow, user.Num, user.ExpTime, user.FlowResetTime, user.Status, time.Now().UnixMilli())
@@ -109,21 +122,33 @@ func (s *Server) handleUserLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := auth.Sign(s.jwtSecret, user.ID, user.RoleID, s.tokenTTL)
+	// A banned password such as the seeded default must be replaced before
+	// the account can be used.
+	if !user.MustChangePassword && s.isBannedPassword(r.Context(), req.Password) {
+		if err := s.store.SetUserMustChangePassword(r.Context(), user.ID, true); err == nil {
+			user.MustChangePassword = true
+		}
+	}
+	if s.startTwoFactorLogin(w, r, user) {
+		return
+	}
+	data, err := s.loginData(r, user)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, Err("登录失败"))
 		return
 	}
+	writeJSON(w, http.StatusOK, OK(data))
+}
 
-	requirePwdChange := user.User == "admin_user" || req.Password == "admin_user"
-	data := map[string]any{
-		"token":                 token,
-		"name":                  user.User,
-		"role_id":               user.RoleID,
-		"requirePasswordChange": requirePwdChange,
+// loginData starts a session for user and builds the login response. The
+// login is complete at this point, so failed attempts are forgotten.
+func (s *Server) loginData(r *http.Request, user *store.User) (map[string]any, error) {
+	data, err := s.startSession(r, user)
+	if err != nil {
+		return nil, err
 	}
-
-	writeJSON(w, http.StatusOK, OK(data))
+	s.clearLoginFailures(r, user.User)
+	return data, nil
 }
 
 func (s *Server) handleUserCreate(w http.ResponseWriter, r *http.Request) {
@@ -140,6 +165,41 @@ func (s *Server) handleUserCreate(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, Err("用户名已存在"))
 		return
 	}
+	if msg := s.passwordPolicyError(r, req.User, req.Pwd); msg != "" {
+		writeJSON(w, http.StatusBadRequest, Err(msg))
+		return
+	}
+
+	roleID := int64(1)
+	if req.RoleID != nil {
+		roleID = *req.RoleID
+	}
+	role, err := s.store.GetRoleByID(r.Context(), roleID)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("角色不存在"))
+		return
+	}
+	if !s.canGrant(r, role.Permissions) {
+		writeJSON(w, http.StatusForbidden, Err("不能授予超出自身的权限"))
+		return
+	}
+	// Resellers always create users below themselves, within their own limits.
+	if !s.can(r, permUserWrite) {
+		req.ParentID = userIDFromCtx(r)
+	}
+	if req.ParentID != 0 {
+		parent, err := s.store.GetUserByID(r.Context(), req.ParentID)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, Err("上级用户不存在"))
+			return
+		}
+		if !s.can(r, permUserWrite) {
+			if msg := resellerLimitError(parent.Flow, parent.Num, parent.ExpTime, req.Flow, req.Num, req.ExpTime); msg != "" {
+				writeJSON(w, http.StatusBadRequest, Err(msg))
+				return
+			}
+		}
+	}
 
 	status := int64(1)
 	if req.Status != nil {
@@ -153,7 +213,8 @@ func (s *Server) handleUserCreate(w http.ResponseWriter, r *http.Request) {
 	user := &store.User{
 		User:          req.User,
 		Pwd:           hashed,
-		RoleID:        1,
+		RoleID:        roleID,
+		ParentID:      req.ParentID,
 		ExpTime:       req.ExpTime,
 		Flow:          req.Flow,
 		InFlow:        0,
@@ -165,10 +226,13 @@ func (s *Server) handleUserCreate(w http.ResponseWriter, r *http.Request) {
 		Status:        status,
 	}
 
-	if _, err := s.store.InsertUser(r.Context(), user); err != nil {
+	id, err := s.store.InsertUser(r.Context(), user)
+	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, Err("创建失败"))
 		return
 	}
+	user.ID = id
+	s.audit(r, auditActionCreate, auditEntityUser, id, nil, user)
 
 	writeJSON(w, http.StatusOK, OK("用户创建成功"))
 }
@@ -179,9 +243,24 @@ func (s *Server) handleUserList(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusInternalServerError, Err("获取失败"))
 		return
 	}
+	// Resellers only see their own sub-users.
+	var visible map[int64]bool
+	if !s.can(r, permUserRead) {
+		subs, err := s.store.ListSubUserIDs(r.Context(), userIDFromCtx(r))
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, Err("获取失败"))
+			return
+		}
+		visible = make(map[int64]bool, len(subs))
+		for _, id := range subs {
+			visible[id] = true
+		}
+	}
+	// The caller and accounts more privileged than the caller are not listed.
+	callerID := userIDFromCtx(r)
 	filtered := make([]store.User, 0, len(users))
 	for i := range users {
-		if users[i].RoleID == 0 {
+		if users[i].ID == callerID || (visible != nil && !visible[users[i].ID]) || !s.canManageUser(r, &users[i]) {
 			continue
 		}
 		users[i].Pwd = ""
@@ -201,6 +280,14 @@ func (s *Server) handleUserUpdate(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, Err("用户不存在"))
 		return
 	}
+	if !s.canManageUserAs(r, permUserWrite, user) {
+		writeJSON(w, http.StatusForbidden, Err("权限不足"))
+		return
+	}
+	if msg := s.subUserLimitError(r, permUserWrite, user, req.Flow, req.Num, req.ExpTime); msg != "" {
+		writeJSON(w, http.StatusBadRequest, Err(msg))
+		return
+	}
 
 	if existing, err := s.store.GetUserByName(r.Context(), req.User); err == nil && existing.ID != req.ID {
 		writeJSON(w, http.StatusBadRequest, Err("用户名已被其他用户使用"))
@@ -213,6 +300,10 @@ func (s *Server) handleUserUpdate(w http.ResponseWriter, r *http.Request) {
 	}
 	var pwd *string
 	if req.Pwd != "" {
+		if msg := s.passwordPolicyError(r, req.User, req.Pwd); msg != "" {
+			writeJSON(w, http.StatusBadRequest, Err(msg))
+			return
+		}
 		hashed, err := hashPassword(req.Pwd)
 		if err != nil {
 			writeJSON(w, http.StatusInternalServerError, Err("密码处理失败"))
@@ -224,6 +315,16 @@ func (s *Server) handleUserUpdate(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusInternalServerError, Err("更新失败"))
 		return
 	}
+	// A password set by someone else must be changed at the next login.
+	if pwd != nil {
+		_ = s.store.SetUserMustChangePassword(r.Context(), req.ID, req.ID != userIDFromCtx(r))
+	}
+	if pwd != nil || status != user.Status {
+		s.revokeUserSessions(r, req.ID)
+	}
+	after, _ := s.store.GetUserByID(r.Context(), req.ID)
+	s.audit(r, auditActionUpdate, auditEntityUser, req.ID, user, after)
+	s.ResumeQuotaPausedForwards(r.Context(), req.ID)
 	writeJSON(w, http.StatusOK, OK("用户更新成功"))
 }
 
@@ -238,17 +339,94 @@ func (s *Server) handleUserDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	user, err := s.store.GetUserByID(r.Context(), req.ID)
-	if err == nil && user.RoleID == 0 {
+	if err == nil && (user.ID == userIDFromCtx(r) || s.roleHas(r.Context(), user.RoleID, permAll)) {
 		writeJSON(w, http.StatusBadRequest, Err("不能删除管理员用户"))
 		return
 	}
+	if err == nil && !s.canManageUserAs(r, permUserWrite, user) {
+		writeJSON(w, http.StatusForbidden, Err("权限不足"))
+		return
+	}
+	if children, err := s.store.CountChildUsers(r.Context(), req.ID); err != nil || children > 0 {
+		writeJSON(w, http.StatusBadRequest, Err("请先删除该用户的下级用户"))
+		return
+	}
 	if err := s.deleteUserCascade(r, req.ID); err != nil {
 		writeJSON(w, http.StatusInternalServerError, Err(err.Error()))
 		return
 	}
+	s.audit(r, auditActionDelete, auditEntityUser, req.ID, user, nil)
 	writeJSON(w, http.StatusOK, OK("用户及关联数据删除成功"))
 }
 
+// handleUserQuota changes only the quota and expiry of a user, for roles that
+// may not otherwise edit accounts.
+func (s *Server) handleUserQuota(w http.ResponseWriter, r *http.Request) {
+	var req userQuotaRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("参数错误"))
+		return
+	}
+	user, err := s.store.GetUserByID(r.Context(), req.ID)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("用户不存在"))
+		return
+	}
+	if !s.canManageUserAs(r, permUserQuota, user) {
+		writeJSON(w, http.StatusForbidden, Err("权限不足"))
+		return
+	}
+	if msg := s.subUserLimitError(r, permUserQuota, user, req.Flow, req.Num, req.ExpTime); msg != "" {
+		writeJSON(w, http.StatusBadRequest, Err(msg))
+		return
+	}
+	if err := s.store.UpdateUserFields(r.Context(), user.ID, user.User, nil, req.Flow, req.Num, req.ExpTime, req.FlowResetTime, user.Status, time.Now().UnixMilli()); err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("更新失败"))
+		return
+	}
+	after, _ := s.store.GetUserByID(r.Context(), user.ID)
+	s.audit(r, auditActionUpdateQuota, auditEntityUser, user.ID, user, after)
+	s.ResumeQuotaPausedForwards(r.Context(), user.ID)
+	writeJSON(w, http.StatusOK, OK("用户配额更新成功"))
+}
+
+func (s *Server) handleUserRole(w http.ResponseWriter, r *http.Request) {
+	var req userRoleRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("参数错误"))
+		return
+	}
+	if req.ID == userIDFromCtx(r) {
+		writeJSON(w, http.StatusBadRequest, Err("不能修改自己的角色"))
+		return
+	}
+	user, err := s.store.GetUserByID(r.Context(), req.ID)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("用户不存在"))
+		return
+	}
+	role, err := s.store.GetRoleByID(r.Context(), req.RoleID)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("角色不存在"))
+		return
+	}
+	if !s.canManageUser(r, user) || !s.canGrant(r, role.Permissions) {
+		writeJSON(w, http.StatusForbidden, Err("不能授予超出自身的权限"))
+		return
+	}
+	if user.RoleID == role.ID {
+		writeJSON(w, http.StatusOK, OK("用户角色更新成功"))
+		return
+	}
+	if err := s.store.UpdateUserRole(r.Context(), user.ID, role.ID, time.Now().UnixMilli()); err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("更新失败"))
+		return
+	}
+	after, _ := s.store.GetUserByID(r.Context(), user.ID)
+	s.audit(r, auditActionUpdateRole, auditEntityUser, user.ID, user, after)
+	writeJSON(w, http.StatusOK, OK("用户角色更新成功"))
+}
+
 func (s *Server) handleUserPackage(w http.ResponseWriter, r *http.Request) {
 	userID := userIDFromCtx(r)
 	user, err := s.store.GetUserByID(r.Context(), userID)
@@ -260,8 +438,7 @@ func (s *Server) handleUserPackage(w http.ResponseWriter, r *http.Request) {
 
 	tunnels, _ := s.store.ListUserTunnelsByUser(r.Context(), userID)
 	forwards, _ := s.store.ListForwardsByUser(r.Context(), userID)
-	stats, _ := s.store.ListRecentStatistics(r.Context(), userID, 24)
-	stats = fillLast24(stats, userID)
+	stats, _ := s.userLast24Hours(r.Context(), user)
 
 	data := map[string]any{
 		"userInfo":          user,
@@ -308,6 +485,14 @@ func (s *Server) handleUserUpdatePassword(w http.ResponseWriter, r *http.Request
 		writeJSON(w, http.StatusBadRequest, Err("用户名已被其他用户使用"))
 		return
 	}
+	if req.NewPassword == req.CurrentPassword {
+		writeJSON(w, http.StatusBadRequest, Err("新密码不能与当前密码相同"))
+		return
+	}
+	if msg := s.passwordPolicyError(r, req.NewUsername, req.NewPassword); msg != "" {
+		writeJSON(w, http.StatusBadRequest, Err(msg))
+		return
+	}
 	newPwd, err := hashPassword(req.NewPassword)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, Err("密码处理失败"))
@@ -317,6 +502,13 @@ func (s *Server) handleUserUpdatePassword(w http.ResponseWriter, r *http.Request
 		writeJSON(w, http.StatusInternalServerError, Err("账号密码修改失败"))
 		return
 	}
+	if err := s.store.SetUserMustChangePassword(r.Context(), userID, false); err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("账号密码修改失败"))
+		return
+	}
+	after, _ := s.store.GetUserByID(r.Context(), userID)
+	s.revokeUserSessions(r, userID)
+	s.audit(r, auditActionUpdatePassword, auditEntityUser, userID, user, after)
 	writeJSON(w, http.StatusOK, OK("账号密码修改成功"))
 }
 
@@ -332,6 +524,7 @@ func (s *Server) handleUserResetFlow(w http.ResponseWriter, r *http.Request) {
 			writeJSON(w, http.StatusBadRequest, Err("用户不存在"))
 			return
 		}
+		before := *user
 		user.InFlow = 0
 		user.OutFlow = 0
 		user.UpdatedTime = ptrInt64(time.Now().UnixMilli())
@@ -339,6 +532,8 @@ func (s *Server) handleUserResetFlow(w http.ResponseWriter, r *http.Request) {
 			writeJSON(w, http.StatusInternalServerError, Err("更新失败"))
 			return
 		}
+		s.audit(r, auditActionResetFlow, auditEntityUser, user.ID, &before, user)
+		s.ResumeQuotaPausedForwards(r.Context(), user.ID)
 		writeJSON(w, http.StatusOK, OK("ok"))
 		return
 	}
@@ -348,12 +543,15 @@ func (s *Server) handleUserResetFlow(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, Err("隧道不存在"))
 		return
 	}
+	before := *ut
 	ut.InFlow = 0
 	ut.OutFlow = 0
 	if err := s.store.UpdateUserTunnel(r.Context(), ut); err != nil {
 		writeJSON(w, http.StatusInternalServerError, Err("更新失败"))
 		return
 	}
+	s.audit(r, auditActionResetFlow, auditEntityUserTunnel, ut.ID, &before, ut)
+	s.ResumeQuotaPausedForwards(r.Context(), ut.UserID)
 	writeJSON(w, http.StatusOK, OK("ok"))
 }
 
@@ -371,7 +569,7 @@ func (s *Server) turnstileConfig(r *http.Request) (bool, string) {
 		enabled = cfg.Value == "true"
 	}
 	if cfg, err := s.store.GetConfigByName(r.Context(), "captcha_type"); err == nil {
-		if strings.EqualFold(cfg.Value, "TURNSTILE") {
+		if strings.EqualFold(cfg.Value, captchaTypeTurnstile) {
 			enabled = true
 		}
 	}
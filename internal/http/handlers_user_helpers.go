@@ -28,7 +28,6 @@ func (s *Server) deleteUserCascade(r *http.Request, userID int64) error {
 		_ = s.store.DeleteForward(r.Context(), fw.ID)
 	}
 
-	_ = s.store.DeleteStatisticsByUser(r.Context(), userID)
 	if err := s.store.DeleteUser(r.Context(), userID); err != nil {
 		return fmt.Errorf("删除用户失败: %w", err)
 	}
@@ -42,8 +41,10 @@ func (s *Server) enqueueGost(r *http.Request, nodeID int64, action string, data
 }
 
 func (s *Server) enqueueGostCtx(ctx context.Context, nodeID int64, action string, data json.RawMessage) error {
-	payload := outbox.GostMessage{NodeID: nodeID, Action: action, Data: data}
-	b, _ := json.Marshal(payload)
-	_, err := s.store.EnqueueOutbox(ctx, action, b)
-	return err
+	return s.enqueueForwardCommandCtx(ctx, 0, nodeID, action, data)
+}
+
+// enqueueForwardCommandCtx enqueues a command whose acknowledgement drives the forward lifecycle.
+func (s *Server) enqueueForwardCommandCtx(ctx context.Context, forwardID, nodeID int64, action string, data json.RawMessage) error {
+	return outbox.Enqueue(ctx, s.store, forwardID, outbox.GostMessage{NodeID: nodeID, Action: action, Data: data})
 }
@@ -1,6 +1,11 @@
 package httpapi
 
-import "strings"
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
 
 func firstIP(raw string) string {
 	if strings.TrimSpace(raw) == "" {
@@ -23,3 +28,87 @@ func pickNodeEntryIP(raw string, fallback string) string {
 	}
 	return strings.TrimSpace(fallback)
 }
+
+// parseTrustedProxies parses a comma separated list of IPs and CIDRs.
+func parseTrustedProxies(raw string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if !strings.Contains(part, "/") {
+			ip := net.ParseIP(part)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", part)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", part)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// SetTrustedProxies sets the reverse proxies whose X-Real-IP and
+// X-Forwarded-For headers are honored, as a comma separated list of IPs and
+// CIDRs.
+func (s *Server) SetTrustedProxies(raw string) error {
+	nets, err := parseTrustedProxies(raw)
+	if err != nil {
+		return err
+	}
+	s.trustedProxies = nets
+	return nil
+}
+
+func (s *Server) trustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range s.trustedProxies {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the request's client address. The X-Real-IP and
+// X-Forwarded-For headers are only honored when the request comes from a
+// trusted proxy; X-Forwarded-For is walked from the right, skipping trusted
+// proxies, so a client cannot prepend a forged address.
+func (s *Server) clientIP(r *http.Request) string {
+	remote := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	if !s.trustedProxy(remote) {
+		return remote
+	}
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := strings.TrimSpace(hops[i])
+			if net.ParseIP(ip) == nil {
+				break
+			}
+			if !s.trustedProxy(ip) || i == 0 {
+				return ip
+			}
+		}
+	}
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(ip) != nil {
+		return ip
+	}
+	return remote
+}
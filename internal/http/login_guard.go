@@ -0,0 +1,196 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"pixia-panel/internal/store"
+)
+
+// Defaults for the brute-force thresholds in vite_config.
+const (
+	defaultLoginMaxFailures   = 5
+	defaultLoginIPMaxFailures = 20
+	defaultLoginFailureWindow = 15 * time.Minute
+	defaultLoginLockout       = time.Minute
+	defaultLoginMaxLockout    = time.Hour
+)
+
+// loginPolicy holds the brute-force thresholds. Once a subject reaches its
+// failure limit it is locked out for lockout, doubling with every further
+// failure up to maxLockout.
+type loginPolicy struct {
+	maxFailures   int64
+	ipMaxFailures int64
+	window        time.Duration
+	lockout       time.Duration
+	maxLockout    time.Duration
+}
+
+type loginLockoutClearRequest struct {
+	Kind    string `json:"kind"`
+	Subject string `json:"subject"`
+}
+
+func (s *Server) loginPolicy(r *http.Request) loginPolicy {
+	return loginPolicy{
+		maxFailures:   s.configInt(r, "login_max_failures", defaultLoginMaxFailures),
+		ipMaxFailures: s.configInt(r, "login_ip_max_failures", defaultLoginIPMaxFailures),
+		window:        time.Duration(s.configInt(r, "login_failure_window_seconds", int64(defaultLoginFailureWindow/time.Second))) * time.Second,
+		lockout:       time.Duration(s.configInt(r, "login_lockout_seconds", int64(defaultLoginLockout/time.Second))) * time.Second,
+		maxLockout:    time.Duration(s.configInt(r, "login_lockout_max_seconds", int64(defaultLoginMaxLockout/time.Second))) * time.Second,
+	}
+}
+
+// configInt reads a positive integer from vite_config, falling back to def.
+func (s *Server) configInt(r *http.Request, name string, def int64) int64 {
+	cfg, err := s.store.GetConfigByName(r.Context(), name)
+	if err != nil {
+		return def
+	}
+	v, err := strconv.ParseInt(cfg.Value, 10, 64)
+	if err != nil || v <= 0 {
+		return def
+	}
+	return v
+}
+
+// lockoutFor returns how long to lock out a subject after failures
+// consecutive failures, or 0 while below limit.
+func (p loginPolicy) lockoutFor(failures, limit int64) time.Duration {
+	excess := failures - limit
+	if excess < 0 {
+		return 0
+	}
+	if excess >= 30 {
+		return p.maxLockout
+	}
+	d := p.lockout << excess
+	if d > p.maxLockout || d <= 0 {
+		d = p.maxLockout
+	}
+	return d
+}
+
+// loginLockedFor returns how long the client IP or username stays locked out.
+func (s *Server) loginLockedFor(r *http.Request, username string) time.Duration {
+	now := time.Now().UnixMilli()
+	var until int64
+	for _, subject := range s.loginSubjects(r, username) {
+		if attempt, err := s.store.GetLoginAttempt(r.Context(), subject.kind, subject.subject); err == nil && attempt.LockedUntil > until {
+			until = attempt.LockedUntil
+		}
+	}
+	if until <= now {
+		return 0
+	}
+	return time.Duration(until-now) * time.Millisecond
+}
+
+// recordLoginFailure counts a failed password or second-factor check against
+// the client IP and username, locking out whichever reached its limit.
+func (s *Server) recordLoginFailure(r *http.Request, username string) {
+	policy := s.loginPolicy(r)
+	now := time.Now()
+	windowStart := now.Add(-policy.window).UnixMilli()
+	for _, subject := range s.loginSubjects(r, username) {
+		failures, err := s.store.RecordLoginFailure(r.Context(), subject.kind, subject.subject, now.UnixMilli(), windowStart)
+		if err != nil {
+			continue
+		}
+		limit := policy.maxFailures
+		if subject.kind == store.LoginAttemptIP {
+			limit = policy.ipMaxFailures
+		}
+		lockout := policy.lockoutFor(failures, limit)
+		if lockout == 0 {
+			continue
+		}
+		until := now.Add(lockout).UnixMilli()
+		if err := s.store.LockLogin(r.Context(), subject.kind, subject.subject, until); err != nil {
+			continue
+		}
+		var userID int64
+		if subject.kind == store.LoginAttemptUser {
+			if user, err := s.store.GetUserByName(r.Context(), subject.subject); err == nil {
+				userID = user.ID
+			}
+		}
+		s.audit(r, auditActionLockout, auditEntityLogin, userID, nil, &store.LoginAttempt{
+			Kind:            subject.kind,
+			Subject:         subject.subject,
+			Failures:        failures,
+			LastFailureTime: now.UnixMilli(),
+			LockedUntil:     until,
+		})
+	}
+}
+
+// clearLoginFailures resets the username's failure count after a completed
+// login. The IP count is left to expire so one valid account cannot be used
+// to keep guessing others.
+func (s *Server) clearLoginFailures(r *http.Request, username string) {
+	_ = s.store.ClearLoginAttempt(r.Context(), store.LoginAttemptUser, username)
+}
+
+func writeLoginLocked(w http.ResponseWriter, wait time.Duration) {
+	secs := int64((wait + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.FormatInt(secs, 10))
+	writeJSON(w, http.StatusTooManyRequests, Err(fmt.Sprintf("登录失败次数过多，请%d秒后再试", secs)))
+}
+
+type loginSubject struct {
+	kind    string
+	subject string
+}
+
+func (s *Server) loginSubjects(r *http.Request, username string) []loginSubject {
+	subjects := []loginSubject{{store.LoginAttemptIP, s.clientIP(r)}}
+	if username != "" {
+		subjects = append(subjects, loginSubject{store.LoginAttemptUser, username})
+	}
+	return subjects
+}
+
+func (s *Server) handleLoginLockoutList(w http.ResponseWriter, r *http.Request) {
+	list, err := s.store.ListLoginLockouts(r.Context(), time.Now().UnixMilli())
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("获取失败"))
+		return
+	}
+	if list == nil {
+		list = []store.LoginAttempt{}
+	}
+	writeJSON(w, http.StatusOK, OK(list))
+}
+
+func (s *Server) handleLoginLockoutClear(w http.ResponseWriter, r *http.Request) {
+	var req loginLockoutClearRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("参数错误"))
+		return
+	}
+	if (req.Kind != store.LoginAttemptIP && req.Kind != store.LoginAttemptUser) || req.Subject == "" {
+		writeJSON(w, http.StatusBadRequest, Err("参数错误"))
+		return
+	}
+	before, err := s.store.GetLoginAttempt(r.Context(), req.Kind, req.Subject)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, Err("锁定记录不存在"))
+		return
+	}
+	if err := s.store.ClearLoginAttempt(r.Context(), req.Kind, req.Subject); err != nil {
+		writeJSON(w, http.StatusInternalServerError, Err("解除失败"))
+		return
+	}
+	var userID int64
+	if req.Kind == store.LoginAttemptUser {
+		if user, err := s.store.GetUserByName(r.Context(), req.Subject); err == nil {
+			userID = user.ID
+		}
+	}
+	s.audit(r, auditActionUnlock, auditEntityLogin, userID, before, nil)
+	writeJSON(w, http.StatusOK, OK("已解除锁定"))
+}
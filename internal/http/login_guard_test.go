@@ -0,0 +1,122 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"pixia-panel/internal/store"
+)
+
+func TestLockoutFor(t *testing.T) {
+	p := loginPolicy{lockout: time.Minute, maxLockout: time.Hour}
+	cases := []struct {
+		failures int64
+		want     time.Duration
+	}{
+		{4, 0},
+		{5, time.Minute},
+		{6, 2 * time.Minute},
+		{8, 8 * time.Minute},
+		{11, time.Hour},
+		{40, time.Hour},
+		{1000, time.Hour},
+	}
+	for _, tc := range cases {
+		if got := p.lockoutFor(tc.failures, 5); got != tc.want {
+			t.Errorf("lockoutFor(%d, 5) = %v, want %v", tc.failures, got, tc.want)
+		}
+	}
+}
+
+func TestRecordLoginFailureWindow(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	window := int64(15 * time.Minute / time.Millisecond)
+	record := func(now int64) int64 {
+		t.Helper()
+		n, err := s.store.RecordLoginFailure(ctx, store.LoginAttemptUser, "alice", now, now-window)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	start := time.Now().UnixMilli()
+	if n := record(start); n != 1 {
+		t.Fatalf("first failure counted as %d", n)
+	}
+	if n := record(start + window/2); n != 2 {
+		t.Fatalf("failure within the window counted as %d, want 2", n)
+	}
+	if n := record(start + window/2 + window + 1); n != 1 {
+		t.Fatalf("failure after the window counted as %d, want a restart at 1", n)
+	}
+
+	// A lockout reaching into the window keeps the count going even when
+	// the last failure is older than the window.
+	later := start + 10*window
+	record(later)
+	if err := s.store.LockLogin(ctx, store.LoginAttemptUser, "alice", later+2*window); err != nil {
+		t.Fatal(err)
+	}
+	if n := record(later + 2*window); n != 2 {
+		t.Fatalf("failure right after a lockout counted as %d, want 2", n)
+	}
+}
+
+func requestFrom(ip string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/user/login", nil)
+	r.RemoteAddr = ip + ":40000"
+	return r
+}
+
+func TestLoginLockout(t *testing.T) {
+	s := newTestServer(t)
+	r := requestFrom("198.51.100.7")
+
+	for i := 0; i < defaultLoginMaxFailures-1; i++ {
+		s.recordLoginFailure(r, "admin_user")
+	}
+	if d := s.loginLockedFor(r, "admin_user"); d != 0 {
+		t.Fatalf("locked out for %v below the failure limit", d)
+	}
+	s.recordLoginFailure(r, "admin_user")
+	d := s.loginLockedFor(r, "admin_user")
+	if d <= 0 || d > defaultLoginLockout {
+		t.Fatalf("locked out for %v at the failure limit, want up to %v", d, defaultLoginLockout)
+	}
+	// The username is locked from any address; other usernames are not.
+	if s.loginLockedFor(requestFrom("203.0.113.9"), "admin_user") == 0 {
+		t.Fatal("username lockout bypassed from another address")
+	}
+	if s.loginLockedFor(r, "someone") != 0 {
+		t.Fatal("other username locked out by the address below its limit")
+	}
+
+	s.clearLoginFailures(r, "admin_user")
+	if d := s.loginLockedFor(r, "admin_user"); d != 0 {
+		t.Fatalf("still locked out for %v after clearing", d)
+	}
+	ip, err := s.store.GetLoginAttempt(context.Background(), store.LoginAttemptIP, "198.51.100.7")
+	if err != nil || ip.Failures != defaultLoginMaxFailures {
+		t.Fatalf("address failures after a successful login = %+v, %v; want them kept", ip, err)
+	}
+}
+
+func TestLoginLockoutByAddress(t *testing.T) {
+	s := newTestServer(t)
+	r := requestFrom("198.51.100.8")
+	// Guessing many usernames from one address locks the address.
+	for i := 0; i < defaultLoginIPMaxFailures; i++ {
+		s.recordLoginFailure(r, "user"+string(rune('a'+i)))
+	}
+	if s.loginLockedFor(r, "fresh") == 0 {
+		t.Fatal("address not locked out after reaching its limit")
+	}
+	if s.loginLockedFor(requestFrom("198.51.100.9"), "fresh") != 0 {
+		t.Fatal("lockout applied to another address")
+	}
+}
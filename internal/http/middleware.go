@@ -14,41 +14,74 @@ type ctxKey int
 const (
 	ctxUserID ctxKey = iota
 	ctxRoleID
+	// ctxSessionID is only set for requests authenticated by a login session.
+	ctxSessionID
 )
 
-func withAuth(secret []byte, next http.Handler) http.Handler {
+// API token scopes. A route declares the least scope a token needs to call
+// it; login sessions may call every route their role allows.
+const (
+	// scopeSession routes reject API tokens.
+	scopeSession       = ""
+	scopeRead          = "read"
+	scopeForwardsWrite = "forwards:write"
+	scopeAdmin         = "admin"
+)
+
+// updatePasswordPath is the only route open to users who must change their
+// password.
+const updatePasswordPath = "/api/v1/user/updatePassword"
+
+func (s *Server) withAuth(scope string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
+		tokenStr := bearerToken(r)
+		if tokenStr == "" {
 			writeJSON(w, http.StatusUnauthorized, Err("未登录"))
 			return
 		}
 
-		tokenStr := authHeader
-		if strings.HasPrefix(authHeader, "Bearer ") {
-			tokenStr = strings.TrimSpace(strings.TrimPrefix(authHeader, "Bearer "))
+		var userID, roleID, sessionID int64
+		var mustChangePassword bool
+		if auth.IsAPIToken(tokenStr) {
+			token, user, err := s.authenticateAPIToken(r, tokenStr)
+			if err != nil {
+				writeJSON(w, http.StatusUnauthorized, Err("令牌无效"))
+				return
+			}
+			if !tokenAllows(token.Scopes, scope) {
+				writeJSON(w, http.StatusForbidden, Err("令牌权限不足"))
+				return
+			}
+			userID, roleID, mustChangePassword = user.ID, user.RoleID, user.MustChangePassword
+		} else {
+			claims, err := s.authenticateSession(r, tokenStr)
+			if err != nil {
+				writeJSON(w, http.StatusUnauthorized, Err("登录无效"))
+				return
+			}
+			userID, roleID, sessionID = claims.UserID, claims.RoleID, claims.SessionID
+			mustChangePassword = claims.MustChangePassword
 		}
-		claims, err := auth.Parse(secret, tokenStr)
-		if err != nil {
-			writeJSON(w, http.StatusUnauthorized, Err("登录无效"))
+		if mustChangePassword && r.URL.Path != updatePasswordPath {
+			writeJSON(w, http.StatusForbidden, Err("请先修改密码"))
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), ctxUserID, claims.UserID)
-		ctx = context.WithValue(ctx, ctxRoleID, claims.RoleID)
+		ctx := context.WithValue(r.Context(), ctxUserID, userID)
+		ctx = context.WithValue(ctx, ctxRoleID, roleID)
+		if sessionID != 0 {
+			ctx = context.WithValue(ctx, ctxSessionID, sessionID)
+		}
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-func requireAdmin(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		roleID, ok := r.Context().Value(ctxRoleID).(int64)
-		if !ok || roleID != 0 {
-			writeJSON(w, http.StatusForbidden, Err("权限不足"))
-			return
-		}
-		next.ServeHTTP(w, r)
-	})
+func bearerToken(r *http.Request) string {
+	authHeader := r.Header.Get("Authorization")
+	if strings.HasPrefix(authHeader, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(authHeader, "Bearer "))
+	}
+	return authHeader
 }
 
 func userIDFromCtx(r *http.Request) int64 {
@@ -58,8 +91,15 @@ func userIDFromCtx(r *http.Request) int64 {
 	return 0
 }
 
-func roleIDFromCtx(r *http.Request) int64 {
-	if v, ok := r.Context().Value(ctxRoleID).(int64); ok {
+// roleIDFromCtx returns the authenticated user's role. ok is false outside
+// withAuth; role 0 is the admin role, so callers must not fall back to it.
+func roleIDFromCtx(r *http.Request) (id int64, ok bool) {
+	id, ok = r.Context().Value(ctxRoleID).(int64)
+	return id, ok
+}
+
+func sessionIDFromCtx(r *http.Request) int64 {
+	if v, ok := r.Context().Value(ctxSessionID).(int64); ok {
 		return v
 	}
 	return 0
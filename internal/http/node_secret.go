@@ -0,0 +1,66 @@
+package httpapi
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// A rotated node secret replaces the old one for good after the grace window,
+// which defaults to defaultNodeSecretGrace and is capped at maxNodeSecretGrace.
+const (
+	defaultNodeSecretGrace = 10 * time.Minute
+	maxNodeSecretGrace     = 24 * time.Hour
+	nodeSecretPushTimeout  = 10 * time.Second
+	// nodeSecretRetryGrace is how long the grace window is kept open after a
+	// failed push to a node still connected with its old secret.
+	nodeSecretRetryGrace = 5 * time.Minute
+)
+
+// pushRotatedSecret resends a rotated secret to a node that reconnected with
+// its old secret during the grace window, e.g. because it missed the push.
+func (s *Server) pushRotatedSecret(ctx context.Context, nodeID int64) {
+	node, err := s.store.GetNodeByID(ctx, nodeID)
+	if err != nil || node.OldSecret == "" || node.OldSecretExpires <= time.Now().UnixMilli() {
+		return
+	}
+	if !strings.EqualFold(s.hub.Secret(nodeID), node.OldSecret) {
+		return
+	}
+	_ = s.hub.RotateSecret(ctx, nodeID, node.Secret, nodeSecretPushTimeout)
+}
+
+// RetireNodeSecrets stops accepting old node secrets whose grace window has
+// ended. Rotated secrets are first pushed again to nodes still connected with
+// their old secret; while such a node has not confirmed, its grace window is
+// extended instead of closing its connection and locking it out.
+func (s *Server) RetireNodeSecrets(ctx context.Context) {
+	now := time.Now()
+	nodes, err := s.store.ListNodes(ctx)
+	if err != nil {
+		return
+	}
+	for _, node := range nodes {
+		if node.OldSecret == "" || !strings.EqualFold(s.hub.Secret(node.ID), node.OldSecret) {
+			continue
+		}
+		if s.hub.RotateSecret(ctx, node.ID, node.Secret, nodeSecretPushTimeout) == nil {
+			continue
+		}
+		if until := now.Add(nodeSecretRetryGrace).UnixMilli(); node.OldSecretExpires < until {
+			_ = s.store.ExtendNodeSecretGrace(ctx, node.ID, until)
+		}
+	}
+
+	ids, err := s.store.RetireNodeSecrets(ctx, now.UnixMilli())
+	if err != nil {
+		return
+	}
+	for _, id := range ids {
+		node, err := s.store.GetNodeByID(ctx, id)
+		if err != nil {
+			continue
+		}
+		s.hub.RetireSecret(id, node.Secret)
+	}
+}
@@ -1,16 +1,81 @@
 package httpapi
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"errors"
+	"fmt"
+	"net/http"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
 const bcryptCost = 12
 
+// Defaults for the password policy in vite_config.
+const (
+	defaultPasswordMinLength  = 8
+	defaultPasswordMinClasses = 2
+)
+
+// passwordPolicyError returns a message if password, chosen for username,
+// violates the configured policy: a minimum length, a minimum number of
+// character classes (lower case, upper case, digits, symbols) and a list of
+// banned passwords.
+func (s *Server) passwordPolicyError(r *http.Request, username, password string) string {
+	if n := s.configInt(r, "password_min_length", defaultPasswordMinLength); int64(utf8.RuneCountInString(password)) < n {
+		return fmt.Sprintf("密码长度不能少于%d位", n)
+	}
+	if n := s.configInt(r, "password_min_classes", defaultPasswordMinClasses); int64(passwordClasses(password)) < n {
+		return fmt.Sprintf("密码需包含小写字母、大写字母、数字、符号中的至少%d种", n)
+	}
+	if strings.EqualFold(password, username) {
+		return "密码不能与用户名相同"
+	}
+	if s.isBannedPassword(r.Context(), password) {
+		return "密码过于简单，请更换"
+	}
+	return ""
+}
+
+// isBannedPassword reports whether password is on the comma-separated
+// password_banned list, ignoring case.
+func (s *Server) isBannedPassword(ctx context.Context, password string) bool {
+	for _, banned := range strings.Split(s.configValue(ctx, "password_banned"), ",") {
+		if banned = strings.TrimSpace(banned); banned != "" && strings.EqualFold(banned, password) {
+			return true
+		}
+	}
+	return false
+}
+
+func passwordClasses(password string) int {
+	var lower, upper, digit, other bool
+	for _, c := range password {
+		switch {
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsDigit(c):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	n := 0
+	for _, ok := range []bool{lower, upper, digit, other} {
+		if ok {
+			n++
+		}
+	}
+	return n
+}
+
 func hashPassword(password string) (string, error) {
 	if strings.TrimSpace(password) == "" {
 		return "", errors.New("empty password")
@@ -0,0 +1,58 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	s := newTestServer(t)
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+
+	cases := []struct {
+		name     string
+		password string
+		ok       bool
+	}{
+		{"too short", "Ab1", false},
+		{"one class", "abcdefghij", false},
+		{"two classes", "abcdef1234", true},
+		{"same as username", "Alice12345", false},
+		{"banned ignoring case", "PASSWORD1", false},
+	}
+	for _, c := range cases {
+		if msg := s.passwordPolicyError(r, "alice12345", c.password); (msg == "") != c.ok {
+			t.Errorf("%s: policy error %q, want ok %v", c.name, msg, c.ok)
+		}
+	}
+}
+
+func TestMustChangePasswordOnlyAllowsUpdatePassword(t *testing.T) {
+	s := newTestServer(t)
+	if err := s.store.SetUserMustChangePassword(context.Background(), 1, true); err != nil {
+		t.Fatal(err)
+	}
+	access, _ := newTestSession(t, s, 1)
+
+	if status, reached := callWithAuth(s, scopeSession, access); status != http.StatusForbidden || reached {
+		t.Fatalf("other route: status %d, handler ran %v; want 403", status, reached)
+	}
+
+	reached := false
+	h := s.withAuth(scopeSession, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+	req := httptest.NewRequest(http.MethodPost, updatePasswordPath, nil)
+	req.Header.Set("Authorization", "Bearer "+access)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if !reached {
+		t.Fatal("update password route refused")
+	}
+
+	s.hub.SetAdminAuth(s.AuthorizeAdminSocket)
+	if status := dialAdminSocket(t, s, access); status != http.StatusForbidden {
+		t.Fatalf("admin socket: status %d, want 403", status)
+	}
+}
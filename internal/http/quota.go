@@ -0,0 +1,92 @@
+package httpapi
+
+import (
+	"context"
+	"time"
+
+	"pixia-panel/internal/gost"
+	"pixia-panel/internal/store"
+)
+
+const bytesPerGB = 1024 * 1024 * 1024
+
+// userPauseReason returns why a user's forwards must be paused, or "" if none.
+func userPauseReason(user *store.User, now int64) string {
+	if user.ExpTime != 0 && user.ExpTime <= now {
+		return store.PauseReasonExpired
+	}
+	if user.Flow*bytesPerGB < user.InFlow+user.OutFlow {
+		return store.PauseReasonUserQuota
+	}
+	if user.Status != 1 {
+		return store.PauseReasonAdmin
+	}
+	return ""
+}
+
+// userTunnelPauseReason returns why forwards on a user_tunnel must be paused, or "" if none.
+func userTunnelPauseReason(ut *store.UserTunnel, now int64) string {
+	if ut.ExpTime != 0 && ut.ExpTime <= now {
+		return store.PauseReasonExpired
+	}
+	if ut.Flow*bytesPerGB <= ut.InFlow+ut.OutFlow {
+		return store.PauseReasonTunnelQuota
+	}
+	if ut.Status != 1 {
+		return store.PauseReasonAdmin
+	}
+	return ""
+}
+
+// ResumeQuotaPausedForwards resumes forwards paused for quota or expiry once
+// the user, user_tunnel and any resellers above them are within limits
+// again. userID 0 checks all users; otherwise sub-users are checked too.
+func (s *Server) ResumeQuotaPausedForwards(ctx context.Context, userID int64) {
+	if userID == 0 {
+		s.resumeQuotaPausedForwards(ctx, 0)
+		return
+	}
+	s.resumeQuotaPausedForwards(ctx, userID)
+	subs, _ := s.store.ListSubUserIDs(ctx, userID)
+	for _, sub := range subs {
+		s.resumeQuotaPausedForwards(ctx, sub)
+	}
+}
+
+func (s *Server) resumeQuotaPausedForwards(ctx context.Context, userID int64) {
+	forwards, err := s.store.ListAutoPausedForwards(ctx, userID)
+	if err != nil || len(forwards) == 0 {
+		return
+	}
+
+	now := time.Now().UnixMilli()
+	users := make(map[int64]*store.User)
+	for _, fw := range forwards {
+		user, ok := users[fw.UserID]
+		if !ok {
+			user, _ = s.store.GetUserByID(ctx, fw.UserID)
+			users[fw.UserID] = user
+		}
+		if user == nil || userPauseReason(user, now) != "" {
+			continue
+		}
+
+		userTunnelID := int64(0)
+		if ut, err := s.store.GetUserTunnelByUserAndTunnel(ctx, fw.UserID, fw.TunnelID); err == nil {
+			if userTunnelPauseReason(ut, now) != "" {
+				continue
+			}
+			userTunnelID = ut.ID
+		}
+		if s.parentPauseReason(ctx, user, fw.TunnelID, now) != "" {
+			continue
+		}
+
+		name := buildServiceName(fw.ID, fw.UserID, userTunnelID)
+		_ = s.enqueueGostCtx(ctx, fw.InNodeID, "ResumeService", gost.ResumeServiceData(name))
+		if fw.TunnelType == 2 {
+			_ = s.enqueueGostCtx(ctx, fw.OutNodeID, "ResumeService", gost.ResumeRemoteServiceData(name))
+		}
+		_ = s.store.ResumeForward(ctx, fw.ID, now)
+	}
+}
@@ -0,0 +1,146 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+
+	"pixia-panel/internal/store"
+)
+
+// Permissions granted through roles. Routes registered with permNone are open
+// to every signed-in user, who may only act on their own data.
+const (
+	permNone            = ""
+	permAll             = "*"
+	permUserRead        = "user.read"
+	permUserWrite       = "user.write"
+	permUserQuota       = "user.quota"
+	permNodeRead        = "node.read"
+	permNodeWrite       = "node.write"
+	permTunnelRead      = "tunnel.read"
+	permTunnelWrite     = "tunnel.write"
+	permSpeedLimitRead  = "speed_limit.read"
+	permSpeedLimitWrite = "speed_limit.write"
+	permForwardRead     = "forward.read"
+	permForwardWrite    = "forward.write"
+	permTrafficRead     = "traffic.read"
+	permConfigWrite     = "config.write"
+	permBackup          = "backup"
+	permAuditRead       = "audit.read"
+	permCacheRead       = "cache.read"
+	permRoleWrite       = "role.write"
+	permSubUserWrite    = "subuser.write"
+)
+
+// allPermissions lists every permission a role may be granted, with a short
+// description for the role editor.
+var allPermissions = []struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}{
+	{permAll, "全部权限"},
+	{permUserRead, "查看用户"},
+	{permUserWrite, "管理用户"},
+	{permUserQuota, "调整用户流量配额与到期时间"},
+	{permNodeRead, "查看节点"},
+	{permNodeWrite, "管理节点"},
+	{permTunnelRead, "查看隧道"},
+	{permTunnelWrite, "管理隧道"},
+	{permSpeedLimitRead, "查看限速规则"},
+	{permSpeedLimitWrite, "管理限速规则"},
+	{permForwardRead, "查看所有用户的转发"},
+	{permForwardWrite, "管理所有用户的转发"},
+	{permTrafficRead, "查看所有流量统计"},
+	{permConfigWrite, "修改系统配置"},
+	{permBackup, "备份与恢复"},
+	{permAuditRead, "查看审计日志"},
+	{permCacheRead, "查看缓存统计"},
+	{permRoleWrite, "管理角色"},
+	{permSubUserWrite, "在自身配额内管理下级用户"},
+}
+
+func isKnownPermission(perm string) bool {
+	for _, p := range allPermissions {
+		if p.Name == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// roleHas reports whether roleID grants perm. Unknown roles grant nothing.
+func (s *Server) roleHas(ctx context.Context, roleID int64, perm string) bool {
+	if perm == permNone {
+		return true
+	}
+	role, err := s.store.GetRoleByID(ctx, roleID)
+	if err != nil {
+		return false
+	}
+	for _, p := range role.Permissions {
+		if p == permAll || p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// can reports whether the authenticated user's role grants perm. Requests
+// that did not pass withAuth have no role and are denied.
+func (s *Server) can(r *http.Request, perm string) bool {
+	roleID, ok := roleIDFromCtx(r)
+	return ok && s.roleHas(r.Context(), roleID, perm)
+}
+
+// canGrant reports whether the caller holds every permission in perms, so
+// roles can never be used to escalate beyond the caller's own rights.
+func (s *Server) canGrant(r *http.Request, perms []string) bool {
+	for _, perm := range perms {
+		if !s.can(r, perm) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasAnyPermission reports whether the caller's role grants anything beyond
+// managing their own data.
+func (s *Server) hasAnyPermission(r *http.Request) bool {
+	roleID, ok := roleIDFromCtx(r)
+	return ok && s.isPrivilegedRole(r.Context(), roleID)
+}
+
+// isPrivilegedRole reports whether roleID grants any permission, i.e. access
+// to other users' data. Accounts with such roles count as administrators for
+// admin_require_2fa.
+func (s *Server) isPrivilegedRole(ctx context.Context, roleID int64) bool {
+	role, err := s.store.GetRoleByID(ctx, roleID)
+	return err == nil && len(role.Permissions) > 0
+}
+
+// canManageUser reports whether the caller holds every permission of user's
+// role, so nobody can take over an account more privileged than their own.
+func (s *Server) canManageUser(r *http.Request, user *store.User) bool {
+	role, err := s.store.GetRoleByID(r.Context(), user.RoleID)
+	if err != nil {
+		return true
+	}
+	return s.canGrant(r, role.Permissions)
+}
+
+func (s *Server) requirePermission(perm string, next http.Handler) http.Handler {
+	return s.requireAnyPermission([]string{perm}, next)
+}
+
+// requireAnyPermission admits callers holding at least one of perms.
+func (s *Server) requireAnyPermission(perms []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, perm := range perms {
+			if s.can(r, perm) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		writeJSON(w, http.StatusForbidden, Err("权限不足"))
+	})
+}
@@ -0,0 +1,161 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"pixia-panel/internal/store"
+)
+
+// Built-in roles seeded by 009_rbac.sql.
+const (
+	testRoleAdmin    int64 = 0
+	testRoleUser     int64 = 1
+	testRoleOperator int64 = 2
+	testRoleSupport  int64 = 3
+	testRoleBilling  int64 = 4
+)
+
+// requestAs returns a request authenticated as userID with roleID, as
+// withAuth would leave it.
+func requestAs(userID, roleID int64) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	ctx := context.WithValue(r.Context(), ctxUserID, userID)
+	ctx = context.WithValue(ctx, ctxRoleID, roleID)
+	return r.WithContext(ctx)
+}
+
+// as runs h authenticated as userID with roleID.
+func as(userID, roleID int64, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), ctxUserID, userID)
+		ctx = context.WithValue(ctx, ctxRoleID, roleID)
+		h(w, r.WithContext(ctx))
+	}
+}
+
+// addTestUser inserts an enabled user with roleID and returns its ID.
+func addTestUser(t *testing.T, s *Server, name string, roleID int64) int64 {
+	t.Helper()
+	id, err := s.store.InsertUser(context.Background(), &store.User{User: name, Pwd: "x", RoleID: roleID, Status: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestCanWithoutRole(t *testing.T) {
+	s := newTestServer(t)
+	anonymous := httptest.NewRequest(http.MethodPost, "/", nil)
+	if s.can(anonymous, permUserRead) || s.can(anonymous, permNone) {
+		t.Fatal("request without a role passed a permission check")
+	}
+	if s.hasAnyPermission(anonymous) {
+		t.Fatal("request without a role counted as privileged")
+	}
+	if !s.can(requestAs(1, testRoleAdmin), permUserRead) {
+		t.Fatal("admin denied user.read")
+	}
+}
+
+func TestCanGrant(t *testing.T) {
+	s := newTestServer(t)
+	cases := []struct {
+		name  string
+		role  int64
+		perms []string
+		want  bool
+	}{
+		{"admin grants everything", testRoleAdmin, []string{permAll, permRoleWrite}, true},
+		{"operator grants its own permissions", testRoleOperator, []string{permNodeRead, permTunnelWrite}, true},
+		{"operator grants nothing", testRoleOperator, nil, true},
+		{"operator cannot grant user management", testRoleOperator, []string{permNodeRead, permUserWrite}, false},
+		{"operator cannot grant all permissions", testRoleOperator, []string{permAll}, false},
+		{"support cannot grant write access", testRoleSupport, []string{permNodeWrite}, false},
+		{"billing cannot grant node access", testRoleBilling, []string{permNodeRead}, false},
+		{"user cannot grant anything", testRoleUser, []string{permForwardRead}, false},
+	}
+	for _, tc := range cases {
+		if got := s.canGrant(requestAs(100, tc.role), tc.perms); got != tc.want {
+			t.Errorf("%s: canGrant = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestCanManageUser(t *testing.T) {
+	s := newTestServer(t)
+	cases := []struct {
+		name   string
+		caller int64
+		target int64
+		want   bool
+	}{
+		{"admin manages admin", testRoleAdmin, testRoleAdmin, true},
+		{"admin manages operator", testRoleAdmin, testRoleOperator, true},
+		{"operator manages regular user", testRoleOperator, testRoleUser, true},
+		{"operator manages operator", testRoleOperator, testRoleOperator, true},
+		{"operator cannot manage admin", testRoleOperator, testRoleAdmin, false},
+		{"operator cannot manage support", testRoleOperator, testRoleSupport, false},
+		{"billing cannot manage operator", testRoleBilling, testRoleOperator, false},
+		{"support cannot manage billing", testRoleSupport, testRoleBilling, false},
+		{"regular user cannot manage support", testRoleUser, testRoleSupport, false},
+	}
+	for _, tc := range cases {
+		target := &store.User{ID: 50, RoleID: tc.target}
+		if got := s.canManageUser(requestAs(100, tc.caller), target); got != tc.want {
+			t.Errorf("%s: canManageUser = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestPrivilegeCeilingOnRoleChanges(t *testing.T) {
+	s := newTestServer(t)
+	operator := addTestUser(t, s, "operator", testRoleOperator)
+	regular := addTestUser(t, s, "regular", testRoleUser)
+	support := addTestUser(t, s, "support", testRoleSupport)
+	setRole := as(operator, testRoleOperator, s.handleUserRole)
+
+	if status, _ := postJSON(t, setRole, userRoleRequest{ID: regular, RoleID: testRoleAdmin}, nil); status != http.StatusForbidden {
+		t.Fatalf("operator promoting to admin: status %d, want 403", status)
+	}
+	if status, _ := postJSON(t, setRole, userRoleRequest{ID: support, RoleID: testRoleUser}, nil); status != http.StatusForbidden {
+		t.Fatalf("operator demoting a more privileged user: status %d, want 403", status)
+	}
+	if status, resp := postJSON(t, setRole, userRoleRequest{ID: regular, RoleID: testRoleOperator}, nil); status != http.StatusOK {
+		t.Fatalf("operator granting its own role: status %d %q", status, resp.Msg)
+	}
+
+	createRole := as(operator, testRoleOperator, s.handleRoleCreate)
+	if status, _ := postJSON(t, createRole, roleRequest{Name: "escalated", Permissions: []string{permNodeRead, permUserWrite}}, nil); status != http.StatusBadRequest {
+		t.Fatalf("operator creating a role beyond its own: status %d, want 400", status)
+	}
+	if status, resp := postJSON(t, createRole, roleRequest{Name: "viewer", Permissions: []string{permNodeRead}}, nil); status != http.StatusOK {
+		t.Fatalf("operator creating a narrower role: status %d %q", status, resp.Msg)
+	}
+	updateRole := as(operator, testRoleOperator, s.handleRoleUpdate)
+	if status, _ := postJSON(t, updateRole, roleRequest{ID: testRoleSupport, Name: "support", Permissions: []string{permNodeRead}}, nil); status != http.StatusForbidden {
+		t.Fatalf("operator editing a more privileged role: status %d, want 403", status)
+	}
+}
+
+func TestAdminSocketRequiresNodeRead(t *testing.T) {
+	s := newTestServer(t)
+	s.hub.SetAdminAuth(s.AuthorizeAdminSocket)
+	for _, tc := range []struct {
+		role   int64
+		status int
+	}{
+		{testRoleAdmin, http.StatusSwitchingProtocols},
+		{testRoleSupport, http.StatusSwitchingProtocols},
+		{testRoleBilling, http.StatusForbidden},
+		{testRoleUser, http.StatusForbidden},
+	} {
+		id := addTestUser(t, s, "socket"+string(rune('a'+tc.role)), tc.role)
+		access, _ := newTestSession(t, s, id)
+		if status := dialAdminSocket(t, s, access); status != tc.status {
+			t.Errorf("admin socket for role %d: status %d, want %d", tc.role, status, tc.status)
+		}
+	}
+}
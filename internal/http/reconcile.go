@@ -0,0 +1,350 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"pixia-panel/internal/gost"
+	"pixia-panel/internal/store"
+)
+
+// Ways a reported object can differ from the desired one.
+const (
+	driftMissing   = "missing"
+	driftConfig    = "config"
+	driftPaused    = "paused"
+	driftNotPaused = "not_paused"
+)
+
+// desiredObject is an object a node should have. forwardID is the forward
+// whose lifecycle its commands drive (0 for limiters); update is the
+// UpdateChains or UpdateLimiters payload.
+type desiredObject struct {
+	gost.Object
+	forwardID int64
+	update    json.RawMessage
+}
+
+type driftItem struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	ForwardID int64  `json:"forwardId,omitempty"`
+	// Reason says how a mismatched object differs: its config, or it is
+	// paused on the node but not in the panel, or the other way round.
+	Reason string `json:"reason,omitempty"`
+}
+
+// nodeDrift compares a node's last reported config with its desired state.
+// Objects with commands queued or delivered since the report are listed as
+// pending and left out of the diff, since the report cannot show them yet.
+type nodeDrift struct {
+	NodeID       int64       `json:"nodeId"`
+	ReportedTime int64       `json:"reportedTime"`
+	Missing      []driftItem `json:"missing"`
+	Extra        []driftItem `json:"extra"`
+	Mismatched   []driftItem `json:"mismatched"`
+	Pending      []driftItem `json:"pending"`
+
+	desired []desiredObject
+	// state maps the key of each missing or mismatched desired object to
+	// driftMissing or its mismatch reason.
+	state map[string]string
+	extra []gost.Object
+}
+
+// desiredNodeState builds every object nodeID should have from the store, in
+// the order they must be created: limiters, then for each forward its chain
+// before the services that use it.
+func (s *Server) desiredNodeState(ctx context.Context, nodeID int64) ([]desiredObject, error) {
+	tunnels, err := s.store.ListTunnels(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var limiters []desiredObject
+	seenLimiters := make(map[int64]bool)
+	addLimiter := func(id *int64) error {
+		if id == nil || seenLimiters[*id] {
+			return nil
+		}
+		seenLimiters[*id] = true
+		limit, err := s.store.GetSpeedLimitByID(ctx, *id)
+		if err != nil || limit.Status != 1 {
+			return nil
+		}
+		obj, err := gost.LimiterObject(gost.AddLimitersData(limit.ID, limit.Speed))
+		if err != nil {
+			return err
+		}
+		limiters = append(limiters, desiredObject{Object: obj, update: gost.UpdateLimitersData(limit.ID, limit.Speed)})
+		return nil
+	}
+
+	tunnelMap := make(map[int64]store.Tunnel, len(tunnels))
+	for _, t := range tunnels {
+		tunnelMap[t.ID] = t
+		if t.InNodeID != nodeID {
+			continue
+		}
+		limits, err := s.store.ListActiveSpeedLimitsByTunnel(ctx, t.ID)
+		if err != nil {
+			return nil, err
+		}
+		for i := range limits {
+			if err := addLimiter(&limits[i].ID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	forwards, err := s.store.ListForwardsAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []desiredObject
+	for i := range forwards {
+		fw := forwards[i].Forward
+		tunnel, ok := tunnelMap[fw.TunnelID]
+		if !ok {
+			continue
+		}
+		onIn := tunnel.InNodeID == nodeID
+		onOut := tunnel.Type == 2 && tunnel.OutNodeID == nodeID && fw.OutPort != nil
+		if !onIn && !onOut {
+			continue
+		}
+		limiter := s.resolveSpeedLimiterCtx(ctx, fw.UserID, fw.TunnelID)
+		if err := addLimiter(limiter); err != nil {
+			return nil, err
+		}
+		name := buildServiceName(fw.ID, fw.UserID, s.resolveUserTunnelIDCtx(ctx, fw.UserID, fw.TunnelID))
+		paused := fw.Status != 1
+
+		var services json.RawMessage
+		if onIn {
+			if tunnel.Type == 2 && fw.OutPort != nil {
+				outAddr := s.tunnelOutIP(ctx, &tunnel) + ":" + strconv.FormatInt(*fw.OutPort, 10)
+				chain, err := gost.ChainObject(gost.AddChainsData(name, outAddr, tunnel.Protocol, fw.InterfaceName))
+				if err != nil {
+					return nil, err
+				}
+				objects = append(objects, desiredObject{Object: chain, forwardID: fw.ID,
+					update: gost.UpdateChainsData(name, outAddr, tunnel.Protocol, fw.InterfaceName)})
+			}
+			services = gost.AddServiceData(name, fw.InPort, limiter, fw.RemoteAddr, gost.TunnelConfig{Type: tunnel.Type, Protocol: tunnel.Protocol, TCPListenAddr: tunnel.TCPListenAddr, UDPListenAddr: tunnel.UDPListenAddr}, fw.Strategy, fw.InterfaceName)
+			if err := appendServices(&objects, services, fw.ID, paused); err != nil {
+				return nil, err
+			}
+		}
+		if onOut {
+			services = gost.AddRemoteServiceData(name, *fw.OutPort, fw.RemoteAddr, tunnel.Protocol, fw.Strategy, fw.InterfaceName, limiter)
+			if err := appendServices(&objects, services, fw.ID, paused); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return append(limiters, objects...), nil
+}
+
+func appendServices(objects *[]desiredObject, data json.RawMessage, forwardID int64, paused bool) error {
+	services, err := gost.ServiceObjects(data)
+	if err != nil {
+		return err
+	}
+	for _, svc := range services {
+		svc.Paused = paused
+		*objects = append(*objects, desiredObject{Object: svc, forwardID: forwardID})
+	}
+	return nil
+}
+
+// nodeDrift diffs the desired state of report.NodeID against report.
+func (s *Server) nodeDrift(ctx context.Context, report *store.NodeConfigReport) (*nodeDrift, error) {
+	desired, err := s.desiredNodeState(ctx, report.NodeID)
+	if err != nil {
+		return nil, err
+	}
+	return s.diffNodeConfig(ctx, report, desired)
+}
+
+func (s *Server) diffNodeConfig(ctx context.Context, report *store.NodeConfigReport, desired []desiredObject) (*nodeDrift, error) {
+	var reported []gost.Object
+	if err := json.Unmarshal(report.Objects, &reported); err != nil {
+		return nil, err
+	}
+	keys, err := s.store.ListInFlightOutboxKeys(ctx, report.NodeID, report.ReportedTime)
+	if err != nil {
+		return nil, err
+	}
+	inFlight := make(map[string]bool)
+	for _, key := range keys {
+		kind, names, _ := strings.Cut(key, ":")
+		for _, name := range strings.Split(names, ",") {
+			inFlight[kind+":"+name] = true
+		}
+	}
+
+	drift := &nodeDrift{
+		NodeID:       report.NodeID,
+		ReportedTime: report.ReportedTime,
+		Missing:      []driftItem{},
+		Extra:        []driftItem{},
+		Mismatched:   []driftItem{},
+		Pending:      []driftItem{},
+		desired:      desired,
+		state:        make(map[string]string),
+	}
+	current := make(map[string]gost.Object, len(reported))
+	for _, obj := range reported {
+		current[obj.Key()] = obj
+	}
+	wanted := make(map[string]bool, len(desired))
+	for _, d := range desired {
+		key := d.Key()
+		wanted[key] = true
+		item := driftItem{Kind: d.Kind, Name: d.Name, ForwardID: d.forwardID}
+		cur, ok := current[key]
+		switch {
+		case inFlight[key]:
+			drift.Pending = append(drift.Pending, item)
+		case !ok:
+			drift.state[key] = driftMissing
+			drift.Missing = append(drift.Missing, item)
+		case cur.Digest != d.Digest:
+			item.Reason = driftConfig
+		case cur.Paused && !d.Paused:
+			item.Reason = driftPaused
+		case !cur.Paused && d.Paused:
+			item.Reason = driftNotPaused
+		}
+		if item.Reason != "" {
+			drift.state[key] = item.Reason
+			drift.Mismatched = append(drift.Mismatched, item)
+		}
+	}
+	for _, obj := range reported {
+		key := obj.Key()
+		if wanted[key] || !managedObject(obj) {
+			continue
+		}
+		item := driftItem{Kind: obj.Kind, Name: obj.Name}
+		if inFlight[key] {
+			drift.Pending = append(drift.Pending, item)
+			continue
+		}
+		drift.Extra = append(drift.Extra, item)
+		drift.extra = append(drift.extra, obj)
+	}
+	return drift, nil
+}
+
+// managedObject reports whether obj is named like the objects the panel
+// creates, so a node's own services such as web_api are never removed.
+func managedObject(obj gost.Object) bool {
+	switch obj.Kind {
+	case gost.KindService:
+		_, _, typ, ok := parseManagedConfigName(obj.Name)
+		return ok && (typ == "tcp" || typ == "udp" || typ == "tls")
+	case gost.KindChain:
+		_, _, typ, ok := parseManagedConfigName(obj.Name)
+		return ok && typ == "chains"
+	case gost.KindLimiter:
+		_, err := strconv.ParseInt(obj.Name, 10, 64)
+		return err == nil
+	}
+	return false
+}
+
+// reconcileNode enqueues the commands that bring the node to its desired
+// state. Extra services and chains go first so their ports and names are
+// free, extra limiters last once no service uses them.
+func (s *Server) reconcileNode(ctx context.Context, drift *nodeDrift) {
+	nodeID := drift.NodeID
+
+	var extraChains []string
+	var extraLimiters []int64
+	extraServices := make(map[string][]string)
+	var bases []string
+	for _, obj := range drift.extra {
+		switch obj.Kind {
+		case gost.KindService:
+			_, base, _, _ := parseManagedConfigName(obj.Name)
+			if _, ok := extraServices[base]; !ok {
+				bases = append(bases, base)
+			}
+			extraServices[base] = append(extraServices[base], obj.Name)
+		case gost.KindChain:
+			_, base, _, _ := parseManagedConfigName(obj.Name)
+			extraChains = append(extraChains, base)
+		case gost.KindLimiter:
+			id, _ := strconv.ParseInt(obj.Name, 10, 64)
+			extraLimiters = append(extraLimiters, id)
+		}
+	}
+	for _, base := range bases {
+		_ = s.enqueueGostCtx(ctx, nodeID, "DeleteService", gost.ServiceNamesData(extraServices[base]))
+	}
+	for _, base := range extraChains {
+		_ = s.enqueueGostCtx(ctx, nodeID, "DeleteChains", gost.DeleteChainsData(base))
+	}
+
+	var add, update []json.RawMessage
+	var pause, resume []string
+	var forwardID int64
+	flush := func() {
+		if len(add) > 0 {
+			_ = s.enqueueForwardCommandCtx(ctx, forwardID, nodeID, "AddService", gost.ServiceListData(add))
+		}
+		if len(update) > 0 {
+			_ = s.enqueueForwardCommandCtx(ctx, forwardID, nodeID, "UpdateService", gost.ServiceListData(update))
+		}
+		if len(pause) > 0 {
+			_ = s.enqueueForwardCommandCtx(ctx, forwardID, nodeID, "PauseService", gost.ServiceNamesData(pause))
+		}
+		if len(resume) > 0 {
+			_ = s.enqueueForwardCommandCtx(ctx, forwardID, nodeID, "ResumeService", gost.ServiceNamesData(resume))
+		}
+		add, update, pause, resume = nil, nil, nil, nil
+	}
+
+	for _, d := range drift.desired {
+		state, ok := drift.state[d.Key()]
+		if !ok {
+			continue
+		}
+		if d.Kind != gost.KindService || d.forwardID != forwardID {
+			flush()
+			forwardID = d.forwardID
+		}
+		switch d.Kind {
+		case gost.KindLimiter, gost.KindChain:
+			action := map[string]string{gost.KindLimiter: "Limiters", gost.KindChain: "Chains"}[d.Kind]
+			if state == driftMissing {
+				_ = s.enqueueForwardCommandCtx(ctx, d.forwardID, nodeID, "Add"+action, d.Data)
+			} else {
+				_ = s.enqueueForwardCommandCtx(ctx, d.forwardID, nodeID, "Update"+action, d.update)
+			}
+		case gost.KindService:
+			// Adds and updates start the service running.
+			switch state {
+			case driftMissing:
+				add = append(add, d.Data)
+			case driftConfig:
+				update = append(update, d.Data)
+			case driftPaused:
+				resume = append(resume, d.Name)
+			}
+			if d.Paused && state != driftPaused {
+				pause = append(pause, d.Name)
+			}
+		}
+	}
+	flush()
+
+	for _, id := range extraLimiters {
+		_ = s.enqueueGostCtx(ctx, nodeID, "DeleteLimiters", gost.DeleteLimitersData(id))
+	}
+}
@@ -0,0 +1,199 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"pixia-panel/internal/gost"
+	"pixia-panel/internal/store"
+)
+
+func desiredService(t *testing.T, name string, forwardID int64, paused bool) desiredObject {
+	t.Helper()
+	raw := json.RawMessage(`{"name":"` + name + `","addr":":1000","handler":{"type":"tcp"},"listener":{"type":"tcp"}}`)
+	objects, err := gost.ServiceObjects(gost.ServiceListData([]json.RawMessage{raw}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := objects[0]
+	obj.Paused = paused
+	return desiredObject{Object: obj, forwardID: forwardID}
+}
+
+func configReport(t *testing.T, nodeID, reportedTime int64, objects []gost.Object) *store.NodeConfigReport {
+	t.Helper()
+	raw, err := json.Marshal(objects)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &store.NodeConfigReport{NodeID: nodeID, Objects: raw, ReportedTime: reportedTime}
+}
+
+// enqueueDone queues a command for key on nodeID and completes it at updatedAt.
+func enqueueDone(t *testing.T, s *Server, nodeID int64, key string, updatedAt int64) {
+	t.Helper()
+	ctx := context.Background()
+	id, err := s.store.EnqueueOutbox(ctx, &store.OutboxItem{NodeID: nodeID, ServiceKey: key, Type: "UpdateService", Payload: json.RawMessage(`[]`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.store.DB().Exec(`UPDATE outbox SET status = ?, updated_at = ? WHERE id = ?`, store.OutboxDone, updatedAt, id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func driftNames(items []driftItem) []string {
+	names := []string{}
+	for _, item := range items {
+		name := item.Kind + ":" + item.Name
+		if item.Reason != "" {
+			name += " " + item.Reason
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestDiffNodeConfig(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	now := time.Now().UnixMilli()
+
+	same := desiredService(t, "1_1_1_tcp", 1, false)
+	missing := desiredService(t, "2_1_1_tcp", 2, false)
+	changed := desiredService(t, "3_1_1_tcp", 3, false)
+	resumed := desiredService(t, "4_1_1_tcp", 4, false)
+	paused := desiredService(t, "5_1_1_tcp", 5, true)
+	queued := desiredService(t, "6_1_1_tcp", 6, false)
+	desired := []desiredObject{same, missing, changed, resumed, paused, queued}
+
+	changedReport := changed.Object
+	changedReport.Digest = "other"
+	pausedOnNode := resumed.Object
+	pausedOnNode.Paused = true
+	runningOnNode := paused.Object
+	runningOnNode.Paused = false
+	reported := []gost.Object{
+		same.Object, changedReport, pausedOnNode, runningOnNode,
+		{Kind: gost.KindService, Name: "9_1_1_tcp", Digest: "x"},
+		{Kind: gost.KindService, Name: "8_1_1_udp", Digest: "x"},
+		{Kind: gost.KindService, Name: "web_api", Digest: "x"},
+		{Kind: gost.KindLimiter, Name: "8", Digest: "x"},
+	}
+
+	if _, err := s.store.EnqueueOutbox(ctx, &store.OutboxItem{NodeID: 1, ServiceKey: "service:6_1_1_tcp,6_1_1_udp", Type: "AddService", Payload: json.RawMessage(`[]`)}); err != nil {
+		t.Fatal(err)
+	}
+	// Delivered before the report, so the report already shows its effect.
+	enqueueDone(t, s, 1, "service:3_1_1_tcp", now-time.Minute.Milliseconds())
+	// Delivered after the report, which cannot show it yet.
+	enqueueDone(t, s, 1, "service:8_1_1_tcp,8_1_1_udp", now+1)
+	// Another node's commands do not matter.
+	if _, err := s.store.EnqueueOutbox(ctx, &store.OutboxItem{NodeID: 2, ServiceKey: "service:2_1_1_tcp", Type: "AddService", Payload: json.RawMessage(`[]`)}); err != nil {
+		t.Fatal(err)
+	}
+
+	drift, err := s.diffNodeConfig(ctx, configReport(t, 1, now, reported), desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checks := []struct {
+		name  string
+		items []driftItem
+		want  []string
+	}{
+		{"missing", drift.Missing, []string{"service:2_1_1_tcp"}},
+		{"mismatched", drift.Mismatched, []string{"service:3_1_1_tcp config", "service:4_1_1_tcp paused", "service:5_1_1_tcp not_paused"}},
+		{"pending", drift.Pending, []string{"service:6_1_1_tcp", "service:8_1_1_udp"}},
+		{"extra", drift.Extra, []string{"service:9_1_1_tcp", "limiter:8"}},
+	}
+	for _, c := range checks {
+		if got := driftNames(c.items); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestReconcileNode(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+
+	limiter, err := gost.LimiterObject(gost.AddLimitersData(3, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := gost.ChainObject(gost.AddChainsData("2_1_1", "192.0.2.2:2000", "tls", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := desiredService(t, "3_1_1_tcp", 3, true)
+	resumed := desiredService(t, "4_1_1_tcp", 4, false)
+	desired := []desiredObject{
+		{Object: limiter, update: gost.UpdateLimitersData(3, 10)},
+		{Object: chain, forwardID: 2, update: gost.UpdateChainsData("2_1_1", "192.0.2.2:2000", "tls", nil)},
+		desiredService(t, "2_1_1_tcp", 2, false),
+		desiredService(t, "2_1_1_udp", 2, false),
+		changed,
+		resumed,
+	}
+
+	chainReport := chain
+	chainReport.Digest = "other"
+	changedReport := changed.Object
+	changedReport.Digest = "other"
+	changedReport.Paused = false
+	pausedOnNode := resumed.Object
+	pausedOnNode.Paused = true
+	reported := []gost.Object{
+		chainReport, changedReport, pausedOnNode,
+		{Kind: gost.KindService, Name: "9_1_1_tcp", Digest: "x"},
+		{Kind: gost.KindService, Name: "9_1_1_udp", Digest: "x"},
+		{Kind: gost.KindChain, Name: "9_1_1_chains", Digest: "x"},
+		{Kind: gost.KindLimiter, Name: "8", Digest: "x"},
+		{Kind: gost.KindService, Name: "web_api", Digest: "x"},
+	}
+
+	drift, err := s.diffNodeConfig(ctx, configReport(t, 1, time.Now().UnixMilli(), reported), desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.reconcileNode(ctx, drift)
+
+	items, _, err := s.store.ListOutbox(ctx, store.OutboxFilter{NodeID: 1, Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	var forwards []int64
+	for i := len(items) - 1; i >= 0; i-- {
+		got = append(got, items[i].Type+" "+items[i].ServiceKey)
+		var forwardID int64
+		if items[i].ForwardID != nil {
+			forwardID = *items[i].ForwardID
+		}
+		forwards = append(forwards, forwardID)
+	}
+	// Extra services and chains are removed first, extra limiters last, and
+	// the desired objects are fixed in the order they were built.
+	want := []string{
+		"DeleteService service:9_1_1_tcp,9_1_1_udp",
+		"DeleteChains chain:9_1_1_chains",
+		"AddLimiters limiter:3",
+		"UpdateChains chain:2_1_1_chains",
+		"AddService service:2_1_1_tcp,2_1_1_udp",
+		"UpdateService service:3_1_1_tcp",
+		"PauseService service:3_1_1_tcp",
+		"ResumeService service:4_1_1_tcp",
+		"DeleteLimiters limiter:8",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("commands =\n%v\nwant\n%v", got, want)
+	}
+	wantForwards := []int64{0, 0, 0, 2, 2, 3, 3, 4, 0}
+	if !reflect.DeepEqual(forwards, wantForwards) {
+		t.Fatalf("forward IDs = %v, want %v", forwards, wantForwards)
+	}
+}
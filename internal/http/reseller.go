@@ -0,0 +1,148 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+
+	"pixia-panel/internal/store"
+)
+
+// maxResellerDepth bounds walks up the reseller tree.
+const maxResellerDepth = 16
+
+// userAncestors returns the resellers above user, nearest first.
+func (s *Server) userAncestors(ctx context.Context, user *store.User) []*store.User {
+	var list []*store.User
+	parentID := user.ParentID
+	for i := 0; parentID != 0 && i < maxResellerDepth; i++ {
+		parent, err := s.store.GetUserByID(ctx, parentID)
+		if err != nil {
+			break
+		}
+		list = append(list, parent)
+		parentID = parent.ParentID
+	}
+	return list
+}
+
+// isSubUser reports whether userID is below the caller in the reseller tree.
+func (s *Server) isSubUser(r *http.Request, userID int64) bool {
+	user, err := s.store.GetUserByID(r.Context(), userID)
+	if err != nil {
+		return false
+	}
+	callerID := userIDFromCtx(r)
+	for _, parent := range s.userAncestors(r.Context(), user) {
+		if parent.ID == callerID {
+			return true
+		}
+	}
+	return false
+}
+
+// canManageUserAs reports whether the caller may manage user, either through
+// the global permission perm or as a reseller above user.
+func (s *Server) canManageUserAs(r *http.Request, perm string, user *store.User) bool {
+	if s.can(r, perm) {
+		return s.canManageUser(r, user)
+	}
+	return s.can(r, permSubUserWrite) && s.isSubUser(r, user.ID)
+}
+
+// ownsUser reports whether data of userID belongs to the caller or, for
+// resellers, to one of their sub-users.
+func (s *Server) ownsUser(r *http.Request, userID int64) bool {
+	if userID == userIDFromCtx(r) {
+		return true
+	}
+	return s.can(r, permSubUserWrite) && s.isSubUser(r, userID)
+}
+
+// resellerLimitError checks limits handed to a sub-user against those of the
+// parent they draw from. Expiry 0 means never.
+func resellerLimitError(parentFlow, parentNum, parentExp, flow, num, exp int64) string {
+	if flow > parentFlow {
+		return "流量不能超过上级用户的流量"
+	}
+	if num > parentNum {
+		return "转发数量不能超过上级用户的转发数量"
+	}
+	if parentExp != 0 && (exp == 0 || exp > parentExp) {
+		return "到期时间不能晚于上级用户的到期时间"
+	}
+	return ""
+}
+
+// parentPauseReason returns why forwards of user on tunnelID must be paused
+// because of a reseller above them, or "" if none.
+func (s *Server) parentPauseReason(ctx context.Context, user *store.User, tunnelID int64, now int64) string {
+	for _, parent := range s.userAncestors(ctx, user) {
+		if reason := userPauseReason(parent, now); reason != "" {
+			return inheritedPauseReason(reason)
+		}
+		ut, err := s.store.GetUserTunnelByUserAndTunnel(ctx, parent.ID, tunnelID)
+		if err != nil {
+			return store.PauseReasonParent
+		}
+		if reason := userTunnelPauseReason(ut, now); reason != "" {
+			return inheritedPauseReason(reason)
+		}
+	}
+	return ""
+}
+
+// inheritedPauseReason maps a reseller's pause reason to the one recorded on
+// their sub-users' forwards. Disabling a reseller must not leave sub-users
+// needing a manual resume.
+func inheritedPauseReason(reason string) string {
+	if reason == store.PauseReasonAdmin {
+		return store.PauseReasonParent
+	}
+	return reason
+}
+
+// checkResellerPools returns a message if creating a forward for user on
+// tunnelID would exceed the pool of a reseller above them.
+func (s *Server) checkResellerPools(ctx context.Context, user *store.User, tunnelID int64, now int64) string {
+	if s.parentPauseReason(ctx, user, tunnelID, now) != "" {
+		return "上级用户的流量已用完、已到期或被禁用"
+	}
+	for _, parent := range s.userAncestors(ctx, user) {
+		count, _ := s.store.CountSubtreeForwards(ctx, parent.ID, 0)
+		if count >= parent.Num {
+			return "上级用户转发数量已满"
+		}
+		ut, err := s.store.GetUserTunnelByUserAndTunnel(ctx, parent.ID, tunnelID)
+		if err != nil {
+			return "上级用户没有该隧道权限"
+		}
+		count, _ = s.store.CountSubtreeForwards(ctx, parent.ID, tunnelID)
+		if count >= ut.Num {
+			return "上级用户隧道转发数量已满"
+		}
+	}
+	return ""
+}
+
+// subUserLimitError checks new limits of user against their parent when the
+// caller acts as a reseller rather than through the global permission perm.
+func (s *Server) subUserLimitError(r *http.Request, perm string, user *store.User, flow, num, exp int64) string {
+	if s.can(r, perm) || user.ParentID == 0 {
+		return ""
+	}
+	parent, err := s.store.GetUserByID(r.Context(), user.ParentID)
+	if err != nil {
+		return "上级用户不存在"
+	}
+	return resellerLimitError(parent.Flow, parent.Num, parent.ExpTime, flow, num, exp)
+}
+
+// parentUserTunnelLimitError returns the tunnel permission of user's parent
+// on tunnelID, or a message if the parent lacks it or the new limits exceed it.
+func (s *Server) parentUserTunnelLimitError(r *http.Request, user *store.User, tunnelID, flow, num, exp int64) (*store.UserTunnel, string) {
+	parentUT, err := s.store.GetUserTunnelByUserAndTunnel(r.Context(), user.ParentID, tunnelID)
+	if err != nil || parentUT.Status != 1 {
+		return nil, "上级用户没有该隧道权限"
+	}
+	return parentUT, resellerLimitError(parentUT.Flow, parentUT.Num, parentUT.ExpTime, flow, num, exp)
+}
@@ -0,0 +1,140 @@
+package httpapi
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"pixia-panel/internal/flow"
+	"pixia-panel/internal/store"
+)
+
+// newResellerServer returns a server where reseller 10 (2 forwards, 1 GB)
+// holds tunnel 1 with room for one forward and sub-user 11 already has
+// forward 1 on it. Tunnel 2 is not assigned to the reseller. extra runs
+// before anything is cached.
+func newResellerServer(t *testing.T, extra ...string) *Server {
+	t.Helper()
+	s := newTestServer(t)
+	queries := []string{
+		`INSERT INTO node(id, name, secret, server_ip, port_sta, port_end, created_time, status) VALUES (1, 'n', 's1', '192.0.2.1', 1000, 2000, 0, 1)`,
+		`INSERT INTO tunnel(id, name, in_node_id, in_ip, out_node_id, out_ip, type, flow, created_time, updated_time, status) VALUES (1, 't1', 1, '192.0.2.1', 1, '192.0.2.1', 1, 2, 0, 0, 1), (2, 't2', 1, '192.0.2.1', 1, '192.0.2.1', 1, 2, 0, 0, 1)`,
+		`INSERT INTO user(id, user, pwd, role_id, parent_id, exp_time, flow, flow_reset_time, num, created_time, status) VALUES (10, 'reseller', 'x', 5, 0, 0, 1, 0, 2, 0, 1), (11, 'sub', 'x', 2, 10, 0, 1, 0, 5, 0, 1)`,
+		`INSERT INTO user_tunnel(id, user_id, tunnel_id, num, flow, flow_reset_time, exp_time, status) VALUES (10, 10, 1, 1, 1, 0, 0, 1), (11, 11, 1, 5, 1, 0, 0, 1), (12, 11, 2, 5, 1, 0, 0, 1)`,
+		`INSERT INTO forward(id, user_id, user_name, name, tunnel_id, in_port, remote_addr, created_time, updated_time, status) VALUES (1, 11, 'sub', 'f', 1, 1500, '198.51.100.1:80', 0, 0, 1)`,
+	}
+	for _, q := range append(queries, extra...) {
+		if _, err := s.store.DB().Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func TestResellerLimitError(t *testing.T) {
+	cases := []struct {
+		name           string
+		flow, num, exp int64
+		parentExp      int64
+		wantErr        bool
+	}{
+		{"within limits", 10, 5, 1000, 2000, false},
+		{"equal to parent", 100, 10, 2000, 2000, false},
+		{"flow above parent", 101, 5, 1000, 2000, true},
+		{"num above parent", 10, 11, 1000, 2000, true},
+		{"expires after parent", 10, 5, 3000, 2000, true},
+		{"never expires under expiring parent", 10, 5, 0, 2000, true},
+		{"parent never expires", 10, 5, 0, 0, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			msg := resellerLimitError(100, 10, tc.parentExp, tc.flow, tc.num, tc.exp)
+			if (msg != "") != tc.wantErr {
+				t.Fatalf("resellerLimitError = %q, want error %v", msg, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckResellerPools(t *testing.T) {
+	now := time.Now().UnixMilli()
+	cases := []struct {
+		name     string
+		extra    []string
+		tunnelID int64
+		want     string
+	}{
+		{"tunnel pool full", nil, 1, "上级用户隧道转发数量已满"},
+		{"reseller lacks tunnel", nil, 2, "上级用户的流量已用完、已到期或被禁用"},
+		{"room left", []string{`UPDATE user_tunnel SET num = 5 WHERE id = 10`}, 1, ""},
+		{"forward pool full", []string{`UPDATE user_tunnel SET num = 5 WHERE id = 10`, `UPDATE user SET num = 1 WHERE id = 10`}, 1, "上级用户转发数量已满"},
+		{"reseller out of traffic", []string{`UPDATE user SET in_flow = 2 * 1073741824 WHERE id = 10`}, 1, "上级用户的流量已用完、已到期或被禁用"},
+		{"reseller disabled", []string{`UPDATE user SET status = 0 WHERE id = 10`}, 1, "上级用户的流量已用完、已到期或被禁用"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newResellerServer(t, tc.extra...)
+			sub, err := s.store.GetUserByID(context.Background(), 11)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.checkResellerPools(context.Background(), sub, tc.tunnelID, now); got != tc.want {
+				t.Fatalf("checkResellerPools = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParentPauseReason(t *testing.T) {
+	now := time.Now().UnixMilli()
+	cases := []struct {
+		name  string
+		extra string
+		want  string
+	}{
+		{"within limits", `UPDATE user SET num = 2 WHERE id = 10`, ""},
+		{"reseller out of traffic", `UPDATE user SET in_flow = 2 * 1073741824 WHERE id = 10`, store.PauseReasonUserQuota},
+		{"reseller tunnel out of traffic", `UPDATE user_tunnel SET out_flow = 1073741824 WHERE id = 10`, store.PauseReasonTunnelQuota},
+		{"reseller expired", `UPDATE user SET exp_time = 1 WHERE id = 10`, store.PauseReasonExpired},
+		{"reseller disabled", `UPDATE user SET status = 0 WHERE id = 10`, store.PauseReasonParent},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newResellerServer(t, tc.extra)
+			sub, err := s.store.GetUserByID(context.Background(), 11)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.parentPauseReason(context.Background(), sub, 1, now); got != tc.want {
+				t.Fatalf("parentPauseReason = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSubUserTrafficDrawsFromResellerPool(t *testing.T) {
+	s := newResellerServer(t)
+	ctx := context.Background()
+	if err := s.flow.Add(flow.Update{ForwardID: 1, UserID: 11, UserTunnelID: 11, Down: 300, Up: 100}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.flow.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int64{10, 11} {
+		user, err := s.store.GetUserByID(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.InFlow != 300 || user.OutFlow != 100 {
+			t.Fatalf("user %d flow = (%d, %d), want (300, 100)", id, user.InFlow, user.OutFlow)
+		}
+	}
+	ut, err := s.store.GetUserTunnelByUserAndTunnel(ctx, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ut.InFlow != 300 || ut.OutFlow != 100 {
+		t.Fatalf("reseller tunnel flow = (%d, %d), want (300, 100)", ut.InFlow, ut.OutFlow)
+	}
+}
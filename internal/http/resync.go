@@ -2,48 +2,44 @@ package httpapi
 
 import (
 	"context"
+	"log"
 
-	"pixia-panel/internal/store"
+	"pixia-panel/internal/gost"
 )
 
-// ResyncNode pushes limiters and services for a node when it reconnects.
-func (s *Server) ResyncNode(ctx context.Context, nodeID int64) {
-	tunnels, err := s.store.ListTunnels(ctx)
+// ResyncNode brings a node's config up to date when it reconnects. Nothing
+// is sent when configHash, the hash of the config the node runs, matches the
+// desired state; otherwise only the difference to the config it last
+// reported is. A node that has never reported is reconciled when its first
+// report arrives, which the agent sends shortly after it starts.
+func (s *Server) ResyncNode(ctx context.Context, nodeID int64, configHash string) {
+	s.pushRotatedSecret(ctx, nodeID)
+
+	desired, err := s.desiredNodeState(ctx, nodeID)
 	if err != nil {
+		log.Printf("node %d reconcile failed: %v", nodeID, err)
 		return
 	}
-
-	tunnelMap := make(map[int64]store.Tunnel, len(tunnels))
-	for _, t := range tunnels {
-		tunnelMap[t.ID] = t
-		if t.InNodeID == nodeID {
-			limits, err := s.store.ListActiveSpeedLimitsByTunnel(ctx, t.ID)
-			if err != nil {
-				continue
-			}
-			for i := range limits {
-				limiterID := limits[i].ID
-				s.ensureLimiterConfig(ctx, t.InNodeID, &limiterID)
-			}
-		}
+	if configHash != "" && configHash == desiredConfigHash(desired) {
+		return
 	}
 
-	forwards, err := s.store.ListForwardsAll(ctx)
+	report, err := s.store.GetNodeConfigReport(ctx, nodeID)
 	if err != nil {
 		return
 	}
+	drift, err := s.diffNodeConfig(ctx, report, desired)
+	if err != nil {
+		log.Printf("node %d reconcile failed: %v", nodeID, err)
+		return
+	}
+	s.reconcileNode(ctx, drift)
+}
 
-	for i := range forwards {
-		fwItem := forwards[i]
-		tunnel, ok := tunnelMap[fwItem.TunnelID]
-		if !ok {
-			continue
-		}
-		if tunnel.InNodeID != nodeID && !(tunnel.Type == 2 && tunnel.OutNodeID == nodeID) {
-			continue
-		}
-		limiter := s.resolveSpeedLimiterCtx(ctx, fwItem.UserID, fwItem.TunnelID)
-		fw := fwItem.Forward
-		s.enqueueForwardGostCtx(ctx, &fw, &tunnel, limiter, "UpdateService")
+func desiredConfigHash(desired []desiredObject) string {
+	objects := make([]gost.Object, len(desired))
+	for i, d := range desired {
+		objects[i] = d.Object
 	}
+	return gost.ConfigHash(objects)
 }
@@ -1,11 +1,14 @@
 package httpapi
 
 import (
+	"net"
 	"net/http"
 	"time"
 
+	"pixia-panel/internal/captcha"
 	"pixia-panel/internal/flow"
 	"pixia-panel/internal/gost"
+	"pixia-panel/internal/oidc"
 	"pixia-panel/internal/store"
 )
 
@@ -14,11 +17,28 @@ type Server struct {
 	flow      *flow.Service
 	hub       *gost.Hub
 	jwtSecret []byte
-	tokenTTL  time.Duration
+	// accessTTL is the lifetime of access tokens; sessionTTL is how long a
+	// session survives without being refreshed.
+	accessTTL  time.Duration
+	sessionTTL time.Duration
+	// captchas holds outstanding challenges of the built-in image captcha.
+	captchas *captcha.Store
+	// oidc talks to the single sign-on provider; oidcPending holds logins
+	// sent to it that await their callback.
+	oidc        *oidc.Client
+	oidcPending *oidc.PendingStore
+	// trustedProxies are the reverse proxies whose client address headers
+	// clientIP honors.
+	trustedProxies []*net.IPNet
 }
 
-func NewServer(store *store.Store, flow *flow.Service, hub *gost.Hub, jwtSecret []byte, tokenTTL time.Duration) *Server {
-	return &Server{store: store, flow: flow, hub: hub, jwtSecret: jwtSecret, tokenTTL: tokenTTL}
+func NewServer(store *store.Store, flow *flow.Service, hub *gost.Hub, jwtSecret []byte, accessTTL, sessionTTL time.Duration) *Server {
+	s := &Server{store: store, flow: flow, hub: hub, jwtSecret: jwtSecret, accessTTL: accessTTL, sessionTTL: sessionTTL,
+		captchas:    captcha.NewStore(captchaTTL, maxCaptchas, maxClientCaptchas),
+		oidc:        oidc.NewClient(oidcHTTPTimeout),
+		oidcPending: oidc.NewPendingStore(oidcLoginTTL, maxOIDCPending)}
+	flow.SetFlushHook(s.enforceQuotas)
+	return s
 }
 
 func (s *Server) Register(mux *http.ServeMux) {
@@ -26,6 +46,7 @@ func (s *Server) Register(mux *http.ServeMux) {
 	mux.HandleFunc("/flow/test", s.handleFlowTest)
 	mux.HandleFunc("/flow/upload", s.handleFlowUpload)
 	mux.HandleFunc("/flow/config", s.handleFlowConfig)
+	mux.HandleFunc("/flow/enroll", s.handleFlowEnroll)
 	mux.HandleFunc("/api/v1/captcha/check", s.handleCaptchaCheck)
 	mux.HandleFunc("/api/v1/captcha/generate", s.handleCaptchaGenerate)
 	mux.HandleFunc("/api/v1/captcha/verify", s.handleCaptchaVerify)
@@ -35,59 +56,105 @@ func (s *Server) Register(mux *http.ServeMux) {
 
 	// auth endpoints
 	mux.HandleFunc("/api/v1/user/login", s.handleUserLogin)
+	mux.HandleFunc("/api/v1/user/login/2fa", s.handleTwoFactorLogin)
+	mux.HandleFunc("/api/v1/user/login/2fa/setup", s.handleTwoFactorLoginSetup)
+	mux.HandleFunc("/api/v1/user/login/oidc/start", s.handleOIDCStart)
+	mux.HandleFunc("/api/v1/user/login/oidc", s.handleOIDCLogin)
+	mux.HandleFunc("/api/v1/user/refresh", s.handleUserRefresh)
 
-	// protected endpoints
-	protected := func(path string, h http.Handler) {
-		mux.Handle(path, withAuth(s.jwtSecret, h))
+	// authenticated endpoints: perm is the role permission required, scope
+	// what an API token needs; see requirePermission and withAuth.
+	route := func(path, perm, scope string, h http.Handler) {
+		mux.Handle(path, s.withAuth(scope, s.requirePermission(perm, h)))
 	}
-
-	admin := func(path string, h http.Handler) {
-		mux.Handle(path, withAuth(s.jwtSecret, requireAdmin(h)))
+	// subRoute additionally admits resellers, whom the handler limits to
+	// their own sub-users.
+	subRoute := func(path, perm, scope string, h http.Handler) {
+		mux.Handle(path, s.withAuth(scope, s.requireAnyPermission([]string{perm, permSubUserWrite}, h)))
 	}
 
-	protected("/api/v1/user/package", http.HandlerFunc(s.handleUserPackage))
-	protected("/api/v1/user/updatePassword", http.HandlerFunc(s.handleUserUpdatePassword))
-	admin("/api/v1/user/create", http.HandlerFunc(s.handleUserCreate))
-	admin("/api/v1/user/list", http.HandlerFunc(s.handleUserList))
-	admin("/api/v1/user/update", http.HandlerFunc(s.handleUserUpdate))
-	admin("/api/v1/user/delete", http.HandlerFunc(s.handleUserDelete))
-	admin("/api/v1/user/reset", http.HandlerFunc(s.handleUserResetFlow))
+	route("/api/v1/user/package", permNone, scopeRead, http.HandlerFunc(s.handleUserPackage))
+	route(updatePasswordPath, permNone, scopeSession, http.HandlerFunc(s.handleUserUpdatePassword))
+	route("/api/v1/traffic/history", permNone, scopeRead, http.HandlerFunc(s.handleTrafficHistory))
+	route("/api/v1/user/2fa/status", permNone, scopeSession, http.HandlerFunc(s.handleTwoFactorStatus))
+	route("/api/v1/user/2fa/enroll", permNone, scopeSession, http.HandlerFunc(s.handleTwoFactorEnroll))
+	route("/api/v1/user/2fa/enable", permNone, scopeSession, http.HandlerFunc(s.handleTwoFactorEnable))
+	route("/api/v1/user/2fa/disable", permNone, scopeSession, http.HandlerFunc(s.handleTwoFactorDisable))
+	route("/api/v1/user/2fa/recovery-codes", permNone, scopeSession, http.HandlerFunc(s.handleTwoFactorRecoveryCodes))
+	route("/api/v1/user/logout", permNone, scopeSession, http.HandlerFunc(s.handleUserLogout))
+	route("/api/v1/user/logout-all", permNone, scopeSession, http.HandlerFunc(s.handleUserLogoutAll))
+	route("/api/v1/user/sessions", permNone, scopeSession, http.HandlerFunc(s.handleSessionList))
+	route("/api/v1/user/sessions/revoke", permNone, scopeSession, http.HandlerFunc(s.handleSessionRevoke))
+	route("/api/v1/token/create", permNone, scopeSession, http.HandlerFunc(s.handleAPITokenCreate))
+	route("/api/v1/token/list", permNone, scopeRead, http.HandlerFunc(s.handleAPITokenList))
+	route("/api/v1/token/revoke", permNone, scopeSession, http.HandlerFunc(s.handleAPITokenRevoke))
+	subRoute("/api/v1/user/create", permUserWrite, scopeAdmin, http.HandlerFunc(s.handleUserCreate))
+	subRoute("/api/v1/user/list", permUserRead, scopeRead, http.HandlerFunc(s.handleUserList))
+	subRoute("/api/v1/user/update", permUserWrite, scopeAdmin, http.HandlerFunc(s.handleUserUpdate))
+	subRoute("/api/v1/user/delete", permUserWrite, scopeAdmin, http.HandlerFunc(s.handleUserDelete))
+	route("/api/v1/user/reset", permUserQuota, scopeAdmin, http.HandlerFunc(s.handleUserResetFlow))
+	route("/api/v1/user/2fa/reset", permUserWrite, scopeAdmin, http.HandlerFunc(s.handleTwoFactorReset))
+	subRoute("/api/v1/user/quota", permUserQuota, scopeAdmin, http.HandlerFunc(s.handleUserQuota))
+	route("/api/v1/user/role", permUserWrite, scopeAdmin, http.HandlerFunc(s.handleUserRole))
+	route("/api/v1/user/oidc/link", permUserWrite, scopeAdmin, http.HandlerFunc(s.handleUserOIDCLink))
+	route("/api/v1/user/oidc/unlink", permUserWrite, scopeAdmin, http.HandlerFunc(s.handleUserOIDCUnlink))
+
+	route("/api/v1/role/list", permUserRead, scopeRead, http.HandlerFunc(s.handleRoleList))
+	route("/api/v1/role/permissions", permUserRead, scopeRead, http.HandlerFunc(s.handleRolePermissions))
+	route("/api/v1/role/create", permRoleWrite, scopeAdmin, http.HandlerFunc(s.handleRoleCreate))
+	route("/api/v1/role/update", permRoleWrite, scopeAdmin, http.HandlerFunc(s.handleRoleUpdate))
+	route("/api/v1/role/delete", permRoleWrite, scopeAdmin, http.HandlerFunc(s.handleRoleDelete))
+
+	route("/api/v1/node/create", permNodeWrite, scopeAdmin, http.HandlerFunc(s.handleNodeCreate))
+	route("/api/v1/node/list", permNodeRead, scopeRead, http.HandlerFunc(s.handleNodeList))
+	route("/api/v1/node/update", permNodeWrite, scopeAdmin, http.HandlerFunc(s.handleNodeUpdate))
+	route("/api/v1/node/delete", permNodeWrite, scopeAdmin, http.HandlerFunc(s.handleNodeDelete))
+	route("/api/v1/node/install", permNodeWrite, scopeAdmin, http.HandlerFunc(s.handleNodeInstall))
+	route("/api/v1/outbox/list", permNodeRead, scopeRead, http.HandlerFunc(s.handleOutboxList))
+	route("/api/v1/outbox/get", permNodeRead, scopeRead, http.HandlerFunc(s.handleOutboxGet))
+	route("/api/v1/outbox/requeue", permNodeWrite, scopeAdmin, http.HandlerFunc(s.handleOutboxRequeue))
+	route("/api/v1/outbox/discard", permNodeWrite, scopeAdmin, http.HandlerFunc(s.handleOutboxDiscard))
+	route("/api/v1/node/enrollments", permNodeRead, scopeRead, http.HandlerFunc(s.handleNodeEnrollments))
+	route("/api/v1/node/rotate-secret", permNodeWrite, scopeAdmin, http.HandlerFunc(s.handleNodeRotateSecret))
+	route("/api/v1/node/drift", permNodeRead, scopeRead, http.HandlerFunc(s.handleNodeDrift))
+	route("/api/v1/node/reconcile", permNodeWrite, scopeAdmin, http.HandlerFunc(s.handleNodeReconcile))
+	route("/api/v1/node/check-status", permNodeRead, scopeRead, http.HandlerFunc(s.handleNodeCheckStatus))
 
-	admin("/api/v1/node/create", http.HandlerFunc(s.handleNodeCreate))
-	admin("/api/v1/node/list", http.HandlerFunc(s.handleNodeList))
-	admin("/api/v1/node/update", http.HandlerFunc(s.handleNodeUpdate))
-	admin("/api/v1/node/delete", http.HandlerFunc(s.handleNodeDelete))
-	admin("/api/v1/node/install", http.HandlerFunc(s.handleNodeInstall))
-	admin("/api/v1/node/check-status", http.HandlerFunc(s.handleNodeCheckStatus))
+	route("/api/v1/tunnel/create", permTunnelWrite, scopeAdmin, http.HandlerFunc(s.handleTunnelCreate))
+	route("/api/v1/tunnel/list", permTunnelRead, scopeRead, http.HandlerFunc(s.handleTunnelList))
+	route("/api/v1/tunnel/get", permTunnelRead, scopeRead, http.HandlerFunc(s.handleTunnelGet))
+	route("/api/v1/tunnel/update", permTunnelWrite, scopeAdmin, http.HandlerFunc(s.handleTunnelUpdate))
+	route("/api/v1/tunnel/delete", permTunnelWrite, scopeAdmin, http.HandlerFunc(s.handleTunnelDelete))
+	subRoute("/api/v1/tunnel/user/assign", permUserWrite, scopeAdmin, http.HandlerFunc(s.handleUserTunnelAssign))
+	subRoute("/api/v1/tunnel/user/list", permUserRead, scopeRead, http.HandlerFunc(s.handleUserTunnelList))
+	subRoute("/api/v1/tunnel/user/remove", permUserWrite, scopeAdmin, http.HandlerFunc(s.handleUserTunnelRemove))
+	subRoute("/api/v1/tunnel/user/update", permUserQuota, scopeAdmin, http.HandlerFunc(s.handleUserTunnelUpdate))
+	route("/api/v1/tunnel/user/tunnel", permNone, scopeRead, http.HandlerFunc(s.handleUserTunnelAvailable))
+	route("/api/v1/tunnel/diagnose", permTunnelRead, scopeRead, http.HandlerFunc(s.handleTunnelDiagnose))
 
-	admin("/api/v1/tunnel/create", http.HandlerFunc(s.handleTunnelCreate))
-	admin("/api/v1/tunnel/list", http.HandlerFunc(s.handleTunnelList))
-	admin("/api/v1/tunnel/get", http.HandlerFunc(s.handleTunnelGet))
-	admin("/api/v1/tunnel/update", http.HandlerFunc(s.handleTunnelUpdate))
-	admin("/api/v1/tunnel/delete", http.HandlerFunc(s.handleTunnelDelete))
-	admin("/api/v1/tunnel/user/assign", http.HandlerFunc(s.handleUserTunnelAssign))
-	admin("/api/v1/tunnel/user/list", http.HandlerFunc(s.handleUserTunnelList))
-	admin("/api/v1/tunnel/user/remove", http.HandlerFunc(s.handleUserTunnelRemove))
-	admin("/api/v1/tunnel/user/update", http.HandlerFunc(s.handleUserTunnelUpdate))
-	protected("/api/v1/tunnel/user/tunnel", http.HandlerFunc(s.handleUserTunnelAvailable))
-	admin("/api/v1/tunnel/diagnose", http.HandlerFunc(s.handleTunnelDiagnose))
+	route("/api/v1/forward/create", permNone, scopeForwardsWrite, http.HandlerFunc(s.handleForwardCreate))
+	route("/api/v1/forward/list", permNone, scopeRead, http.HandlerFunc(s.handleForwardList))
+	route("/api/v1/forward/update", permNone, scopeForwardsWrite, http.HandlerFunc(s.handleForwardUpdate))
+	route("/api/v1/forward/delete", permNone, scopeForwardsWrite, http.HandlerFunc(s.handleForwardDelete))
+	route("/api/v1/forward/force-delete", permNone, scopeForwardsWrite, http.HandlerFunc(s.handleForwardForceDelete))
+	route("/api/v1/forward/pause", permNone, scopeForwardsWrite, http.HandlerFunc(s.handleForwardPause))
+	route("/api/v1/forward/resume", permNone, scopeForwardsWrite, http.HandlerFunc(s.handleForwardResume))
+	route("/api/v1/forward/diagnose", permNone, scopeRead, http.HandlerFunc(s.handleForwardDiagnose))
+	route("/api/v1/forward/update-order", permNone, scopeForwardsWrite, http.HandlerFunc(s.handleForwardUpdateOrder))
 
-	protected("/api/v1/forward/create", http.HandlerFunc(s.handleForwardCreate))
-	protected("/api/v1/forward/list", http.HandlerFunc(s.handleForwardList))
-	protected("/api/v1/forward/update", http.HandlerFunc(s.handleForwardUpdate))
-	protected("/api/v1/forward/delete", http.HandlerFunc(s.handleForwardDelete))
-	protected("/api/v1/forward/force-delete", http.HandlerFunc(s.handleForwardForceDelete))
-	protected("/api/v1/forward/pause", http.HandlerFunc(s.handleForwardPause))
-	protected("/api/v1/forward/resume", http.HandlerFunc(s.handleForwardResume))
-	protected("/api/v1/forward/diagnose", http.HandlerFunc(s.handleForwardDiagnose))
-	protected("/api/v1/forward/update-order", http.HandlerFunc(s.handleForwardUpdateOrder))
+	route("/api/v1/speed-limit/create", permSpeedLimitWrite, scopeAdmin, http.HandlerFunc(s.handleSpeedLimitCreate))
+	route("/api/v1/speed-limit/list", permSpeedLimitRead, scopeRead, http.HandlerFunc(s.handleSpeedLimitList))
+	route("/api/v1/speed-limit/update", permSpeedLimitWrite, scopeAdmin, http.HandlerFunc(s.handleSpeedLimitUpdate))
+	route("/api/v1/speed-limit/delete", permSpeedLimitWrite, scopeAdmin, http.HandlerFunc(s.handleSpeedLimitDelete))
+	route("/api/v1/speed-limit/tunnels", permSpeedLimitRead, scopeRead, http.HandlerFunc(s.handleSpeedLimitTunnels))
 
-	admin("/api/v1/speed-limit/create", http.HandlerFunc(s.handleSpeedLimitCreate))
-	admin("/api/v1/speed-limit/list", http.HandlerFunc(s.handleSpeedLimitList))
-	admin("/api/v1/speed-limit/update", http.HandlerFunc(s.handleSpeedLimitUpdate))
-	admin("/api/v1/speed-limit/delete", http.HandlerFunc(s.handleSpeedLimitDelete))
-	admin("/api/v1/speed-limit/tunnels", http.HandlerFunc(s.handleSpeedLimitTunnels))
+	route("/api/v1/config/update", permConfigWrite, scopeAdmin, http.HandlerFunc(s.handleConfigUpdateBatch))
+	route("/api/v1/config/update-single", permConfigWrite, scopeAdmin, http.HandlerFunc(s.handleConfigUpdateSingle))
 
-	admin("/api/v1/config/update", http.HandlerFunc(s.handleConfigUpdateBatch))
-	admin("/api/v1/config/update-single", http.HandlerFunc(s.handleConfigUpdateSingle))
+	route("/api/v1/backup/download", permBackup, scopeAdmin, http.HandlerFunc(s.handleBackupDownload))
+	route("/api/v1/backup/restore", permBackup, scopeAdmin, http.HandlerFunc(s.handleBackupRestore))
+	route("/api/v1/cache/stats", permCacheRead, scopeRead, http.HandlerFunc(s.handleCacheStats))
+	route("/api/v1/audit/list", permAuditRead, scopeRead, http.HandlerFunc(s.handleAuditList))
+	route("/api/v1/login/lockouts", permUserRead, scopeRead, http.HandlerFunc(s.handleLoginLockoutList))
+	route("/api/v1/login/lockouts/clear", permUserWrite, scopeAdmin, http.HandlerFunc(s.handleLoginLockoutClear))
 }
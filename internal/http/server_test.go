@@ -0,0 +1,62 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"pixia-panel/internal/db"
+	"pixia-panel/internal/flow"
+	"pixia-panel/internal/gost"
+	"pixia-panel/internal/migrate"
+	"pixia-panel/internal/store"
+)
+
+// newTestServer returns a server on a fresh, fully migrated database.
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	conn, err := db.Open(filepath.Join(t.TempDir(), "panel.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if err := migrate.Apply(conn, filepath.Join("..", "..", "migrations")); err != nil {
+		t.Fatal(err)
+	}
+	st := store.New(conn)
+	// The seeded admin must change its default password before anything else.
+	if err := st.SetUserMustChangePassword(context.Background(), 1, false); err != nil {
+		t.Fatal(err)
+	}
+	return NewServer(st, flow.New(st, flow.Options{}), gost.NewHub(), []byte("test-secret"), time.Minute, time.Hour)
+}
+
+// postJSON runs h on a POST of body and decodes the response; Data is
+// re-encoded into data when non-nil.
+func postJSON(t *testing.T, h http.HandlerFunc, body any, data any) (int, Response) {
+	t.Helper()
+	raw, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(raw))
+	req.RemoteAddr = "192.0.2.1:1234"
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	var resp Response
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode %q: %v", rec.Body.String(), err)
+	}
+	if data != nil && resp.Data != nil {
+		b, _ := json.Marshal(resp.Data)
+		if err := json.Unmarshal(b, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.Code, resp
+}
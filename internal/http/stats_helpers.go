@@ -1,52 +1,46 @@
 package httpapi
 
 import (
-	"fmt"
-	"strconv"
-	"strings"
+	"context"
 	"time"
 
 	"pixia-panel/internal/store"
 )
 
-func fillLast24(list []store.StatisticsFlow, userID int64) []store.StatisticsFlow {
-	if len(list) >= 24 {
-		return list
+// userLast24Hours returns the user's billed traffic for each of the last 24
+// hours, newest first. TotalFlow is the user's counter at the end of the hour.
+func (s *Server) userLast24Hours(ctx context.Context, user *store.User) ([]store.StatisticsFlow, error) {
+	current := store.HourStart(time.Now())
+	points, err := s.store.QueryTraffic(ctx, store.TrafficQuery{
+		Granularity: store.GranularityHour,
+		Start:       current.Add(-23 * time.Hour).UnixMilli(),
+		End:         current.Add(time.Hour).UnixMilli(),
+		UserID:      user.ID,
+	})
+	if err != nil {
+		return nil, err
 	}
-	result := make([]store.StatisticsFlow, len(list))
-	copy(result, list)
-
-	startHour := time.Now().Hour()
-	if len(result) > 0 {
-		startHour = parseHour(result[len(result)-1].Time) - 1
+	flows := make(map[int64]int64, len(points))
+	for _, p := range points {
+		flows[p.Time] = p.BilledInFlow + p.BilledOutFlow
 	}
 
-	for len(result) < 24 {
-		if startHour < 0 {
-			startHour = 23
-		}
-		result = append(result, store.StatisticsFlow{
-			UserID:    userID,
-			Flow:      0,
-			TotalFlow: 0,
-			Time:      fmt.Sprintf("%02d:00", startHour),
+	total := user.InFlow + user.OutFlow
+	list := make([]store.StatisticsFlow, 0, 24)
+	for i := 0; i < 24; i++ {
+		bucket := current.Add(-time.Duration(i) * time.Hour)
+		flow := flows[bucket.UnixMilli()]
+		list = append(list, store.StatisticsFlow{
+			UserID:      user.ID,
+			Flow:        flow,
+			TotalFlow:   total,
+			Time:        bucket.Format("15:04"),
+			CreatedTime: bucket.UnixMilli(),
 		})
-		startHour--
-	}
-	return result
-}
-
-func parseHour(val string) int {
-	if val == "" {
-		return time.Now().Hour()
-	}
-	if strings.Contains(val, ":") {
-		parts := strings.Split(val, ":")
-		if len(parts) > 0 {
-			if h, err := strconv.Atoi(parts[0]); err == nil {
-				return h
-			}
+		total -= flow
+		if total < 0 {
+			total = 0
 		}
 	}
-	return time.Now().Hour()
+	return list, nil
 }
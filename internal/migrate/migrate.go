@@ -1,56 +1,259 @@
 package migrate
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
-// Apply executes all .sql files in the given directory in lexicographic order.
+var (
+	ErrChecksumMismatch = errors.New("migration checksum mismatch")
+	ErrNoDownMigration  = errors.New("no down migration")
+)
+
+// Migration is a versioned schema change loaded from NNN_name.sql, with an
+// optional NNN_name.down.sql holding its rollback.
+type Migration struct {
+	Version  int64
+	Name     string
+	Checksum string
+	up       string
+	down     string
+}
+
+// Status describes a migration and whether it has been applied.
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt int64
+}
+
+type appliedRecord struct {
+	checksum  string
+	appliedAt int64
+}
+
+const createTrackingTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+  version INTEGER PRIMARY KEY,
+  name TEXT NOT NULL,
+  checksum TEXT NOT NULL,
+  applied_at INTEGER NOT NULL
+)`
+
+// Apply runs every pending migration in version order, each in its own
+// transaction. It refuses to run if an applied migration has changed.
 func Apply(db *sql.DB, dir string) error {
-	entries, err := os.ReadDir(dir)
+	migrations, applied, err := prepare(db, dir)
 	if err != nil {
 		return err
 	}
 
-	var files []string
-	for _, entry := range entries {
-		if entry.IsDir() {
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
 			continue
 		}
-		name := entry.Name()
-		if strings.HasSuffix(name, ".sql") {
-			files = append(files, filepath.Join(dir, name))
+		if err := runTx(db, m.up, func(tx *sql.Tx) error {
+			_, err := tx.Exec(`INSERT INTO schema_migrations(version, name, checksum, applied_at) VALUES(?, ?, ?, ?)`, m.Version, m.Name, m.Checksum, time.Now().UnixMilli())
+			return err
+		}); err != nil {
+			return fmt.Errorf("apply migration %03d_%s: %w", m.Version, m.Name, err)
 		}
 	}
+	return nil
+}
 
-	sort.Strings(files)
+// Rollback reverts the most recently applied migration and returns it.
+// It returns nil when nothing has been applied.
+func Rollback(db *sql.DB, dir string) (*Migration, error) {
+	migrations, applied, err := prepare(db, dir)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, path := range files {
-		content, err := os.ReadFile(path)
-		if err != nil {
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if strings.TrimSpace(m.down) == "" {
+			return nil, fmt.Errorf("rollback migration %03d_%s: %w", m.Version, m.Name, ErrNoDownMigration)
+		}
+		if err := runTx(db, m.down, func(tx *sql.Tx) error {
+			_, err := tx.Exec(`DELETE FROM schema_migrations WHERE version = ?`, m.Version)
 			return err
+		}); err != nil {
+			return nil, fmt.Errorf("rollback migration %03d_%s: %w", m.Version, m.Name, err)
 		}
+		return &m, nil
+	}
+	return nil, nil
+}
+
+// List reports every known migration and whether it has been applied.
+func List(db *sql.DB, dir string) ([]Status, error) {
+	migrations, err := Load(dir)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(createTrackingTable); err != nil {
+		return nil, err
+	}
+	applied, err := loadApplied(db)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]Status, 0, len(migrations))
+	for _, m := range migrations {
+		st := Status{Migration: m}
+		if rec, ok := applied[m.Version]; ok {
+			st.Applied = true
+			st.AppliedAt = rec.appliedAt
+		}
+		list = append(list, st)
+	}
+	return list, nil
+}
+
+// Load reads the migrations in dir ordered by version.
+func Load(dir string) ([]Migration, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
 
-		if strings.TrimSpace(string(content)) == "" {
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
 			continue
 		}
+		version, name, isDown, err := parseFileName(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
 
-		if err := execSQL(db, path, content); err != nil {
-			return err
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("migration version %03d used by %s and %s", version, m.Name, name)
+		}
+		if isDown {
+			m.down = string(content)
+		} else {
+			m.up = string(content)
+			m.Checksum = checksum(content)
 		}
 	}
 
-	return nil
+	list := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Checksum == "" {
+			return nil, fmt.Errorf("migration %03d_%s has no up file", m.Version, m.Name)
+		}
+		list = append(list, *m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
 }
 
-func execSQL(db *sql.DB, path string, content []byte) error {
-	_, err := db.Exec(string(content))
+// prepare loads migrations and the applied set, and verifies checksums.
+func prepare(db *sql.DB, dir string) ([]Migration, map[int64]appliedRecord, error) {
+	migrations, err := Load(dir)
 	if err != nil {
-		return fmt.Errorf("apply migration %s: %w", filepath.Base(path), err)
+		return nil, nil, err
 	}
-	return nil
+	if _, err := db.Exec(createTrackingTable); err != nil {
+		return nil, nil, err
+	}
+	applied, err := loadApplied(db)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	known := make(map[int64]struct{}, len(migrations))
+	for _, m := range migrations {
+		known[m.Version] = struct{}{}
+		rec, ok := applied[m.Version]
+		if ok && rec.checksum != m.Checksum {
+			return nil, nil, fmt.Errorf("%03d_%s: %w", m.Version, m.Name, ErrChecksumMismatch)
+		}
+	}
+	for version := range applied {
+		if _, ok := known[version]; !ok {
+			return nil, nil, fmt.Errorf("applied migration %03d missing from %s", version, dir)
+		}
+	}
+	return migrations, applied, nil
+}
+
+func loadApplied(db *sql.DB) (map[int64]appliedRecord, error) {
+	rows, err := db.Query(`SELECT version, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]appliedRecord)
+	for rows.Next() {
+		var version int64
+		var rec appliedRecord
+		if err := rows.Scan(&version, &rec.checksum, &rec.appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = rec
+	}
+	return applied, rows.Err()
+}
+
+func runTx(db *sql.DB, content string, record func(*sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(content) != "" {
+		if _, err := tx.Exec(content); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	if err := record(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func parseFileName(file string) (int64, string, bool, error) {
+	base := strings.TrimSuffix(file, ".sql")
+	isDown := strings.HasSuffix(base, ".down")
+	base = strings.TrimSuffix(base, ".down")
+
+	prefix, name, ok := strings.Cut(base, "_")
+	if !ok || name == "" {
+		return 0, "", false, fmt.Errorf("invalid migration file name %s", file)
+	}
+	version, err := strconv.ParseInt(prefix, 10, 64)
+	if err != nil || version <= 0 {
+		return 0, "", false, fmt.Errorf("invalid migration version in %s", file)
+	}
+	return version, name, isDown, nil
+}
+
+func checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
 }
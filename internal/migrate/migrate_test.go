@@ -0,0 +1,219 @@
+package migrate
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "modernc.org/sqlite"
+
+	"pixia-panel/internal/db"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	conn, err := db.Open(filepath.Join(t.TempDir(), "panel.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// writeMigrations creates dir holding files, named as in a migrations dir.
+func writeMigrations(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func tableExists(t *testing.T, conn *sql.DB, name string) bool {
+	t.Helper()
+	var n int
+	if err := conn.QueryRow(`SELECT COUNT(1) FROM sqlite_master WHERE type = 'table' AND name = ?`, name).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n > 0
+}
+
+func appliedVersions(t *testing.T, conn *sql.DB, dir string) []int64 {
+	t.Helper()
+	list, err := List(conn, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var versions []int64
+	for _, st := range list {
+		if st.Applied {
+			versions = append(versions, st.Version)
+		}
+	}
+	return versions
+}
+
+func TestApplyAndRollback(t *testing.T) {
+	conn := openTestDB(t)
+	dir := writeMigrations(t, map[string]string{
+		"001_a.sql":      "CREATE TABLE a (id INTEGER);",
+		"001_a.down.sql": "DROP TABLE a;",
+		"002_b.sql":      "CREATE TABLE b (id INTEGER);",
+		"002_b.down.sql": "DROP TABLE b;",
+	})
+
+	if err := Apply(conn, dir); err != nil {
+		t.Fatal(err)
+	}
+	if !tableExists(t, conn, "a") || !tableExists(t, conn, "b") {
+		t.Fatal("tables not created")
+	}
+	// Applied migrations are not run again.
+	if err := Apply(conn, dir); err != nil {
+		t.Fatalf("second Apply: %v", err)
+	}
+
+	m, err := Rollback(conn, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m == nil || m.Version != 2 || tableExists(t, conn, "b") || !tableExists(t, conn, "a") {
+		t.Fatalf("Rollback reverted %+v, want only 002", m)
+	}
+	if got := appliedVersions(t, conn, dir); len(got) != 1 || got[0] != 1 {
+		t.Fatalf("applied = %v, want [1]", got)
+	}
+
+	if _, err := Rollback(conn, dir); err != nil {
+		t.Fatal(err)
+	}
+	m, err = Rollback(conn, dir)
+	if err != nil || m != nil {
+		t.Fatalf("Rollback with nothing applied = %+v, %v", m, err)
+	}
+}
+
+func TestApplyFailureRollsBackMigration(t *testing.T) {
+	conn := openTestDB(t)
+	dir := writeMigrations(t, map[string]string{
+		"001_a.sql": "CREATE TABLE a (id INTEGER);",
+		"002_b.sql": "CREATE TABLE b (id INTEGER); INSERT INTO missing VALUES (1);",
+	})
+	if err := Apply(conn, dir); err == nil || !strings.Contains(err.Error(), "002_b") {
+		t.Fatalf("Apply = %v, want failure of 002_b", err)
+	}
+	if !tableExists(t, conn, "a") || tableExists(t, conn, "b") {
+		t.Fatal("failed migration left partial changes or undid the earlier one")
+	}
+	if got := appliedVersions(t, conn, dir); len(got) != 1 || got[0] != 1 {
+		t.Fatalf("applied = %v, want [1]", got)
+	}
+}
+
+func TestChecksumMismatch(t *testing.T) {
+	conn := openTestDB(t)
+	dir := writeMigrations(t, map[string]string{
+		"001_a.sql":      "CREATE TABLE a (id INTEGER);",
+		"001_a.down.sql": "DROP TABLE a;",
+	})
+	if err := Apply(conn, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the up file is checksummed; fixing a down file is allowed.
+	if err := os.WriteFile(filepath.Join(dir, "001_a.down.sql"), []byte("DROP TABLE IF EXISTS a;"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Apply(conn, dir); err != nil {
+		t.Fatalf("Apply after editing down file: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "001_a.sql"), []byte("CREATE TABLE a (id INTEGER, name TEXT);"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Apply(conn, dir); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("Apply after editing applied migration = %v, want ErrChecksumMismatch", err)
+	}
+	if _, err := Rollback(conn, dir); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("Rollback after editing applied migration = %v, want ErrChecksumMismatch", err)
+	}
+}
+
+func TestAppliedMigrationMissing(t *testing.T) {
+	conn := openTestDB(t)
+	dir := writeMigrations(t, map[string]string{
+		"001_a.sql": "CREATE TABLE a (id INTEGER);",
+		"002_b.sql": "CREATE TABLE b (id INTEGER);",
+	})
+	if err := Apply(conn, dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "002_b.sql")); err != nil {
+		t.Fatal(err)
+	}
+	if err := Apply(conn, dir); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Fatalf("Apply = %v, want missing migration error", err)
+	}
+}
+
+func TestRollbackWithoutDown(t *testing.T) {
+	conn := openTestDB(t)
+	dir := writeMigrations(t, map[string]string{"001_a.sql": "CREATE TABLE a (id INTEGER);"})
+	if err := Apply(conn, dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Rollback(conn, dir); !errors.Is(err, ErrNoDownMigration) {
+		t.Fatalf("Rollback = %v, want ErrNoDownMigration", err)
+	}
+}
+
+func TestLoadRejectsBadFiles(t *testing.T) {
+	cases := map[string]map[string]string{
+		"duplicate version": {"001_a.sql": "", "001_b.sql": ""},
+		"down without up":   {"001_a.down.sql": ""},
+		"bad name":          {"first.sql": ""},
+		"zero version":      {"000_a.sql": ""},
+	}
+	for name, files := range cases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Load(writeMigrations(t, files)); err == nil {
+				t.Fatal("Load accepted invalid migrations")
+			}
+		})
+	}
+}
+
+// TestRepositoryMigrationsRoundTrip applies the shipped migrations, rolls all
+// of them back and applies them again, so every down file must undo its up
+// file cleanly.
+func TestRepositoryMigrationsRoundTrip(t *testing.T) {
+	conn := openTestDB(t)
+	dir := filepath.Join("..", "..", "migrations")
+	if err := Apply(conn, dir); err != nil {
+		t.Fatal(err)
+	}
+	all, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range all[1:] {
+		if _, err := Rollback(conn, dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := appliedVersions(t, conn, dir); len(got) != 1 || got[0] != 1 {
+		t.Fatalf("applied after rolling back = %v, want [1]", got)
+	}
+	if err := Apply(conn, dir); err != nil {
+		t.Fatalf("re-apply: %v", err)
+	}
+	if got := appliedVersions(t, conn, dir); len(got) != len(all) {
+		t.Fatalf("applied %d of %d migrations", len(got), len(all))
+	}
+}
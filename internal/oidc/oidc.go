@@ -0,0 +1,264 @@
+// Package oidc implements the relying-party side of the OpenID Connect
+// authorization code flow with PKCE.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// discoveryTTL is how long a provider's discovery document and keys are
+// reused before being fetched again.
+const discoveryTTL = time.Hour
+
+// maxResponseSize bounds responses read from the provider.
+const maxResponseSize = 1 << 20
+
+// Config identifies the client at one provider.
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// Client talks to OpenID providers, caching their discovery documents and
+// signing keys per issuer.
+type Client struct {
+	http *http.Client
+
+	mu        sync.Mutex
+	providers map[string]*provider
+}
+
+type provider struct {
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	Issuer                string `json:"issuer"`
+
+	fetched time.Time
+	keys    map[string]any
+}
+
+// NewClient returns a client whose requests time out after timeout.
+func NewClient(timeout time.Duration) *Client {
+	return &Client{
+		http:      &http.Client{Timeout: timeout},
+		providers: make(map[string]*provider),
+	}
+}
+
+// NewVerifier returns a random PKCE code verifier, also usable as state or
+// nonce.
+func NewVerifier() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Challenge returns the S256 PKCE challenge for verifier.
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthURL returns the provider URL the browser is sent to for login.
+func (c *Client) AuthURL(ctx context.Context, cfg Config, state, nonce, verifier string) (string, error) {
+	p, err := c.provider(ctx, cfg.Issuer)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(p.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", cfg.ClientID)
+	q.Set("redirect_uri", cfg.RedirectURL)
+	q.Set("scope", strings.Join(cfg.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", Challenge(verifier))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Exchange redeems an authorization code and returns the verified claims of
+// the ID token, which must carry nonce.
+func (c *Client) Exchange(ctx context.Context, cfg Config, code, verifier, nonce string) (map[string]any, error) {
+	p, err := c.provider(ctx, cfg.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", cfg.RedirectURL)
+	form.Set("code_verifier", verifier)
+	if cfg.ClientSecret == "" {
+		form.Set("client_id", cfg.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(cfg.ClientID), url.QueryEscape(cfg.ClientSecret))
+	}
+
+	var tok struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := c.do(req, &tok); err != nil {
+		if tok.Error != "" {
+			return nil, fmt.Errorf("token endpoint: %s %s", tok.Error, tok.ErrorDescription)
+		}
+		return nil, err
+	}
+	if tok.IDToken == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+	return c.verifyIDToken(ctx, cfg, p, tok.IDToken, nonce)
+}
+
+func (c *Client) verifyIDToken(ctx context.Context, cfg Config, p *provider, raw, nonce string) (map[string]any, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return c.key(ctx, cfg.Issuer, p, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}),
+		jwt.WithIssuer(p.Issuer),
+		jwt.WithAudience(cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if got, _ := claims["nonce"].(string); got == "" || got != nonce {
+		return nil, errors.New("id_token nonce mismatch")
+	}
+	// With several audiences the token must name us as the authorized party.
+	if aud, ok := claims["aud"].([]any); ok && len(aud) > 1 {
+		if azp, _ := claims["azp"].(string); azp != cfg.ClientID {
+			return nil, errors.New("id_token azp mismatch")
+		}
+	}
+	return claims, nil
+}
+
+// provider returns the cached discovery document of issuer, fetching it when
+// missing or stale.
+func (c *Client) provider(ctx context.Context, issuer string) (*provider, error) {
+	issuer = strings.TrimSuffix(issuer, "/")
+	if issuer == "" {
+		return nil, errors.New("issuer not configured")
+	}
+	c.mu.Lock()
+	p := c.providers[issuer]
+	c.mu.Unlock()
+	if p != nil && time.Since(p.fetched) < discoveryTTL {
+		return p, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	p = &provider{}
+	if err := c.do(req, p); err != nil {
+		return nil, fmt.Errorf("discovery: %w", err)
+	}
+	if strings.TrimSuffix(p.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("discovery: issuer %q does not match %q", p.Issuer, issuer)
+	}
+	if p.AuthorizationEndpoint == "" || p.TokenEndpoint == "" || p.JWKSURI == "" {
+		return nil, errors.New("discovery: missing endpoints")
+	}
+	p.fetched = time.Now()
+	c.mu.Lock()
+	c.providers[issuer] = p
+	c.mu.Unlock()
+	return p, nil
+}
+
+// key returns the signing key kid of p. Unknown keys trigger one refetch of
+// the key set so rotated keys are picked up.
+func (c *Client) key(ctx context.Context, issuer string, p *provider, kid string) (any, error) {
+	c.mu.Lock()
+	keys := p.keys
+	c.mu.Unlock()
+	if k := pickKey(keys, kid); k != nil {
+		return k, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set jwkSet
+	if err := c.do(req, &set); err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+	keys = set.parse()
+	c.mu.Lock()
+	p.keys = keys
+	c.mu.Unlock()
+	if k := pickKey(keys, kid); k != nil {
+		return k, nil
+	}
+	return nil, fmt.Errorf("jwks: no key %q", kid)
+}
+
+// pickKey returns key kid, or the only key when the token names none.
+func pickKey(keys map[string]any, kid string) any {
+	if kid == "" && len(keys) == 1 {
+		for _, k := range keys {
+			return k
+		}
+	}
+	return keys[kid]
+}
+
+func (c *Client) do(req *http.Request, out any) error {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+	// Decode error responses too so callers can report the provider's error.
+	decodeErr := json.Unmarshal(body, out)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return decodeErr
+}
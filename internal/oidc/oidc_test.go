@@ -0,0 +1,237 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testClientID = "panel"
+	testKeyID    = "key-1"
+	testNonce    = "nonce-1"
+	testVerifier = "verifier-1"
+	testCode     = "code-1"
+)
+
+// mockIssuer is an OpenID provider serving discovery, keys and a token
+// endpoint that returns whatever ID token the test sets.
+type mockIssuer struct {
+	*httptest.Server
+	key     *rsa.PrivateKey
+	idToken string
+	// tokenRequest is the last form posted to the token endpoint.
+	tokenRequest url.Values
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockIssuer{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.URL,
+			"authorization_endpoint": m.URL + "/authorize",
+			"token_endpoint":         m.URL + "/token",
+			"jwks_uri":               m.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": testKeyID,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		m.tokenRequest = r.PostForm
+		if r.PostForm.Get("code") != testCode {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": m.idToken})
+	})
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+func (m *mockIssuer) config() Config {
+	return Config{Issuer: m.URL, ClientID: testClientID, RedirectURL: "https://panel.example/callback", Scopes: []string{"openid"}}
+}
+
+func (m *mockIssuer) claims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":   m.URL,
+		"sub":   "subject-1",
+		"aud":   testClientID,
+		"exp":   now.Add(time.Hour).Unix(),
+		"iat":   now.Unix(),
+		"nonce": testNonce,
+	}
+}
+
+func (m *mockIssuer) sign(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestExchange(t *testing.T) {
+	m := newMockIssuer(t)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		key     *rsa.PrivateKey
+		kid     string
+		claims  func(jwt.MapClaims)
+		nonce   string
+		wantErr string
+	}{
+		{name: "valid"},
+		{name: "bad signature", key: otherKey, wantErr: "signature"},
+		{name: "unknown kid", kid: "key-2", wantErr: `no key "key-2"`},
+		{name: "wrong audience", claims: func(c jwt.MapClaims) { c["aud"] = "someone-else" }, wantErr: "aud"},
+		{name: "wrong issuer", claims: func(c jwt.MapClaims) { c["iss"] = "https://evil.example" }, wantErr: "iss"},
+		{name: "expired", claims: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, wantErr: "expired"},
+		{name: "missing expiry", claims: func(c jwt.MapClaims) { delete(c, "exp") }, wantErr: "exp"},
+		{name: "nonce mismatch", nonce: "nonce-2", wantErr: "nonce mismatch"},
+		{name: "missing nonce", claims: func(c jwt.MapClaims) { delete(c, "nonce") }, wantErr: "nonce mismatch"},
+		{
+			name:    "several audiences without azp",
+			claims:  func(c jwt.MapClaims) { c["aud"] = []string{testClientID, "other"} },
+			wantErr: "azp mismatch",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			key, kid, nonce := m.key, testKeyID, testNonce
+			if tc.key != nil {
+				key = tc.key
+			}
+			if tc.kid != "" {
+				kid = tc.kid
+			}
+			if tc.nonce != "" {
+				nonce = tc.nonce
+			}
+			claims := m.claims()
+			if tc.claims != nil {
+				tc.claims(claims)
+			}
+			m.idToken = m.sign(t, key, kid, claims)
+
+			got, err := NewClient(5*time.Second).Exchange(context.Background(), m.config(), testCode, testVerifier, nonce)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("Exchange error = %v, want one containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Exchange: %v", err)
+			}
+			if got["sub"] != "subject-1" {
+				t.Fatalf("sub = %v", got["sub"])
+			}
+			if v := m.tokenRequest.Get("code_verifier"); v != testVerifier {
+				t.Fatalf("code_verifier = %q", v)
+			}
+			if v := m.tokenRequest.Get("client_id"); v != testClientID {
+				t.Fatalf("client_id = %q", v)
+			}
+		})
+	}
+}
+
+func TestExchangeRejectedCode(t *testing.T) {
+	m := newMockIssuer(t)
+	_, err := NewClient(5*time.Second).Exchange(context.Background(), m.config(), "wrong", testVerifier, testNonce)
+	if err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Fatalf("Exchange error = %v, want invalid_grant", err)
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	m := newMockIssuer(t)
+	cfg := m.config()
+	// The same server under another name reports an issuer that differs
+	// from the configured one.
+	cfg.Issuer = strings.Replace(m.URL, "127.0.0.1", "localhost", 1)
+	_, err := NewClient(5*time.Second).AuthURL(context.Background(), cfg, "state", testNonce, testVerifier)
+	if err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("AuthURL error = %v, want issuer mismatch", err)
+	}
+}
+
+func TestAuthURL(t *testing.T) {
+	m := newMockIssuer(t)
+	raw, err := NewClient(5*time.Second).AuthURL(context.Background(), m.config(), "state-1", testNonce, testVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             testClientID,
+		"state":                 "state-1",
+		"nonce":                 testNonce,
+		"code_challenge":        Challenge(testVerifier),
+		"code_challenge_method": "S256",
+	}
+	for k, v := range want {
+		if got := q.Get(k); got != v {
+			t.Errorf("%s = %q, want %q", k, got, v)
+		}
+	}
+	if u.Path != "/authorize" {
+		t.Errorf("path = %q", u.Path)
+	}
+}
+
+func TestPendingStoreTakeOnce(t *testing.T) {
+	s := NewPendingStore(time.Minute, 10)
+	if err := s.Put("state", Pending{Nonce: testNonce, Verifier: testVerifier}); err != nil {
+		t.Fatal(err)
+	}
+	p, ok := s.Take("state")
+	if !ok || p.Nonce != testNonce {
+		t.Fatalf("Take = %+v, %v", p, ok)
+	}
+	if _, ok := s.Take("state"); ok {
+		t.Fatal("state taken twice")
+	}
+}
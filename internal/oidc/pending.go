@@ -0,0 +1,64 @@
+package oidc
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrTooManyPending is returned when too many logins are in progress.
+var ErrTooManyPending = errors.New("too many pending logins")
+
+// Pending is a login that was sent to the provider and awaits its callback.
+type Pending struct {
+	Verifier string
+	Nonce    string
+	expires  time.Time
+}
+
+// PendingStore keeps started logins by state until their callback arrives or
+// they expire. Each state can be taken once.
+type PendingStore struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	max     int
+	entries map[string]Pending
+}
+
+// NewPendingStore returns a store whose logins expire after ttl, holding at
+// most max of them at a time.
+func NewPendingStore(ttl time.Duration, max int) *PendingStore {
+	return &PendingStore{ttl: ttl, max: max, entries: make(map[string]Pending)}
+}
+
+// Put records a login under state.
+func (s *PendingStore) Put(state string, p Pending) error {
+	now := time.Now()
+	p.expires = now.Add(s.ttl)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.entries) >= s.max {
+		for k, e := range s.entries {
+			if now.After(e.expires) {
+				delete(s.entries, k)
+			}
+		}
+		if len(s.entries) >= s.max {
+			return ErrTooManyPending
+		}
+	}
+	s.entries[state] = p
+	return nil
+}
+
+// Take removes and returns the login recorded under state.
+func (s *PendingStore) Take(state string) (Pending, bool) {
+	s.mu.Lock()
+	p, ok := s.entries[state]
+	delete(s.entries, state)
+	s.mu.Unlock()
+	if !ok || time.Now().After(p.expires) {
+		return Pending{}, false
+	}
+	return p, true
+}
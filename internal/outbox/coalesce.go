@@ -0,0 +1,84 @@
+package outbox
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// ServiceKey identifies the gost object a command acts on by its kind and the
+// names of the services, chain or limiter involved, e.g. "service:1_2_3_tcp,1_2_3_udp".
+// Pending commands with the same key on the same node may supersede each
+// other; commands with an empty key are never merged.
+func ServiceKey(action string, data json.RawMessage) string {
+	var kind string
+	switch {
+	case strings.HasSuffix(action, "Service"):
+		kind = "service"
+	case strings.HasSuffix(action, "Chains"):
+		kind = "chain"
+	case strings.HasSuffix(action, "Limiters"):
+		kind = "limiter"
+	default:
+		return ""
+	}
+
+	var names []string
+	var list []struct {
+		Name string `json:"name"`
+	}
+	var obj struct {
+		Name     string   `json:"name"`
+		Services []string `json:"services"`
+		Chain    string   `json:"chain"`
+		Limiter  string   `json:"limiter"`
+	}
+	if json.Unmarshal(data, &list) == nil {
+		for _, item := range list {
+			names = append(names, item.Name)
+		}
+	} else if json.Unmarshal(data, &obj) == nil {
+		names = append(names, obj.Services...)
+		for _, name := range []string{obj.Chain, obj.Limiter, obj.Name} {
+			if name != "" {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	for _, name := range names {
+		if name == "" {
+			return ""
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+	return kind + ":" + strings.Join(names, ",")
+}
+
+// Supersedes reports whether a pending command may be dropped because the
+// next pending command for the same object replaces its effect. Adds are
+// never dropped, so updates queued behind them still find the object.
+func Supersedes(earlier, later string) bool {
+	e, l := verb(earlier), verb(later)
+	switch l {
+	case "Update":
+		return e == "Update"
+	case "Delete":
+		return e == "Update" || e == "Delete" || e == "Pause" || e == "Resume"
+	case "Pause", "Resume":
+		return e == "Pause" || e == "Resume"
+	}
+	return false
+}
+
+func verb(action string) string {
+	for _, v := range []string{"Add", "Update", "Delete", "Pause", "Resume"} {
+		if strings.HasPrefix(action, v) {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,191 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"pixia-panel/internal/db"
+	"pixia-panel/internal/migrate"
+	"pixia-panel/internal/store"
+)
+
+func TestServiceKey(t *testing.T) {
+	cases := []struct {
+		action string
+		data   string
+		want   string
+	}{
+		{"AddService", `[{"name":"1_2_3_udp"},{"name":"1_2_3_tcp"}]`, "service:1_2_3_tcp,1_2_3_udp"},
+		{"UpdateService", `[{"name":"1_2_3_tcp"}]`, "service:1_2_3_tcp"},
+		{"DeleteService", `{"services":["1_2_3_udp","1_2_3_tcp"]}`, "service:1_2_3_tcp,1_2_3_udp"},
+		{"PauseService", `{"services":["1_2_3_tcp"]}`, "service:1_2_3_tcp"},
+		{"AddChains", `{"name":"1_2_chains"}`, "chain:1_2_chains"},
+		{"DeleteChains", `{"chain":"1_2_chains"}`, "chain:1_2_chains"},
+		{"AddLimiters", `{"name":"7"}`, "limiter:7"},
+		{"DeleteLimiters", `{"limiter":"7"}`, "limiter:7"},
+		{"AddService", `[{"name":"1_2_3_tcp"},{"name":""}]`, ""},
+		{"DeleteService", `{"services":[]}`, ""},
+		{"AddService", `not json`, ""},
+		{"Reload", `{"name":"x"}`, ""},
+	}
+	for _, tc := range cases {
+		if got := ServiceKey(tc.action, json.RawMessage(tc.data)); got != tc.want {
+			t.Errorf("ServiceKey(%s, %s) = %q, want %q", tc.action, tc.data, got, tc.want)
+		}
+	}
+}
+
+func TestSupersedes(t *testing.T) {
+	cases := []struct {
+		earlier, later string
+		want           bool
+	}{
+		{"UpdateService", "UpdateService", true},
+		{"AddService", "UpdateService", false},
+		{"PauseService", "UpdateService", false},
+		{"UpdateService", "DeleteService", true},
+		{"PauseService", "DeleteService", true},
+		{"ResumeService", "DeleteService", true},
+		{"DeleteService", "DeleteService", true},
+		{"AddService", "DeleteService", false},
+		{"PauseService", "ResumeService", true},
+		{"ResumeService", "PauseService", true},
+		{"UpdateService", "PauseService", false},
+		{"DeleteService", "AddService", false},
+		{"AddChains", "DeleteChains", false},
+	}
+	for _, tc := range cases {
+		if got := Supersedes(tc.earlier, tc.later); got != tc.want {
+			t.Errorf("Supersedes(%s, %s) = %v, want %v", tc.earlier, tc.later, got, tc.want)
+		}
+	}
+}
+
+func newTestStore(t *testing.T) *store.Store {
+	t.Helper()
+	conn, err := db.Open(filepath.Join(t.TempDir(), "panel.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if err := migrate.Apply(conn, filepath.Join("..", "..", "migrations")); err != nil {
+		t.Fatal(err)
+	}
+	return store.New(conn)
+}
+
+func enqueue(t *testing.T, st *store.Store, nodeID int64, action, data string) {
+	t.Helper()
+	msg := GostMessage{NodeID: nodeID, Action: action, Data: json.RawMessage(data)}
+	if err := Enqueue(context.Background(), st, 0, msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// claimAll claims batches until none is left, completing each batch before
+// the next, and returns the claimed IDs in claim order.
+func claimAll(t *testing.T, st *store.Store, limit int) [][]int64 {
+	t.Helper()
+	ctx := context.Background()
+	var batches [][]int64
+	for {
+		items, err := st.ClaimNextOutboxBatch(ctx, limit, Supersedes)
+		if errors.Is(err, store.ErrNotFound) {
+			return batches
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []int64
+		for _, item := range items {
+			ids = append(ids, item.ID)
+			if err := st.MarkOutboxSuccess(ctx, item.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+		batches = append(batches, ids)
+	}
+}
+
+func TestClaimOrderPerNode(t *testing.T) {
+	st := newTestStore(t)
+	enqueue(t, st, 1, "AddService", `[{"name":"a"}]`)        // 1
+	enqueue(t, st, 1, "AddService", `[{"name":"b"}]`)        // 2
+	enqueue(t, st, 2, "AddService", `[{"name":"a"}]`)        // 3
+	enqueue(t, st, 1, "DeleteService", `{"services":["a"]}`) // 4
+	enqueue(t, st, 2, "AddService", `[{"name":"b"}]`)        // 5
+
+	got := claimAll(t, st, 10)
+	want := [][]int64{{1, 3}, {2, 5}, {4}}
+	if !equalBatches(got, want) {
+		t.Fatalf("claimed %v, want %v", got, want)
+	}
+}
+
+func TestClaimHeldBackByRetry(t *testing.T) {
+	st := newTestStore(t)
+	ctx := context.Background()
+	enqueue(t, st, 1, "AddService", `[{"name":"a"}]`)
+	enqueue(t, st, 1, "AddService", `[{"name":"b"}]`)
+
+	item, err := st.ClaimNextOutbox(ctx, Supersedes)
+	if err != nil || item.ID != 1 {
+		t.Fatalf("first claim = %+v, %v", item, err)
+	}
+	if _, err := st.ClaimNextOutbox(ctx, Supersedes); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("claim while 1 is processing = %v, want ErrNotFound", err)
+	}
+	if err := st.MarkOutboxFailed(ctx, item.ID, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.ClaimNextOutbox(ctx, Supersedes); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("claim while 1 waits for its retry = %v, want ErrNotFound", err)
+	}
+}
+
+func TestClaimSupersedes(t *testing.T) {
+	st := newTestStore(t)
+	ctx := context.Background()
+	enqueue(t, st, 1, "AddService", `[{"name":"a"}]`)        // 1: kept, adds are never dropped
+	enqueue(t, st, 1, "UpdateService", `[{"name":"a"}]`)     // 2: superseded by 3
+	enqueue(t, st, 1, "UpdateService", `[{"name":"a"}]`)     // 3: superseded by 4
+	enqueue(t, st, 1, "DeleteService", `{"services":["a"]}`) // 4
+	enqueue(t, st, 1, "UpdateService", `[{"name":"b"}]`)     // 5: another key
+	enqueue(t, st, 2, "UpdateService", `[{"name":"a"}]`)     // 6: another node
+
+	got := claimAll(t, st, 10)
+	want := [][]int64{{1, 6}, {4}, {5}}
+	if !equalBatches(got, want) {
+		t.Fatalf("claimed %v, want %v", got, want)
+	}
+	for _, id := range []int64{2, 3} {
+		item, err := st.GetOutboxItem(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item.Status != store.OutboxSuperseded {
+			t.Errorf("item %d status = %s, want %s", id, item.Status, store.OutboxSuperseded)
+		}
+	}
+}
+
+func equalBatches(a, b [][]int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if len(a[i]) != len(b[i]) {
+			return false
+		}
+		for j := range a[i] {
+			if a[i][j] != b[i][j] {
+				return false
+			}
+		}
+	}
+	return true
+}
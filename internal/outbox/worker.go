@@ -78,6 +78,26 @@ type GostMessage struct {
 
 const commandResponseTimeout = 10 * time.Second
 
+// Enqueue queues msg for its node. A non-zero forwardID links the command to
+// that forward's lifecycle.
+func Enqueue(ctx context.Context, st *store.Store, forwardID int64, msg GostMessage) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	item := &store.OutboxItem{
+		NodeID:     msg.NodeID,
+		ServiceKey: ServiceKey(msg.Action, msg.Data),
+		Type:       msg.Action,
+		Payload:    payload,
+	}
+	if forwardID > 0 {
+		item.ForwardID = &forwardID
+	}
+	_, err = st.EnqueueOutbox(ctx, item)
+	return err
+}
+
 func (w *Worker) Run(ctx context.Context) {
 	ticker := time.NewTicker(w.interval)
 	defer ticker.Stop()
@@ -92,20 +112,23 @@ func (w *Worker) Run(ctx context.Context) {
 	}
 }
 
+// processOnce delivers due items until none are left. Each claim hands out
+// at most one item per node, so a node's commands go out strictly in order.
 func (w *Worker) processOnce(ctx context.Context) {
 	w.requeueStaleProcessing(ctx)
 
-	items, err := w.store.ClaimNextOutboxBatch(ctx, w.batchSize)
-	if err != nil {
-		if err == store.ErrNotFound {
+	for ctx.Err() == nil {
+		items, err := w.store.ClaimNextOutboxBatch(ctx, w.batchSize, Supersedes)
+		if err != nil {
+			if err != store.ErrNotFound {
+				log.Printf("outbox claim error: %v", err)
+			}
 			return
 		}
-		log.Printf("outbox claim error: %v", err)
-		return
-	}
 
-	for i := range items {
-		w.processItem(ctx, &items[i])
+		for i := range items {
+			w.processItem(ctx, &items[i])
+		}
 	}
 }
 
@@ -117,54 +140,102 @@ func (w *Worker) processItem(ctx context.Context, item *store.OutboxItem) {
 	var msg GostMessage
 	if err := json.Unmarshal(item.Payload, &msg); err != nil {
 		log.Printf("outbox payload invalid: %v", err)
+		w.recordAttempt(ctx, item, "invalid payload")
 		_ = w.store.MarkOutboxDead(ctx, item.ID, false)
+		w.failForward(ctx, item, "invalid payload", true)
 		return
 	}
 
 	exists, err := w.store.NodeExists(ctx, msg.NodeID)
 	if err != nil {
 		log.Printf("outbox node lookup failed: node_id=%d err=%v", msg.NodeID, err)
-		w.markFailed(ctx, item)
+		w.markFailed(ctx, item, err.Error())
 		return
 	}
 	if !exists {
 		log.Printf("outbox node missing, mark dead: node_id=%d action=%s", msg.NodeID, msg.Action)
+		w.recordAttempt(ctx, item, "node not found")
 		_ = w.store.MarkOutboxDead(ctx, item.ID, false)
+		w.failForward(ctx, item, "node not found", true)
 		return
 	}
 
 	resp, err := w.hub.SendAndWait(ctx, msg.NodeID, msg.Action, msg.Data, commandResponseTimeout)
 	if err != nil {
 		log.Printf("gost send failed: %v", err)
-		w.markFailed(ctx, item)
+		w.markFailed(ctx, item, err.Error())
 		return
 	}
 
 	if !resp.Success {
 		if shouldAcknowledgeAsSuccess(msg.Action, resp.Message) {
-			_ = w.store.MarkOutboxSuccess(ctx, item.ID)
+			w.markSuccess(ctx, item)
 			return
 		}
 		log.Printf("gost command failed: action=%s node_id=%d message=%s", msg.Action, msg.NodeID, resp.Message)
-		w.markFailed(ctx, item)
+		w.markFailed(ctx, item, resp.Message)
 		return
 	}
 
+	w.markSuccess(ctx, item)
+}
+
+func (w *Worker) markSuccess(ctx context.Context, item *store.OutboxItem) {
 	_ = w.store.MarkOutboxSuccess(ctx, item.ID)
+	if item.ForwardID == nil {
+		return
+	}
+	if err := w.store.CompleteForwardLifecycle(ctx, *item.ForwardID); err != nil {
+		log.Printf("forward lifecycle update failed: forward_id=%d err=%v", *item.ForwardID, err)
+	}
 }
 
-func (w *Worker) markFailed(ctx context.Context, item *store.OutboxItem) {
+func (w *Worker) markFailed(ctx context.Context, item *store.OutboxItem, reason string) {
 	if item == nil {
 		return
 	}
+	w.recordAttempt(ctx, item, reason)
 
 	if w.maxRetries > 0 && item.RetryCount+1 >= w.maxRetries {
 		_ = w.store.MarkOutboxDead(ctx, item.ID, true)
+		w.failForward(ctx, item, reason, true)
 		return
 	}
 
 	delay := w.retryDelay(item.RetryCount)
 	_ = w.store.MarkOutboxFailed(ctx, item.ID, delay)
+	w.failForward(ctx, item, reason, false)
+}
+
+// recordAttempt keeps the reason of a failed delivery in the item's history.
+func (w *Worker) recordAttempt(ctx context.Context, item *store.OutboxItem, reason string) {
+	if reason == "" {
+		reason = "unknown error"
+	}
+	if err := w.store.RecordOutboxAttempt(ctx, item.ID, item.RetryCount+1, reason); err != nil {
+		log.Printf("outbox attempt record failed: id=%d err=%v", item.ID, err)
+	}
+}
+
+// failForward records the failure on the linked forward; dead items move it to failed.
+func (w *Worker) failForward(ctx context.Context, item *store.OutboxItem, reason string, dead bool) {
+	if item.ForwardID == nil {
+		return
+	}
+	if reason == "" {
+		reason = "unknown error"
+	}
+	reason = item.Type + ": " + reason
+
+	var err error
+	if dead {
+		err = w.store.FailForwardLifecycle(ctx, *item.ForwardID, reason)
+	} else {
+		err = w.store.SetForwardLastError(ctx, *item.ForwardID, reason)
+	}
+	if err != nil {
+		log.Printf("forward lifecycle update failed: forward_id=%d err=%v", *item.ForwardID, err)
+	}
 }
 
 func (w *Worker) retryDelay(retryCount int64) time.Duration {
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+const apiTokenColumns = `id, user_id, name, token_hash, prefix, scopes, exp_time, last_used_time, last_used_ip, created_time, revoked_time`
+
+func (s *Store) InsertAPIToken(ctx context.Context, token *APIToken) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `INSERT INTO api_token(user_id, name, token_hash, prefix, scopes, exp_time, created_time) VALUES(?, ?, ?, ?, ?, ?, ?)`,
+		token.UserID, token.Name, token.TokenHash, token.Prefix, strings.Join(token.Scopes, ","), token.ExpTime, token.CreatedTime)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (s *Store) GetAPITokenByID(ctx context.Context, id int64) (*APIToken, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+apiTokenColumns+` FROM api_token WHERE id = ?`, id)
+	return scanAPIToken(row)
+}
+
+func (s *Store) GetAPITokenByHash(ctx context.Context, hash string) (*APIToken, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+apiTokenColumns+` FROM api_token WHERE token_hash = ?`, hash)
+	return scanAPIToken(row)
+}
+
+// ListAPITokens returns the tokens of userID, or of all users when userID is 0.
+func (s *Store) ListAPITokens(ctx context.Context, userID int64) ([]APIToken, error) {
+	query := `SELECT ` + apiTokenColumns + ` FROM api_token`
+	var args []any
+	if userID != 0 {
+		query += ` WHERE user_id = ?`
+		args = append(args, userID)
+	}
+	rows, err := s.db.QueryContext(ctx, query+` ORDER BY id DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []APIToken
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *token)
+	}
+	return list, rows.Err()
+}
+
+func (s *Store) RevokeAPIToken(ctx context.Context, id, revoked int64) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE api_token SET revoked_time = ? WHERE id = ? AND revoked_time IS NULL`, revoked, id)
+	return err
+}
+
+func (s *Store) TouchAPIToken(ctx context.Context, id, used int64, ip string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE api_token SET last_used_time = ?, last_used_ip = ? WHERE id = ?`, used, ip, id)
+	return err
+}
+
+func scanAPIToken(scanner interface{ Scan(dest ...any) error }) (*APIToken, error) {
+	var token APIToken
+	var scopes string
+	var lastUsed, revoked sql.NullInt64
+	var lastIP sql.NullString
+	if err := scanner.Scan(&token.ID, &token.UserID, &token.Name, &token.TokenHash, &token.Prefix, &scopes, &token.ExpTime, &lastUsed, &lastIP, &token.CreatedTime, &revoked); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if scopes != "" {
+		token.Scopes = strings.Split(scopes, ",")
+	}
+	if lastUsed.Valid {
+		token.LastUsedTime = &lastUsed.Int64
+	}
+	if lastIP.Valid {
+		token.LastUsedIP = &lastIP.String
+	}
+	if revoked.Valid {
+		token.RevokedTime = &revoked.Int64
+	}
+	return &token, nil
+}
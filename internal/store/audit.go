@@ -0,0 +1,89 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+)
+
+// AuditFilter selects audit entries. Zero values are ignored; End is exclusive.
+type AuditFilter struct {
+	ActorID    int64
+	EntityType string
+	EntityID   int64
+	Action     string
+	Start      int64
+	End        int64
+	Limit      int
+	Offset     int
+}
+
+func (s *Store) InsertAuditLog(ctx context.Context, entry *AuditLog) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO audit_log(actor_id, actor_role, client_ip, action, entity_type, entity_id, before_json, after_json, created_time)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.ActorID, entry.ActorRole, entry.ClientIP, entry.Action, entry.EntityType, entry.EntityID, entry.Before, entry.After, entry.CreatedTime)
+	return err
+}
+
+// ListAuditLogs returns matching entries newest first and the total match count.
+func (s *Store) ListAuditLogs(ctx context.Context, filter AuditFilter) ([]AuditLog, int64, error) {
+	var where []string
+	var args []any
+	if filter.ActorID != 0 {
+		where = append(where, "actor_id = ?")
+		args = append(args, filter.ActorID)
+	}
+	if filter.EntityType != "" {
+		where = append(where, "entity_type = ?")
+		args = append(args, filter.EntityType)
+	}
+	if filter.EntityID != 0 {
+		where = append(where, "entity_id = ?")
+		args = append(args, filter.EntityID)
+	}
+	if filter.Action != "" {
+		where = append(where, "action = ?")
+		args = append(args, filter.Action)
+	}
+	if filter.Start != 0 {
+		where = append(where, "created_time >= ?")
+		args = append(args, filter.Start)
+	}
+	if filter.End != 0 {
+		where = append(where, "created_time < ?")
+		args = append(args, filter.End)
+	}
+	clause := ""
+	if len(where) > 0 {
+		clause = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	var total int64
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(1) FROM audit_log`+clause, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT id, actor_id, actor_role, client_ip, action, entity_type, entity_id, before_json, after_json, created_time FROM audit_log`+clause+` ORDER BY id DESC LIMIT ? OFFSET ?`,
+		append(args, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var list []AuditLog
+	for rows.Next() {
+		var entry AuditLog
+		var before, after sql.NullString
+		if err := rows.Scan(&entry.ID, &entry.ActorID, &entry.ActorRole, &entry.ClientIP, &entry.Action, &entry.EntityType, &entry.EntityID, &before, &after, &entry.CreatedTime); err != nil {
+			return nil, 0, err
+		}
+		if before.Valid {
+			entry.Before = &before.String
+		}
+		if after.Valid {
+			entry.After = &after.String
+		}
+		list = append(list, entry)
+	}
+	return list, total, rows.Err()
+}
@@ -0,0 +1,154 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+
+	"modernc.org/sqlite"
+)
+
+var ErrInvalidBackup = errors.New("invalid backup")
+
+// Snapshot writes a consistent copy of the live database to path.
+// The target file must not exist.
+func (s *Store) Snapshot(ctx context.Context, path string) error {
+	_, err := s.db.ExecContext(ctx, `VACUUM INTO ?`, path)
+	return err
+}
+
+// ValidateBackup checks that the SQLite file at path is intact and carries the
+// same tables and applied migrations as the live database.
+func (s *Store) ValidateBackup(ctx context.Context, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	backup, err := sql.Open("sqlite", readOnlyURI(path))
+	if err != nil {
+		return err
+	}
+	defer backup.Close()
+
+	var integrity string
+	if err := backup.QueryRowContext(ctx, `PRAGMA integrity_check`).Scan(&integrity); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	if integrity != "ok" {
+		return fmt.Errorf("%w: integrity check: %s", ErrInvalidBackup, integrity)
+	}
+
+	liveTables, err := listTables(ctx, s.db)
+	if err != nil {
+		return err
+	}
+	backupTables, err := listTables(ctx, backup)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	for name := range liveTables {
+		if _, ok := backupTables[name]; !ok {
+			return fmt.Errorf("%w: missing table %s", ErrInvalidBackup, name)
+		}
+	}
+
+	liveVersions, err := listMigrationChecksums(ctx, s.db)
+	if err != nil {
+		return err
+	}
+	backupVersions, err := listMigrationChecksums(ctx, backup)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	if len(liveVersions) != len(backupVersions) {
+		return fmt.Errorf("%w: schema version mismatch", ErrInvalidBackup)
+	}
+	for version, sum := range liveVersions {
+		if backupVersions[version] != sum {
+			return fmt.Errorf("%w: schema version mismatch at migration %03d", ErrInvalidBackup, version)
+		}
+	}
+	return nil
+}
+
+// Restore validates the backup at path and copies it over the live database
+// using SQLite's online backup API, so open connections see the new content.
+func (s *Store) Restore(ctx context.Context, path string) error {
+	if err := s.ValidateBackup(ctx, path); err != nil {
+		return err
+	}
+
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	defer s.cache.clearAll()
+	return conn.Raw(func(driverConn any) error {
+		restorer, ok := driverConn.(interface {
+			NewRestore(srcURI string) (*sqlite.Backup, error)
+		})
+		if !ok {
+			return errors.New("sqlite driver does not support restore")
+		}
+		bk, err := restorer.NewRestore(readOnlyURI(path))
+		if err != nil {
+			return err
+		}
+		for {
+			more, err := bk.Step(-1)
+			if err != nil {
+				_ = bk.Finish()
+				return err
+			}
+			if !more {
+				break
+			}
+		}
+		return bk.Finish()
+	})
+}
+
+func readOnlyURI(path string) string {
+	return (&url.URL{Scheme: "file", Opaque: path, RawQuery: "mode=ro"}).String()
+}
+
+func listTables(ctx context.Context, db *sql.DB) (map[string]struct{}, error) {
+	rows, err := db.QueryContext(ctx, `SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tables := make(map[string]struct{})
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		tables[name] = struct{}{}
+	}
+	return tables, rows.Err()
+}
+
+func listMigrationChecksums(ctx context.Context, db *sql.DB) (map[int64]string, error) {
+	rows, err := db.QueryContext(ctx, `SELECT version, checksum FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make(map[int64]string)
+	for rows.Next() {
+		var version int64
+		var sum string
+		if err := rows.Scan(&version, &sum); err != nil {
+			return nil, err
+		}
+		versions[version] = sum
+	}
+	return versions, rows.Err()
+}
@@ -0,0 +1,166 @@
+package store
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// CacheStats reports the read-through cache counters of one entity type.
+type CacheStats struct {
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Entries int    `json:"entries"`
+}
+
+// entityCache is a read-through cache of rows keyed by ID. Values are stored
+// and returned by copy so callers may modify what they get back.
+type entityCache[T any] struct {
+	mu     sync.RWMutex
+	items  map[int64]T
+	gen    uint64
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+func newEntityCache[T any]() *entityCache[T] {
+	return &entityCache[T]{items: make(map[int64]T)}
+}
+
+func (c *entityCache[T]) get(id int64, load func() (*T, error)) (*T, error) {
+	c.mu.RLock()
+	v, ok := c.items[id]
+	gen := c.gen
+	c.mu.RUnlock()
+	if ok {
+		c.hits.Add(1)
+		return &v, nil
+	}
+
+	c.misses.Add(1)
+	loaded, err := load()
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	// Skip caching if a write invalidated entries while we were loading.
+	if c.gen == gen {
+		c.items[id] = *loaded
+	}
+	c.mu.Unlock()
+	return loaded, nil
+}
+
+func (c *entityCache[T]) invalidate(ids ...int64) {
+	c.mu.Lock()
+	for _, id := range ids {
+		delete(c.items, id)
+	}
+	c.gen++
+	c.mu.Unlock()
+}
+
+func (c *entityCache[T]) clear() {
+	c.mu.Lock()
+	c.items = make(map[int64]T)
+	c.gen++
+	c.mu.Unlock()
+}
+
+func (c *entityCache[T]) stats() CacheStats {
+	c.mu.RLock()
+	n := len(c.items)
+	c.mu.RUnlock()
+	return CacheStats{Hits: c.hits.Load(), Misses: c.misses.Load(), Entries: n}
+}
+
+// pairCache maps (user_id, tunnel_id) to a user_tunnel ID, caching misses as 0.
+type pairCache struct {
+	mu     sync.RWMutex
+	items  map[[2]int64]int64
+	gen    uint64
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+func (c *pairCache) get(userID, tunnelID int64, load func() (int64, error)) (int64, error) {
+	key := [2]int64{userID, tunnelID}
+	c.mu.RLock()
+	id, ok := c.items[key]
+	gen := c.gen
+	c.mu.RUnlock()
+	if ok {
+		c.hits.Add(1)
+		return id, nil
+	}
+
+	c.misses.Add(1)
+	id, err := load()
+	if err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	if c.gen == gen {
+		c.items[key] = id
+	}
+	c.mu.Unlock()
+	return id, nil
+}
+
+func (c *pairCache) clear() {
+	c.mu.Lock()
+	c.items = make(map[[2]int64]int64)
+	c.gen++
+	c.mu.Unlock()
+}
+
+func (c *pairCache) stats() CacheStats {
+	c.mu.RLock()
+	n := len(c.items)
+	c.mu.RUnlock()
+	return CacheStats{Hits: c.hits.Load(), Misses: c.misses.Load(), Entries: n}
+}
+
+type entityCaches struct {
+	users       *entityCache[User]
+	tunnels     *entityCache[Tunnel]
+	userTunnels *entityCache[UserTunnel]
+	forwards    *entityCache[Forward]
+	nodes       *entityCache[Node]
+	roles       *entityCache[Role]
+	utPairs     *pairCache
+}
+
+func newEntityCaches() *entityCaches {
+	return &entityCaches{
+		users:       newEntityCache[User](),
+		tunnels:     newEntityCache[Tunnel](),
+		userTunnels: newEntityCache[UserTunnel](),
+		forwards:    newEntityCache[Forward](),
+		nodes:       newEntityCache[Node](),
+		roles:       newEntityCache[Role](),
+		utPairs:     &pairCache{items: make(map[[2]int64]int64)},
+	}
+}
+
+func (c *entityCaches) clearAll() {
+	c.users.clear()
+	c.tunnels.clear()
+	c.userTunnels.clear()
+	c.forwards.clear()
+	c.nodes.clear()
+	c.roles.clear()
+	c.utPairs.clear()
+}
+
+// CacheStats returns hit/miss counters of the entity cache keyed by entity name.
+func (s *Store) CacheStats() map[string]CacheStats {
+	return map[string]CacheStats{
+		"user":            s.cache.users.stats(),
+		"tunnel":          s.cache.tunnels.stats(),
+		"userTunnel":      s.cache.userTunnels.stats(),
+		"userTunnelByKey": s.cache.utPairs.stats(),
+		"forward":         s.cache.forwards.stats(),
+		"node":            s.cache.nodes.stats(),
+		"role":            s.cache.roles.stats(),
+	}
+}
@@ -4,8 +4,40 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"time"
 )
 
+// Forward lifecycle states, driven by outbox acknowledgements.
+const (
+	LifecycleCreating = "creating"
+	LifecycleUpdating = "updating"
+	LifecycleActive   = "active"
+	LifecycleFailed   = "failed"
+	LifecyclePaused   = "paused"
+)
+
+// Reasons recorded when a forward is paused. Quota, expiry and parent pauses
+// are lifted automatically once the condition clears; the others stay paused.
+const (
+	PauseReasonUser        = "user"
+	PauseReasonAdmin       = "admin"
+	PauseReasonUserQuota   = "user_quota"
+	PauseReasonTunnelQuota = "tunnel_quota"
+	PauseReasonExpired     = "expired"
+	// PauseReasonParent means a reseller above the user is over quota,
+	// expired or disabled.
+	PauseReasonParent = "parent"
+)
+
+// IsAutoPauseReason reports whether a pause reason is lifted automatically.
+func IsAutoPauseReason(reason string) bool {
+	switch reason {
+	case PauseReasonUserQuota, PauseReasonTunnelQuota, PauseReasonExpired, PauseReasonParent:
+		return true
+	}
+	return false
+}
+
 type ForwardWithTunnel struct {
 	Forward
 	TunnelName string `json:"tunnelName"`
@@ -16,12 +48,14 @@ type ForwardWithTunnel struct {
 }
 
 func (s *Store) GetForwardByID(ctx context.Context, id int64) (*Forward, error) {
-	row := s.db.QueryRowContext(ctx, `SELECT id, user_id, user_name, name, tunnel_id, in_port, out_port, remote_addr, strategy, interface_name, in_flow, out_flow, created_time, updated_time, status, inx, lifecycle FROM forward WHERE id = ?`, id)
-	return scanForward(row)
+	return s.cache.forwards.get(id, func() (*Forward, error) {
+		row := s.db.QueryRowContext(ctx, `SELECT id, user_id, user_name, name, tunnel_id, in_port, out_port, remote_addr, strategy, interface_name, in_flow, out_flow, created_time, updated_time, status, inx, lifecycle, last_error, pause_reason FROM forward WHERE id = ?`, id)
+		return scanForward(row)
+	})
 }
 
 func (s *Store) ListForwardsByUser(ctx context.Context, userID int64) ([]ForwardWithTunnel, error) {
-	rows, err := s.db.QueryContext(ctx, `SELECT f.id, f.user_id, f.user_name, f.name, f.tunnel_id, f.in_port, f.out_port, f.remote_addr, f.strategy, f.interface_name, f.in_flow, f.out_flow, f.created_time, f.updated_time, f.status, f.inx, f.lifecycle,
+	rows, err := s.db.QueryContext(ctx, `SELECT f.id, f.user_id, f.user_name, f.name, f.tunnel_id, f.in_port, f.out_port, f.remote_addr, f.strategy, f.interface_name, f.in_flow, f.out_flow, f.created_time, f.updated_time, f.status, f.inx, f.lifecycle, f.last_error, f.pause_reason,
 		t.name, t.type, t.in_node_id, t.out_node_id, t.in_ip
 		FROM forward f JOIN tunnel t ON f.tunnel_id = t.id WHERE f.user_id = ? ORDER BY f.inx, f.id`, userID)
 	if err != nil {
@@ -32,8 +66,21 @@ func (s *Store) ListForwardsByUser(ctx context.Context, userID int64) ([]Forward
 	return scanForwardWithTunnelRows(rows)
 }
 
+// ListForwardsBySubtree lists the forwards of userID and all of their sub-users.
+func (s *Store) ListForwardsBySubtree(ctx context.Context, userID int64) ([]ForwardWithTunnel, error) {
+	rows, err := s.db.QueryContext(ctx, subtreeCTE+` SELECT f.id, f.user_id, f.user_name, f.name, f.tunnel_id, f.in_port, f.out_port, f.remote_addr, f.strategy, f.interface_name, f.in_flow, f.out_flow, f.created_time, f.updated_time, f.status, f.inx, f.lifecycle, f.last_error, f.pause_reason,
+		t.name, t.type, t.in_node_id, t.out_node_id, t.in_ip
+		FROM forward f JOIN tunnel t ON f.tunnel_id = t.id WHERE f.user_id IN (SELECT id FROM subtree) ORDER BY f.inx, f.id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanForwardWithTunnelRows(rows)
+}
+
 func (s *Store) ListForwardsAll(ctx context.Context) ([]ForwardWithTunnel, error) {
-	rows, err := s.db.QueryContext(ctx, `SELECT f.id, f.user_id, f.user_name, f.name, f.tunnel_id, f.in_port, f.out_port, f.remote_addr, f.strategy, f.interface_name, f.in_flow, f.out_flow, f.created_time, f.updated_time, f.status, f.inx, f.lifecycle,
+	rows, err := s.db.QueryContext(ctx, `SELECT f.id, f.user_id, f.user_name, f.name, f.tunnel_id, f.in_port, f.out_port, f.remote_addr, f.strategy, f.interface_name, f.in_flow, f.out_flow, f.created_time, f.updated_time, f.status, f.inx, f.lifecycle, f.last_error, f.pause_reason,
 		t.name, t.type, t.in_node_id, t.out_node_id, t.in_ip
 		FROM forward f JOIN tunnel t ON f.tunnel_id = t.id ORDER BY f.inx, f.id`)
 	if err != nil {
@@ -45,7 +92,7 @@ func (s *Store) ListForwardsAll(ctx context.Context) ([]ForwardWithTunnel, error
 }
 
 func (s *Store) ListForwardsByTunnel(ctx context.Context, tunnelID int64) ([]Forward, error) {
-	rows, err := s.db.QueryContext(ctx, `SELECT id, user_id, user_name, name, tunnel_id, in_port, out_port, remote_addr, strategy, interface_name, in_flow, out_flow, created_time, updated_time, status, inx, lifecycle FROM forward WHERE tunnel_id = ?`, tunnelID)
+	rows, err := s.db.QueryContext(ctx, `SELECT id, user_id, user_name, name, tunnel_id, in_port, out_port, remote_addr, strategy, interface_name, in_flow, out_flow, created_time, updated_time, status, inx, lifecycle, last_error, pause_reason FROM forward WHERE tunnel_id = ?`, tunnelID)
 	if err != nil {
 		return nil, err
 	}
@@ -74,23 +121,90 @@ func (s *Store) InsertForward(ctx context.Context, forward *Forward) (int64, err
 }
 
 func (s *Store) UpdateForward(ctx context.Context, forward *Forward) error {
-	_, err := s.db.ExecContext(ctx, `UPDATE forward SET user_id = ?, user_name = ?, name = ?, tunnel_id = ?, in_port = ?, out_port = ?, remote_addr = ?, strategy = ?, interface_name = ?, updated_time = ?, status = ?, inx = ?, lifecycle = ? WHERE id = ?`,
-		forward.UserID, forward.UserName, forward.Name, forward.TunnelID, forward.InPort, forward.OutPort, forward.RemoteAddr, forward.Strategy, forward.InterfaceName, forward.UpdatedTime, forward.Status, forward.Inx, forward.Lifecycle, forward.ID)
+	_, err := s.db.ExecContext(ctx, `UPDATE forward SET user_id = ?, user_name = ?, name = ?, tunnel_id = ?, in_port = ?, out_port = ?, remote_addr = ?, strategy = ?, interface_name = ?, updated_time = ?, status = ?, inx = ?, lifecycle = ?, last_error = ? WHERE id = ?`,
+		forward.UserID, forward.UserName, forward.Name, forward.TunnelID, forward.InPort, forward.OutPort, forward.RemoteAddr, forward.Strategy, forward.InterfaceName, forward.UpdatedTime, forward.Status, forward.Inx, forward.Lifecycle, forward.LastError, forward.ID)
+	s.cache.forwards.invalidate(forward.ID)
 	return err
 }
 
 func (s *Store) UpdateForwardStatus(ctx context.Context, id int64, status int64, lifecycle string, updated int64) error {
 	_, err := s.db.ExecContext(ctx, `UPDATE forward SET status = ?, lifecycle = ?, updated_time = ? WHERE id = ?`, status, lifecycle, updated, id)
+	s.cache.forwards.invalidate(id)
+	return err
+}
+
+// CompleteForwardLifecycle moves a creating/updating forward to active once
+// none of its outbox commands are still pending.
+func (s *Store) CompleteForwardLifecycle(ctx context.Context, id int64) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE forward SET lifecycle = ?, last_error = NULL, updated_time = ?
+		WHERE id = ? AND lifecycle IN (?, ?)
+		AND NOT EXISTS (SELECT 1 FROM outbox WHERE forward_id = ? AND status IN ('pending', 'processing'))`,
+		LifecycleActive, time.Now().UnixMilli(), id, LifecycleCreating, LifecycleUpdating, id)
+	s.cache.forwards.invalidate(id)
+	return err
+}
+
+// FailForwardLifecycle marks a creating/updating forward as failed.
+func (s *Store) FailForwardLifecycle(ctx context.Context, id int64, lastError string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE forward SET lifecycle = ?, last_error = ?, updated_time = ? WHERE id = ? AND lifecycle IN (?, ?)`,
+		LifecycleFailed, lastError, time.Now().UnixMilli(), id, LifecycleCreating, LifecycleUpdating)
+	s.cache.forwards.invalidate(id)
 	return err
 }
 
+// SetForwardLastError records a transient apply error without changing lifecycle.
+func (s *Store) SetForwardLastError(ctx context.Context, id int64, lastError string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE forward SET last_error = ? WHERE id = ?`, lastError, id)
+	s.cache.forwards.invalidate(id)
+	return err
+}
+
+// PauseForward marks a forward paused. Automatic reasons never replace a
+// user or admin pause that is already in effect.
+func (s *Store) PauseForward(ctx context.Context, id int64, reason string, updated int64) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE forward SET status = 0, lifecycle = ?, updated_time = ?,
+		pause_reason = CASE WHEN ? AND status = 0 AND pause_reason IN (?, ?) THEN pause_reason ELSE ? END
+		WHERE id = ?`,
+		LifecyclePaused, updated, IsAutoPauseReason(reason), PauseReasonUser, PauseReasonAdmin, reason, id)
+	s.cache.forwards.invalidate(id)
+	return err
+}
+
+// ResumeForward marks a forward active and clears its pause reason.
+func (s *Store) ResumeForward(ctx context.Context, id int64, updated int64) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE forward SET status = 1, lifecycle = ?, pause_reason = '', updated_time = ? WHERE id = ?`, LifecycleActive, updated, id)
+	s.cache.forwards.invalidate(id)
+	return err
+}
+
+// ListAutoPausedForwards lists forwards paused for quota or expiry. userID 0 lists all users.
+func (s *Store) ListAutoPausedForwards(ctx context.Context, userID int64) ([]ForwardWithTunnel, error) {
+	query := `SELECT f.id, f.user_id, f.user_name, f.name, f.tunnel_id, f.in_port, f.out_port, f.remote_addr, f.strategy, f.interface_name, f.in_flow, f.out_flow, f.created_time, f.updated_time, f.status, f.inx, f.lifecycle, f.last_error, f.pause_reason,
+		t.name, t.type, t.in_node_id, t.out_node_id, t.in_ip
+		FROM forward f JOIN tunnel t ON f.tunnel_id = t.id WHERE f.status = 0 AND f.pause_reason IN (?, ?, ?, ?)`
+	args := []any{PauseReasonUserQuota, PauseReasonTunnelQuota, PauseReasonExpired, PauseReasonParent}
+	if userID != 0 {
+		query += " AND f.user_id = ?"
+		args = append(args, userID)
+	}
+	rows, err := s.db.QueryContext(ctx, query+" ORDER BY f.user_id, f.id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanForwardWithTunnelRows(rows)
+}
+
 func (s *Store) UpdateForwardOrder(ctx context.Context, id int64, inx int64) error {
 	_, err := s.db.ExecContext(ctx, `UPDATE forward SET inx = ? WHERE id = ?`, inx, id)
+	s.cache.forwards.invalidate(id)
 	return err
 }
 
 func (s *Store) DeleteForward(ctx context.Context, id int64) error {
 	_, err := s.db.ExecContext(ctx, `DELETE FROM forward WHERE id = ?`, id)
+	s.cache.forwards.invalidate(id)
 	return err
 }
 
@@ -103,6 +217,20 @@ func (s *Store) CountForwardsByUser(ctx context.Context, userID int64) (int64, e
 	return c, nil
 }
 
+// CountSubtreeForwards counts the forwards of userID and all of their
+// sub-users, on tunnelID only unless it is 0.
+func (s *Store) CountSubtreeForwards(ctx context.Context, userID, tunnelID int64) (int64, error) {
+	query := subtreeCTE + ` SELECT COUNT(1) FROM forward WHERE user_id IN (SELECT id FROM subtree)`
+	args := []any{userID}
+	if tunnelID != 0 {
+		query += ` AND tunnel_id = ?`
+		args = append(args, tunnelID)
+	}
+	var c int64
+	err := s.db.QueryRowContext(ctx, query, args...).Scan(&c)
+	return c, err
+}
+
 func (s *Store) CountForwardsByUserTunnel(ctx context.Context, userID, tunnelID int64) (int64, error) {
 	row := s.db.QueryRowContext(ctx, `SELECT COUNT(1) FROM forward WHERE user_id = ? AND tunnel_id = ?`, userID, tunnelID)
 	var c int64
@@ -125,7 +253,8 @@ func scanForward(scanner interface{ Scan(dest ...any) error }) (*Forward, error)
 	var forward Forward
 	var outPort sql.NullInt64
 	var iface sql.NullString
-	if err := scanner.Scan(&forward.ID, &forward.UserID, &forward.UserName, &forward.Name, &forward.TunnelID, &forward.InPort, &outPort, &forward.RemoteAddr, &forward.Strategy, &iface, &forward.InFlow, &forward.OutFlow, &forward.CreatedTime, &forward.UpdatedTime, &forward.Status, &forward.Inx, &forward.Lifecycle); err != nil {
+	var lastError sql.NullString
+	if err := scanner.Scan(&forward.ID, &forward.UserID, &forward.UserName, &forward.Name, &forward.TunnelID, &forward.InPort, &outPort, &forward.RemoteAddr, &forward.Strategy, &iface, &forward.InFlow, &forward.OutFlow, &forward.CreatedTime, &forward.UpdatedTime, &forward.Status, &forward.Inx, &forward.Lifecycle, &lastError, &forward.PauseReason); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNotFound
 		}
@@ -137,6 +266,9 @@ func scanForward(scanner interface{ Scan(dest ...any) error }) (*Forward, error)
 	if iface.Valid {
 		forward.InterfaceName = &iface.String
 	}
+	if lastError.Valid {
+		forward.LastError = &lastError.String
+	}
 	return &forward, nil
 }
 
@@ -146,7 +278,8 @@ func scanForwardWithTunnelRows(rows *sql.Rows) ([]ForwardWithTunnel, error) {
 		var fw ForwardWithTunnel
 		var outPort sql.NullInt64
 		var iface sql.NullString
-		if err := rows.Scan(&fw.ID, &fw.UserID, &fw.UserName, &fw.Name, &fw.TunnelID, &fw.InPort, &outPort, &fw.RemoteAddr, &fw.Strategy, &iface, &fw.InFlow, &fw.OutFlow, &fw.CreatedTime, &fw.UpdatedTime, &fw.Status, &fw.Inx, &fw.Lifecycle,
+		var lastError sql.NullString
+		if err := rows.Scan(&fw.ID, &fw.UserID, &fw.UserName, &fw.Name, &fw.TunnelID, &fw.InPort, &outPort, &fw.RemoteAddr, &fw.Strategy, &iface, &fw.InFlow, &fw.OutFlow, &fw.CreatedTime, &fw.UpdatedTime, &fw.Status, &fw.Inx, &fw.Lifecycle, &lastError, &fw.PauseReason,
 			&fw.TunnelName, &fw.TunnelType, &fw.InNodeID, &fw.OutNodeID, &fw.InIP); err != nil {
 			return nil, err
 		}
@@ -156,6 +289,9 @@ func scanForwardWithTunnelRows(rows *sql.Rows) ([]ForwardWithTunnel, error) {
 		if iface.Valid {
 			fw.InterfaceName = &iface.String
 		}
+		if lastError.Valid {
+			fw.LastError = &lastError.String
+		}
 		list = append(list, fw)
 	}
 	return list, rows.Err()
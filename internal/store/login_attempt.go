@@ -0,0 +1,77 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// Kinds of login attempt subjects.
+const (
+	LoginAttemptIP   = "ip"
+	LoginAttemptUser = "user"
+)
+
+func (s *Store) GetLoginAttempt(ctx context.Context, kind, subject string) (*LoginAttempt, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT kind, subject, failures, last_failure_time, locked_until FROM login_attempt WHERE kind = ? AND subject = ?`, kind, subject)
+	return scanLoginAttempt(row)
+}
+
+// RecordLoginFailure counts a failed login and returns the number of
+// consecutive failures. Counting restarts once neither the last failure nor
+// the lockout reaches back to windowStart.
+func (s *Store) RecordLoginFailure(ctx context.Context, kind, subject string, now, windowStart int64) (int64, error) {
+	var failures int64
+	err := s.db.QueryRowContext(ctx, `INSERT INTO login_attempt(kind, subject, failures, last_failure_time, locked_until) VALUES(?, ?, 1, ?, 0)
+		ON CONFLICT(kind, subject) DO UPDATE SET
+		  failures = CASE WHEN MAX(last_failure_time, locked_until) < ? THEN 1 ELSE failures + 1 END,
+		  last_failure_time = excluded.last_failure_time
+		RETURNING failures`, kind, subject, now, windowStart).Scan(&failures)
+	return failures, err
+}
+
+func (s *Store) LockLogin(ctx context.Context, kind, subject string, until int64) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE login_attempt SET locked_until = ? WHERE kind = ? AND subject = ?`, until, kind, subject)
+	return err
+}
+
+func (s *Store) ClearLoginAttempt(ctx context.Context, kind, subject string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM login_attempt WHERE kind = ? AND subject = ?`, kind, subject)
+	return err
+}
+
+// ListLoginLockouts returns subjects locked out at now, latest lockout first.
+func (s *Store) ListLoginLockouts(ctx context.Context, now int64) ([]LoginAttempt, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT kind, subject, failures, last_failure_time, locked_until FROM login_attempt WHERE locked_until > ? ORDER BY locked_until DESC`, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []LoginAttempt
+	for rows.Next() {
+		attempt, err := scanLoginAttempt(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *attempt)
+	}
+	return list, rows.Err()
+}
+
+// DeleteLoginAttemptsBefore removes subjects idle and unlocked since cutoff.
+func (s *Store) DeleteLoginAttemptsBefore(ctx context.Context, cutoff int64) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM login_attempt WHERE last_failure_time < ? AND locked_until < ?`, cutoff, cutoff)
+	return err
+}
+
+func scanLoginAttempt(scanner interface{ Scan(dest ...any) error }) (*LoginAttempt, error) {
+	var attempt LoginAttempt
+	if err := scanner.Scan(&attempt.Kind, &attempt.Subject, &attempt.Failures, &attempt.LastFailureTime, &attempt.LockedUntil); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &attempt, nil
+}
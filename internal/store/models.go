@@ -9,6 +9,7 @@ type User struct {
 	User          string `json:"user"`
 	Pwd           string `json:"pwd"`
 	RoleID        int64  `json:"roleId"`
+	ParentID      int64  `json:"parentId"`
 	ExpTime       int64  `json:"expTime"`
 	Flow          int64  `json:"flow"`
 	InFlow        int64  `json:"inFlow"`
@@ -18,6 +19,8 @@ type User struct {
 	CreatedTime   int64  `json:"createdTime"`
 	UpdatedTime   *int64 `json:"updatedTime"`
 	Status        int64  `json:"status"`
+	// MustChangePassword restricts the user to changing their password.
+	MustChangePassword bool `json:"mustChangePassword"`
 }
 
 type Node struct {
@@ -35,6 +38,10 @@ type Node struct {
 	CreatedTime int64   `json:"createdTime"`
 	UpdatedTime *int64  `json:"updatedTime"`
 	Status      int64   `json:"status"`
+	// OldSecret stays valid until OldSecretExpires after a secret rotation
+	// so the node can switch over without dropping its connection.
+	OldSecret        string `json:"-"`
+	OldSecretExpires int64  `json:"oldSecretExpires"`
 }
 
 type Tunnel struct {
@@ -99,10 +106,12 @@ type Forward struct {
 	Status        int64   `json:"status"`
 	Inx           int64   `json:"inx"`
 	Lifecycle     string  `json:"lifecycle"`
+	LastError     *string `json:"lastError"`
+	PauseReason   string  `json:"pauseReason"`
 }
 
+// StatisticsFlow is one hour of a user's billed traffic as shown on the dashboard.
 type StatisticsFlow struct {
-	ID          int64  `json:"id"`
 	UserID      int64  `json:"userId"`
 	Flow        int64  `json:"flow"`
 	TotalFlow   int64  `json:"totalFlow"`
@@ -110,6 +119,116 @@ type StatisticsFlow struct {
 	CreatedTime int64  `json:"createdTime"`
 }
 
+// AuditNoActorRole is the ActorRole of entries recorded without a signed-in
+// user, e.g. failed logins; their ActorID is 0.
+const AuditNoActorRole int64 = -1
+
+type AuditLog struct {
+	ID          int64   `json:"id"`
+	ActorID     int64   `json:"actorId"`
+	ActorRole   int64   `json:"actorRole"`
+	ClientIP    string  `json:"clientIp"`
+	Action      string  `json:"action"`
+	EntityType  string  `json:"entityType"`
+	EntityID    int64   `json:"entityId"`
+	Before      *string `json:"before"`
+	After       *string `json:"after"`
+	CreatedTime int64   `json:"createdTime"`
+}
+
+// UserTOTP is a user's TOTP enrollment. The secret is pending until Enabled.
+type UserTOTP struct {
+	UserID      int64  `json:"userId"`
+	Secret      string `json:"-"`
+	Enabled     bool   `json:"enabled"`
+	LastStep    int64  `json:"-"`
+	CreatedTime int64  `json:"createdTime"`
+	UpdatedTime *int64 `json:"updatedTime"`
+}
+
+// UserOIDC binds a user to a single sign-on identity, the subject of an ID
+// token from issuer.
+type UserOIDC struct {
+	UserID      int64  `json:"userId"`
+	Issuer      string `json:"issuer"`
+	Subject     string `json:"subject"`
+	CreatedTime int64  `json:"createdTime"`
+}
+
+// NodeEnrollment is a one-time token the install command hands to a node,
+// which exchanges it for the node secret on first start.
+type NodeEnrollment struct {
+	ID          int64   `json:"id"`
+	NodeID      int64   `json:"nodeId"`
+	TokenHash   string  `json:"-"`
+	CreatedBy   int64   `json:"createdBy"`
+	CreatedTime int64   `json:"createdTime"`
+	ExpTime     int64   `json:"expTime"`
+	UsedTime    *int64  `json:"usedTime"`
+	UsedIP      *string `json:"usedIp"`
+}
+
+// NodeConfigReport is the config a node last reported, reduced to the gost
+// objects in Objects (a JSON array).
+type NodeConfigReport struct {
+	NodeID       int64           `json:"nodeId"`
+	Objects      json.RawMessage `json:"objects"`
+	ReportedTime int64           `json:"reportedTime"`
+}
+
+// APIToken is a long-lived credential for automation. Only the SHA-256 hash
+// of the token is stored; Prefix identifies it in listings.
+type APIToken struct {
+	ID           int64    `json:"id"`
+	UserID       int64    `json:"userId"`
+	Name         string   `json:"name"`
+	TokenHash    string   `json:"-"`
+	Prefix       string   `json:"prefix"`
+	Scopes       []string `json:"scopes"`
+	ExpTime      int64    `json:"expTime"`
+	LastUsedTime *int64   `json:"lastUsedTime"`
+	LastUsedIP   *string  `json:"lastUsedIp"`
+	CreatedTime  int64    `json:"createdTime"`
+	RevokedTime  *int64   `json:"revokedTime"`
+}
+
+// UserSession is a login session. The refresh token rotates on every use;
+// PrevRefreshHash keeps the last one so its reuse can be detected.
+type UserSession struct {
+	ID              int64   `json:"id"`
+	UserID          int64   `json:"userId"`
+	RefreshHash     string  `json:"-"`
+	PrevRefreshHash *string `json:"-"`
+	IP              string  `json:"ip"`
+	UserAgent       string  `json:"userAgent"`
+	CreatedTime     int64   `json:"createdTime"`
+	LastSeenTime    int64   `json:"lastSeenTime"`
+	ExpTime         int64   `json:"expTime"`
+	RevokedTime     *int64  `json:"revokedTime"`
+}
+
+// LoginAttempt tracks consecutive failed logins for one client IP or
+// username. LockedUntil is 0 when the subject is not locked out.
+type LoginAttempt struct {
+	Kind            string `json:"kind"`
+	Subject         string `json:"subject"`
+	Failures        int64  `json:"failures"`
+	LastFailureTime int64  `json:"lastFailureTime"`
+	LockedUntil     int64  `json:"lockedUntil"`
+}
+
+// Role groups permissions granted to users with that role_id. Builtin
+// roles are created by migrations and cannot be deleted.
+type Role struct {
+	ID          int64    `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Builtin     bool     `json:"builtin"`
+	Permissions []string `json:"permissions"`
+	CreatedTime int64    `json:"createdTime"`
+	UpdatedTime *int64   `json:"updatedTime"`
+}
+
 type ViteConfig struct {
 	ID    int64  `json:"id"`
 	Name  string `json:"name"`
@@ -119,11 +238,24 @@ type ViteConfig struct {
 
 type OutboxItem struct {
 	ID          int64           `json:"id"`
+	ForwardID   *int64          `json:"forwardId"`
+	NodeID      int64           `json:"nodeId"`
+	ServiceKey  string          `json:"serviceKey"`
 	Type        string          `json:"type"`
-	Payload     json.RawMessage `json:"payload"`
+	Payload     json.RawMessage `json:"payload,omitempty"`
 	Status      string          `json:"status"`
 	RetryCount  int64           `json:"retryCount"`
 	NextRetryAt *int64          `json:"nextRetryAt"`
+	LastError   string          `json:"lastError"`
 	CreatedAt   int64           `json:"createdAt"`
 	UpdatedAt   int64           `json:"updatedAt"`
 }
+
+// OutboxAttempt is one failed delivery of an outbox item.
+type OutboxAttempt struct {
+	ID        int64  `json:"id"`
+	OutboxID  int64  `json:"outboxId"`
+	Attempt   int64  `json:"attempt"`
+	Error     string `json:"error"`
+	CreatedAt int64  `json:"createdAt"`
+}
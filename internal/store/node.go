@@ -9,8 +9,10 @@ import (
 )
 
 func (s *Store) GetNodeByID(ctx context.Context, id int64) (*Node, error) {
-	row := s.db.QueryRowContext(ctx, `SELECT id, name, secret, ip, server_ip, port_sta, port_end, version, http, tls, socks, created_time, updated_time, status FROM node WHERE id = ?`, id)
-	return scanNode(row)
+	return s.cache.nodes.get(id, func() (*Node, error) {
+		row := s.db.QueryRowContext(ctx, `SELECT id, name, secret, ip, server_ip, port_sta, port_end, version, http, tls, socks, created_time, updated_time, status, old_secret, old_secret_expires FROM node WHERE id = ?`, id)
+		return scanNode(row)
+	})
 }
 
 func (s *Store) NodeExists(ctx context.Context, id int64) (bool, error) {
@@ -25,14 +27,18 @@ func (s *Store) NodeExists(ctx context.Context, id int64) (bool, error) {
 	return true, nil
 }
 
+// GetNodeBySecret returns the node whose secret, or whose previous secret
+// while its rotation grace window lasts, is secret.
 func (s *Store) GetNodeBySecret(ctx context.Context, secret string) (*Node, error) {
 	secret = strings.TrimSpace(secret)
-	row := s.db.QueryRowContext(ctx, `SELECT id, name, secret, ip, server_ip, port_sta, port_end, version, http, tls, socks, created_time, updated_time, status FROM node WHERE lower(secret) = lower(?)`, secret)
+	row := s.db.QueryRowContext(ctx, `SELECT id, name, secret, ip, server_ip, port_sta, port_end, version, http, tls, socks, created_time, updated_time, status, old_secret, old_secret_expires FROM node
+		WHERE lower(secret) = lower(?) OR (old_secret != '' AND lower(old_secret) = lower(?) AND old_secret_expires > ?)
+		ORDER BY lower(secret) = lower(?) DESC LIMIT 1`, secret, secret, time.Now().UnixMilli(), secret)
 	return scanNode(row)
 }
 
 func (s *Store) ListNodes(ctx context.Context) ([]Node, error) {
-	rows, err := s.db.QueryContext(ctx, `SELECT id, name, secret, ip, server_ip, port_sta, port_end, version, http, tls, socks, created_time, updated_time, status FROM node ORDER BY id`)
+	rows, err := s.db.QueryContext(ctx, `SELECT id, name, secret, ip, server_ip, port_sta, port_end, version, http, tls, socks, created_time, updated_time, status, old_secret, old_secret_expires FROM node ORDER BY id`)
 	if err != nil {
 		return nil, err
 	}
@@ -63,6 +69,7 @@ func (s *Store) InsertNode(ctx context.Context, node *Node) (int64, error) {
 func (s *Store) UpdateNode(ctx context.Context, node *Node) error {
 	_, err := s.db.ExecContext(ctx, `UPDATE node SET name = ?, ip = ?, server_ip = ?, port_sta = ?, port_end = ?, version = ?, http = ?, tls = ?, socks = ?, updated_time = ?, status = ? WHERE id = ?`,
 		node.Name, node.IP, node.ServerIP, node.PortSta, node.PortEnd, node.Version, node.HTTP, node.TLS, node.Socks, node.UpdatedTime, node.Status, node.ID)
+	s.cache.nodes.invalidate(node.ID)
 	return err
 }
 
@@ -86,11 +93,59 @@ func (s *Store) UpdateNodeStatus(ctx context.Context, id int64, status int64, ve
 	}
 	_, err := s.db.ExecContext(ctx, `UPDATE node SET status = ?, version = COALESCE(?, version), http = COALESCE(?, http), tls = COALESCE(?, tls), socks = COALESCE(?, socks), updated_time = ? WHERE id = ?`,
 		status, v, httpAny, tlsAny, socksAny, now, id)
+	s.cache.nodes.invalidate(id)
+	return err
+}
+
+// RotateNodeSecret replaces the node's secret, keeping the current one valid
+// as old_secret until graceUntil.
+func (s *Store) RotateNodeSecret(ctx context.Context, id int64, secret string, graceUntil int64) error {
+	defer s.cache.nodes.invalidate(id)
+	_, err := s.db.ExecContext(ctx, `UPDATE node SET old_secret = secret, old_secret_expires = ?, secret = ?, updated_time = ? WHERE id = ?`,
+		graceUntil, secret, time.Now().UnixMilli(), id)
 	return err
 }
 
+// ExtendNodeSecretGrace keeps the node's old secret valid until graceUntil.
+func (s *Store) ExtendNodeSecretGrace(ctx context.Context, id int64, graceUntil int64) error {
+	defer s.cache.nodes.invalidate(id)
+	_, err := s.db.ExecContext(ctx, `UPDATE node SET old_secret_expires = ? WHERE id = ? AND old_secret != ''`, graceUntil, id)
+	return err
+}
+
+// RetireNodeSecrets clears previous secrets whose grace window ended before
+// now and returns the IDs of the affected nodes.
+func (s *Store) RetireNodeSecrets(ctx context.Context, now int64) ([]int64, error) {
+	rows, err := s.db.QueryContext(ctx, `UPDATE node SET old_secret = '', old_secret_expires = 0 WHERE old_secret != '' AND old_secret_expires <= ? RETURNING id`, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	s.cache.nodes.invalidate(ids...)
+	return ids, nil
+}
+
 func (s *Store) DeleteNode(ctx context.Context, id int64) error {
 	_, err := s.db.ExecContext(ctx, `DELETE FROM node WHERE id = ?`, id)
+	s.cache.nodes.invalidate(id)
+	if err != nil {
+		return err
+	}
+	if _, err = s.db.ExecContext(ctx, `DELETE FROM node_enrollment WHERE node_id = ?`, id); err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `DELETE FROM node_config_report WHERE node_id = ?`, id)
 	return err
 }
 
@@ -100,7 +155,7 @@ func scanNode(scanner interface{ Scan(dest ...any) error }) (*Node, error) {
 	var ip sql.NullString
 	var version sql.NullString
 	var updated sql.NullInt64
-	if err := scanner.Scan(&node.ID, &node.Name, &node.Secret, &ip, &node.ServerIP, &node.PortSta, &node.PortEnd, &version, &node.HTTP, &node.TLS, &node.Socks, &node.CreatedTime, &updated, &node.Status); err != nil {
+	if err := scanner.Scan(&node.ID, &node.Name, &node.Secret, &ip, &node.ServerIP, &node.PortSta, &node.PortEnd, &version, &node.HTTP, &node.TLS, &node.Socks, &node.CreatedTime, &updated, &node.Status, &node.OldSecret, &node.OldSecretExpires); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNotFound
 		}
@@ -0,0 +1,56 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// SaveNodeConfigReport replaces the stored report of report.NodeID.
+func (s *Store) SaveNodeConfigReport(ctx context.Context, report *NodeConfigReport) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO node_config_report(node_id, objects, reported_time) VALUES(?, ?, ?)
+		ON CONFLICT(node_id) DO UPDATE SET objects = excluded.objects, reported_time = excluded.reported_time`,
+		report.NodeID, string(report.Objects), report.ReportedTime)
+	return err
+}
+
+// GetNodeConfigReport returns the last report of nodeID, or ErrNotFound if
+// the node has not reported since it was added.
+func (s *Store) GetNodeConfigReport(ctx context.Context, nodeID int64) (*NodeConfigReport, error) {
+	var report NodeConfigReport
+	var objects string
+	err := s.db.QueryRowContext(ctx, `SELECT node_id, objects, reported_time FROM node_config_report WHERE node_id = ?`, nodeID).
+		Scan(&report.NodeID, &objects, &report.ReportedTime)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	report.Objects = []byte(objects)
+	return &report, nil
+}
+
+// ListInFlightOutboxKeys returns the service keys of nodeID's commands whose
+// effect a report taken at since cannot show yet: those still queued and
+// those delivered after it.
+func (s *Store) ListInFlightOutboxKeys(ctx context.Context, nodeID, since int64) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT DISTINCT service_key FROM outbox
+		WHERE node_id = ? AND service_key != ''
+		AND (status IN (?, ?) OR (status = ? AND updated_at >= ?))`,
+		nodeID, OutboxPending, OutboxProcessing, OutboxDone, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
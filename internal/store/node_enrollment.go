@@ -0,0 +1,81 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+const nodeEnrollmentColumns = `id, node_id, token_hash, created_by, created_time, exp_time, used_time, used_ip`
+
+func (s *Store) InsertNodeEnrollment(ctx context.Context, e *NodeEnrollment) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `INSERT INTO node_enrollment(node_id, token_hash, created_by, created_time, exp_time) VALUES(?, ?, ?, ?, ?)`,
+		e.NodeID, e.TokenHash, e.CreatedBy, e.CreatedTime, e.ExpTime)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// ListNodeEnrollments returns the enrollment tokens issued for nodeID, newest
+// first.
+func (s *Store) ListNodeEnrollments(ctx context.Context, nodeID int64) ([]NodeEnrollment, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+nodeEnrollmentColumns+` FROM node_enrollment WHERE node_id = ? ORDER BY id DESC`, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []NodeEnrollment
+	for rows.Next() {
+		e, err := scanNodeEnrollment(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *e)
+	}
+	return list, rows.Err()
+}
+
+// ExpireNodeEnrollments ends the unused tokens of nodeID, so only the most
+// recently issued install command works.
+func (s *Store) ExpireNodeEnrollments(ctx context.Context, nodeID, now int64) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE node_enrollment SET exp_time = ? WHERE node_id = ? AND used_time IS NULL AND exp_time > ?`, now, nodeID, now)
+	return err
+}
+
+// ConsumeNodeEnrollment marks the unexpired, unused token with hash as used
+// by ip and returns it. Each token can be consumed once; ErrNotFound is
+// returned otherwise.
+func (s *Store) ConsumeNodeEnrollment(ctx context.Context, hash string, now int64, ip string) (*NodeEnrollment, error) {
+	row := s.db.QueryRowContext(ctx, `UPDATE node_enrollment SET used_time = ?, used_ip = ?
+		WHERE token_hash = ? AND used_time IS NULL AND exp_time > ?
+		RETURNING `+nodeEnrollmentColumns, now, ip, hash, now)
+	return scanNodeEnrollment(row)
+}
+
+// DeleteExpiredNodeEnrollments removes tokens that expired unused before
+// before. Used tokens are kept as the record of which host enrolled.
+func (s *Store) DeleteExpiredNodeEnrollments(ctx context.Context, before int64) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM node_enrollment WHERE used_time IS NULL AND exp_time < ?`, before)
+	return err
+}
+
+func scanNodeEnrollment(scanner interface{ Scan(dest ...any) error }) (*NodeEnrollment, error) {
+	var e NodeEnrollment
+	var used sql.NullInt64
+	var ip sql.NullString
+	if err := scanner.Scan(&e.ID, &e.NodeID, &e.TokenHash, &e.CreatedBy, &e.CreatedTime, &e.ExpTime, &used, &ip); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if used.Valid {
+		e.UsedTime = &used.Int64
+	}
+	if ip.Valid {
+		e.UsedIP = &ip.String
+	}
+	return &e, nil
+}
@@ -0,0 +1,84 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrOIDCIdentityTaken is returned when a single sign-on identity is already
+// bound to another user.
+var ErrOIDCIdentityTaken = errors.New("oidc identity bound to another user")
+
+// GetUserByOIDC returns the user bound to the identity (issuer, subject).
+func (s *Store) GetUserByOIDC(ctx context.Context, issuer, subject string) (*User, error) {
+	var userID int64
+	err := s.db.QueryRowContext(ctx, `SELECT user_id FROM user_oidc WHERE issuer = ? AND subject = ?`, issuer, subject).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.GetUserByID(ctx, userID)
+}
+
+func (s *Store) GetUserOIDC(ctx context.Context, userID int64) (*UserOIDC, error) {
+	var b UserOIDC
+	err := s.db.QueryRowContext(ctx, `SELECT user_id, issuer, subject, created_time FROM user_oidc WHERE user_id = ?`, userID).
+		Scan(&b.UserID, &b.Issuer, &b.Subject, &b.CreatedTime)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// LinkUserOIDC binds userID to the identity (issuer, subject), replacing the
+// user's previous binding.
+func (s *Store) LinkUserOIDC(ctx context.Context, userID int64, issuer, subject string) error {
+	return s.withImmediateTx(ctx, func(conn *sql.Conn) error {
+		return linkUserOIDC(ctx, conn, userID, issuer, subject)
+	})
+}
+
+func (s *Store) UnlinkUserOIDC(ctx context.Context, userID int64) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM user_oidc WHERE user_id = ?`, userID)
+	return err
+}
+
+// InsertOIDCUser creates user bound to the identity (issuer, subject) in one
+// transaction, so two concurrent first logins cannot create two accounts.
+func (s *Store) InsertOIDCUser(ctx context.Context, user *User, issuer, subject string) (int64, error) {
+	var id int64
+	err := s.withImmediateTx(ctx, func(conn *sql.Conn) error {
+		res, err := conn.ExecContext(ctx, insertUserQuery, insertUserArgs(user)...)
+		if err != nil {
+			return err
+		}
+		id, _ = res.LastInsertId()
+		return linkUserOIDC(ctx, conn, id, issuer, subject)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func linkUserOIDC(ctx context.Context, conn *sql.Conn, userID int64, issuer, subject string) error {
+	var owner int64
+	err := conn.QueryRowContext(ctx, `SELECT user_id FROM user_oidc WHERE issuer = ? AND subject = ?`, issuer, subject).Scan(&owner)
+	switch {
+	case err == nil && owner != userID:
+		return ErrOIDCIdentityTaken
+	case err != nil && !errors.Is(err, sql.ErrNoRows):
+		return err
+	}
+	_, err = conn.ExecContext(ctx, `INSERT INTO user_oidc(user_id, issuer, subject, created_time) VALUES(?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET issuer = excluded.issuer, subject = excluded.subject, created_time = excluded.created_time`,
+		userID, issuer, subject, time.Now().UnixMilli())
+	return err
+}
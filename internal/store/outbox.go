@@ -0,0 +1,288 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Outbox item states.
+const (
+	OutboxPending    = "pending"
+	OutboxProcessing = "processing"
+	OutboxDone       = "done"
+	OutboxDead       = "dead"
+	OutboxSuperseded = "superseded"
+)
+
+// OutboxFilter selects outbox items. Zero values are ignored; CreatedBefore is
+// exclusive.
+type OutboxFilter struct {
+	IDs           []int64
+	Status        string
+	NodeID        int64
+	Action        string
+	CreatedAfter  int64
+	CreatedBefore int64
+	Limit         int
+	Offset        int
+}
+
+func (f OutboxFilter) where() (string, []any) {
+	var where []string
+	var args []any
+	if len(f.IDs) > 0 {
+		where = append(where, "id IN ("+inPlaceholders(len(f.IDs))+")")
+		args = append(args, idArgs(f.IDs)...)
+	}
+	if f.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, f.Status)
+	}
+	if f.NodeID != 0 {
+		where = append(where, "node_id = ?")
+		args = append(args, f.NodeID)
+	}
+	if f.Action != "" {
+		where = append(where, "type = ?")
+		args = append(args, f.Action)
+	}
+	if f.CreatedAfter != 0 {
+		where = append(where, "created_at >= ?")
+		args = append(args, f.CreatedAfter)
+	}
+	if f.CreatedBefore != 0 {
+		where = append(where, "created_at < ?")
+		args = append(args, f.CreatedBefore)
+	}
+	if len(where) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(where, " AND "), args
+}
+
+const outboxColumns = `id, forward_id, type, status, retry_count, next_retry_at, created_at, updated_at, last_error, node_id, service_key`
+
+// ListOutbox returns matching items newest first without their payloads, and
+// the total match count.
+func (s *Store) ListOutbox(ctx context.Context, filter OutboxFilter) ([]OutboxItem, int64, error) {
+	clause, args := filter.where()
+	var total int64
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(1) FROM outbox`+clause, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT `+outboxColumns+` FROM outbox`+clause+` ORDER BY id DESC LIMIT ? OFFSET ?`,
+		append(args, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var list []OutboxItem
+	for rows.Next() {
+		item, err := scanOutboxItem(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		list = append(list, *item)
+	}
+	return list, total, rows.Err()
+}
+
+// GetOutboxItem returns the item with its payload.
+func (s *Store) GetOutboxItem(ctx context.Context, id int64) (*OutboxItem, error) {
+	var payload []byte
+	row := s.db.QueryRowContext(ctx, `SELECT `+outboxColumns+`, payload FROM outbox WHERE id = ?`, id)
+	item, err := scanOutboxItem(row, &payload)
+	if err != nil {
+		return nil, err
+	}
+	item.Payload = payload
+	return item, nil
+}
+
+// RecordOutboxAttempt records a failed delivery of item id and keeps msg as
+// the item's last error.
+func (s *Store) RecordOutboxAttempt(ctx context.Context, id, attempt int64, msg string) error {
+	now := time.Now().UnixMilli()
+	if _, err := s.db.ExecContext(ctx, `INSERT INTO outbox_attempt(outbox_id, attempt, error, created_at) VALUES(?, ?, ?, ?)`, id, attempt, msg, now); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, `UPDATE outbox SET last_error = ? WHERE id = ?`, msg, id)
+	return err
+}
+
+// ListOutboxAttempts returns the failed deliveries of item id, oldest first.
+func (s *Store) ListOutboxAttempts(ctx context.Context, id int64) ([]OutboxAttempt, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, outbox_id, attempt, error, created_at FROM outbox_attempt WHERE outbox_id = ? ORDER BY id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []OutboxAttempt
+	for rows.Next() {
+		var a OutboxAttempt
+		if err := rows.Scan(&a.ID, &a.OutboxID, &a.Attempt, &a.Error, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, a)
+	}
+	return list, rows.Err()
+}
+
+// RequeueDeadOutbox moves the dead items matching filter back to pending with
+// a fresh retry budget and returns their IDs. Forwards that failed because of
+// them go back to updating so the next acknowledgement completes them. Items
+// with a newer command for the same service key are marked superseded
+// instead, so a stale config is never replayed over a later one.
+func (s *Store) RequeueDeadOutbox(ctx context.Context, filter OutboxFilter) ([]int64, error) {
+	filter.Status = OutboxDead
+	var ids []int64
+	err := s.withImmediateTx(ctx, func(conn *sql.Conn) error {
+		var err error
+		ids, err = selectOutboxIDs(ctx, conn, filter)
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+		now := time.Now().UnixMilli()
+		ids, err = supersedeStaleDeadOutbox(ctx, conn, ids, now)
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+		in := inPlaceholders(len(ids))
+		if _, err := conn.ExecContext(ctx, `UPDATE forward SET lifecycle = ?, updated_time = ?
+			WHERE lifecycle = ? AND id IN (SELECT forward_id FROM outbox WHERE id IN (`+in+`))`,
+			append([]any{LifecycleUpdating, now, LifecycleFailed}, idArgs(ids)...)...); err != nil {
+			return err
+		}
+		_, err = conn.ExecContext(ctx, `UPDATE outbox SET status = 'pending', retry_count = 0, next_retry_at = NULL, updated_at = ? WHERE id IN (`+in+`)`,
+			append([]any{now}, idArgs(ids)...)...)
+		return err
+	})
+	if len(ids) > 0 {
+		s.cache.forwards.clear()
+	}
+	return ids, err
+}
+
+// supersedeStaleDeadOutbox marks the items among ids that have a newer item
+// for the same node and service key as superseded, and returns the others.
+func supersedeStaleDeadOutbox(ctx context.Context, conn *sql.Conn, ids []int64, now int64) ([]int64, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT d.id, (SELECT MIN(n.id) FROM outbox n
+			WHERE n.node_id = d.node_id AND n.service_key = d.service_key AND n.id > d.id AND n.status != ?)
+		FROM outbox d WHERE d.service_key != '' AND d.id IN (`+inPlaceholders(len(ids))+`)`,
+		append([]any{OutboxSuperseded}, idArgs(ids)...)...)
+	if err != nil {
+		return nil, err
+	}
+	newer := make(map[int64]int64)
+	for rows.Next() {
+		var id int64
+		var next sql.NullInt64
+		if err := rows.Scan(&id, &next); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if next.Valid {
+			newer[id] = next.Int64
+		}
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(newer) == 0 {
+		return ids, nil
+	}
+
+	kept := make([]int64, 0, len(ids)-len(newer))
+	for _, id := range ids {
+		next, ok := newer[id]
+		if !ok {
+			kept = append(kept, id)
+			continue
+		}
+		if _, err := conn.ExecContext(ctx, `UPDATE outbox SET status = ?, next_retry_at = NULL, last_error = ?, updated_at = ? WHERE id = ?`,
+			OutboxSuperseded, "superseded by #"+strconv.FormatInt(next, 10), now, id); err != nil {
+			return nil, err
+		}
+	}
+	return kept, nil
+}
+
+// DeleteDeadOutbox removes the dead items matching filter and their attempts
+// and returns their IDs.
+func (s *Store) DeleteDeadOutbox(ctx context.Context, filter OutboxFilter) ([]int64, error) {
+	filter.Status = OutboxDead
+	var ids []int64
+	err := s.withImmediateTx(ctx, func(conn *sql.Conn) error {
+		var err error
+		ids, err = selectOutboxIDs(ctx, conn, filter)
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+		in := inPlaceholders(len(ids))
+		if _, err := conn.ExecContext(ctx, `DELETE FROM outbox_attempt WHERE outbox_id IN (`+in+`)`, idArgs(ids)...); err != nil {
+			return err
+		}
+		_, err = conn.ExecContext(ctx, `DELETE FROM outbox WHERE id IN (`+in+`)`, idArgs(ids)...)
+		return err
+	})
+	return ids, err
+}
+
+func selectOutboxIDs(ctx context.Context, conn *sql.Conn, filter OutboxFilter) ([]int64, error) {
+	clause, args := filter.where()
+	rows, err := conn.QueryContext(ctx, `SELECT id FROM outbox`+clause+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func inPlaceholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+func idArgs(ids []int64) []any {
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return args
+}
+
+func scanOutboxItem(scanner interface{ Scan(dest ...any) error }, extra ...any) (*OutboxItem, error) {
+	var item OutboxItem
+	var forwardID, next sql.NullInt64
+	dest := append([]any{&item.ID, &forwardID, &item.Type, &item.Status, &item.RetryCount, &next, &item.CreatedAt, &item.UpdatedAt, &item.LastError, &item.NodeID, &item.ServiceKey}, extra...)
+	if err := scanner.Scan(dest...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if forwardID.Valid {
+		item.ForwardID = &forwardID.Int64
+	}
+	if next.Valid {
+		item.NextRetryAt = &next.Int64
+	}
+	return &item, nil
+}
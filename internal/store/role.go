@@ -0,0 +1,155 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+func (s *Store) GetRoleByID(ctx context.Context, id int64) (*Role, error) {
+	return s.cache.roles.get(id, func() (*Role, error) {
+		row := s.db.QueryRowContext(ctx, `SELECT id, name, description, builtin, created_time, updated_time FROM role WHERE id = ?`, id)
+		role, err := scanRole(row)
+		if err != nil {
+			return nil, err
+		}
+		perms, err := s.rolePermissions(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		role.Permissions = perms
+		return role, nil
+	})
+}
+
+func (s *Store) GetRoleByName(ctx context.Context, name string) (*Role, error) {
+	var id int64
+	err := s.db.QueryRowContext(ctx, `SELECT id FROM role WHERE name = ?`, name).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.GetRoleByID(ctx, id)
+}
+
+func (s *Store) ListRoles(ctx context.Context) ([]Role, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, name, description, builtin, created_time, updated_time FROM role ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	var roles []Role
+	for rows.Next() {
+		role, err := scanRole(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		roles = append(roles, *role)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range roles {
+		perms, err := s.rolePermissions(ctx, roles[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		roles[i].Permissions = perms
+	}
+	return roles, nil
+}
+
+func (s *Store) InsertRole(ctx context.Context, role *Role) (int64, error) {
+	var id int64
+	err := s.withImmediateTx(ctx, func(conn *sql.Conn) error {
+		res, err := conn.ExecContext(ctx, `INSERT INTO role(name, description, builtin, created_time) VALUES(?, ?, 0, ?)`, role.Name, role.Description, role.CreatedTime)
+		if err != nil {
+			return err
+		}
+		id, err = res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		return replaceRolePermissions(ctx, conn, id, role.Permissions)
+	})
+	return id, err
+}
+
+// UpdateRole saves the name, description and permissions of role.
+func (s *Store) UpdateRole(ctx context.Context, role *Role) error {
+	defer s.cache.roles.invalidate(role.ID)
+	return s.withImmediateTx(ctx, func(conn *sql.Conn) error {
+		if _, err := conn.ExecContext(ctx, `UPDATE role SET name = ?, description = ?, updated_time = ? WHERE id = ?`, role.Name, role.Description, role.UpdatedTime, role.ID); err != nil {
+			return err
+		}
+		return replaceRolePermissions(ctx, conn, role.ID, role.Permissions)
+	})
+}
+
+func (s *Store) DeleteRole(ctx context.Context, id int64) error {
+	defer s.cache.roles.invalidate(id)
+	_, err := s.db.ExecContext(ctx, `DELETE FROM role WHERE id = ? AND builtin = 0`, id)
+	return err
+}
+
+func (s *Store) CountUsersByRole(ctx context.Context, roleID int64) (int64, error) {
+	var count int64
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(1) FROM user WHERE role_id = ?`, roleID).Scan(&count)
+	return count, err
+}
+
+func (s *Store) UpdateUserRole(ctx context.Context, userID, roleID, updated int64) error {
+	defer s.cache.users.invalidate(userID)
+	_, err := s.db.ExecContext(ctx, `UPDATE user SET role_id = ?, updated_time = ? WHERE id = ?`, roleID, updated, userID)
+	return err
+}
+
+func (s *Store) rolePermissions(ctx context.Context, roleID int64) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT permission FROM role_permission WHERE role_id = ? ORDER BY permission`, roleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	perms := []string{}
+	for rows.Next() {
+		var perm string
+		if err := rows.Scan(&perm); err != nil {
+			return nil, err
+		}
+		perms = append(perms, perm)
+	}
+	return perms, rows.Err()
+}
+
+func replaceRolePermissions(ctx context.Context, conn *sql.Conn, roleID int64, perms []string) error {
+	if _, err := conn.ExecContext(ctx, `DELETE FROM role_permission WHERE role_id = ?`, roleID); err != nil {
+		return err
+	}
+	for _, perm := range perms {
+		if _, err := conn.ExecContext(ctx, `INSERT OR IGNORE INTO role_permission(role_id, permission) VALUES(?, ?)`, roleID, perm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scanRole(scanner interface{ Scan(dest ...any) error }) (*Role, error) {
+	var role Role
+	var builtin int64
+	var updated sql.NullInt64
+	if err := scanner.Scan(&role.ID, &role.Name, &role.Description, &builtin, &role.CreatedTime, &updated); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	role.Builtin = builtin == 1
+	if updated.Valid {
+		role.UpdatedTime = &updated.Int64
+	}
+	return &role, nil
+}
@@ -0,0 +1,105 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+const sessionColumns = `id, user_id, refresh_hash, prev_refresh_hash, ip, user_agent, created_time, last_seen_time, exp_time, revoked_time`
+
+func (s *Store) InsertSession(ctx context.Context, session *UserSession) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `INSERT INTO user_session(user_id, refresh_hash, ip, user_agent, created_time, last_seen_time, exp_time) VALUES(?, ?, ?, ?, ?, ?, ?)`,
+		session.UserID, session.RefreshHash, session.IP, session.UserAgent, session.CreatedTime, session.LastSeenTime, session.ExpTime)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (s *Store) GetSessionByID(ctx context.Context, id int64) (*UserSession, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+sessionColumns+` FROM user_session WHERE id = ?`, id)
+	return scanSession(row)
+}
+
+// GetSessionByRefreshHash finds the session whose current or previous refresh
+// token hashes to hash.
+func (s *Store) GetSessionByRefreshHash(ctx context.Context, hash string) (*UserSession, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+sessionColumns+` FROM user_session WHERE refresh_hash = ? OR prev_refresh_hash = ? LIMIT 1`, hash, hash)
+	return scanSession(row)
+}
+
+// RotateSession replaces the refresh token of an active session and extends
+// it to exp. It reports false if oldHash is no longer current, so concurrent
+// refreshes cannot both succeed.
+func (s *Store) RotateSession(ctx context.Context, id int64, oldHash, newHash string, exp, now int64, ip, userAgent string) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `UPDATE user_session SET refresh_hash = ?, prev_refresh_hash = refresh_hash, exp_time = ?, last_seen_time = ?, ip = ?, user_agent = ?
+		WHERE id = ? AND refresh_hash = ? AND revoked_time IS NULL AND exp_time > ?`,
+		newHash, exp, now, ip, userAgent, id, oldHash, now)
+	if err != nil {
+		return false, err
+	}
+	affected, _ := res.RowsAffected()
+	return affected == 1, nil
+}
+
+func (s *Store) TouchSession(ctx context.Context, id, now int64, ip string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE user_session SET last_seen_time = ?, ip = ? WHERE id = ?`, now, ip, id)
+	return err
+}
+
+func (s *Store) RevokeSession(ctx context.Context, id, now int64) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE user_session SET revoked_time = ? WHERE id = ? AND revoked_time IS NULL`, now, id)
+	return err
+}
+
+// RevokeUserSessions revokes every active session of userID except exceptID.
+func (s *Store) RevokeUserSessions(ctx context.Context, userID, exceptID, now int64) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE user_session SET revoked_time = ? WHERE user_id = ? AND id != ? AND revoked_time IS NULL`, now, userID, exceptID)
+	return err
+}
+
+// ListActiveSessions returns the unrevoked, unexpired sessions of userID,
+// most recently seen first.
+func (s *Store) ListActiveSessions(ctx context.Context, userID, now int64) ([]UserSession, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+sessionColumns+` FROM user_session WHERE user_id = ? AND revoked_time IS NULL AND exp_time > ? ORDER BY last_seen_time DESC`, userID, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []UserSession
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *session)
+	}
+	return list, rows.Err()
+}
+
+// DeleteSessionsBefore removes sessions that expired or were revoked before cutoff.
+func (s *Store) DeleteSessionsBefore(ctx context.Context, cutoff int64) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM user_session WHERE exp_time < ? OR revoked_time < ?`, cutoff, cutoff)
+	return err
+}
+
+func scanSession(scanner interface{ Scan(dest ...any) error }) (*UserSession, error) {
+	var session UserSession
+	var prev sql.NullString
+	var revoked sql.NullInt64
+	if err := scanner.Scan(&session.ID, &session.UserID, &session.RefreshHash, &prev, &session.IP, &session.UserAgent, &session.CreatedTime, &session.LastSeenTime, &session.ExpTime, &revoked); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if prev.Valid {
+		session.PrevRefreshHash = &prev.String
+	}
+	if revoked.Valid {
+		session.RevokedTime = &revoked.Int64
+	}
+	return &session, nil
+}
@@ -70,6 +70,8 @@ func (s *Store) UpdateSpeedLimit(ctx context.Context, limit *SpeedLimit) error {
 
 func (s *Store) DeleteSpeedLimit(ctx context.Context, id int64) error {
 	_, err := s.db.ExecContext(ctx, `DELETE FROM speed_limit WHERE id = ?`, id)
+	// user_tunnel.speed_id is set to NULL by the foreign key.
+	s.cache.userTunnels.clear()
 	return err
 }
 
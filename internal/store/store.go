@@ -3,9 +3,8 @@ package store
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
 	"errors"
-	"fmt"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -15,11 +14,12 @@ var (
 )
 
 type Store struct {
-	db *sql.DB
+	db    *sql.DB
+	cache *entityCaches
 }
 
 func New(db *sql.DB) *Store {
-	return &Store{db: db}
+	return &Store{db: db, cache: newEntityCaches()}
 }
 
 func (s *Store) DB() *sql.DB {
@@ -51,46 +51,86 @@ func (s *Store) withImmediateTx(ctx context.Context, fn func(*sql.Conn) error) e
 	return nil
 }
 
-// ApplyFlow atomically updates forward/user/user_tunnel flow stats.
-func (s *Store) ApplyFlow(ctx context.Context, forwardID, userID, userTunnelID, d, u int64) error {
-	return s.withImmediateTx(ctx, func(conn *sql.Conn) error {
-		res, err := conn.ExecContext(ctx, "UPDATE forward SET in_flow = in_flow + ?, out_flow = out_flow + ? WHERE id = ?", d, u, forwardID)
-		if err != nil {
-			return err
-		}
-		affected, _ := res.RowsAffected()
-		if affected == 0 {
-			return fmt.Errorf("forward %d: %w", forwardID, ErrNotFound)
-		}
-
-		res, err = conn.ExecContext(ctx, "UPDATE user SET in_flow = in_flow + ?, out_flow = out_flow + ? WHERE id = ?", d, u, userID)
-		if err != nil {
-			return err
-		}
-		affected, _ = res.RowsAffected()
-		if affected == 0 {
-			return fmt.Errorf("user %d: %w", userID, ErrNotFound)
-		}
+// ApplyFlows atomically updates forward/user/user_tunnel flow stats and the
+// hourly traffic history for a batch of deltas. Forwards keep raw bytes (Down,
+// Up); users and user_tunnels, including those of any resellers above the
+// user, receive billed bytes. Deltas whose forward no longer exists are
+// skipped; the applied deltas are returned.
+func (s *Store) ApplyFlows(ctx context.Context, deltas []FlowDelta) ([]FlowDelta, error) {
+	if len(deltas) == 0 {
+		return nil, nil
+	}
+	var applied []FlowDelta
+	var rolledUp bool
+	err := s.withImmediateTx(ctx, func(conn *sql.Conn) error {
+		applied = applied[:0]
+		rolledUp = false
+		for _, delta := range deltas {
+			res, err := conn.ExecContext(ctx, "UPDATE forward SET in_flow = in_flow + ?, out_flow = out_flow + ? WHERE id = ?", delta.Down, delta.Up, delta.ForwardID)
+			if err != nil {
+				return err
+			}
+			if affected, _ := res.RowsAffected(); affected == 0 {
+				continue
+			}
 
-		if userTunnelID != 0 {
-			res, err = conn.ExecContext(ctx, "UPDATE user_tunnel SET in_flow = in_flow + ?, out_flow = out_flow + ? WHERE id = ?", d, u, userTunnelID)
+			if _, err := conn.ExecContext(ctx, "UPDATE user SET in_flow = in_flow + ?, out_flow = out_flow + ? WHERE id = ?", delta.BilledDown, delta.BilledUp, delta.UserID); err != nil {
+				return err
+			}
+			if delta.UserTunnelID != 0 {
+				if _, err := conn.ExecContext(ctx, "UPDATE user_tunnel SET in_flow = in_flow + ?, out_flow = out_flow + ? WHERE id = ?", delta.BilledDown, delta.BilledUp, delta.UserTunnelID); err != nil {
+					return err
+				}
+			}
+			// Resellers draw from their own quota for the traffic of their sub-users.
+			res, err = conn.ExecContext(ctx, ancestorsCTE+" UPDATE user SET in_flow = in_flow + ?, out_flow = out_flow + ? WHERE id IN (SELECT id FROM ancestors)", delta.UserID, delta.BilledDown, delta.BilledUp)
 			if err != nil {
 				return err
 			}
-			affected, _ = res.RowsAffected()
-			if affected == 0 {
-				return fmt.Errorf("user_tunnel %d: %w", userTunnelID, ErrNotFound)
+			if affected, _ := res.RowsAffected(); affected > 0 {
+				rolledUp = true
+				if _, err := conn.ExecContext(ctx, ancestorsCTE+" UPDATE user_tunnel SET in_flow = in_flow + ?, out_flow = out_flow + ? WHERE tunnel_id = ? AND user_id IN (SELECT id FROM ancestors)", delta.UserID, delta.BilledDown, delta.BilledUp, delta.TunnelID); err != nil {
+					return err
+				}
 			}
+			if err := addHourlyTraffic(ctx, conn, delta); err != nil {
+				return err
+			}
+			applied = append(applied, delta)
 		}
-
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
+	if rolledUp {
+		// Ancestor rows changed too; dropping both caches is cheaper than
+		// resolving every ancestor of every delta.
+		s.cache.users.clear()
+		s.cache.userTunnels.clear()
+	}
+	for _, delta := range applied {
+		s.cache.forwards.invalidate(delta.ForwardID)
+		s.cache.users.invalidate(delta.UserID)
+		if delta.UserTunnelID != 0 {
+			s.cache.userTunnels.invalidate(delta.UserTunnelID)
+		}
+	}
+	return applied, nil
 }
 
 // Outbox
-func (s *Store) EnqueueOutbox(ctx context.Context, typ string, payload json.RawMessage) (int64, error) {
+
+// EnqueueOutbox enqueues item for item.NodeID. An item with a ForwardID is
+// linked to that forward so its acknowledgement can drive the forward lifecycle.
+func (s *Store) EnqueueOutbox(ctx context.Context, item *OutboxItem) (int64, error) {
 	now := time.Now().UnixMilli()
-	res, err := s.db.ExecContext(ctx, "INSERT INTO outbox(type, payload, status, retry_count, next_retry_at, created_at, updated_at) VALUES(?, ?, 'pending', 0, NULL, ?, ?)", typ, payload, now, now)
+	var fwID any
+	if item.ForwardID != nil && *item.ForwardID > 0 {
+		fwID = *item.ForwardID
+	}
+	res, err := s.db.ExecContext(ctx, "INSERT INTO outbox(forward_id, node_id, service_key, type, payload, status, retry_count, next_retry_at, created_at, updated_at) VALUES(?, ?, ?, ?, ?, 'pending', 0, NULL, ?, ?)",
+		fwID, item.NodeID, item.ServiceKey, item.Type, item.Payload, now, now)
 	if err != nil {
 		return 0, err
 	}
@@ -98,8 +138,8 @@ func (s *Store) EnqueueOutbox(ctx context.Context, typ string, payload json.RawM
 	return id, nil
 }
 
-func (s *Store) ClaimNextOutbox(ctx context.Context) (*OutboxItem, error) {
-	items, err := s.ClaimNextOutboxBatch(ctx, 1)
+func (s *Store) ClaimNextOutbox(ctx context.Context, supersedes func(earlier, later string) bool) (*OutboxItem, error) {
+	items, err := s.ClaimNextOutboxBatch(ctx, 1, supersedes)
 	if err != nil {
 		return nil, err
 	}
@@ -110,39 +150,37 @@ func (s *Store) ClaimNextOutbox(ctx context.Context) (*OutboxItem, error) {
 	return &item, nil
 }
 
-func (s *Store) ClaimNextOutboxBatch(ctx context.Context, limit int) ([]OutboxItem, error) {
+// ClaimNextOutboxBatch claims up to limit due items, at most one per node: a
+// node's items are handed out one at a time in ID order, and an item waiting
+// for its retry holds back the node's later items. Before claiming, an item
+// is marked superseded instead when supersedes reports that the next pending
+// item for the same service key replaces it.
+func (s *Store) ClaimNextOutboxBatch(ctx context.Context, limit int, supersedes func(earlier, later string) bool) ([]OutboxItem, error) {
 	if limit <= 0 {
 		limit = 1
 	}
 
-	items := make([]OutboxItem, 0, limit)
+	var items []OutboxItem
 	err := s.withImmediateTx(ctx, func(conn *sql.Conn) error {
 		now := time.Now().UnixMilli()
-		rows, err := conn.QueryContext(ctx, `SELECT id, type, payload, status, retry_count, next_retry_at, created_at, updated_at
-			FROM outbox
-			WHERE status = 'pending' AND (next_retry_at IS NULL OR next_retry_at <= ?)
-			ORDER BY COALESCE(next_retry_at, 0), id
-			LIMIT ?`, now, limit)
-		if err != nil {
-			return err
-		}
-		defer rows.Close()
-
-		ids := make([]int64, 0, limit)
-		for rows.Next() {
-			var item OutboxItem
-			var next sql.NullInt64
-			if err := rows.Scan(&item.ID, &item.Type, &item.Payload, &item.Status, &item.RetryCount, &next, &item.CreatedAt, &item.UpdatedAt); err != nil {
+		for {
+			var err error
+			items, err = selectOutboxHeads(ctx, conn, now, limit)
+			if err != nil {
 				return err
 			}
-			if next.Valid {
-				item.NextRetryAt = &next.Int64
+			dropped, err := supersedeOutboxHeads(ctx, conn, items, now, supersedes)
+			if err != nil {
+				return err
+			}
+			if !dropped {
+				break
 			}
-			items = append(items, item)
-			ids = append(ids, item.ID)
 		}
-		if err := rows.Err(); err != nil {
-			return err
+
+		ids := make([]int64, 0, len(items))
+		for _, item := range items {
+			ids = append(ids, item.ID)
 		}
 		if len(ids) == 0 {
 			return ErrNotFound
@@ -176,6 +214,71 @@ func (s *Store) ClaimNextOutboxBatch(ctx context.Context, limit int) ([]OutboxIt
 	return items, nil
 }
 
+// selectOutboxHeads returns due pending items that have no earlier open item
+// on the same node.
+func selectOutboxHeads(ctx context.Context, conn *sql.Conn, now int64, limit int) ([]OutboxItem, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT id, forward_id, node_id, service_key, type, payload, status, retry_count, next_retry_at, created_at, updated_at
+		FROM outbox o
+		WHERE status = 'pending' AND (next_retry_at IS NULL OR next_retry_at <= ?)
+		  AND NOT EXISTS (SELECT 1 FROM outbox p WHERE p.node_id = o.node_id AND p.id < o.id AND p.status IN ('pending', 'processing'))
+		ORDER BY COALESCE(next_retry_at, 0), id
+		LIMIT ?`, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]OutboxItem, 0, limit)
+	for rows.Next() {
+		var item OutboxItem
+		var forwardID, next sql.NullInt64
+		if err := rows.Scan(&item.ID, &forwardID, &item.NodeID, &item.ServiceKey, &item.Type, &item.Payload, &item.Status, &item.RetryCount, &next, &item.CreatedAt, &item.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if forwardID.Valid {
+			item.ForwardID = &forwardID.Int64
+		}
+		if next.Valid {
+			item.NextRetryAt = &next.Int64
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// supersedeOutboxHeads marks the items replaced by the next pending item for
+// their service key as superseded and reports whether it marked any.
+func supersedeOutboxHeads(ctx context.Context, conn *sql.Conn, items []OutboxItem, now int64, supersedes func(earlier, later string) bool) (bool, error) {
+	if supersedes == nil {
+		return false, nil
+	}
+	dropped := false
+	for _, item := range items {
+		if item.ServiceKey == "" {
+			continue
+		}
+		var nextID int64
+		var nextType string
+		err := conn.QueryRowContext(ctx, `SELECT id, type FROM outbox WHERE node_id = ? AND service_key = ? AND status = 'pending' AND id > ? ORDER BY id LIMIT 1`,
+			item.NodeID, item.ServiceKey, item.ID).Scan(&nextID, &nextType)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if !supersedes(item.Type, nextType) {
+			continue
+		}
+		if _, err := conn.ExecContext(ctx, `UPDATE outbox SET status = ?, next_retry_at = NULL, last_error = ?, updated_at = ? WHERE id = ?`,
+			OutboxSuperseded, "superseded by #"+strconv.FormatInt(nextID, 10), now, item.ID); err != nil {
+			return false, err
+		}
+		dropped = true
+	}
+	return dropped, nil
+}
+
 func (s *Store) MarkOutboxSuccess(ctx context.Context, id int64) error {
 	now := time.Now().UnixMilli()
 	_, err := s.db.ExecContext(ctx, "UPDATE outbox SET status = 'done', next_retry_at = NULL, updated_at = ? WHERE id = ?", now, id)
@@ -219,59 +322,10 @@ func (s *Store) MarkOutboxDeadByNodeID(ctx context.Context, nodeID int64) (int64
 		return 0, nil
 	}
 
-	type outboxMessage struct {
-		NodeID int64 `json:"node_id"`
-	}
-
-	ids := make([]int64, 0, 16)
-	err := s.withImmediateTx(ctx, func(conn *sql.Conn) error {
-		rows, err := conn.QueryContext(ctx, `SELECT id, payload FROM outbox WHERE status IN ('pending', 'processing')`)
-		if err != nil {
-			return err
-		}
-		defer rows.Close()
-
-		for rows.Next() {
-			var id int64
-			var payload []byte
-			if err := rows.Scan(&id, &payload); err != nil {
-				return err
-			}
-
-			var msg outboxMessage
-			if err := json.Unmarshal(payload, &msg); err != nil {
-				continue
-			}
-			if msg.NodeID == nodeID {
-				ids = append(ids, id)
-			}
-		}
-		if err := rows.Err(); err != nil {
-			return err
-		}
-		if len(ids) == 0 {
-			return nil
-		}
-
-		now := time.Now().UnixMilli()
-		args := make([]any, 0, len(ids)+1)
-		args = append(args, now)
-		placeholders := make([]string, 0, len(ids))
-		for _, id := range ids {
-			placeholders = append(placeholders, "?")
-			args = append(args, id)
-		}
-
-		query := "UPDATE outbox SET status = 'dead', next_retry_at = NULL, updated_at = ? WHERE id IN (" + strings.Join(placeholders, ",") + ")"
-		if _, err := conn.ExecContext(ctx, query, args...); err != nil {
-			return err
-		}
-
-		return nil
-	})
+	now := time.Now().UnixMilli()
+	res, err := s.db.ExecContext(ctx, "UPDATE outbox SET status = 'dead', next_retry_at = NULL, updated_at = ? WHERE node_id = ? AND status IN ('pending', 'processing')", now, nodeID)
 	if err != nil {
 		return 0, err
 	}
-
-	return int64(len(ids)), nil
+	return res.RowsAffected()
 }
@@ -0,0 +1,191 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Traffic history granularities.
+const (
+	GranularityHour = "hour"
+	GranularityDay  = "day"
+)
+
+// Traffic history group-by keys.
+const (
+	TrafficGroupNone    = ""
+	TrafficGroupForward = "forward"
+	TrafficGroupTunnel  = "tunnel"
+	TrafficGroupNode    = "node"
+	TrafficGroupUser    = "user"
+)
+
+var trafficGroupColumns = map[string]string{
+	TrafficGroupForward: "forward_id",
+	TrafficGroupTunnel:  "tunnel_id",
+	TrafficGroupNode:    "node_id",
+	TrafficGroupUser:    "user_id",
+}
+
+// FlowDelta is one flow increment attributed to a forward. NodeID is the
+// node that reported it. Time is unix millis and selects the history bucket.
+type FlowDelta struct {
+	ForwardID    int64
+	UserID       int64
+	UserTunnelID int64
+	TunnelID     int64
+	NodeID       int64
+	Down         int64
+	Up           int64
+	BilledDown   int64
+	BilledUp     int64
+	Time         int64
+}
+
+// TrafficQuery selects traffic history in [Start, End). Zero filters are ignored.
+type TrafficQuery struct {
+	Granularity string
+	GroupBy     string
+	Start       int64
+	End         int64
+	UserID      int64
+	ForwardID   int64
+	TunnelID    int64
+	NodeID      int64
+	// SubtreeOf limits results to this user and their sub-users.
+	SubtreeOf int64
+}
+
+// TrafficPoint is the traffic of one bucket, optionally for one group key.
+type TrafficPoint struct {
+	Time          int64 `json:"time"`
+	ID            int64 `json:"id,omitempty"`
+	InFlow        int64 `json:"inFlow"`
+	OutFlow       int64 `json:"outFlow"`
+	BilledInFlow  int64 `json:"billedInFlow"`
+	BilledOutFlow int64 `json:"billedOutFlow"`
+}
+
+// HourStart returns the start of the local hour containing t.
+func HourStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+}
+
+// DayStart returns local midnight of the day containing t.
+func DayStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+func addHourlyTraffic(ctx context.Context, conn *sql.Conn, delta FlowDelta) error {
+	ts := delta.Time
+	if ts == 0 {
+		ts = time.Now().UnixMilli()
+	}
+	bucket := HourStart(time.UnixMilli(ts)).UnixMilli()
+	_, err := conn.ExecContext(ctx, `INSERT INTO traffic_hourly(bucket, forward_id, user_id, tunnel_id, node_id, in_flow, out_flow, billed_in_flow, billed_out_flow)
+VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(bucket, forward_id, node_id) DO UPDATE SET
+  user_id = excluded.user_id,
+  tunnel_id = excluded.tunnel_id,
+  in_flow = in_flow + excluded.in_flow,
+  out_flow = out_flow + excluded.out_flow,
+  billed_in_flow = billed_in_flow + excluded.billed_in_flow,
+  billed_out_flow = billed_out_flow + excluded.billed_out_flow`,
+		bucket, delta.ForwardID, delta.UserID, delta.TunnelID, delta.NodeID, delta.Down, delta.Up, delta.BilledDown, delta.BilledUp)
+	return err
+}
+
+// RollupDailyTraffic rebuilds the daily rows of the day starting at dayStart
+// from the hourly history.
+func (s *Store) RollupDailyTraffic(ctx context.Context, dayStart time.Time) error {
+	start := DayStart(dayStart)
+	end := start.AddDate(0, 0, 1)
+	return s.withImmediateTx(ctx, func(conn *sql.Conn) error {
+		if _, err := conn.ExecContext(ctx, `DELETE FROM traffic_daily WHERE bucket = ?`, start.UnixMilli()); err != nil {
+			return err
+		}
+		_, err := conn.ExecContext(ctx, `INSERT INTO traffic_daily(bucket, forward_id, user_id, tunnel_id, node_id, in_flow, out_flow, billed_in_flow, billed_out_flow)
+SELECT ?, forward_id, MAX(user_id), MAX(tunnel_id), node_id, SUM(in_flow), SUM(out_flow), SUM(billed_in_flow), SUM(billed_out_flow)
+FROM traffic_hourly WHERE bucket >= ? AND bucket < ? GROUP BY forward_id, node_id`,
+			start.UnixMilli(), start.UnixMilli(), end.UnixMilli())
+		return err
+	})
+}
+
+// DeleteTrafficBefore trims hourly and daily history older than the cutoffs.
+func (s *Store) DeleteTrafficBefore(ctx context.Context, hourlyCutoff, dailyCutoff int64) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM traffic_hourly WHERE bucket < ?`, hourlyCutoff); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, `DELETE FROM traffic_daily WHERE bucket < ?`, dailyCutoff)
+	return err
+}
+
+// QueryTraffic sums traffic history per bucket and, if requested, per group key.
+func (s *Store) QueryTraffic(ctx context.Context, q TrafficQuery) ([]TrafficPoint, error) {
+	table := "traffic_hourly"
+	switch q.Granularity {
+	case GranularityHour:
+	case GranularityDay:
+		table = "traffic_daily"
+	default:
+		return nil, fmt.Errorf("unknown granularity %q", q.Granularity)
+	}
+
+	keyExpr, groupBy := "0", "bucket"
+	if q.GroupBy != TrafficGroupNone {
+		col, ok := trafficGroupColumns[q.GroupBy]
+		if !ok {
+			return nil, fmt.Errorf("unknown group %q", q.GroupBy)
+		}
+		keyExpr, groupBy = col, "bucket, "+col
+	}
+
+	prefix := ""
+	var args []any
+	if q.SubtreeOf != 0 {
+		prefix = subtreeCTE + " "
+		args = append(args, q.SubtreeOf)
+	}
+	where := []string{"bucket >= ?", "bucket < ?"}
+	args = append(args, q.Start, q.End)
+	if q.SubtreeOf != 0 {
+		where = append(where, "user_id IN (SELECT id FROM subtree)")
+	}
+	filters := []struct {
+		col string
+		val int64
+	}{
+		{"user_id", q.UserID},
+		{"forward_id", q.ForwardID},
+		{"tunnel_id", q.TunnelID},
+		{"node_id", q.NodeID},
+	}
+	for _, f := range filters {
+		if f.val != 0 {
+			where = append(where, f.col+" = ?")
+			args = append(args, f.val)
+		}
+	}
+
+	query := prefix + `SELECT bucket, ` + keyExpr + `, SUM(in_flow), SUM(out_flow), SUM(billed_in_flow), SUM(billed_out_flow) FROM ` + table +
+		` WHERE ` + strings.Join(where, " AND ") + ` GROUP BY ` + groupBy + ` ORDER BY ` + groupBy
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []TrafficPoint
+	for rows.Next() {
+		var p TrafficPoint
+		if err := rows.Scan(&p.Time, &p.ID, &p.InFlow, &p.OutFlow, &p.BilledInFlow, &p.BilledOutFlow); err != nil {
+			return nil, err
+		}
+		list = append(list, p)
+	}
+	return list, rows.Err()
+}
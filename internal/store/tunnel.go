@@ -7,8 +7,10 @@ import (
 )
 
 func (s *Store) GetTunnelByID(ctx context.Context, id int64) (*Tunnel, error) {
-	row := s.db.QueryRowContext(ctx, `SELECT id, name, traffic_ratio, in_node_id, in_ip, out_node_id, out_ip, type, protocol, flow, tcp_listen_addr, udp_listen_addr, interface_name, created_time, updated_time, status FROM tunnel WHERE id = ?`, id)
-	return scanTunnel(row)
+	return s.cache.tunnels.get(id, func() (*Tunnel, error) {
+		row := s.db.QueryRowContext(ctx, `SELECT id, name, traffic_ratio, in_node_id, in_ip, out_node_id, out_ip, type, protocol, flow, tcp_listen_addr, udp_listen_addr, interface_name, created_time, updated_time, status FROM tunnel WHERE id = ?`, id)
+		return scanTunnel(row)
+	})
 }
 
 func (s *Store) GetTunnelByName(ctx context.Context, name string) (*Tunnel, error) {
@@ -48,11 +50,16 @@ func (s *Store) InsertTunnel(ctx context.Context, tunnel *Tunnel) (int64, error)
 func (s *Store) UpdateTunnel(ctx context.Context, tunnel *Tunnel) error {
 	_, err := s.db.ExecContext(ctx, `UPDATE tunnel SET name = ?, traffic_ratio = ?, protocol = ?, flow = ?, tcp_listen_addr = ?, udp_listen_addr = ?, interface_name = ?, updated_time = ?, status = ? WHERE id = ?`,
 		tunnel.Name, tunnel.TrafficRatio, tunnel.Protocol, tunnel.Flow, tunnel.TCPListenAddr, tunnel.UDPListenAddr, tunnel.InterfaceName, tunnel.UpdatedTime, tunnel.Status, tunnel.ID)
+	s.cache.tunnels.invalidate(tunnel.ID)
 	return err
 }
 
 func (s *Store) DeleteTunnel(ctx context.Context, id int64) error {
 	_, err := s.db.ExecContext(ctx, `DELETE FROM tunnel WHERE id = ?`, id)
+	// user_tunnel rows cascade with the tunnel.
+	s.cache.tunnels.invalidate(id)
+	s.cache.userTunnels.clear()
+	s.cache.utPairs.clear()
 	return err
 }
 
@@ -76,11 +83,13 @@ func (s *Store) CountTunnelsByOutNode(ctx context.Context, nodeID int64) (int64,
 
 func (s *Store) UpdateTunnelsInIP(ctx context.Context, nodeID int64, ip string) error {
 	_, err := s.db.ExecContext(ctx, `UPDATE tunnel SET in_ip = ? WHERE in_node_id = ?`, ip, nodeID)
+	s.cache.tunnels.clear()
 	return err
 }
 
 func (s *Store) UpdateTunnelsOutIP(ctx context.Context, nodeID int64, ip string) error {
 	_, err := s.db.ExecContext(ctx, `UPDATE tunnel SET out_ip = ? WHERE out_node_id = ?`, ip, nodeID)
+	s.cache.tunnels.clear()
 	return err
 }
 
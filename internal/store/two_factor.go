@@ -0,0 +1,115 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+func (s *Store) GetUserTOTP(ctx context.Context, userID int64) (*UserTOTP, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT user_id, secret, enabled, last_step, created_time, updated_time FROM user_totp WHERE user_id = ?`, userID)
+	var t UserTOTP
+	var enabled int64
+	var updated sql.NullInt64
+	if err := row.Scan(&t.UserID, &t.Secret, &enabled, &t.LastStep, &t.CreatedTime, &updated); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	t.Enabled = enabled == 1
+	if updated.Valid {
+		t.UpdatedTime = &updated.Int64
+	}
+	return &t, nil
+}
+
+// SavePendingTOTP stores a new, not yet enabled secret for the user. An
+// enabled enrollment is left untouched.
+func (s *Store) SavePendingTOTP(ctx context.Context, userID int64, secret string) error {
+	now := time.Now().UnixMilli()
+	res, err := s.db.ExecContext(ctx, `INSERT INTO user_totp(user_id, secret, enabled, last_step, created_time, updated_time) VALUES(?, ?, 0, 0, ?, NULL)
+		ON CONFLICT(user_id) DO UPDATE SET secret = excluded.secret, last_step = 0, updated_time = ? WHERE enabled = 0`,
+		userID, secret, now, now)
+	if err != nil {
+		return err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return errors.New("totp already enabled")
+	}
+	return nil
+}
+
+// EnableTOTP activates the pending secret, marks step as used and replaces the
+// recovery codes with codeHashes.
+func (s *Store) EnableTOTP(ctx context.Context, userID, step int64, codeHashes []string) error {
+	now := time.Now().UnixMilli()
+	return s.withImmediateTx(ctx, func(conn *sql.Conn) error {
+		res, err := conn.ExecContext(ctx, `UPDATE user_totp SET enabled = 1, last_step = ?, updated_time = ? WHERE user_id = ? AND enabled = 0`, step, now, userID)
+		if err != nil {
+			return err
+		}
+		if affected, _ := res.RowsAffected(); affected == 0 {
+			return ErrNotFound
+		}
+		return replaceRecoveryCodes(ctx, conn, userID, codeHashes, now)
+	})
+}
+
+// UseTOTPStep records step as the last accepted one. It reports false when
+// the step, or a later one, was already used.
+func (s *Store) UseTOTPStep(ctx context.Context, userID, step int64) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `UPDATE user_totp SET last_step = ? WHERE user_id = ? AND last_step < ?`, step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	affected, _ := res.RowsAffected()
+	return affected == 1, nil
+}
+
+// UseRecoveryCode consumes an unused recovery code matching codeHash.
+func (s *Store) UseRecoveryCode(ctx context.Context, userID int64, codeHash string) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `UPDATE user_recovery_code SET used_time = ? WHERE user_id = ? AND code_hash = ? AND used_time IS NULL`,
+		time.Now().UnixMilli(), userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	affected, _ := res.RowsAffected()
+	return affected > 0, nil
+}
+
+func (s *Store) ReplaceRecoveryCodes(ctx context.Context, userID int64, codeHashes []string) error {
+	return s.withImmediateTx(ctx, func(conn *sql.Conn) error {
+		return replaceRecoveryCodes(ctx, conn, userID, codeHashes, time.Now().UnixMilli())
+	})
+}
+
+func (s *Store) CountUnusedRecoveryCodes(ctx context.Context, userID int64) (int64, error) {
+	var count int64
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(1) FROM user_recovery_code WHERE user_id = ? AND used_time IS NULL`, userID).Scan(&count)
+	return count, err
+}
+
+// DeleteUserTOTP removes the enrollment and recovery codes of the user.
+func (s *Store) DeleteUserTOTP(ctx context.Context, userID int64) error {
+	return s.withImmediateTx(ctx, func(conn *sql.Conn) error {
+		if _, err := conn.ExecContext(ctx, `DELETE FROM user_recovery_code WHERE user_id = ?`, userID); err != nil {
+			return err
+		}
+		_, err := conn.ExecContext(ctx, `DELETE FROM user_totp WHERE user_id = ?`, userID)
+		return err
+	})
+}
+
+func replaceRecoveryCodes(ctx context.Context, conn *sql.Conn, userID int64, codeHashes []string, now int64) error {
+	if _, err := conn.ExecContext(ctx, `DELETE FROM user_recovery_code WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		if _, err := conn.ExecContext(ctx, `INSERT INTO user_recovery_code(user_id, code_hash, used_time, created_time) VALUES(?, ?, NULL, ?)`, userID, hash, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -6,18 +6,26 @@ import (
 	"errors"
 )
 
+// subtreeCTE selects the user bound to ? and every sub-user below them.
+const subtreeCTE = `WITH RECURSIVE subtree(id) AS (SELECT ? UNION SELECT u.id FROM user u JOIN subtree ON u.parent_id = subtree.id)`
+
+// ancestorsCTE selects the resellers above the user bound to ?.
+const ancestorsCTE = `WITH RECURSIVE ancestors(id) AS (SELECT parent_id FROM user WHERE id = ? AND parent_id != 0 UNION SELECT u.parent_id FROM user u JOIN ancestors ON u.id = ancestors.id WHERE u.parent_id != 0)`
+
 func (s *Store) GetUserByID(ctx context.Context, id int64) (*User, error) {
-	row := s.db.QueryRowContext(ctx, `SELECT id, user, pwd, role_id, exp_time, flow, in_flow, out_flow, flow_reset_time, num, created_time, updated_time, status FROM user WHERE id = ?`, id)
-	return scanUser(row)
+	return s.cache.users.get(id, func() (*User, error) {
+		row := s.db.QueryRowContext(ctx, `SELECT id, user, pwd, role_id, parent_id, exp_time, flow, in_flow, out_flow, flow_reset_time, num, created_time, updated_time, status, must_change_password FROM user WHERE id = ?`, id)
+		return scanUser(row)
+	})
 }
 
 func (s *Store) GetUserByName(ctx context.Context, username string) (*User, error) {
-	row := s.db.QueryRowContext(ctx, `SELECT id, user, pwd, role_id, exp_time, flow, in_flow, out_flow, flow_reset_time, num, created_time, updated_time, status FROM user WHERE user = ?`, username)
+	row := s.db.QueryRowContext(ctx, `SELECT id, user, pwd, role_id, parent_id, exp_time, flow, in_flow, out_flow, flow_reset_time, num, created_time, updated_time, status, must_change_password FROM user WHERE user = ?`, username)
 	return scanUser(row)
 }
 
 func (s *Store) ListUsers(ctx context.Context) ([]User, error) {
-	rows, err := s.db.QueryContext(ctx, `SELECT id, user, pwd, role_id, exp_time, flow, in_flow, out_flow, flow_reset_time, num, created_time, updated_time, status FROM user ORDER BY id`) 
+	rows, err := s.db.QueryContext(ctx, `SELECT id, user, pwd, role_id, parent_id, exp_time, flow, in_flow, out_flow, flow_reset_time, num, created_time, updated_time, status, must_change_password FROM user ORDER BY id`)
 	if err != nil {
 		return nil, err
 	}
@@ -34,10 +42,40 @@ func (s *Store) ListUsers(ctx context.Context) ([]User, error) {
 	return users, rows.Err()
 }
 
+// ListSubUserIDs returns every user below userID in the reseller tree.
+func (s *Store) ListSubUserIDs(ctx context.Context, userID int64) ([]int64, error) {
+	rows, err := s.db.QueryContext(ctx, subtreeCTE+` SELECT id FROM subtree WHERE id != ? ORDER BY id`, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *Store) CountChildUsers(ctx context.Context, userID int64) (int64, error) {
+	var count int64
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(1) FROM user WHERE parent_id = ?`, userID).Scan(&count)
+	return count, err
+}
+
+const insertUserQuery = `INSERT INTO user(user, pwd, role_id, parent_id, exp_time, flow, in_flow, out_flow, flow_reset_time, num, created_time, updated_time, status, must_change_password)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+func insertUserArgs(user *User) []any {
+	return []any{user.User, user.Pwd, user.RoleID, user.ParentID, user.ExpTime, user.Flow, user.InFlow, user.OutFlow, user.FlowResetTime, user.Num, user.CreatedTime, user.UpdatedTime, user.Status, user.MustChangePassword}
+}
+
 func (s *Store) InsertUser(ctx context.Context, user *User) (int64, error) {
-	res, err := s.db.ExecContext(ctx, `INSERT INTO user(user, pwd, role_id, exp_time, flow, in_flow, out_flow, flow_reset_time, num, created_time, updated_time, status)
-		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		user.User, user.Pwd, user.RoleID, user.ExpTime, user.Flow, user.InFlow, user.OutFlow, user.FlowResetTime, user.Num, user.CreatedTime, user.UpdatedTime, user.Status)
+	res, err := s.db.ExecContext(ctx, insertUserQuery, insertUserArgs(user)...)
 	if err != nil {
 		return 0, err
 	}
@@ -46,12 +84,14 @@ func (s *Store) InsertUser(ctx context.Context, user *User) (int64, error) {
 }
 
 func (s *Store) UpdateUser(ctx context.Context, user *User) error {
-	_, err := s.db.ExecContext(ctx, `UPDATE user SET user = ?, pwd = ?, role_id = ?, exp_time = ?, flow = ?, in_flow = ?, out_flow = ?, flow_reset_time = ?, num = ?, updated_time = ?, status = ? WHERE id = ?`,
-		user.User, user.Pwd, user.RoleID, user.ExpTime, user.Flow, user.InFlow, user.OutFlow, user.FlowResetTime, user.Num, user.UpdatedTime, user.Status, user.ID)
+	_, err := s.db.ExecContext(ctx, `UPDATE user SET user = ?, pwd = ?, role_id = ?, parent_id = ?, exp_time = ?, flow = ?, in_flow = ?, out_flow = ?, flow_reset_time = ?, num = ?, updated_time = ?, status = ?, must_change_password = ? WHERE id = ?`,
+		user.User, user.Pwd, user.RoleID, user.ParentID, user.ExpTime, user.Flow, user.InFlow, user.OutFlow, user.FlowResetTime, user.Num, user.UpdatedTime, user.Status, user.MustChangePassword, user.ID)
+	s.cache.users.invalidate(user.ID)
 	return err
 }
 
 func (s *Store) UpdateUserFields(ctx context.Context, id int64, user string, pwd *string, flow int64, num int64, expTime int64, flowReset int64, status int64, updated int64) error {
+	defer s.cache.users.invalidate(id)
 	if pwd != nil {
 		_, err := s.db.ExecContext(ctx, `UPDATE user SET user = ?, pwd = ?, flow = ?, num = ?, exp_time = ?, flow_reset_time = ?, status = ?, updated_time = ? WHERE id = ?`,
 			user, *pwd, flow, num, expTime, flowReset, status, updated, id)
@@ -62,15 +102,36 @@ func (s *Store) UpdateUserFields(ctx context.Context, id int64, user string, pwd
 	return err
 }
 
+// SetUserMustChangePassword sets whether the user must change their password
+// before using anything else.
+func (s *Store) SetUserMustChangePassword(ctx context.Context, id int64, must bool) error {
+	defer s.cache.users.invalidate(id)
+	_, err := s.db.ExecContext(ctx, `UPDATE user SET must_change_password = ? WHERE id = ?`, must, id)
+	return err
+}
+
+// ResetUserFlowsForDay zeroes the used flow of users whose reset day is day.
+// Reset days past the end of the month fire on its last day.
+func (s *Store) ResetUserFlowsForDay(ctx context.Context, day, lastDay int) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE user SET in_flow = 0, out_flow = 0 WHERE flow_reset_time != 0 AND (flow_reset_time = ? OR (flow_reset_time > ? AND ? = ?))`, day, lastDay, day, lastDay)
+	s.cache.users.clear()
+	return err
+}
+
 func (s *Store) DeleteUser(ctx context.Context, id int64) error {
 	_, err := s.db.ExecContext(ctx, `DELETE FROM user WHERE id = ?`, id)
+	// user_tunnel and forward rows cascade with the user.
+	s.cache.users.invalidate(id)
+	s.cache.userTunnels.clear()
+	s.cache.utPairs.clear()
+	s.cache.forwards.clear()
 	return err
 }
 
 func scanUser(scanner interface{ Scan(dest ...any) error }) (*User, error) {
 	var user User
 	var updated sql.NullInt64
-	if err := scanner.Scan(&user.ID, &user.User, &user.Pwd, &user.RoleID, &user.ExpTime, &user.Flow, &user.InFlow, &user.OutFlow, &user.FlowResetTime, &user.Num, &user.CreatedTime, &updated, &user.Status); err != nil {
+	if err := scanner.Scan(&user.ID, &user.User, &user.Pwd, &user.RoleID, &user.ParentID, &user.ExpTime, &user.Flow, &user.InFlow, &user.OutFlow, &user.FlowResetTime, &user.Num, &user.CreatedTime, &updated, &user.Status, &user.MustChangePassword); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNotFound
 		}
@@ -13,13 +13,30 @@ type UserTunnelDetail struct {
 }
 
 func (s *Store) GetUserTunnelByID(ctx context.Context, id int64) (*UserTunnel, error) {
-	row := s.db.QueryRowContext(ctx, `SELECT id, user_id, tunnel_id, speed_id, num, flow, in_flow, out_flow, flow_reset_time, exp_time, status FROM user_tunnel WHERE id = ?`, id)
-	return scanUserTunnel(row)
+	return s.cache.userTunnels.get(id, func() (*UserTunnel, error) {
+		row := s.db.QueryRowContext(ctx, `SELECT id, user_id, tunnel_id, speed_id, num, flow, in_flow, out_flow, flow_reset_time, exp_time, status FROM user_tunnel WHERE id = ?`, id)
+		return scanUserTunnel(row)
+	})
 }
 
+// GetUserTunnelByUserAndTunnel resolves the pair to an ID through the cache,
+// remembering pairs without a user_tunnel as well.
 func (s *Store) GetUserTunnelByUserAndTunnel(ctx context.Context, userID, tunnelID int64) (*UserTunnel, error) {
-	row := s.db.QueryRowContext(ctx, `SELECT id, user_id, tunnel_id, speed_id, num, flow, in_flow, out_flow, flow_reset_time, exp_time, status FROM user_tunnel WHERE user_id = ? AND tunnel_id = ?`, userID, tunnelID)
-	return scanUserTunnel(row)
+	id, err := s.cache.utPairs.get(userID, tunnelID, func() (int64, error) {
+		var id int64
+		err := s.db.QueryRowContext(ctx, `SELECT id FROM user_tunnel WHERE user_id = ? AND tunnel_id = ?`, userID, tunnelID).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+		return id, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if id == 0 {
+		return nil, ErrNotFound
+	}
+	return s.GetUserTunnelByID(ctx, id)
 }
 
 func (s *Store) ListUserTunnelsByUser(ctx context.Context, userID int64) ([]UserTunnelDetail, error) {
@@ -49,6 +66,7 @@ func (s *Store) InsertUserTunnel(ctx context.Context, ut *UserTunnel) (int64, er
 	res, err := s.db.ExecContext(ctx, `INSERT INTO user_tunnel(user_id, tunnel_id, speed_id, num, flow, in_flow, out_flow, flow_reset_time, exp_time, status)
 		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		ut.UserID, ut.TunnelID, ut.SpeedID, ut.Num, ut.Flow, ut.InFlow, ut.OutFlow, ut.FlowResetTime, ut.ExpTime, ut.Status)
+	s.cache.utPairs.clear()
 	if err != nil {
 		return 0, err
 	}
@@ -59,11 +77,22 @@ func (s *Store) InsertUserTunnel(ctx context.Context, ut *UserTunnel) (int64, er
 func (s *Store) UpdateUserTunnel(ctx context.Context, ut *UserTunnel) error {
 	_, err := s.db.ExecContext(ctx, `UPDATE user_tunnel SET speed_id = ?, num = ?, flow = ?, in_flow = ?, out_flow = ?, flow_reset_time = ?, exp_time = ?, status = ? WHERE id = ?`,
 		ut.SpeedID, ut.Num, ut.Flow, ut.InFlow, ut.OutFlow, ut.FlowResetTime, ut.ExpTime, ut.Status, ut.ID)
+	s.cache.userTunnels.invalidate(ut.ID)
+	return err
+}
+
+// ResetUserTunnelFlowsForDay zeroes the used flow of user tunnels whose reset
+// day is day. Reset days past the end of the month fire on its last day.
+func (s *Store) ResetUserTunnelFlowsForDay(ctx context.Context, day, lastDay int) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE user_tunnel SET in_flow = 0, out_flow = 0 WHERE flow_reset_time != 0 AND (flow_reset_time = ? OR (flow_reset_time > ? AND ? = ?))`, day, lastDay, day, lastDay)
+	s.cache.userTunnels.clear()
 	return err
 }
 
 func (s *Store) DeleteUserTunnel(ctx context.Context, id int64) error {
 	_, err := s.db.ExecContext(ctx, `DELETE FROM user_tunnel WHERE id = ?`, id)
+	s.cache.userTunnels.invalidate(id)
+	s.cache.utPairs.clear()
 	return err
 }
 
@@ -0,0 +1,69 @@
+package tasks
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	backupPrefix = "pixia-backup-"
+	backupSuffix = ".db"
+)
+
+// Backup writes a snapshot of the database into dir and keeps only the
+// newest retention snapshots. A retention of 0 or less keeps everything.
+func (s *Scheduler) Backup(ctx context.Context, dir string, retention int) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	name := backupPrefix + time.Now().Format("20060102150405") + backupSuffix
+	path := filepath.Join(dir, name)
+	tmp := path + ".tmp"
+	_ = os.Remove(tmp)
+	if err := s.store.Snapshot(ctx, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	if retention <= 0 {
+		return nil
+	}
+	return pruneBackups(dir, retention)
+}
+
+func pruneBackups(dir string, retention int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, backupPrefix) || !strings.HasSuffix(name, backupSuffix) {
+			continue
+		}
+		names = append(names, name)
+	}
+	if len(names) <= retention {
+		return nil
+	}
+
+	// Timestamped names sort chronologically.
+	sort.Strings(names)
+	for _, name := range names[:len(names)-retention] {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -19,45 +19,36 @@ func New(store *store.Store, api *httpapi.Server) *Scheduler {
 	return &Scheduler{store: store, api: api}
 }
 
-// HourlyStatistics collects per-user flow stats and trims old records.
-func (s *Scheduler) HourlyStatistics(ctx context.Context) {
-	now := time.Now()
-	cutoff := now.Add(-48 * time.Hour).UnixMilli()
-	_ = s.store.DeleteStatisticsOlderThan(ctx, cutoff)
+// Traffic history retention.
+const (
+	hourlyTrafficRetention = 30 * 24 * time.Hour
+	dailyTrafficRetention  = 365 * 24 * time.Hour
+)
 
-	rows, err := s.store.DB().QueryContext(ctx, `SELECT id, in_flow, out_flow FROM user`)
-	if err != nil {
-		return
-	}
-	defer rows.Close()
+// sessionRetention is how long expired or revoked sessions are kept.
+const sessionRetention = 7 * 24 * time.Hour
 
-	var items []store.StatisticsFlow
-	hourString := now.Format("15:04")
-	created := now.UnixMilli()
-	for rows.Next() {
-		var userID, inFlow, outFlow int64
-		if err := rows.Scan(&userID, &inFlow, &outFlow); err != nil {
-			continue
-		}
-		total := inFlow + outFlow
-
-		var lastTotal int64
-		row := s.store.DB().QueryRowContext(ctx, `SELECT total_flow FROM statistics_flow WHERE user_id = ? ORDER BY id DESC LIMIT 1`, userID)
-		_ = row.Scan(&lastTotal)
-		increment := total - lastTotal
-		if increment < 0 {
-			increment = total
-		}
+// loginAttemptRetention is how long failed login counters outlive their last
+// failure or lockout.
+const loginAttemptRetention = 24 * time.Hour
 
-		items = append(items, store.StatisticsFlow{
-			UserID:      userID,
-			Flow:        increment,
-			TotalFlow:   total,
-			Time:        hourString,
-			CreatedTime: created,
-		})
-	}
-	_ = s.store.InsertStatistics(ctx, items)
+// nodeEnrollmentRetention is how long unused install tokens are listed after
+// they expire.
+const nodeEnrollmentRetention = 7 * 24 * time.Hour
+
+// HourlyStatistics rolls hourly traffic up into daily rows and trims old history.
+func (s *Scheduler) HourlyStatistics(ctx context.Context) {
+	now := time.Now()
+	today := store.DayStart(now)
+	// Yesterday is rebuilt too so the run just after midnight finalizes it.
+	_ = s.store.RollupDailyTraffic(ctx, today.AddDate(0, 0, -1))
+	_ = s.store.RollupDailyTraffic(ctx, today)
+	_ = s.store.DeleteTrafficBefore(ctx, now.Add(-hourlyTrafficRetention).UnixMilli(), now.Add(-dailyTrafficRetention).UnixMilli())
+}
+
+// RetireNodeSecrets ends the grace window of rotated node secrets.
+func (s *Scheduler) RetireNodeSecrets(ctx context.Context) {
+	s.api.RetireNodeSecrets(ctx)
 }
 
 // DailyReset resets flows and handles expiration.
@@ -66,13 +57,20 @@ func (s *Scheduler) DailyReset(ctx context.Context) {
 	day := today.Day()
 	lastDay := daysInMonth(today)
 
-	_, _ = s.store.DB().ExecContext(ctx, `UPDATE user SET in_flow = 0, out_flow = 0 WHERE flow_reset_time != 0 AND (flow_reset_time = ? OR (flow_reset_time > ? AND ? = ?))`, day, lastDay, day, lastDay)
-	_, _ = s.store.DB().ExecContext(ctx, `UPDATE user_tunnel SET in_flow = 0, out_flow = 0 WHERE flow_reset_time != 0 AND (flow_reset_time = ? OR (flow_reset_time > ? AND ? = ?))`, day, lastDay, day, lastDay)
+	_ = s.store.ResetUserFlowsForDay(ctx, day, lastDay)
+	_ = s.store.ResetUserTunnelFlowsForDay(ctx, day, lastDay)
 
 	s.expireUsers(ctx)
 	s.expireUserTunnels(ctx)
+	s.api.ResumeQuotaPausedForwards(ctx, 0)
+	_ = s.store.DeleteSessionsBefore(ctx, today.Add(-sessionRetention).UnixMilli())
+	_ = s.store.DeleteLoginAttemptsBefore(ctx, today.Add(-loginAttemptRetention).UnixMilli())
+	_ = s.store.DeleteExpiredNodeEnrollments(ctx, today.Add(-nodeEnrollmentRetention).UnixMilli())
 }
 
+// expireUsers pauses the forwards of expired users. The users stay enabled so
+// the pause reason alone records the expiry, and extending exp_time resumes
+// their forwards.
 func (s *Scheduler) expireUsers(ctx context.Context) {
 	now := time.Now().UnixMilli()
 	rows, err := s.store.DB().QueryContext(ctx, `SELECT id FROM user WHERE role_id != 0 AND status = 1 AND exp_time IS NOT NULL AND exp_time < ?`, now)
@@ -86,11 +84,18 @@ func (s *Scheduler) expireUsers(ctx context.Context) {
 		if err := rows.Scan(&id); err != nil {
 			continue
 		}
-		_, _ = s.store.DB().ExecContext(ctx, `UPDATE user SET status = 0 WHERE id = ?`, id)
 		s.pauseUserForwards(ctx, id)
+		// Sub-users draw from the expired reseller's pool.
+		if subs, err := s.store.ListSubUserIDs(ctx, id); err == nil {
+			for _, sub := range subs {
+				s.pauseUserForwards(ctx, sub)
+			}
+		}
 	}
 }
 
+// expireUserTunnels pauses the forwards on expired user_tunnels, which stay
+// enabled like expired users.
 func (s *Scheduler) expireUserTunnels(ctx context.Context) {
 	now := time.Now().UnixMilli()
 	rows, err := s.store.DB().QueryContext(ctx, `SELECT id, user_id, tunnel_id FROM user_tunnel WHERE status = 1 AND exp_time IS NOT NULL AND exp_time < ?`, now)
@@ -104,7 +109,6 @@ func (s *Scheduler) expireUserTunnels(ctx context.Context) {
 		if err := rows.Scan(&id, &userID, &tunnelID); err != nil {
 			continue
 		}
-		_, _ = s.store.DB().ExecContext(ctx, `UPDATE user_tunnel SET status = 0 WHERE id = ?`, id)
 		s.pauseUserTunnelForwards(ctx, userID, tunnelID)
 	}
 }
@@ -115,12 +119,15 @@ func (s *Scheduler) pauseUserForwards(ctx context.Context, userID int64) {
 		return
 	}
 	for _, fw := range forwards {
+		if fw.Status != 1 {
+			continue
+		}
 		name := buildServiceName(fw.ID, fw.UserID, s.resolveUserTunnelID(ctx, fw.UserID, fw.TunnelID))
 		_ = s.api.EnqueueGost(ctx, fw.InNodeID, "PauseService", gost.PauseServiceData(name))
 		if fw.TunnelType == 2 {
 			_ = s.api.EnqueueGost(ctx, fw.OutNodeID, "PauseService", gost.PauseRemoteServiceData(name))
 		}
-		_ = s.store.UpdateForwardStatus(ctx, fw.ID, 0, "paused", time.Now().UnixMilli())
+		_ = s.store.PauseForward(ctx, fw.ID, store.PauseReasonExpired, time.Now().UnixMilli())
 	}
 }
 
@@ -130,7 +137,7 @@ func (s *Scheduler) pauseUserTunnelForwards(ctx context.Context, userID, tunnelI
 		return
 	}
 	for _, fw := range forwards {
-		if fw.TunnelID != tunnelID {
+		if fw.TunnelID != tunnelID || fw.Status != 1 {
 			continue
 		}
 		name := buildServiceName(fw.ID, fw.UserID, s.resolveUserTunnelID(ctx, fw.UserID, fw.TunnelID))
@@ -138,7 +145,7 @@ func (s *Scheduler) pauseUserTunnelForwards(ctx context.Context, userID, tunnelI
 		if fw.TunnelType == 2 {
 			_ = s.api.EnqueueGost(ctx, fw.OutNodeID, "PauseService", gost.PauseRemoteServiceData(name))
 		}
-		_ = s.store.UpdateForwardStatus(ctx, fw.ID, 0, "paused", time.Now().UnixMilli())
+		_ = s.store.PauseForward(ctx, fw.ID, store.PauseReasonExpired, time.Now().UnixMilli())
 	}
 }
 
@@ -0,0 +1,169 @@
+package tasks
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"pixia-panel/internal/db"
+	"pixia-panel/internal/flow"
+	"pixia-panel/internal/gost"
+	httpapi "pixia-panel/internal/http"
+	"pixia-panel/internal/migrate"
+	"pixia-panel/internal/store"
+
+	_ "modernc.org/sqlite"
+)
+
+// newTestScheduler returns a scheduler on a fresh database holding user 2
+// with forward 1 on tunnel 1 through user tunnel 1.
+func newTestScheduler(t *testing.T) (*Scheduler, *store.Store) {
+	t.Helper()
+	conn, err := db.Open(filepath.Join(t.TempDir(), "panel.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if err := migrate.Apply(conn, filepath.Join("..", "..", "migrations")); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(24 * time.Hour).UnixMilli()
+	for _, q := range []struct {
+		query string
+		args  []any
+	}{
+		{`INSERT INTO node(id, name, secret, server_ip, port_sta, port_end, created_time, status) VALUES (1, 'in', 's1', '192.0.2.1', 1000, 2000, 0, 1)`, nil},
+		{`INSERT INTO tunnel(id, name, traffic_ratio, in_node_id, in_ip, out_node_id, out_ip, type, flow, created_time, updated_time, status) VALUES (1, 't', 1, 1, '192.0.2.1', 1, '192.0.2.1', 1, 2, 0, 0, 1)`, nil},
+		{`INSERT INTO user(id, user, pwd, role_id, exp_time, flow, flow_reset_time, num, created_time, status) VALUES (2, 'u', 'x', 1, ?, 100, 0, 10, 0, 1)`, []any{future}},
+		{`INSERT INTO user_tunnel(id, user_id, tunnel_id, num, flow, flow_reset_time, exp_time, status) VALUES (1, 2, 1, 10, 100, 0, ?, 1)`, []any{future}},
+		{`INSERT INTO forward(id, user_id, user_name, name, tunnel_id, in_port, remote_addr, created_time, updated_time, status) VALUES (1, 2, 'u', 'f', 1, 1500, '198.51.100.1:80', 0, 0, 1)`, nil},
+	} {
+		if _, err := conn.Exec(q.query, q.args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	st := store.New(conn)
+	api := httpapi.NewServer(st, flow.New(st, flow.Options{}), gost.NewHub(), []byte("test-secret"), time.Minute, time.Hour)
+	return New(st, api), st
+}
+
+func forwardState(t *testing.T, st *store.Store) (status int64, reason string) {
+	t.Helper()
+	if err := st.DB().QueryRow(`SELECT status, pause_reason FROM forward WHERE id = 1`).Scan(&status, &reason); err != nil {
+		t.Fatal(err)
+	}
+	return status, reason
+}
+
+// renewUser extends user 2 by an hour.
+func renewUser(t *testing.T, st *store.Store) {
+	t.Helper()
+	exp := time.Now().Add(time.Hour).UnixMilli()
+	if err := st.UpdateUserFields(context.Background(), 2, "u", nil, 100, 10, exp, 0, 1, time.Now().UnixMilli()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRenewedUserResumesForwards(t *testing.T) {
+	s, st := newTestScheduler(t)
+	ctx := context.Background()
+	if _, err := st.DB().Exec(`UPDATE user SET exp_time = ? WHERE id = 2`, time.Now().Add(-time.Hour).UnixMilli()); err != nil {
+		t.Fatal(err)
+	}
+
+	s.DailyReset(ctx)
+	if status, reason := forwardState(t, st); status != 0 || reason != store.PauseReasonExpired {
+		t.Fatalf("forward after expiry = %d %q, want paused for expiry", status, reason)
+	}
+	var userStatus int64
+	if err := st.DB().QueryRow(`SELECT status FROM user WHERE id = 2`).Scan(&userStatus); err != nil {
+		t.Fatal(err)
+	}
+	if userStatus != 1 {
+		t.Fatalf("expired user status = %d, want 1", userStatus)
+	}
+
+	// Renewing, as handleUserUpdate does, resumes the forward.
+	renewUser(t, st)
+	s.api.ResumeQuotaPausedForwards(ctx, 2)
+	if status, reason := forwardState(t, st); status != 1 || reason != "" {
+		t.Fatalf("forward after renewal = %d %q, want running", status, reason)
+	}
+}
+
+func TestRenewedUserTunnelResumesForwards(t *testing.T) {
+	s, st := newTestScheduler(t)
+	ctx := context.Background()
+	if _, err := st.DB().Exec(`UPDATE user_tunnel SET exp_time = ? WHERE id = 1`, time.Now().Add(-time.Hour).UnixMilli()); err != nil {
+		t.Fatal(err)
+	}
+
+	s.DailyReset(ctx)
+	if status, reason := forwardState(t, st); status != 0 || reason != store.PauseReasonExpired {
+		t.Fatalf("forward after expiry = %d %q, want paused for expiry", status, reason)
+	}
+
+	ut, err := st.GetUserTunnelByID(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ut.ExpTime = time.Now().Add(time.Hour).UnixMilli()
+	if err := st.UpdateUserTunnel(ctx, ut); err != nil {
+		t.Fatal(err)
+	}
+	s.api.ResumeQuotaPausedForwards(ctx, 2)
+	if status, reason := forwardState(t, st); status != 1 || reason != "" {
+		t.Fatalf("forward after renewal = %d %q, want running", status, reason)
+	}
+}
+
+func TestUserPausedForwardStaysPausedOnExpiry(t *testing.T) {
+	s, st := newTestScheduler(t)
+	ctx := context.Background()
+	if err := st.PauseForward(ctx, 1, store.PauseReasonUser, time.Now().UnixMilli()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.DB().Exec(`UPDATE user SET exp_time = ? WHERE id = 2`, time.Now().Add(-time.Hour).UnixMilli()); err != nil {
+		t.Fatal(err)
+	}
+	s.DailyReset(ctx)
+
+	renewUser(t, st)
+	s.api.ResumeQuotaPausedForwards(ctx, 2)
+	if status, reason := forwardState(t, st); status != 0 || reason != store.PauseReasonUser {
+		t.Fatalf("forward = %d %q, want paused by the user", status, reason)
+	}
+}
+
+func TestRenewedResellerResumesSubUserForwards(t *testing.T) {
+	s, st := newTestScheduler(t)
+	ctx := context.Background()
+	future := time.Now().Add(24 * time.Hour).UnixMilli()
+	for _, q := range []struct {
+		query string
+		args  []any
+	}{
+		{`INSERT INTO user(id, user, pwd, role_id, exp_time, flow, flow_reset_time, num, created_time, status) VALUES (3, 'r', 'x', 5, ?, 100, 0, 10, 0, 1)`, []any{time.Now().Add(-time.Hour).UnixMilli()}},
+		{`INSERT INTO user_tunnel(id, user_id, tunnel_id, num, flow, flow_reset_time, exp_time, status) VALUES (2, 3, 1, 10, 100, 0, ?, 1)`, []any{future}},
+		{`UPDATE user SET parent_id = 3 WHERE id = 2`, nil},
+	} {
+		if _, err := st.DB().Exec(q.query, q.args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.DailyReset(ctx)
+	if status, _ := forwardState(t, st); status != 0 {
+		t.Fatal("sub-user forward still running after the reseller expired")
+	}
+
+	exp := time.Now().Add(time.Hour).UnixMilli()
+	if err := st.UpdateUserFields(ctx, 3, "r", nil, 100, 10, exp, 0, 1, time.Now().UnixMilli()); err != nil {
+		t.Fatal(err)
+	}
+	s.api.ResumeQuotaPausedForwards(ctx, 3)
+	if status, reason := forwardState(t, st); status != 1 || reason != "" {
+		t.Fatalf("sub-user forward after the reseller's renewal = %d %q, want running", status, reason)
+	}
+}
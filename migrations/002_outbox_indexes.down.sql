@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_outbox_status_updated_at;
+DROP INDEX IF EXISTS idx_outbox_status_next_retry_id;
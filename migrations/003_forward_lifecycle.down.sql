@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_outbox_forward_status;
+
+ALTER TABLE outbox DROP COLUMN forward_id;
+ALTER TABLE forward DROP COLUMN pause_reason;
+ALTER TABLE forward DROP COLUMN last_error;
@@ -0,0 +1,5 @@
+ALTER TABLE forward ADD COLUMN last_error TEXT;
+ALTER TABLE forward ADD COLUMN pause_reason TEXT NOT NULL DEFAULT '';
+ALTER TABLE outbox ADD COLUMN forward_id INTEGER;
+
+CREATE INDEX IF NOT EXISTS idx_outbox_forward_status ON outbox(forward_id, status);
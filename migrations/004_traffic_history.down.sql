@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS traffic_daily;
+DROP TABLE IF EXISTS traffic_hourly;
@@ -0,0 +1,40 @@
+-- Traffic history keeps one row per bucket, forward and reporting node, so
+-- traffic on a tunnel's exit node is attributed to that node.
+CREATE TABLE IF NOT EXISTS traffic_hourly (
+  bucket INTEGER NOT NULL,
+  forward_id INTEGER NOT NULL,
+  user_id INTEGER NOT NULL,
+  tunnel_id INTEGER NOT NULL,
+  node_id INTEGER NOT NULL,
+  in_flow INTEGER NOT NULL DEFAULT 0,
+  out_flow INTEGER NOT NULL DEFAULT 0,
+  billed_in_flow INTEGER NOT NULL DEFAULT 0,
+  billed_out_flow INTEGER NOT NULL DEFAULT 0,
+  PRIMARY KEY (bucket, forward_id, node_id)
+);
+
+CREATE TABLE IF NOT EXISTS traffic_daily (
+  bucket INTEGER NOT NULL,
+  forward_id INTEGER NOT NULL,
+  user_id INTEGER NOT NULL,
+  tunnel_id INTEGER NOT NULL,
+  node_id INTEGER NOT NULL,
+  in_flow INTEGER NOT NULL DEFAULT 0,
+  out_flow INTEGER NOT NULL DEFAULT 0,
+  billed_in_flow INTEGER NOT NULL DEFAULT 0,
+  billed_out_flow INTEGER NOT NULL DEFAULT 0,
+  PRIMARY KEY (bucket, forward_id, node_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_traffic_hourly_user_bucket ON traffic_hourly(user_id, bucket);
+CREATE INDEX IF NOT EXISTS idx_traffic_hourly_tunnel_bucket ON traffic_hourly(tunnel_id, bucket);
+CREATE INDEX IF NOT EXISTS idx_traffic_hourly_node_bucket ON traffic_hourly(node_id, bucket);
+CREATE INDEX IF NOT EXISTS idx_traffic_hourly_forward_bucket ON traffic_hourly(forward_id, bucket);
+CREATE INDEX IF NOT EXISTS idx_traffic_daily_user_bucket ON traffic_daily(user_id, bucket);
+CREATE INDEX IF NOT EXISTS idx_traffic_daily_tunnel_bucket ON traffic_daily(tunnel_id, bucket);
+CREATE INDEX IF NOT EXISTS idx_traffic_daily_node_bucket ON traffic_daily(node_id, bucket);
+CREATE INDEX IF NOT EXISTS idx_traffic_daily_forward_bucket ON traffic_daily(forward_id, bucket);
+
+-- statistics_flow is no longer written. Its rows carry no forward or node and
+-- cannot be moved into the tables above, so the table is kept for existing
+-- data until a later release drops it.
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  actor_id INTEGER NOT NULL,
+  actor_role INTEGER NOT NULL,
+  client_ip TEXT NOT NULL DEFAULT '',
+  action TEXT NOT NULL,
+  entity_type TEXT NOT NULL,
+  entity_id INTEGER NOT NULL DEFAULT 0,
+  before_json TEXT,
+  after_json TEXT,
+  created_time INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_created_time ON audit_log(created_time);
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor_created ON audit_log(actor_id, created_time);
+CREATE INDEX IF NOT EXISTS idx_audit_log_entity_created ON audit_log(entity_type, entity_id, created_time);
@@ -0,0 +1,3 @@
+DELETE FROM vite_config WHERE name = 'admin_require_2fa';
+DROP TABLE IF EXISTS user_recovery_code;
+DROP TABLE IF EXISTS user_totp;
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS api_token (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id INTEGER NOT NULL,
+  name TEXT NOT NULL,
+  token_hash TEXT NOT NULL UNIQUE,
+  prefix TEXT NOT NULL,
+  scopes TEXT NOT NULL,
+  exp_time INTEGER NOT NULL,
+  last_used_time INTEGER,
+  last_used_ip TEXT,
+  created_time INTEGER NOT NULL,
+  revoked_time INTEGER,
+  FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_token_user ON api_token(user_id);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS user_session (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id INTEGER NOT NULL,
+  refresh_hash TEXT NOT NULL UNIQUE,
+  prev_refresh_hash TEXT,
+  ip TEXT NOT NULL DEFAULT '',
+  user_agent TEXT NOT NULL DEFAULT '',
+  created_time INTEGER NOT NULL,
+  last_seen_time INTEGER NOT NULL,
+  exp_time INTEGER NOT NULL,
+  revoked_time INTEGER,
+  FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_session_user ON user_session(user_id);
+CREATE INDEX IF NOT EXISTS idx_user_session_prev_refresh ON user_session(prev_refresh_hash);
@@ -0,0 +1,3 @@
+UPDATE user SET role_id = 1 WHERE role_id != 0;
+DROP TABLE IF EXISTS role_permission;
+DROP TABLE IF EXISTS role;
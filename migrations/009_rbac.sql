@@ -0,0 +1,38 @@
+CREATE TABLE IF NOT EXISTS role (
+  id INTEGER PRIMARY KEY,
+  name TEXT NOT NULL UNIQUE,
+  description TEXT NOT NULL DEFAULT '',
+  builtin INTEGER NOT NULL DEFAULT 0,
+  created_time INTEGER NOT NULL,
+  updated_time INTEGER
+);
+
+CREATE TABLE IF NOT EXISTS role_permission (
+  role_id INTEGER NOT NULL,
+  permission TEXT NOT NULL,
+  PRIMARY KEY (role_id, permission),
+  FOREIGN KEY (role_id) REFERENCES role(id) ON DELETE CASCADE
+);
+
+-- Built-in roles. 0 and 1 keep the meaning role_id had before: administrator
+-- and regular user.
+INSERT OR IGNORE INTO role (id, name, description, builtin, created_time) VALUES
+  (0, 'admin', '管理员，拥有全部权限', 1, 1755147963000),
+  (1, 'user', '普通用户，只能管理自己的转发', 1, 1755147963000),
+  (2, 'operator', '运维，管理节点、隧道、限速与转发', 1, 1755147963000),
+  (3, 'support', '客服，只读访问全部数据', 1, 1755147963000),
+  (4, 'billing', '财务，调整流量配额与到期时间', 1, 1755147963000);
+
+INSERT OR IGNORE INTO role_permission (role_id, permission) VALUES
+  (0, '*'),
+  (2, 'node.read'), (2, 'node.write'),
+  (2, 'tunnel.read'), (2, 'tunnel.write'),
+  (2, 'speed_limit.read'), (2, 'speed_limit.write'),
+  (2, 'forward.read'), (2, 'forward.write'),
+  (2, 'traffic.read'), (2, 'cache.read'),
+  (3, 'user.read'), (3, 'node.read'), (3, 'tunnel.read'), (3, 'speed_limit.read'),
+  (3, 'forward.read'), (3, 'traffic.read'), (3, 'audit.read'), (3, 'cache.read'),
+  (4, 'user.read'), (4, 'user.quota'), (4, 'tunnel.read');
+
+-- Anything that was not an administrator was a regular user.
+UPDATE user SET role_id = 1 WHERE role_id NOT IN (SELECT id FROM role);
@@ -0,0 +1,6 @@
+UPDATE user SET role_id = 1 WHERE role_id = 5;
+DELETE FROM role_permission WHERE role_id = 5;
+DELETE FROM role WHERE id = 5;
+
+DROP INDEX IF EXISTS idx_user_parent_id;
+ALTER TABLE user DROP COLUMN parent_id;
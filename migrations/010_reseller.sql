@@ -0,0 +1,10 @@
+-- parent_id links a sub-user to the reseller that created it; 0 means none.
+ALTER TABLE user ADD COLUMN parent_id INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_user_parent_id ON user(parent_id);
+
+INSERT OR IGNORE INTO role (id, name, description, builtin, created_time) VALUES
+  (5, 'reseller', '代理商，在自身配额内管理下级用户', 1, 1755147963000);
+
+INSERT OR IGNORE INTO role_permission (role_id, permission) VALUES
+  (5, 'subuser.write');
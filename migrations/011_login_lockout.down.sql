@@ -0,0 +1,4 @@
+DELETE FROM vite_config WHERE name IN ('login_max_failures', 'login_ip_max_failures', 'login_failure_window_seconds', 'login_lockout_seconds', 'login_lockout_max_seconds');
+
+DROP INDEX IF EXISTS idx_login_attempt_locked_until;
+DROP TABLE IF EXISTS login_attempt;
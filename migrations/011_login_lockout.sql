@@ -0,0 +1,18 @@
+-- Failed login attempts per client IP (kind 'ip') and per username (kind 'user').
+CREATE TABLE IF NOT EXISTS login_attempt (
+  kind TEXT NOT NULL,
+  subject TEXT NOT NULL,
+  failures INTEGER NOT NULL DEFAULT 0,
+  last_failure_time INTEGER NOT NULL,
+  locked_until INTEGER NOT NULL DEFAULT 0,
+  PRIMARY KEY (kind, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_attempt_locked_until ON login_attempt(locked_until);
+
+INSERT OR IGNORE INTO vite_config (name, value, time) VALUES
+  ('login_max_failures', '5', 1755147963000),
+  ('login_ip_max_failures', '20', 1755147963000),
+  ('login_failure_window_seconds', '900', 1755147963000),
+  ('login_lockout_seconds', '60', 1755147963000),
+  ('login_lockout_max_seconds', '3600', 1755147963000);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS user_oidc;
+DELETE FROM vite_config WHERE name IN ('oidc_enabled', 'oidc_issuer', 'oidc_client_id', 'oidc_client_secret', 'oidc_redirect_uri', 'oidc_scopes', 'oidc_username_claim', 'oidc_role_claim', 'oidc_default_role', 'oidc_auto_provision', 'oidc_disable_admin_password');
@@ -0,0 +1,25 @@
+-- OpenID Connect single sign-on settings; see the README for their meaning.
+INSERT OR IGNORE INTO vite_config (name, value, time) VALUES
+  ('oidc_enabled', 'false', 1755147963000),
+  ('oidc_issuer', '', 1755147963000),
+  ('oidc_client_id', '', 1755147963000),
+  ('oidc_client_secret', '', 1755147963000),
+  ('oidc_redirect_uri', '', 1755147963000),
+  ('oidc_scopes', 'openid profile email', 1755147963000),
+  ('oidc_username_claim', 'preferred_username', 1755147963000),
+  ('oidc_role_claim', '', 1755147963000),
+  ('oidc_default_role', 'user', 1755147963000),
+  ('oidc_auto_provision', 'false', 1755147963000),
+  ('oidc_disable_admin_password', 'false', 1755147963000);
+
+-- Single sign-on logins are matched by the provider's (issuer, subject) pair
+-- rather than by username. Existing accounts are bound by an admin.
+CREATE TABLE IF NOT EXISTS user_oidc (
+  user_id INTEGER PRIMARY KEY,
+  issuer TEXT NOT NULL,
+  subject TEXT NOT NULL,
+  created_time INTEGER NOT NULL,
+  FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_oidc_identity ON user_oidc(issuer, subject);
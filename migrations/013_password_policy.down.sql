@@ -0,0 +1,3 @@
+DELETE FROM vite_config WHERE name IN ('password_min_length', 'password_min_classes', 'password_banned');
+
+ALTER TABLE user DROP COLUMN must_change_password;
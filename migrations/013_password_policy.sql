@@ -0,0 +1,10 @@
+-- must_change_password limits a user to changing their password; it is set
+-- for the seeded administrator and for passwords reset by an administrator.
+ALTER TABLE user ADD COLUMN must_change_password INTEGER NOT NULL DEFAULT 0;
+
+UPDATE user SET must_change_password = 1 WHERE user = 'admin_user' OR pwd = '3c85cdebade1c51cf64ca9f3c09d182d';
+
+INSERT OR IGNORE INTO vite_config (name, value, time) VALUES
+  ('password_min_length', '8', 1755147963000),
+  ('password_min_classes', '2', 1755147963000),
+  ('password_banned', 'admin_user,admin,password,12345678,123456789,1234567890,qwerty123,11111111,88888888,password1', 1755147963000);
@@ -0,0 +1,2 @@
+ALTER TABLE node DROP COLUMN old_secret_expires;
+ALTER TABLE node DROP COLUMN old_secret;
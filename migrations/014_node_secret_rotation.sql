@@ -0,0 +1,4 @@
+-- Previous node secret, still accepted until old_secret_expires while the
+-- node switches to a rotated secret.
+ALTER TABLE node ADD COLUMN old_secret TEXT NOT NULL DEFAULT '';
+ALTER TABLE node ADD COLUMN old_secret_expires INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_node_enrollment_node;
+DROP TABLE IF EXISTS node_enrollment;
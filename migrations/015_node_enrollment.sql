@@ -0,0 +1,15 @@
+-- One-time tokens a node exchanges for its secret on first start. Only the
+-- SHA-256 of the token is stored.
+CREATE TABLE IF NOT EXISTS node_enrollment (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  node_id INTEGER NOT NULL,
+  token_hash TEXT NOT NULL UNIQUE,
+  created_by INTEGER NOT NULL DEFAULT 0,
+  created_time INTEGER NOT NULL,
+  exp_time INTEGER NOT NULL,
+  used_time INTEGER,
+  used_ip TEXT,
+  FOREIGN KEY (node_id) REFERENCES node(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_node_enrollment_node ON node_enrollment(node_id);
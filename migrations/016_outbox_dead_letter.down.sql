@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_outbox_attempt_outbox;
+DROP TABLE IF EXISTS outbox_attempt;
+ALTER TABLE outbox DROP COLUMN last_error;
@@ -0,0 +1,12 @@
+-- Failed delivery attempts of outbox items with the node's error message.
+ALTER TABLE outbox ADD COLUMN last_error TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS outbox_attempt (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  outbox_id INTEGER NOT NULL,
+  attempt INTEGER NOT NULL,
+  error TEXT NOT NULL,
+  created_at INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_attempt_outbox ON outbox_attempt(outbox_id);
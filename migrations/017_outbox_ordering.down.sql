@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_outbox_node_key;
+DROP INDEX IF EXISTS idx_outbox_node_status;
+ALTER TABLE outbox DROP COLUMN service_key;
+ALTER TABLE outbox DROP COLUMN node_id;
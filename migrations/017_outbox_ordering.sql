@@ -0,0 +1,10 @@
+-- Outbox items are delivered in order per node, and pending commands for the
+-- same gost service, chain or limiter (service_key) may supersede each other.
+-- Items queued before this migration keep an empty key and are never merged.
+ALTER TABLE outbox ADD COLUMN node_id INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE outbox ADD COLUMN service_key TEXT NOT NULL DEFAULT '';
+
+UPDATE outbox SET node_id = COALESCE(json_extract(payload, '$.node_id'), 0);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_node_status ON outbox(node_id, status, id);
+CREATE INDEX IF NOT EXISTS idx_outbox_node_key ON outbox(node_id, service_key, id);
@@ -0,0 +1,8 @@
+-- The services, chains and limiters a node last reported, as digests the
+-- reconciler diffs against the desired state (see gost.Object).
+CREATE TABLE IF NOT EXISTS node_config_report (
+  node_id INTEGER PRIMARY KEY,
+  objects TEXT NOT NULL,
+  reported_time INTEGER NOT NULL,
+  FOREIGN KEY (node_id) REFERENCES node(id) ON DELETE CASCADE
+);
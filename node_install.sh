@@ -68,28 +68,30 @@ delete_self() {
 }
 
 get_config_params() {
-  if [[ -z "$SERVER_ADDR" || -z "$SECRET" ]]; then
+  if [[ -z "$SERVER_ADDR" || ( -z "$SECRET" && -z "$ENROLL_TOKEN" ) ]]; then
     echo "请输入配置参数："
 
     if [[ -z "$SERVER_ADDR" ]]; then
       read -p "面板地址: " SERVER_ADDR
     fi
 
-    if [[ -z "$SECRET" ]]; then
-      read -p "节点密钥: " SECRET
+    if [[ -z "$SECRET" && -z "$ENROLL_TOKEN" ]]; then
+      read -p "安装令牌: " ENROLL_TOKEN
     fi
 
-    if [[ -z "$SERVER_ADDR" || -z "$SECRET" ]]; then
+    if [[ -z "$SERVER_ADDR" || ( -z "$SECRET" && -z "$ENROLL_TOKEN" ) ]]; then
       echo "❌ 参数不完整，操作取消。"
       exit 1
     fi
   fi
 }
 
-while getopts "a:s:" opt; do
+# -t 为面板生成的一次性安装令牌，节点首次启动时用它换取密钥；-s 直接指定密钥
+while getopts "a:s:t:" opt; do
   case $opt in
     a) SERVER_ADDR="$OPTARG" ;;
     s) SECRET="$OPTARG" ;;
+    t) ENROLL_TOKEN="$OPTARG" ;;
     *) echo "❌ 无效参数"; exit 1 ;;
   esac
 done
@@ -144,6 +146,7 @@ install_gost() {
 {
   "addr": "$SERVER_ADDR",
   "secret": "$SECRET",
+  "enroll_token": "$ENROLL_TOKEN",
   "http": 1,
   "tls": 1,
   "socks": 1
@@ -249,7 +252,7 @@ uninstall_gost() {
 }
 
 main() {
-  if [[ -n "$SERVER_ADDR" && -n "$SECRET" ]]; then
+  if [[ -n "$SERVER_ADDR" && ( -n "$SECRET" || -n "$ENROLL_TOKEN" ) ]]; then
     install_gost
     delete_self
     exit 0
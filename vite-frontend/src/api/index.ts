@@ -6,6 +6,7 @@ export interface LoginData {
   username: string;
   password: string;
   captchaId: string;
+  captchaCode?: string;
   turnstileToken?: string;
 }
 
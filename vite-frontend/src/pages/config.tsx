@@ -67,7 +67,7 @@ const CONFIG_ITEMS: ConfigItem[] = [
   {
     key: 'captcha_type',
     label: '验证码类型',
-    description: '当前仅支持 Cloudflare Turnstile',
+    description: '选择登录时使用的验证码',
     type: 'select',
     dependsOn: 'captcha_enabled',
     dependsValue: 'true',
@@ -76,6 +76,11 @@ const CONFIG_ITEMS: ConfigItem[] = [
         label: 'Cloudflare Turnstile',
         value: 'TURNSTILE',
         description: '使用 Cloudflare 交互式验证码'
+      },
+      {
+        label: '图片验证码',
+        value: 'IMAGE',
+        description: '面板内置的图片验证码，无需访问外网'
       }
     ]
   },
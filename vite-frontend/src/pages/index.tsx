@@ -8,16 +8,22 @@ import { isWebViewFunc } from '@/utils/panel';
 import { siteConfig, getCachedConfig } from '@/config/site';
 import { title } from "@/components/primitives";
 import DefaultLayout from "@/layouts/default";
-import { login, LoginData, checkCaptcha } from "@/api";
+import { login, LoginData, checkCaptcha, generateCaptcha } from "@/api";
 
 
 interface LoginForm {
   username: string;
   password: string;
   captchaId: string;
+  captchaCode: string;
   turnstileToken?: string;
 }
 
+interface ImageCaptcha {
+  id: string;
+  image: string;
+}
+
 
 
 export default function IndexPage() {
@@ -25,6 +31,7 @@ export default function IndexPage() {
     username: "",
     password: "",
     captchaId: "",
+    captchaCode: "",
     turnstileToken: "",
   });
   const [loading, setLoading] = useState(false);
@@ -32,6 +39,8 @@ export default function IndexPage() {
   const [showTurnstile, setShowTurnstile] = useState(false);
   const [turnstileEnabled, setTurnstileEnabled] = useState(false);
   const [turnstileSiteKey, setTurnstileSiteKey] = useState("");
+  const [imageCaptchaEnabled, setImageCaptchaEnabled] = useState(false);
+  const [imageCaptcha, setImageCaptcha] = useState<ImageCaptcha | null>(null);
   const [pendingLogin, setPendingLogin] = useState(false);
   const navigate = useNavigate();
   const turnstileContainerRef = useRef<HTMLDivElement>(null);
@@ -49,10 +58,13 @@ export default function IndexPage() {
         const enabled = await getCachedConfig('turnstile_enabled');
         const captchaType = await getCachedConfig('captcha_type');
         const siteKey = await getCachedConfig('turnstile_site_key');
-        const useTurnstile = (captchaType || '').toUpperCase() === 'TURNSTILE' || enabled === 'true';
+        const useImage = (captchaType || '').toUpperCase() === 'IMAGE';
+        const useTurnstile = !useImage && ((captchaType || '').toUpperCase() === 'TURNSTILE' || enabled === 'true');
+        setImageCaptchaEnabled(useImage);
         setTurnstileEnabled(useTurnstile);
         setTurnstileSiteKey(siteKey || "");
       } catch (error) {
+        setImageCaptchaEnabled(false);
         setTurnstileEnabled(false);
         setTurnstileSiteKey("");
       }
@@ -123,6 +135,21 @@ export default function IndexPage() {
       }
     });
   };
+  // 获取图片验证码，每个验证码只能校验一次
+  const loadImageCaptcha = async () => {
+    try {
+      const res = await generateCaptcha();
+      if (res.code !== 0 || !res.data) {
+        toast.error(res.msg || '获取验证码失败');
+        return;
+      }
+      setImageCaptcha({ id: res.data.id, image: res.data.image });
+      setForm(prev => ({ ...prev, captchaCode: "" }));
+    } catch (error) {
+      toast.error('获取验证码失败，请稍后重试');
+    }
+  };
+
   // 验证表单
   const validateForm = (): boolean => {
     const newErrors: Partial<LoginForm> = {};
@@ -158,7 +185,8 @@ export default function IndexPage() {
       const loginData: LoginData = {
         username: form.username.trim(),
         password: form.password,
-        captchaId: form.captchaId,
+        captchaId: imageCaptcha ? imageCaptcha.id : form.captchaId,
+        captchaCode: form.captchaCode.trim(),
         turnstileToken: turnstileToken,
       };
 
@@ -229,6 +257,21 @@ export default function IndexPage() {
         return;
       }
 
+      if (imageCaptchaEnabled) {
+        if (!imageCaptcha) {
+          await loadImageCaptcha();
+          setLoading(false);
+          return;
+        }
+        if (!form.captchaCode.trim()) {
+          setErrors(prev => ({ ...prev, captchaCode: '请输入验证码' }));
+          setLoading(false);
+          return;
+        }
+        await performLogin();
+        return;
+      }
+
       if (!turnstileEnabled || !turnstileSiteKey) {
         toast.error('未配置 Cloudflare 验证码');
         setLoading(false);
@@ -292,6 +335,29 @@ export default function IndexPage() {
                   isInvalid={!!errors.password}
                 />
 
+                {imageCaptchaEnabled && imageCaptcha && (
+                  <div className="flex items-center gap-2">
+                    <Input
+                      label="验证码"
+                      placeholder="请输入图片中的字符"
+                      value={form.captchaCode}
+                      onChange={(e) => handleInputChange('captchaCode', e.target.value)}
+                      onKeyDown={handleKeyPress}
+                      variant="bordered"
+                      isDisabled={loading}
+                      isInvalid={!!errors.captchaCode}
+                      errorMessage={errors.captchaCode}
+                    />
+                    <img
+                      src={imageCaptcha.image}
+                      alt="验证码"
+                      title="点击刷新"
+                      className="h-12 cursor-pointer rounded"
+                      onClick={() => !loading && loadImageCaptcha()}
+                    />
+                  </div>
+                )}
+
                 {showTurnstile && turnstileEnabled && (
                   <div className="flex justify-center">
                     <div ref={turnstileContainerRef} />